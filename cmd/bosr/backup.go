@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/n1/n1/internal/backup"
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/log"
+	"github.com/n1/n1/internal/rotate"
+	"github.com/n1/n1/internal/secretstore"
+
+	"github.com/urfave/cli/v2"
+)
+
+var backupCmd = &cli.Command{
+	Name:      "backup",
+	Usage:     "backup <vault.db> [dest]  – write a consistent, verified backup of the vault",
+	ArgsUsage: "<path> [dest]",
+	Description: "Backs the vault up while it stays in use. Without dest, or when dest is a directory,\n" +
+		"the backup is named after the vault and the current time, and --keep-daily and\n" +
+		"--keep-weekly remove older backups of the vault in that directory.",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "keep-daily",
+			Usage: "Keep the newest backup of each of the last `N` days and remove the rest",
+		},
+		&cli.IntFlag{
+			Name:  "keep-weekly",
+			Usage: "Keep the newest backup of each of the last `N` weeks and remove the rest",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 1 || c.NArg() > 2 {
+			return cli.Exit("Usage: backup [--keep-daily N] [--keep-weekly N] <vault.db> [dest]", 1)
+		}
+		path, mk, err := vaultKey(c.Args().First())
+		if err != nil {
+			return err
+		}
+		policy := backup.Policy{Daily: c.Int("keep-daily"), Weekly: c.Int("keep-weekly")}
+
+		dir, dest := filepath.Dir(path), ""
+		if arg := c.Args().Get(1); arg != "" {
+			if info, err := os.Stat(arg); err == nil && info.IsDir() {
+				dir = arg
+			} else {
+				dest = arg
+			}
+		}
+		if dest == "" {
+			dest = backup.Name(dir, path, time.Now())
+		} else if policy != (backup.Policy{}) {
+			return cli.Exit("--keep-daily and --keep-weekly only apply to timestamped backups in a directory", 1)
+		}
+
+		if err := backup.Create(path, dest, mk); err != nil {
+			return fmt.Errorf("backup failed: %w", err)
+		}
+		log.Info().Str("backup", dest).Msg("✓ Backup written and verified")
+
+		if policy != (backup.Policy{}) {
+			removed, err := backup.Prune(dir, path, policy)
+			for _, b := range removed {
+				log.Info().Str("backup", b.Path).Msg("Removed expired backup")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var restoreCmd = &cli.Command{
+	Name:      "restore",
+	Usage:     "restore <backup> <vault.db>  – replace the vault with a verified backup",
+	ArgsUsage: "<backup> <path>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "no-backup",
+			Usage: "Do not back up the current vault before replacing it",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit("Usage: restore [--no-backup] <backup> <vault.db>", 1)
+		}
+		backupPath := c.Args().Get(0)
+		path, mk, err := vaultKey(c.Args().Get(1))
+		if err != nil {
+			return err
+		}
+		if _, err := rotate.LoadJournal(path); err == nil {
+			return fmt.Errorf("%w; run 'bosr recover' first", rotate.ErrInterrupted)
+		}
+		if rotate.RetiredKey(path, secretstore.Default) != nil {
+			return fmt.Errorf("%w; run 'bosr key rotate --online' to finish it first", rotate.ErrOnlineRotation)
+		}
+		pinned, pinEnabled, err := integrity.Pinned(secretstore.Default, path)
+		if err != nil {
+			return err
+		}
+
+		// Only a backup taken under the vault's current key can be restored:
+		// the key in the secret store stays as it is
+		if _, err := backup.Verify(backupPath, mk); err != nil {
+			return fmt.Errorf("backup cannot be restored with the vault's key (was it taken before a key rotation?): %w", err)
+		}
+		log.Info().Str("backup", backupPath).Msg("✓ Backup verified with the vault's key")
+
+		if _, err := os.Stat(path); err == nil && !c.Bool("no-backup") {
+			safety := backup.Name(filepath.Dir(path), path, time.Now())
+			if err := backup.Create(path, safety, mk); err != nil {
+				return fmt.Errorf("failed to back up the current vault (use --no-backup to skip): %w", err)
+			}
+			log.Info().Str("backup", safety).Msg("Backed up the current vault")
+		}
+
+		seal, err := backup.Restore(backupPath, path, mk)
+		if err != nil {
+			return fmt.Errorf("restore failed: %w", err)
+		}
+
+		// Restoring an older backup is a deliberate rollback
+		if pinEnabled && seal != nil && seal.Counter < pinned {
+			if err := integrity.Repin(secretstore.Default, path, pinned, seal.Counter); err != nil {
+				return fmt.Errorf("failed to move the pinned rollback counter back: %w", err)
+			}
+			log.Warn().Int64("from", pinned).Int64("to", seal.Counter).Msg("Moved the pinned rollback counter back to the restored vault's")
+		}
+		log.Info().Str("path", path).Msg("✓ Vault restored")
+		return nil
+	},
+}
+
+// vaultKey resolves the vault path and fetches its master key from the
+// secret store
+func vaultKey(arg string) (string, []byte, error) {
+	path, err := filepath.Abs(arg)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	mk, err := secretstore.Default.Get(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get key from secret store: %w", err)
+	}
+	return path, mk, nil
+}
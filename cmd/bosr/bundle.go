@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/n1/n1/internal/bundle"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/importer"
+	"github.com/n1/n1/internal/log"
+	"github.com/n1/n1/internal/plaintext"
+	"github.com/n1/n1/internal/secretstore"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+var exportCmd = &cli.Command{
+	Name:      "export",
+	Usage:     "export --to <bundle.age> <vault.db>  – write an encrypted, portable bundle of the vault",
+	ArgsUsage: "<path>",
+	Description: "Writes the vault's records, scopes, labels, links and full history to a single\n" +
+		"archive encrypted with age, to one or more X25519 recipients (age1...) or to a\n" +
+		"passphrase. The bundle does not depend on the vault's key; import it with 'bosr import'.\n\n" +
+		"--format json, yaml, csv or dotenv writes decrypted records instead, for other tools.\n" +
+		"These exports hold secrets in the clear and require --plaintext.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "Write to `FILE` (plaintext formats default to stdout)",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Write an encrypted bundle, or decrypted json, yaml, csv or dotenv",
+			Value: "bundle",
+		},
+		&cli.BoolFlag{
+			Name:  "plaintext",
+			Usage: "Confirm that a json, yaml, csv or dotenv export holds decrypted secrets",
+		},
+		&cli.StringFlag{
+			Name:  "prefix",
+			Usage: "Only export keys starting with `PREFIX`, written relative to it (plaintext formats)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "recipient",
+			Usage: "Encrypt to the age X25519 public key `age1...` (repeatable)",
+		},
+		&cli.BoolFlag{
+			Name:  "passphrase",
+			Usage: "Encrypt with a passphrase, read from the terminal or the first line of stdin",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: export --to <bundle.age> (--recipient age1... | --passphrase) <vault.db>\n"+
+				"       export --format json|yaml|csv|dotenv --plaintext [--prefix PREFIX] [--to FILE] <vault.db>", 1)
+		}
+		if format := c.String("format"); format != "bundle" {
+			return exportPlaintext(c, format)
+		}
+		if c.String("to") == "" {
+			return cli.Exit("--to is required for bundles", 1)
+		}
+		if c.IsSet("prefix") {
+			return cli.Exit("--prefix only applies to plaintext formats", 1)
+		}
+		recipients, err := bundleRecipients(c.StringSlice("recipient"), c.Bool("passphrase"))
+		if err != nil {
+			return err
+		}
+
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		b, err := bundle.Read(db, vault)
+		if err != nil {
+			return fmt.Errorf("failed to read vault: %w", err)
+		}
+		dest := c.String("to")
+		err = writePrivateFile(dest, func(w io.Writer) error { return b.Encrypt(w, recipients...) })
+		if err != nil {
+			return err
+		}
+		log.Info().Str("bundle", dest).Int("records", b.Manifest.Records).Int("events", b.Manifest.Events).Msg("✓ Vault exported")
+		return nil
+	},
+}
+
+var importCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "import <bundle.age> <vault.db>  – import a bundle or another password manager's export",
+	ArgsUsage: "<bundle|export> <path>",
+	Description: "A vault that does not exist yet is created and receives the bundle's full history.\n" +
+		"Into an existing vault, the bundle's records are merged: --on-conflict says what happens\n" +
+		"to records whose key already holds a different value (skip, overwrite, or rename to\n" +
+		"<key>.imported).\n\n" +
+		"With --format, the file is the export of another password manager instead (for pass,\n" +
+		"the password-store directory). Its entries become typed records, keyed by their folder\n" +
+		"and name, and are merged the same way. --format json, yaml, csv or dotenv reads the\n" +
+		"files 'bosr export --plaintext' writes.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Read a json, yaml, csv or dotenv file ('-' for stdin), or the export of another password manager: " + importFormatList(),
+			Value: "bundle",
+		},
+		&cli.StringFlag{
+			Name:  "prefix",
+			Usage: "Prepend `PREFIX` to the keys of a json, yaml, csv or dotenv file",
+		},
+		&cli.StringFlag{
+			Name:  "gpg",
+			Usage: "The gpg `COMMAND` that decrypts pass entries",
+			Value: "gpg",
+		},
+		&cli.StringSliceFlag{
+			Name:  "identity",
+			Usage: "Decrypt with the age identities in `FILE` (repeatable)",
+		},
+		&cli.BoolFlag{
+			Name:  "passphrase",
+			Usage: "Decrypt with a passphrase, read from the terminal or the first line of stdin",
+		},
+		&cli.StringFlag{
+			Name:  "on-conflict",
+			Usage: "What to do with conflicting records: skip, overwrite or rename",
+			Value: string(bundle.ConflictSkip),
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Overwrite records in read-only scopes",
+		},
+		&cli.BoolFlag{
+			Name:  "full-encryption",
+			Usage: "Create a new vault fully encrypted (see 'bosr init')",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit("Usage: import [--format FORMAT] [--identity FILE | --passphrase] [--on-conflict skip|overwrite|rename] <bundle.age|export> <vault.db>", 1)
+		}
+		policy, err := bundle.ParseConflict(c.String("on-conflict"))
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		var b *bundle.Bundle
+		if format := c.String("format"); format == "bundle" {
+			identities, err := bundleIdentities(c.StringSlice("identity"), c.Bool("passphrase"))
+			if err != nil {
+				return err
+			}
+			if b, err = openBundle(c.Args().Get(0), identities); err != nil {
+				return err
+			}
+		} else if f, parseErr := plaintext.ParseFormat(format); parseErr == nil {
+			if b, err = readPlaintext(f, c.Args().Get(0), c.String("prefix")); err != nil {
+				return err
+			}
+		} else if b, err = readExport(format, c.Args().Get(0), c.String("gpg")); err != nil {
+			return err
+		}
+
+		path, err := filepath.Abs(c.Args().Get(1))
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		_, statErr := os.Stat(path)
+		created := errors.Is(statErr, fs.ErrNotExist)
+		if created {
+			db, _, err := createVault(path, c.Bool("full-encryption"))
+			if err != nil {
+				return err
+			}
+			db.Close()
+		}
+
+		report, err := importBundle(path, b, policy, c.Bool("force"))
+		if err != nil && created {
+			// Leave nothing behind that a second attempt would merge into
+			_ = secretstore.Default.Delete(path)
+			_ = os.Remove(path)
+		}
+		if report != nil {
+			logImport(report)
+		}
+		if err != nil {
+			return fmt.Errorf("import failed: %w", err)
+		}
+		log.Info().Str("path", path).Msg("✓ Import complete")
+		return nil
+	},
+}
+
+// importBundle imports b into the vault at path and makes sure the vault has
+// its canary record
+func importBundle(path string, b *bundle.Bundle, policy bundle.Conflict, force bool) (*bundle.Report, error) {
+	_, db, vault, err := openVault(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if force {
+		vault = vault.WithForce()
+	}
+
+	report, err := bundle.Import(vault, b, policy)
+	if err != nil {
+		return report, err
+	}
+	// A bundle's history carries the canary of the vault it came from, so a
+	// new vault only gets its own if the history lacked one
+	if _, err := vault.Get("__n1_canary__"); errors.Is(err, dao.ErrNotFound) {
+		if err := vault.WithInternalKeys().Put("__n1_canary__", []byte("ok")); err != nil {
+			return report, fmt.Errorf("failed to create canary record: %w", err)
+		}
+	} else if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func logImport(r *bundle.Report) {
+	if r.Replayed > 0 {
+		log.Info().Int("events", r.Replayed).Int("records", len(r.Imported)).Msg("Replayed the bundle's history")
+		return
+	}
+	for _, key := range r.Skipped {
+		log.Warn().Str("key", key).Msg("Skipped conflicting record")
+	}
+	for _, key := range r.Overwritten {
+		log.Info().Str("key", key).Msg("Overwrote conflicting record")
+	}
+	for from, to := range r.Renamed {
+		log.Info().Str("key", from).Str("as", to).Msg("Imported conflicting record under a new key")
+	}
+	log.Info().
+		Int("imported", len(r.Imported)).
+		Int("unchanged", len(r.Unchanged)).
+		Int("skipped", len(r.Skipped)).
+		Int("overwritten", len(r.Overwritten)).
+		Int("renamed", len(r.Renamed)).
+		Int("links", r.Links).
+		Msg("Merged the bundle's records")
+}
+
+// bundleRecipients parses the recipients of an export: public keys, or a
+// passphrase
+func bundleRecipients(keys []string, passphrase bool) ([]age.Recipient, error) {
+	if passphrase == (len(keys) > 0) {
+		return nil, cli.Exit("Use either --recipient or --passphrase", 1)
+	}
+	if passphrase {
+		p, err := readPassphrase(true)
+		if err != nil {
+			return nil, err
+		}
+		r, err := age.NewScryptRecipient(p)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{r}, nil
+	}
+	var recipients []age.Recipient
+	for _, k := range keys {
+		r, err := age.ParseX25519Recipient(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", k, err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// bundleIdentities loads the identities an import decrypts with
+func bundleIdentities(files []string, passphrase bool) ([]age.Identity, error) {
+	if passphrase == (len(files) > 0) {
+		return nil, cli.Exit("Use either --identity or --passphrase", 1)
+	}
+	if passphrase {
+		p, err := readPassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		id, err := age.NewScryptIdentity(p)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{id}, nil
+	}
+	var identities []age.Identity
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open identity file: %w", err)
+		}
+		ids, err := age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identities from %s: %w", name, err)
+		}
+		identities = append(identities, ids...)
+	}
+	return identities, nil
+}
+
+// readPassphrase prompts for a passphrase on the terminal, twice when it is
+// chosen, or reads the first line of stdin when that is not a terminal
+func readPassphrase(confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return "", fmt.Errorf("failed to read passphrase from stdin: %w", err)
+		}
+		return line, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(p) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		if string(again) != string(p) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return string(p), nil
+}
+
+// exportPlaintext writes decrypted records to stdout or a new 0600 file
+func exportPlaintext(c *cli.Context, name string) error {
+	format, err := plaintext.ParseFormat(name)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("%v: use bundle, json, yaml, csv or dotenv", err), 1)
+	}
+	if !c.Bool("plaintext") {
+		return cli.Exit("A "+name+" export holds decrypted secrets: pass --plaintext to confirm", 1)
+	}
+	if len(c.StringSlice("recipient")) > 0 || c.Bool("passphrase") {
+		return cli.Exit("--recipient and --passphrase only apply to bundles", 1)
+	}
+
+	_, db, vault, err := openVault(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	entries, err := plaintext.Collect(vault, c.String("prefix"))
+	if err != nil {
+		return fmt.Errorf("failed to read vault: %w", err)
+	}
+	write := func(w io.Writer) error { return plaintext.Write(w, format, entries) }
+	dest := c.String("to")
+	if dest == "" || dest == "-" {
+		return write(os.Stdout)
+	}
+	if err := writePrivateFile(dest, write); err != nil {
+		return err
+	}
+	log.Warn().Str("file", dest).Int("records", len(entries)).Msg("Exported decrypted records")
+	return nil
+}
+
+// writePrivateFile writes a new 0600 file at dest, which is only created
+// once write has succeeded. Neither dest nor a leftover partial file is ever
+// overwritten: the partial file is created exclusively and linked into place,
+// which fails if dest has appeared meanwhile.
+func writePrivateFile(dest string, write func(io.Writer) error) error {
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	partial := dest + ".partial"
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	defer os.Remove(partial)
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Link(partial, dest)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	return nil
+}
+
+// readPlaintext reads a json, yaml, csv or dotenv file, or stdin for "-", as a
+// bundle of records to merge
+func readPlaintext(format plaintext.Format, path, prefix string) (*bundle.Bundle, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	entries, err := plaintext.Read(r, format)
+	if err != nil {
+		return nil, err
+	}
+	b := &bundle.Bundle{}
+	for _, e := range entries {
+		value, err := e.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		b.Records = append(b.Records, bundle.Record{Key: prefix + e.Key, Value: value, Scope: e.Scope, Labels: e.Labels})
+	}
+	return b, nil
+}
+
+// readExport reads the export of another password manager as a bundle of
+// records to merge
+func readExport(format, path, gpg string) (*bundle.Bundle, error) {
+	f, err := importer.ParseFormat(format)
+	if err != nil {
+		return nil, cli.Exit(fmt.Sprintf("%v: use bundle, json, yaml, csv, dotenv or %s", err, importFormatList()), 1)
+	}
+	result, err := importer.Read(f, path, importer.Options{GPG: gpg})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range result.Skipped {
+		log.Warn().Str("key", key).Msg("Skipped empty entry")
+	}
+	b := &bundle.Bundle{}
+	for _, e := range result.Entries {
+		b.Records = append(b.Records, bundle.Record{Key: e.Key, Value: e.Value, Labels: e.Labels})
+	}
+	log.Info().Str("format", format).Int("entries", len(b.Records)).Msg("Read export")
+	return b, nil
+}
+
+func importFormatList() string {
+	var names []string
+	for _, f := range importer.Formats {
+		names = append(names, string(f))
+	}
+	return strings.Join(names, ", ")
+}
+
+func openBundle(path string, identities []age.Identity) (*bundle.Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer f.Close()
+	b, err := bundle.Open(bufio.NewReader(f), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
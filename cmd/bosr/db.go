@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/n1/n1/internal/log"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/n1/n1/internal/secretstore"
+	"github.com/n1/n1/internal/sqlite"
+
+	"github.com/urfave/cli/v2"
+)
+
+// eventLogVersion is the migration that creates the event log, the source of
+// truth for every record. Rolling back past it is destructive.
+const eventLogVersion = 4
+
+var dbCmd = &cli.Command{
+	Name:  "db",
+	Usage: "db <subcommand> <vault.db> – maintain the vault database",
+	Subcommands: []*cli.Command{
+		dbMigrateCmd,
+	},
+}
+
+var dbMigrateCmd = &cli.Command{
+	Name:  "migrate",
+	Usage: "migrate <subcommand> <vault.db> – inspect and move the schema version",
+	Subcommands: []*cli.Command{
+		dbMigrateStatusCmd,
+		dbMigrateUpCmd,
+		dbMigrateDownCmd,
+	},
+}
+
+var dbMigrateStatusCmd = &cli.Command{
+	Name:      "status",
+	Usage:     "status <vault.db>  – list migrations and whether they are applied",
+	ArgsUsage: "<path>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: db migrate status <vault.db>", 1)
+		}
+		db, runner, err := openMigrations(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		statuses, err := runner.Status()
+		if err != nil {
+			return err
+		}
+		drift := false
+		for _, s := range statuses {
+			state, appliedAt := "pending", "-"
+			switch {
+			case s.Drift:
+				state, drift = "drift", true
+			case s.Unknown:
+				state = "unknown"
+			case s.Applied:
+				state = "applied"
+			case s.Reserved:
+				state = "unavailable"
+			case s.Cursor != "":
+				state = "interrupted"
+			}
+			if s.Applied {
+				appliedAt = s.AppliedAt.UTC().Format("2006-01-02T15:04:05Z")
+			}
+			fmt.Printf("%d\t%s\t%s\t%s\n", s.Version, state, appliedAt, s.Description)
+		}
+		if drift {
+			return cli.Exit("Applied migrations have changed since they were applied; refusing to migrate this vault", 1)
+		}
+		return nil
+	},
+}
+
+var dbMigrateUpCmd = &cli.Command{
+	Name:      "up",
+	Usage:     "up <vault.db>  – apply pending migrations",
+	ArgsUsage: "<path>",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "to",
+			Usage: "Stop at this version instead of the latest",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: db migrate up [--to version] <vault.db>", 1)
+		}
+		db, runner, err := openMigrations(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		compat, err := runner.Compatibility()
+		if err != nil {
+			return err
+		}
+		if compat.Newer() {
+			return compat.Err()
+		}
+		target := runner.Latest()
+		if c.IsSet("to") {
+			target = c.Int("to")
+		}
+		current, err := runner.Version()
+		if err != nil {
+			return err
+		}
+		if target < current {
+			return cli.Exit(fmt.Sprintf("Vault is at version %d; use 'db migrate down --to %d' to roll back", current, target), 1)
+		}
+		return migrateTo(runner, target)
+	},
+}
+
+var dbMigrateDownCmd = &cli.Command{
+	Name:      "down",
+	Usage:     "down <vault.db>  – roll back the latest migration",
+	ArgsUsage: "<path>",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "to",
+			Usage: "Roll back every migration above this version",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Allow rolling back the event log, destroying every record",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: db migrate down [--to version] [--force] <vault.db>", 1)
+		}
+		db, runner, err := openMigrations(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		current, target, err := previousVersion(runner)
+		if err != nil {
+			return err
+		}
+		if c.IsSet("to") {
+			target = c.Int("to")
+		}
+		if target > current {
+			return cli.Exit(fmt.Sprintf("Vault is at version %d; use 'db migrate up --to %d' to migrate forward", current, target), 1)
+		}
+		if target < eventLogVersion && current >= eventLogVersion && !c.Bool("force") {
+			return cli.Exit(fmt.Sprintf("Rolling back below version %d drops the event log and every record in it; pass --force to proceed", eventLogVersion), 1)
+		}
+		return migrateTo(runner, target)
+	},
+}
+
+// openMigrations opens a vault database without migrating it. The master key
+// is only needed by data migrations, so a missing key is not an error until
+// one of them needs it.
+func openMigrations(arg string) (*sql.DB, *migrations.Runner, error) {
+	path, err := filepath.Abs(arg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, fmt.Errorf("vault file not found: %w", err)
+	}
+	// Fully encrypted vaults cannot even be read without the key
+	mk, keyErr := secretstore.Default.Get(path)
+	if encrypted, err := sqlite.IsEncrypted(path); err == nil && encrypted && keyErr != nil {
+		return nil, nil, fmt.Errorf("failed to get key from secret store: %w", keyErr)
+	}
+	db, err := sqlite.OpenFile(path, mk, sqlite.DefaultOptions())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database file '%s': %w", path, err)
+	}
+	runner := migrations.NewVaultRunner(db).OnProgress(logMigrationProgress)
+	if keyErr == nil {
+		runner.WithKey(mk)
+	}
+	return db, runner, nil
+}
+
+func migrateTo(runner *migrations.Runner, target int) error {
+	if err := runner.MigrateTo(target); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	// Rolling back drops projection tables; 'events replay' rebuilds them
+	// once the schema is current again
+	log.Info().Int("version", target).Msg("✓ Vault schema migrated")
+	return nil
+}
+
+// previousVersion returns the current version and the highest applied
+// version below it
+func previousVersion(runner *migrations.Runner) (int, int, error) {
+	statuses, err := runner.Status()
+	if err != nil {
+		return 0, 0, err
+	}
+	current, previous := 0, 0
+	for _, s := range statuses {
+		if s.Applied && s.Version > current {
+			current, previous = s.Version, current
+		}
+	}
+	return current, previous, nil
+}
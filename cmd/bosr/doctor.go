@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/n1/n1/internal/doctor"
+	"github.com/n1/n1/internal/secretstore"
+
+	"github.com/urfave/cli/v2"
+)
+
+var doctorCmd = &cli.Command{
+	Name:      "doctor",
+	Usage:     "doctor <vault.db>  – diagnose a vault that does not open or rotate, and say how to fix it",
+	ArgsUsage: "<path>",
+	Description: "Checks the secret store and the vault's key, file permissions, files left behind by\n" +
+		"interrupted rotations, migrations and exports, the schema version, free disk space and\n" +
+		"SQLite's journal files, and prints the steps that fix each problem. The vault is not\n" +
+		"changed. Exits with an error if any check fails.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Write the findings as JSON",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: doctor [--json] <vault.db>", 1)
+		}
+		path, err := filepath.Abs(c.Args().First())
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path: %w", err)
+		}
+
+		report := doctor.Run(path, secretstore.Default)
+		if c.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			printFindings(report)
+		}
+		if report.Failed() {
+			return errors.New("some checks failed")
+		}
+		return nil
+	},
+}
+
+// printFindings prints one line per finding, followed by its fixes
+func printFindings(r *doctor.Report) {
+	marks := map[doctor.Status]string{doctor.StatusOK: "✓", doctor.StatusWarn: "!", doctor.StatusFail: "✗"}
+	fmt.Println(r.Path)
+	for _, f := range r.Findings {
+		fmt.Printf("%s %-12s %s\n", marks[f.Status], f.Check, f.Message)
+		for _, fix := range f.Fix {
+			fmt.Printf("  → %s\n", fix)
+		}
+	}
+}
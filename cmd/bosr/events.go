@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/log"
+
+	"github.com/urfave/cli/v2"
+)
+
+var eventsCmd = &cli.Command{
+	Name:  "events",
+	Usage: "events <subcommand> <vault.db> – inspect the append-only event log",
+	Subcommands: []*cli.Command{
+		eventsListCmd,
+		eventsVerifyCmd,
+		eventsReplayCmd,
+	},
+}
+
+var eventsListCmd = &cli.Command{
+	Name:      "ls",
+	Usage:     "ls <vault.db>  – list events in sequence order",
+	ArgsUsage: "<path>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: events ls <vault.db>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		return vault.Events().Each(func(e *dao.Event) error {
+			fmt.Printf("%d\t%s\t%s\t%s\n", e.Seq, e.CreatedAt.Format("2006-01-02T15:04:05Z"), e.Type, e.Key)
+			return nil
+		})
+	},
+}
+
+var eventsVerifyCmd = &cli.Command{
+	Name:      "verify",
+	Usage:     "verify <vault.db>  – check the hash chain and decrypt every event",
+	ArgsUsage: "<path>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: events verify <vault.db>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if err := vault.Events().Verify(); err != nil {
+			return fmt.Errorf("event log verification failed: %w", err)
+		}
+		seq, _, err := vault.Events().Head()
+		if err != nil {
+			return err
+		}
+		log.Info().Int64("events", seq).Msg("✓ Event log verified")
+		return nil
+	},
+}
+
+var eventsReplayCmd = &cli.Command{
+	Name:      "replay",
+	Usage:     "replay <vault.db>  – rebuild the vault table from the event log",
+	ArgsUsage: "<path>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: events replay <vault.db>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		applied, err := vault.Replay()
+		if err != nil {
+			return fmt.Errorf("replay failed: %w", err)
+		}
+		log.Info().Int("events", applied).Msg("✓ Vault rebuilt from event log")
+		return nil
+	},
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/graph"
+	"github.com/n1/n1/internal/holdr"
+	"github.com/n1/n1/internal/log"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Edge types for references recorded inside Holds
+const (
+	holdRefersTo = "refers-to"
+	holdAmends   = "amends"
+)
+
+var linkCmd = &cli.Command{
+	Name:  "link",
+	Usage: "link <subcommand> <vault.db> – manage typed links between records",
+	Subcommands: []*cli.Command{
+		linkAddCmd,
+		linkRemoveCmd,
+		linkListCmd,
+	},
+}
+
+var linkAddCmd = &cli.Command{
+	Name:      "add",
+	Usage:     "add <vault.db> <from> <to>  – link two records (keys or Hold IDs)",
+	ArgsUsage: "<path> <from> <to>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "type",
+			Usage: "Link type (e.g. " + strings.Join([]string{dao.LinkCredentialFor, dao.LinkDerivedFrom, dao.LinkBlocks, dao.LinkRelated}, ", ") + ")",
+			Value: dao.LinkRelated,
+		},
+		&cli.Float64Flag{
+			Name:  "weight",
+			Usage: "Cost of following the link in path queries",
+			Value: 1,
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Allow linking from a record in a read-only scope",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 3 {
+			return cli.Exit("Usage: link add [--type t] [--weight w] [--force] <vault.db> <from> <to>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if c.Bool("force") {
+			vault = vault.WithForce()
+		}
+
+		l := dao.Link{
+			From:   recordKey(c.Args().Get(1)),
+			To:     recordKey(c.Args().Get(2)),
+			Type:   c.String("type"),
+			Weight: c.Float64("weight"),
+		}
+		if err := vault.Link(l); err != nil {
+			return fmt.Errorf("failed to link %s to %s: %w", l.From, l.To, err)
+		}
+
+		log.Info().Str("from", l.From).Str("to", l.To).Str("type", l.Type).Msg("Link added")
+		return nil
+	},
+}
+
+var linkRemoveCmd = &cli.Command{
+	Name:      "rm",
+	Usage:     "rm <vault.db> <from> <to>  – remove links between two records",
+	ArgsUsage: "<path> <from> <to>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "type",
+			Usage: "Only remove links of this type",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Allow unlinking from a record in a read-only scope",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 3 {
+			return cli.Exit("Usage: link rm [--type t] [--force] <vault.db> <from> <to>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if c.Bool("force") {
+			vault = vault.WithForce()
+		}
+
+		from, to := recordKey(c.Args().Get(1)), recordKey(c.Args().Get(2))
+		if err := vault.Unlink(from, to, c.String("type")); err != nil {
+			return fmt.Errorf("failed to unlink %s from %s: %w", from, to, err)
+		}
+
+		log.Info().Str("from", from).Str("to", to).Msg("Links removed")
+		return nil
+	},
+}
+
+var linkListCmd = &cli.Command{
+	Name:      "ls",
+	Usage:     "ls <vault.db> <key>  – list the links of a record",
+	ArgsUsage: "<path> <key>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "backlinks",
+			Usage: "List links pointing at the record instead",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit("Usage: link ls [--backlinks] <vault.db> <key>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		g, err := loadGraph(vault)
+		if err != nil {
+			return err
+		}
+		key := recordKey(c.Args().Get(1))
+		edges := g.Outgoing(key)
+		if c.Bool("backlinks") {
+			edges = g.Incoming(key)
+		}
+		printEdges(edges)
+		return nil
+	},
+}
+
+var graphCmd = &cli.Command{
+	Name:      "graph",
+	Usage:     "graph <vault.db>  – query the graph of links and Hold references",
+	ArgsUsage: "<path>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "Restrict to records around this key or Hold ID",
+		},
+		&cli.IntFlag{
+			Name:  "depth",
+			Usage: "Number of hops around --from to include",
+			Value: 1,
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "Print the cheapest path from --from to this record",
+		},
+		&cli.BoolFlag{
+			Name:  "components",
+			Usage: "Print connected components, one per line",
+		},
+		&cli.BoolFlag{
+			Name:  "dot",
+			Usage: "Render the graph in Graphviz DOT format",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: graph [--from key [--depth n] [--to key]] [--components] [--dot] <vault.db>", 1)
+		}
+		if c.IsSet("to") && !c.IsSet("from") {
+			return cli.Exit("--to requires --from", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		g, err := loadGraph(vault)
+		if err != nil {
+			return err
+		}
+
+		if c.IsSet("from") {
+			from := recordKey(c.String("from"))
+			if c.IsSet("to") {
+				path, cost, err := g.ShortestPath(from, recordKey(c.String("to")))
+				if err != nil {
+					return err
+				}
+				if c.Bool("dot") {
+					return pathGraph(path, from).WriteDOT(os.Stdout)
+				}
+				printEdges(path)
+				fmt.Printf("cost\t%g\n", cost)
+				return nil
+			}
+			around, err := g.Neighbours(from, c.Int("depth"))
+			if err != nil {
+				return err
+			}
+			g = g.Subgraph(append(around, from))
+		}
+
+		switch {
+		case c.Bool("components"):
+			for _, component := range g.Components() {
+				fmt.Println(strings.Join(component, "\t"))
+			}
+		case c.Bool("dot"):
+			return g.WriteDOT(os.Stdout)
+		default:
+			printEdges(g.Edges())
+		}
+		return nil
+	},
+}
+
+// loadGraph builds the graph of all user records, their links and the
+// references and amendments recorded in Holds
+func loadGraph(vault *dao.SecureVaultDAO) (*graph.Graph, error) {
+	g := graph.New()
+	keys, err := vault.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", err)
+	}
+	for _, k := range keys {
+		if !dao.IsInternalKey(k) {
+			g.AddNode(k)
+		}
+	}
+
+	links, err := vault.AllLinks()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range links {
+		g.AddEdge(graph.Edge{From: l.From, To: l.To, Type: l.Type, Weight: l.Weight})
+	}
+
+	holds, err := holdr.NewRepository(vault).List()
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range holds {
+		for _, ref := range h.Refs {
+			g.AddEdge(graph.Edge{From: holdr.Key(h.ID), To: holdr.Key(ref), Type: holdRefersTo, Weight: 1})
+		}
+		if h.Amends != "" {
+			g.AddEdge(graph.Edge{From: holdr.Key(h.ID), To: holdr.Key(h.Amends), Type: holdAmends, Weight: 1})
+		}
+	}
+	return g, nil
+}
+
+// pathGraph turns a path into a graph for rendering
+func pathGraph(path []graph.Edge, from string) *graph.Graph {
+	g := graph.New()
+	g.AddNode(from)
+	for _, e := range path {
+		g.AddEdge(e)
+	}
+	return g
+}
+
+// recordKey accepts Hold IDs wherever a record key is expected
+func recordKey(arg string) string {
+	if holdr.IsID(arg) {
+		return holdr.Key(arg)
+	}
+	return arg
+}
+
+func printEdges(edges []graph.Edge) {
+	for _, e := range edges {
+		fmt.Printf("%s\t%s\t%g\t%s\n", e.From, e.Type, e.Weight, e.To)
+	}
+}
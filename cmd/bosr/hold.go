@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/n1/n1/internal/holdr"
+	"github.com/n1/n1/internal/log"
+
+	"github.com/urfave/cli/v2"
+)
+
+var holdCmd = &cli.Command{
+	Name:  "hold",
+	Usage: "hold <subcommand> <vault.db> – manage immutable Hold records",
+	Subcommands: []*cli.Command{
+		holdNewCmd,
+		holdGetCmd,
+		holdListCmd,
+		holdAmendCmd,
+	},
+}
+
+var holdNewCmd = &cli.Command{
+	Name:      "new",
+	Usage:     "new <vault.db> <body>  – create a Hold (body is JSON, or text stored as a JSON string)",
+	ArgsUsage: "<path> <body>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "kind",
+			Usage: "Kind of Hold (note, credential, task, ...)",
+			Value: "note",
+		},
+		&cli.StringSliceFlag{
+			Name:  "tag",
+			Usage: "Tag the Hold (repeatable)",
+		},
+		&cli.StringFlag{
+			Name:  "scope",
+			Usage: "Scope to file the Hold under",
+			Value: holdr.DefaultScope,
+		},
+		&cli.StringSliceFlag{
+			Name:  "ref",
+			Usage: "ID of a Hold this one refers to (repeatable)",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit("Usage: hold new [--kind k] [--tag t] [--scope s] [--ref id] <vault.db> <body>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		h := &holdr.Hold{
+			Kind:  holdr.Kind(c.String("kind")),
+			Body:  holdBody(c.Args().Get(1)),
+			Tags:  c.StringSlice("tag"),
+			Scope: c.String("scope"),
+			Refs:  c.StringSlice("ref"),
+		}
+		if err := holdr.NewRepository(vault).Create(h); err != nil {
+			return fmt.Errorf("failed to create hold: %w", err)
+		}
+
+		log.Info().Str("id", h.ID).Str("kind", string(h.Kind)).Msg("Hold created")
+		fmt.Println(h.ID)
+		return nil
+	},
+}
+
+var holdGetCmd = &cli.Command{
+	Name:      "get",
+	Usage:     "get <vault.db> <id>  – print a Hold as canonical JSON",
+	ArgsUsage: "<path> <id>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "history",
+			Usage: "Print the Hold followed by every Hold it amends",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit("Usage: hold get [--history] <vault.db> <id>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		repo := holdr.NewRepository(vault)
+		id := c.Args().Get(1)
+
+		holds := make([]*holdr.Hold, 0, 1)
+		if c.Bool("history") {
+			holds, err = repo.History(id)
+		} else {
+			var h *holdr.Hold
+			h, err = repo.Get(id)
+			holds = append(holds, h)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get hold: %w", err)
+		}
+
+		for _, h := range holds {
+			data, err := h.Canonical()
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		}
+		return nil
+	},
+}
+
+var holdListCmd = &cli.Command{
+	Name:      "ls",
+	Usage:     "ls <vault.db>  – list current Holds",
+	ArgsUsage: "<path>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "Include Holds that have been superseded by an amendment",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: hold ls [--all] <vault.db>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		repo := holdr.NewRepository(vault)
+		var holds []*holdr.Hold
+		if c.Bool("all") {
+			holds, err = repo.List()
+		} else {
+			holds, err = repo.Current()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list holds: %w", err)
+		}
+
+		for _, h := range holds {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n",
+				h.ID, h.Kind, h.Scope, h.CreatedAt.Format("2006-01-02T15:04:05Z"), strings.Join(h.Tags, ","))
+		}
+		return nil
+	},
+}
+
+var holdAmendCmd = &cli.Command{
+	Name:      "amend",
+	Usage:     "amend <vault.db> <id> <body>  – create a new Hold superseding an existing one",
+	ArgsUsage: "<path> <id> <body>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 3 {
+			return cli.Exit("Usage: hold amend <vault.db> <id> <body>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		h, err := holdr.NewRepository(vault).Amend(c.Args().Get(1), holdBody(c.Args().Get(2)))
+		if err != nil {
+			return fmt.Errorf("failed to amend hold: %w", err)
+		}
+
+		log.Info().Str("id", h.ID).Str("amends", h.Amends).Msg("Hold amended")
+		fmt.Println(h.ID)
+		return nil
+	},
+}
+
+// holdBody interprets a command-line body: valid JSON is used as is,
+// anything else is stored as a JSON string.
+func holdBody(arg string) json.RawMessage {
+	if json.Valid([]byte(arg)) {
+		return json.RawMessage(arg)
+	}
+	quoted, _ := json.Marshal(arg)
+	return quoted
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/n1/n1/internal/dao"
+
+	"github.com/urfave/cli/v2"
+)
+
+// trashScope is hidden from listings unless asked for
+const trashScope = "trashbox"
+
+var lsCmd = &cli.Command{
+	Name:      "ls",
+	Usage:     "ls <vault.db>  – list record keys with their scopes and tags",
+	ArgsUsage: "<path>",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "tag",
+			Usage: "Only list records carrying this tag or name=value label (repeatable, all must match)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "any-tag",
+			Usage: "Only list records carrying at least one of these tags (repeatable)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "not-tag",
+			Usage: "Exclude records carrying this tag (repeatable)",
+		},
+		&cli.StringFlag{
+			Name:  "scope",
+			Usage: "Only list records in this scope",
+		},
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "Include records in the trashbox",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: ls [--scope s] [--all] [--tag t] [--any-tag t] [--not-tag t] <vault.db>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		tags := dao.TagQuery{
+			All:  c.StringSlice("tag"),
+			Any:  c.StringSlice("any-tag"),
+			None: c.StringSlice("not-tag"),
+		}
+		entries, err := listEntries(vault, c.String("scope"), c.Bool("all"), tags)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Printf("%s\t%s\t%s\n", e.key, e.scope, strings.Join(e.labels, ","))
+		}
+		return nil
+	},
+}
+
+type listEntry struct {
+	key    string
+	scope  string
+	labels []string
+}
+
+// listEntries returns the user records to show in a listing, sorted by key.
+// Internal records are never shown; the trashbox only when asked for
+// explicitly or with all.
+func listEntries(vault *dao.SecureVaultDAO, scope string, all bool, tags dao.TagQuery) ([]listEntry, error) {
+	keys, err := vault.FindByTags(tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list records: %w", err)
+	}
+	sort.Strings(keys)
+
+	var entries []listEntry
+	for _, k := range keys {
+		if dao.IsInternalKey(k) {
+			continue
+		}
+		s, err := vault.ScopeOf(k)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scope of %s: %w", k, err)
+		}
+		if scope != "" && s != scope || scope == "" && !all && s == trashScope {
+			continue
+		}
+		labels, err := vault.Labels(k)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, listEntry{key: k, scope: s, labels: labels})
+	}
+	return entries, nil
+}
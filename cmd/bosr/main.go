@@ -2,11 +2,12 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
@@ -15,7 +16,8 @@ import (
 	"github.com/n1/n1/internal/crypto"
 	"github.com/n1/n1/internal/dao"
 	"github.com/n1/n1/internal/log"
-	"github.com/n1/n1/internal/migrations"
+	"github.com/n1/n1/internal/record"
+	"github.com/n1/n1/internal/rotate"
 	"github.com/n1/n1/internal/secretstore"
 	"github.com/n1/n1/internal/sqlite"
 
@@ -30,12 +32,31 @@ func main() {
 		Name:    "bosr",
 		Version: version,
 		Usage:   "bosr – the n1 lock-box CLI",
+		// Field values (URLs, notes) may legitimately contain commas
+		DisableSliceFlagSeparator: true,
 		Commands: []*cli.Command{
 			initCmd,
 			openCmd,
 			keyCmd, // Keep the top-level key command structure
 			putCmd,
 			getCmd,
+			mvCmd,
+			lsCmd,
+			scopeCmd,
+			tagCmd,
+			searchCmd,
+			linkCmd,
+			graphCmd,
+			holdCmd,
+			eventsCmd,
+			verifyCmd,
+			doctorCmd,
+			dbCmd,
+			recoverCmd,
+			backupCmd,
+			restoreCmd,
+			exportCmd,
+			importCmd,
 		},
 	}
 
@@ -57,12 +78,19 @@ func main() {
 
 var initCmd = &cli.Command{
 	Name:      "init",
-	Usage:     "init <vault.db>   – create plaintext vault file and store its key",
+	Usage:     "init <vault.db>   – create vault file and store its key",
 	ArgsUsage: "<path>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "full-encryption",
+			Usage: "Encrypt the whole database file, not just record values (hides names, timestamps and schema)",
+		},
+	},
 	Action: func(c *cli.Context) error {
 		if c.NArg() != 1 {
-			return cli.Exit("Usage: init <vault.db>", 1)
+			return cli.Exit("Usage: init [--full-encryption] <vault.db>", 1)
 		}
+		fullEncryption := c.Bool("full-encryption")
 		path, err := filepath.Abs(c.Args().First())
 		if err != nil {
 			return fmt.Errorf("failed to get absolute path: %w", err)
@@ -76,53 +104,74 @@ var initCmd = &cli.Command{
 		//     return fmt.Errorf("key already exists for path: %s", path)
 		// }
 
-		// 1· generate master-key (for application-level encryption)
-		mk, err := crypto.Generate(32)
-		if err != nil {
-			return fmt.Errorf("failed to generate master key: %w", err)
-		}
-
-		// 2· persist in secret store
-		if err = secretstore.Default.Put(path, mk); err != nil {
-			// Consider if we should attempt cleanup if this fails
-			return fmt.Errorf("failed to store master key: %w", err)
-		}
-		log.Info().Str("path", path).Msg("Master key generated and stored")
-
-		// 3· create *plaintext* DB file by opening it
-		// The Open function now only takes the path.
-		db, err := sqlite.Open(path)
+		db, mk, err := createVault(path, fullEncryption)
 		if err != nil {
-			// If DB creation fails, should we remove the key we just stored?
-			_ = secretstore.Default.Delete(path) // Cleanup key if DB creation fails
-			return fmt.Errorf("failed to create database file '%s': %w", path, err)
+			return err
 		}
 		defer db.Close() // Ensure DB is closed
 
-		// 4· Run migrations to bootstrap the vault table
-		log.Info().Msg("Running migrations to initialize vault schema...")
-		if err := migrations.BootstrapVault(db); err != nil {
-			// If migrations fail, clean up
-			_ = secretstore.Default.Delete(path)
-			return fmt.Errorf("failed to initialize vault schema: %w", err)
-		}
-
 		// Add a canary record for key verification
 		secureDAO := dao.NewSecureVaultDAO(db, mk)
 		canaryKey := "__n1_canary__"
 		canaryPlaintext := []byte("ok")
-		if err := secureDAO.Put(canaryKey, canaryPlaintext); err != nil {
+		if err := secureDAO.WithInternalKeys().Put(canaryKey, canaryPlaintext); err != nil {
 			// If canary creation fails, clean up
 			_ = secretstore.Default.Delete(path)
 			return fmt.Errorf("failed to create canary record: %w", err)
 		}
 		log.Debug().Msg("Added canary record for key verification")
 
+		if fullEncryption {
+			log.Info().Str("path", path).Msg("Fully encrypted vault file created and initialized")
+			return nil
+		}
 		log.Info().Str("path", path).Msg("Plaintext vault file created and initialized")
 		return nil
 	},
 }
 
+// createVault generates and stores the master key for a new vault at path,
+// creates the database file and bootstraps the vault schema. On failure the
+// key is removed again. Callers must close the returned handle.
+func createVault(path string, fullEncryption bool) (*sql.DB, []byte, error) {
+	// 1· generate master-key (for application-level encryption)
+	mk, err := crypto.Generate(32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate master key: %w", err)
+	}
+
+	// 2· persist in secret store
+	if err = secretstore.Default.Put(path, mk); err != nil {
+		// Consider if we should attempt cleanup if this fails
+		return nil, nil, fmt.Errorf("failed to store master key: %w", err)
+	}
+	log.Info().Str("path", path).Msg("Master key generated and stored")
+
+	// 3· create the DB file by opening it: plaintext with encrypted
+	// values, or encrypted as a whole with a key derived from the master key
+	var db *sql.DB
+	if fullEncryption {
+		db, err = sqlite.OpenEncrypted(path, mk, sqlite.DefaultOptions())
+	} else {
+		db, err = sqlite.Open(path)
+	}
+	if err != nil {
+		// If DB creation fails, should we remove the key we just stored?
+		_ = secretstore.Default.Delete(path) // Cleanup key if DB creation fails
+		return nil, nil, fmt.Errorf("failed to create database file '%s': %w", path, err)
+	}
+
+	// 4· Run migrations to bootstrap the vault table
+	log.Info().Msg("Running migrations to initialize vault schema...")
+	if _, err := migrateVault(path, db, mk); err != nil {
+		// If migrations fail, clean up
+		db.Close()
+		_ = secretstore.Default.Delete(path)
+		return nil, nil, fmt.Errorf("failed to initialize vault schema: %w", err)
+	}
+	return db, mk, nil
+}
+
 var openCmd = &cli.Command{
 	Name:      "open",
 	Usage:     "open <vault.db>     – check key exists and DB file is accessible",
@@ -139,23 +188,36 @@ var openCmd = &cli.Command{
 		// 1. Check if the key exists in the secret store
 		mk, err := secretstore.Default.Get(path)
 		if err != nil {
-			return fmt.Errorf("failed to get key from secret store (does it exist?): %w", err)
+			return fmt.Errorf("failed to get key from secret store (run 'bosr doctor %s' for help): %w", path, err)
 		}
 		log.Info().Str("path", path).Msg("Key found in secret store")
 
-		// 2. Try opening the plaintext DB file
-		db, err := sqlite.Open(path)
+		// 2. Try opening the plaintext DB file, bringing older vaults up to
+		// the current schema (e.g. the event log)
+		db, readOnly, err := openDB(path, mk)
 		if err != nil {
-			return fmt.Errorf("failed to open database file '%s': %w", path, err)
+			return err
 		}
 		defer db.Close() // Ensure DB is closed
 
 		// 3. Verify the key can decrypt data in the vault
 		secureDAO := dao.NewSecureVaultDAO(db, mk)
+		if retired := rotate.RetiredKey(path, secretstore.Default); retired != nil {
+			log.Warn().Msg("An online key rotation is unfinished; run 'bosr key rotate --online' to finish it")
+			secureDAO = secureDAO.WithRetiredKey(retired)
+		}
 		canaryKey := "__n1_canary__"
 		plaintext, err := secureDAO.Get(canaryKey)
 
 		if err == nil && string(plaintext) == "ok" {
+			// 4. Verify the integrity seal over all records and the rollback counter
+			if _, err := checkIntegrity(path, db, mk); err != nil {
+				return err
+			}
+			if readOnly != nil {
+				log.Info().Str("path", path).Msg("✓ Vault check complete: Key verified and database accessible read-only.")
+				return nil
+			}
 			log.Info().Str("path", path).Msg("✓ Vault check complete: Key verified and database accessible.")
 			return nil
 		} else if errors.Is(err, dao.ErrNotFound) {
@@ -193,10 +255,14 @@ var keyRotateCmd = &cli.Command{
 			Usage: "Simulate key rotation without making changes",
 			Value: false,
 		},
+		&cli.BoolFlag{
+			Name:  "online",
+			Usage: "Re-encrypt in place while the vault stays in use; rerun to resume",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		if c.NArg() != 1 {
-			return cli.Exit("Usage: key rotate [--dry-run] <vault.db>", 1)
+			return cli.Exit("Usage: key rotate [--dry-run] [--online] <vault.db>", 1)
 		}
 		path, err := filepath.Abs(c.Args().First())
 		if err != nil {
@@ -207,45 +273,51 @@ var keyRotateCmd = &cli.Command{
 		if dryRun {
 			fmt.Println("Running in dry-run mode - no changes will be made")
 		}
-
-		// 1. Pre-flight checks
-		originalPath := path
-		backupPath := originalPath + ".bak"
-		tempPath := originalPath + ".tmp"
-
-		// Check if backup or temp files already exist
-		if _, err := os.Stat(backupPath); err == nil {
-			return fmt.Errorf("backup file %s already exists; please remove it before proceeding", backupPath)
-		}
-		if _, err := os.Stat(tempPath); err == nil {
-			return fmt.Errorf("temporary file %s already exists; please remove it before proceeding", tempPath)
+		if c.Bool("online") {
+			if dryRun {
+				return cli.Exit("--dry-run cannot be combined with --online", 1)
+			}
+			return rotateOnline(c.Context, path)
 		}
-
-		// Check original file exists
-		originalInfo, err := os.Stat(originalPath)
-		if err != nil {
-			return fmt.Errorf("cannot access original vault at %s: %w", originalPath, err)
+		if rotate.RetiredKey(path, secretstore.Default) != nil {
+			return fmt.Errorf("%w; run 'bosr key rotate --online' to finish it", rotate.ErrOnlineRotation)
 		}
 
-		// Check available disk space
-		originalSize := originalInfo.Size()
-		requiredSpace := originalSize * 3 // Original + backup + temp
-
-		// Get available disk space (platform-specific)
-		var stat syscall.Statfs_t
-		if err := syscall.Statfs(filepath.Dir(originalPath), &stat); err != nil {
-			log.Warn().Err(err).Msg("Could not check available disk space")
+		// 1. Resolve a rotation that was interrupted, e.g. by a crash,
+		// before anything else looks at the vault files
+		rotator := rotate.New(path, secretstore.Default).
+			OnPhase(logRotationPhase).
+			OnProgress(func(done, total int64) { log.Info().Msgf("Migrating data... %d / %d", done, total) })
+		if dryRun {
+			if j, err := rotate.LoadJournal(path); err == nil {
+				log.Warn().Str("phase", string(j.Phase)).Msg("An interrupted key rotation would be recovered first")
+			}
 		} else {
-			availableSpace := stat.Bavail * uint64(stat.Bsize)
-			if uint64(requiredSpace) > availableSpace {
-				return fmt.Errorf("insufficient disk space: need approximately %d bytes, have %d bytes available",
-					requiredSpace, availableSpace)
+			outcome, err := rotator.Recover()
+			if err != nil {
+				return fmt.Errorf("failed to recover interrupted key rotation (see 'bosr recover'): %w", err)
+			}
+			switch outcome {
+			case rotate.OutcomeCompleted:
+				log.Info().Msg("Completed an interrupted key rotation; the vault now uses its new key")
+				return nil
+			case rotate.OutcomeRolledBack:
+				log.Info().Msg("Rolled back an interrupted key rotation; starting over")
 			}
 		}
 
-		// Warn if file is large
-		if originalSize > 1024*1024*1024 { // 1GB
-			log.Warn().Int64("size_bytes", originalSize).Msg("Vault file is very large, rotation may take significant time and disk space")
+		// 2. Pre-flight checks: leftover files of an earlier rotation and
+		// room for the backup and the re-encrypted copy
+		originalPath := path
+		space, err := rotate.Preflight(originalPath)
+		if err != nil {
+			return err
+		}
+		if !space.Measured {
+			log.Warn().Msg("Could not check available disk space")
+		}
+		if space.Large() {
+			log.Warn().Int64("size_bytes", space.Size).Msg("Vault file is very large, rotation may take significant time and disk space")
 			fmt.Print("Vault file is large (>1GB). Continue with rotation? (y/N): ")
 			reader := bufio.NewReader(os.Stdin)
 			response, err := reader.ReadString('\n')
@@ -258,31 +330,28 @@ var keyRotateCmd = &cli.Command{
 			}
 		}
 
-		// 2. Get old key from store
+		// 3. Get current key from store
 		oldMK, err := secretstore.Default.Get(originalPath)
 		if err != nil {
 			return fmt.Errorf("failed to get current key from secret store: %w", err)
 		}
 		log.Info().Msg("Retrieved current master key")
 
-		// 3. Generate new key
-		newMK, err := crypto.Generate(32)
-		if err != nil {
-			return fmt.Errorf("failed to generate new master key: %w", err)
-		}
-		log.Info().Msg("Generated new master key")
-
 		// Open original DB to list keys
-		originalDB, err := sqlite.Open(originalPath)
+		originalDB, err := sqlite.OpenFile(originalPath, oldMK, sqlite.DefaultOptions())
 		if err != nil {
 			return fmt.Errorf("failed to open database file '%s': %w", originalPath, err)
 		}
 
-		// Create a secure vault DAO with the old key
-		oldSecureDAO := dao.NewSecureVaultDAO(originalDB, oldMK)
+		// Bring older vaults up to the current schema. Seeding the event log
+		// matters here: the log is what gets carried over into the rotated vault.
+		if _, err := migrateVault(originalPath, originalDB, oldMK); err != nil {
+			originalDB.Close()
+			return fmt.Errorf("failed to migrate vault schema: %w", err)
+		}
 
 		// List all keys in the vault
-		keys, err := oldSecureDAO.List()
+		keys, err := dao.NewSecureVaultDAO(originalDB, oldMK).List()
 		if err != nil {
 			originalDB.Close()
 			return fmt.Errorf("failed to list vault keys: %w", err)
@@ -300,166 +369,86 @@ var keyRotateCmd = &cli.Command{
 			return nil
 		}
 
-		// Close the original DB before copying
-		originalDB.Close()
-
-		// 4. Create backup
-		log.Info().Str("backup_path", backupPath).Msg("Creating backup of original vault...")
-		if err := copyFile(originalPath, backupPath); err != nil {
-			return fmt.Errorf("failed to create backup: %w", err)
-		}
-		log.Info().Msg("Backup created successfully")
-
-		// Function to clean up on failure
-		cleanup := func(keepBackup bool) {
-			log.Debug().Msg("Running cleanup...")
-			if _, err := os.Stat(tempPath); err == nil {
-				if err := os.Remove(tempPath); err != nil {
-					log.Warn().Err(err).Str("path", tempPath).Msg("Failed to remove temporary file during cleanup")
-				} else {
-					log.Debug().Str("path", tempPath).Msg("Removed temporary file")
-				}
-			}
-
-			if !keepBackup {
-				if _, err := os.Stat(backupPath); err == nil {
-					if err := os.Remove(backupPath); err != nil {
-						log.Warn().Err(err).Str("path", backupPath).Msg("Failed to remove backup file during cleanup")
-					} else {
-						log.Debug().Str("path", backupPath).Msg("Removed backup file")
-					}
-				}
-			}
-		}
-
-		// 5. Setup temp DB
-		log.Info().Str("temp_path", tempPath).Msg("Creating temporary database...")
-		tempDB, err := sqlite.Open(tempPath)
-		if err != nil {
-			cleanup(true) // Keep backup on failure
-			return fmt.Errorf("failed to create temporary database: %w", err)
-		}
-
-		// Initialize schema in temp DB
-		if err := migrations.BootstrapVault(tempDB); err != nil {
-			tempDB.Close()
-			cleanup(true) // Keep backup on failure
-			return fmt.Errorf("failed to initialize schema in temporary database: %w", err)
-		}
-
-		// 6. Open original DB again
-		originalDB, err = sqlite.Open(originalPath)
-		if err != nil {
-			tempDB.Close()
-			cleanup(true) // Keep backup on failure
-			return fmt.Errorf("failed to reopen original database: %w", err)
-		}
-
-		// 7. Migrate data with progress
-		log.Info().Msg("Migrating data to temporary database with new key...")
-		oldSecureDAO = dao.NewSecureVaultDAO(originalDB, oldMK)
-		tempRawDAO := dao.NewVaultDAO(tempDB)
-
-		for i, k := range keys {
-			// Show progress
-			log.Info().Msgf("Migrating data... %d / %d", i+1, len(keys))
-
-			// Get and decrypt with old key
-			plaintext, err := oldSecureDAO.Get(k)
-			if err != nil {
-				originalDB.Close()
-				tempDB.Close()
-				cleanup(true) // Keep backup on failure
-				return fmt.Errorf("failed to get value for key %s during rotation: %w", k, err)
-			}
-
-			// Encrypt with new key
-			newCiphertext, err := crypto.EncryptBlob(newMK, plaintext)
-			if err != nil {
-				originalDB.Close()
-				tempDB.Close()
-				cleanup(true) // Keep backup on failure
-				return fmt.Errorf("failed to encrypt value for key %s during rotation: %w", k, err)
-			}
-
-			// Store in temp DB
-			err = tempRawDAO.Put(k, newCiphertext)
-			if err != nil {
-				originalDB.Close()
-				tempDB.Close()
-				cleanup(true) // Keep backup on failure
-				return fmt.Errorf("failed to store value for key %s in temporary database: %w", k, err)
-			}
+		// Never carry a tampered or rolled-back vault over into a freshly sealed one
+		if _, err := checkIntegrity(originalPath, originalDB, oldMK); err != nil {
+			originalDB.Close()
+			return err
 		}
 
-		// 8. Close DBs
+		// Close the original DB before copying
 		originalDB.Close()
-		tempDB.Close()
-		log.Info().Msg("Data migration completed successfully")
-
-		// 9. Update key store
-		log.Info().Msg("Updating key store with new master key...")
-		if err := secretstore.Default.Put(originalPath, newMK); err != nil {
-			cleanup(true) // Keep backup on failure
-			return fmt.Errorf("failed to update master key in secret store: %w", err)
-		}
-		log.Info().Msg("Key store updated successfully")
-
-		// 10. Atomic replace
-		log.Info().Msg("Replacing original vault with new vault...")
-		if err := os.Rename(tempPath, originalPath); err != nil {
-			// Critical failure: key store has new key but original DB is still old
-			log.Error().Err(err).Msg("CRITICAL: Failed to replace original vault with new vault")
-			log.Error().Msg("The key store has been updated with the new key, but the rename operation failed.")
-			log.Error().Msgf("You need to manually rename %s to %s", tempPath, originalPath)
-			return fmt.Errorf("failed to replace original vault with new vault: %w", err)
-		}
 
-		// 11. Delete backup
-		log.Info().Msg("Removing backup file...")
-		if err := os.Remove(backupPath); err != nil {
-			log.Warn().Err(err).Msg("Failed to remove backup file, but key rotation was successful")
-			log.Warn().Msgf("You may want to manually remove the backup file: %s", backupPath)
+		// 4. Back up, re-encrypt into a temporary vault, stage the new key and
+		// swap, journaling each phase. Failures are rolled back; a crash is
+		// resolved by the next 'key rotate' or 'recover'.
+		log.Info().Str("backup_path", rotate.BackupPath(originalPath)).Str("temp_path", rotate.TempPath(originalPath)).Msg("Rotating master key...")
+		if err := rotator.Run(); err != nil {
+			return fmt.Errorf("key rotation failed: %w", err)
 		}
 
-		// 12. Report success
 		log.Info().Msg("Key rotation completed successfully")
 		return nil
 	},
 }
 
-// Helper function to copy a file
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+// rotateOnline switches the vault to a new key at once and re-encrypts it in
+// place while other processes keep using it. Interrupting it is safe: running
+// it again resumes where it stopped.
+func rotateOnline(ctx context.Context, path string) error {
+	mk, err := secretstore.Default.Get(path)
 	if err != nil {
-		return fmt.Errorf("failed to open source file: %w", err)
+		return fmt.Errorf("failed to get current key from secret store: %w", err)
 	}
-	defer sourceFile.Close()
-
-	destFile, err := os.Create(dst)
+	db, readOnly, err := openDB(path, mk)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
+		return err
+	}
+	defer db.Close()
+	if readOnly != nil {
+		return fmt.Errorf("cannot rotate the key: %w", readOnly)
 	}
-	defer destFile.Close()
 
-	buf := make([]byte, 1024*1024) // 1MB buffer
-	for {
-		n, err := sourceFile.Read(buf)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("error reading from source file: %w", err)
-		}
-		if n == 0 {
-			break
-		}
+	resuming := rotate.RetiredKey(path, secretstore.Default) != nil
+	rotator := rotate.NewOnline(path, secretstore.Default).
+		OnProgress(func(done, total int64) { log.Info().Msgf("Re-encrypting... %d / %d", done, total) })
+	vault, err := rotator.Start(db)
+	if err != nil {
+		return fmt.Errorf("failed to start online key rotation: %w", err)
+	}
+	if resuming {
+		log.Info().Msg("Resuming online key rotation")
+	} else {
+		log.Info().Msg("Online key rotation started; new writes use the new key")
+	}
 
-		if _, err := destFile.Write(buf[:n]); err != nil {
-			return fmt.Errorf("error writing to destination file: %w", err)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := rotator.Run(ctx, vault); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("online key rotation interrupted; run 'bosr key rotate --online' again to resume")
 		}
+		return fmt.Errorf("online key rotation failed: %w", err)
 	}
-
+	log.Info().Msg("Online key rotation completed successfully")
 	return nil
 }
 
+// logRotationPhase reports each journaled phase of a key rotation
+func logRotationPhase(p rotate.Phase) {
+	switch p {
+	case rotate.PhaseStarted:
+		log.Info().Msg("Generated new master key")
+	case rotate.PhaseBackedUp:
+		log.Info().Msg("Backup created successfully")
+	case rotate.PhasePopulated:
+		log.Info().Msg("Data migration completed successfully")
+	case rotate.PhaseKeyStaged:
+		log.Info().Msg("New master key staged in secret store")
+	case rotate.PhaseSwapped:
+		log.Info().Msg("Replaced original vault with new vault")
+	}
+}
+
 // Helper function to create a SecureVaultDAO
 func NewSecureVaultDAO(db *sql.DB, key []byte) *dao.SecureVaultDAO {
 	return dao.NewSecureVaultDAO(db, key)
@@ -468,74 +457,140 @@ func NewSecureVaultDAO(db *sql.DB, key []byte) *dao.SecureVaultDAO {
 var putCmd = &cli.Command{
 	Name:      "put",
 	Usage:     "put <vault.db> <key> <value>  – store an encrypted value",
-	ArgsUsage: "<path> <key> <value>",
+	ArgsUsage: "<path> <key> [value]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "type",
+			Usage: "Store a structured record of this type (" + recordTypeList() + ")",
+		},
+		&cli.StringSliceFlag{
+			Name:  "field",
+			Usage: "Set a record field as name=value (repeatable, requires --type)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "field-file",
+			Usage: "Set a record field from a file as name=path (repeatable, requires --type)",
+		},
+		&cli.StringFlag{
+			Name:  "scope",
+			Usage: "File the record under this scope (default: inbox for new records, unchanged otherwise)",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Allow writing to a record in a read-only scope",
+		},
+	},
 	Action: func(c *cli.Context) error {
-		if c.NArg() != 3 {
+		recordType := c.String("type")
+		if recordType == "" && (len(c.StringSlice("field")) > 0 || len(c.StringSlice("field-file")) > 0) {
+			return cli.Exit("--field and --field-file require --type", 1)
+		}
+		if recordType == "" && c.NArg() != 3 {
 			return cli.Exit("Usage: put <vault.db> <key> <value>", 1)
 		}
-		path, err := filepath.Abs(c.Args().First())
-		if err != nil {
-			return fmt.Errorf("failed to get absolute path: %w", err)
+		if recordType != "" && c.NArg() != 2 {
+			return cli.Exit("Usage: put --type <type> --field name=value ... <vault.db> <key>", 1)
 		}
 		key := c.Args().Get(1)
-		value := c.Args().Get(2)
 
-		// 1. Get the master key from the secret store
-		mk, err := secretstore.Default.Get(path)
-		if err != nil {
-			return fmt.Errorf("failed to get key from secret store: %w", err)
+		var value []byte
+		var err error
+		if recordType != "" {
+			value, err = buildRecord(recordType, c.StringSlice("field"), c.StringSlice("field-file"))
+			if err != nil {
+				return err
+			}
+		} else {
+			value = []byte(c.Args().Get(2))
 		}
 
-		// 2. Open the database
-		db, err := sqlite.Open(path)
+		// 1-3. Get the master key, open the database and create a secure vault DAO
+		_, db, vault, err := openVault(c.Args().First())
 		if err != nil {
-			return fmt.Errorf("failed to open database file '%s': %w", path, err)
+			return err
 		}
 		defer db.Close()
-
-		// 3. Create a secure vault DAO
-		vault := dao.NewSecureVaultDAO(db, mk)
+		if c.Bool("force") {
+			vault = vault.WithForce()
+		}
 
 		// 4. Store the value
-		if err := vault.Put(key, []byte(value)); err != nil {
+		if err := vault.Apply(&dao.Event{Type: dao.EventPut, Key: key, Value: value, Scope: c.String("scope")}); err != nil {
 			return fmt.Errorf("failed to store value: %w", err)
 		}
 
-		log.Info().Str("key", key).Msg("Value stored successfully")
+		log.Info().Str("key", key).Str("type", recordType).Msg("Value stored successfully")
 		return nil
 	},
 }
 
+// buildRecord assembles and encodes a structured record from --field and --field-file flags
+func buildRecord(recordType string, fields, fieldFiles []string) ([]byte, error) {
+	r, err := record.New(record.Type(recordType))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --type: %w", err)
+	}
+	for _, f := range fields {
+		name, value, err := record.ParseAssignment(f)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range fieldFiles {
+		name, file, err := record.ParseAssignment(f)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read field %s from %s: %w", name, file, err)
+		}
+		if err := r.Set(name, string(content)); err != nil {
+			return nil, err
+		}
+	}
+	return record.Encode(r)
+}
+
+// recordTypeList returns the supported record types for flag help texts
+func recordTypeList() string {
+	var names []string
+	for _, t := range record.Types() {
+		names = append(names, string(t))
+	}
+	return strings.Join(names, ", ")
+}
+
 var getCmd = &cli.Command{
 	Name:      "get",
 	Usage:     "get <vault.db> <key>  – retrieve an encrypted value",
 	ArgsUsage: "<path> <key>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "field",
+			Usage: "Print only this field of a structured record",
+		},
+		&cli.BoolFlag{
+			Name:  "show-secrets",
+			Usage: "Reveal secret fields when printing a whole structured record",
+		},
+	},
 	Action: func(c *cli.Context) error {
 		if c.NArg() != 2 {
 			return cli.Exit("Usage: get <vault.db> <key>", 1)
 		}
-		path, err := filepath.Abs(c.Args().First())
-		if err != nil {
-			return fmt.Errorf("failed to get absolute path: %w", err)
-		}
 		key := c.Args().Get(1)
 
-		// 1. Get the master key from the secret store
-		mk, err := secretstore.Default.Get(path)
-		if err != nil {
-			return fmt.Errorf("failed to get key from secret store: %w", err)
-		}
-
-		// 2. Open the database
-		db, err := sqlite.Open(path)
+		// 1-3. Get the master key, open the database and create a secure vault DAO
+		_, db, vault, err := openVault(c.Args().First())
 		if err != nil {
-			return fmt.Errorf("failed to open database file '%s': %w", path, err)
+			return err
 		}
 		defer db.Close()
 
-		// 3. Create a secure vault DAO
-		vault := dao.NewSecureVaultDAO(db, mk)
-
 		// 4. Retrieve the value
 		value, err := vault.Get(key)
 		if err != nil {
@@ -545,8 +600,26 @@ var getCmd = &cli.Command{
 			return fmt.Errorf("failed to retrieve value: %w", err)
 		}
 
-		// Still print the value to stdout for CLI usage
-		fmt.Printf("%s\n", string(value))
+		// 5. Structured records are printed field-wise, raw values verbatim
+		rec, err := record.Decode(value)
+		switch {
+		case errors.Is(err, record.ErrNotRecord):
+			if c.String("field") != "" {
+				return fmt.Errorf("key '%s' holds a plain value, not a structured record", key)
+			}
+			// Still print the value to stdout for CLI usage
+			fmt.Printf("%s\n", string(value))
+		case err != nil:
+			return fmt.Errorf("failed to decode record: %w", err)
+		case c.String("field") != "":
+			field, ok := rec.Get(c.String("field"))
+			if !ok {
+				return fmt.Errorf("record '%s' has no field '%s'", key, c.String("field"))
+			}
+			fmt.Printf("%s\n", field)
+		default:
+			fmt.Print(record.Format(rec, c.Bool("show-secrets")))
+		}
 		log.Debug().Str("key", key).Int("value_size", len(value)).Msg("Value retrieved successfully")
 		return nil
 	},
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/n1/n1/internal/log"
+	"github.com/n1/n1/internal/rotate"
+	"github.com/n1/n1/internal/secretstore"
+
+	"github.com/urfave/cli/v2"
+)
+
+var recoverCmd = &cli.Command{
+	Name:      "recover",
+	Usage:     "recover [--old-key FILE] [--yes] <vault.db>  – resolve an interrupted key rotation or repair its leftover files",
+	ArgsUsage: "<path>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "old-key",
+			Usage: "file holding another key to try on the vault and its leftover files (raw, hex or base64)",
+		},
+		&cli.BoolFlag{
+			Name:    "yes",
+			Aliases: []string{"y"},
+			Usage:   "repair without asking for confirmation",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: recover [--old-key FILE] [--yes] <vault.db>", 1)
+		}
+		path, err := filepath.Abs(c.Args().First())
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path: %w", err)
+		}
+
+		j, err := rotate.LoadJournal(path)
+		if err != nil && !errors.Is(err, rotate.ErrNoJournal) {
+			return err
+		}
+		if j != nil {
+			log.Info().Str("phase", string(j.Phase)).Time("started_at", j.StartedAt).
+				Str("old_key", j.OldKey).Str("new_key", j.NewKey).Msg("Found an interrupted key rotation")
+
+			outcome, err := rotate.New(path, secretstore.Default).Recover()
+			if err == nil {
+				switch outcome {
+				case rotate.OutcomeCompleted:
+					log.Info().Msg("✓ Key rotation completed; the vault now uses its new key")
+				case rotate.OutcomeRolledBack:
+					log.Info().Msg("✓ Key rotation rolled back; the vault keeps its old key")
+				}
+				return nil
+			}
+			log.Warn().Err(err).Msg("Could not resolve the rotation from its journal; inspecting its files instead")
+		}
+
+		var provided [][]byte
+		if name := c.String("old-key"); name != "" {
+			key, err := readKeyFile(name)
+			if err != nil {
+				return err
+			}
+			provided = append(provided, key)
+		}
+
+		plan, err := rotate.Inspect(path, secretstore.Default, provided...)
+		if plan != nil {
+			printPlan(plan)
+		}
+		if errors.Is(err, rotate.ErrNoKey) {
+			return fmt.Errorf("%w; pass the key it was last rotated from with --old-key", err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to inspect vault files: %w", err)
+		}
+		if plan.Empty() {
+			log.Info().Str("path", path).Msg("No interrupted key rotation found; nothing to recover")
+			return nil
+		}
+
+		if !c.Bool("yes") {
+			fmt.Print("Apply these changes? (y/N): ")
+			reader := bufio.NewReader(os.Stdin)
+			response, err := reader.ReadString('\n')
+			if err != nil && response == "" {
+				return fmt.Errorf("failed to read user input: %w", err)
+			}
+			response = strings.TrimSpace(strings.ToLower(response))
+			if response != "y" && response != "yes" {
+				return fmt.Errorf("recovery cancelled by user")
+			}
+		}
+
+		if err := rotate.Repair(plan, secretstore.Default); err != nil {
+			return fmt.Errorf("failed to repair vault: %w", err)
+		}
+		log.Info().Str("key", plan.Keep.Fingerprint).Msg("✓ Vault repaired; it opens with the key in the secret store")
+		if plan.Aside != "" {
+			log.Warn().Str("path", plan.Aside).Msg("The unreadable vault was kept; delete it once you no longer need it")
+		}
+		return nil
+	},
+}
+
+// printPlan shows which key opens each of the vault's files and what a
+// repair would change
+func printPlan(p *rotate.Plan) {
+	fmt.Println(p.Path)
+	for _, f := range p.Files {
+		switch {
+		case !f.Exists:
+			fmt.Printf("  %-7s missing\n", f.Role)
+		case f.Opens():
+			fmt.Printf("  %-7s %d bytes, opens with key %s (%s)\n", f.Role, f.Size, f.Fingerprint, f.Source)
+		default:
+			fmt.Printf("  %-7s %d bytes, no known key opens it: %v\n", f.Role, f.Size, f.Err)
+		}
+	}
+	if p.Keep == nil {
+		return
+	}
+	for _, step := range p.Steps() {
+		fmt.Printf("  → %s\n", step)
+	}
+}
+
+// readKeyFile reads a 32-byte master key stored raw, in hex or in base64
+func readKeyFile(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s does not hold a 32-byte key (raw, hex or base64)", name)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/log"
+
+	"github.com/urfave/cli/v2"
+)
+
+var mvCmd = &cli.Command{
+	Name:      "mv",
+	Usage:     "mv --scope <scope> <vault.db> <key>  – file a record under another scope",
+	ArgsUsage: "<path> <key>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "scope",
+			Usage:    "Scope to move the record to",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Allow moving a record out of a read-only scope",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit("Usage: mv [--force] --scope <scope> <vault.db> <key>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if c.Bool("force") {
+			vault = vault.WithForce()
+		}
+
+		key, scope := c.Args().Get(1), c.String("scope")
+		if err := vault.Move(key, scope); err != nil {
+			return fmt.Errorf("failed to move %s: %w", key, err)
+		}
+
+		log.Info().Str("key", key).Str("scope", scope).Msg("Record moved")
+		return nil
+	},
+}
+
+var scopeCmd = &cli.Command{
+	Name:  "scope",
+	Usage: "scope <subcommand> <vault.db> – manage scopes",
+	Subcommands: []*cli.Command{
+		scopeListCmd,
+		scopeAddCmd,
+		scopeRemoveCmd,
+	},
+}
+
+var scopeListCmd = &cli.Command{
+	Name:      "ls",
+	Usage:     "ls <vault.db>  – list scopes, their policies and record counts",
+	ArgsUsage: "<path>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: scope ls <vault.db>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		scopes, err := vault.Scopes()
+		if err != nil {
+			return fmt.Errorf("failed to list scopes: %w", err)
+		}
+		for _, s := range scopes {
+			keys, err := vault.ListScope(s.Name)
+			if err != nil {
+				return fmt.Errorf("failed to list scope %s: %w", s.Name, err)
+			}
+			origin := "custom"
+			if s.Builtin {
+				origin = "builtin"
+			}
+			fmt.Printf("%s\t%s\t%s\t%d\n", s.Name, s.Policy, origin, len(keys))
+		}
+		return nil
+	},
+}
+
+var scopeAddCmd = &cli.Command{
+	Name:      "add",
+	Usage:     "add <vault.db> <name>  – define a new scope",
+	ArgsUsage: "<path> <name>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "Refuse changes to records in the scope unless forced",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit("Usage: scope add [--read-only] <vault.db> <name>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		s := dao.Scope{Name: c.Args().Get(1), Policy: dao.PolicyOpen}
+		if c.Bool("read-only") {
+			s.Policy = dao.PolicyReadOnly
+		}
+		if err := vault.DefineScope(s); err != nil {
+			return fmt.Errorf("failed to add scope: %w", err)
+		}
+
+		log.Info().Str("scope", s.Name).Str("policy", string(s.Policy)).Msg("Scope added")
+		return nil
+	},
+}
+
+var scopeRemoveCmd = &cli.Command{
+	Name:      "rm",
+	Usage:     "rm <vault.db> <name>  – remove an empty user-defined scope",
+	ArgsUsage: "<path> <name>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit("Usage: scope rm <vault.db> <name>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		name := c.Args().Get(1)
+		if err := vault.RemoveScope(name); err != nil {
+			return fmt.Errorf("failed to remove scope: %w", err)
+		}
+
+		log.Info().Str("scope", name).Msg("Scope removed")
+		return nil
+	},
+}
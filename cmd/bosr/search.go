@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/n1/n1/internal/log"
+
+	"github.com/urfave/cli/v2"
+)
+
+var searchCmd = &cli.Command{
+	Name:      "search",
+	Usage:     `search <vault.db> <query>  – full-text search (words, "phrases", prefix*, -excluded) or --semantic similarity search`,
+	ArgsUsage: "<path> <query>",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "Maximum number of results (0 for all)",
+			Value: 20,
+		},
+		&cli.BoolFlag{
+			Name:  "semantic",
+			Usage: "Rank records by similarity to the query text instead of matching words",
+		},
+		&cli.BoolFlag{
+			Name:  "reindex",
+			Usage: "Rebuild the search index before searching",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit(`Usage: search [--semantic] [--limit n] [--reindex] <vault.db> "<query>"`, 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		// Vaults written before the index existed are indexed on first search
+		reindex := c.Bool("reindex")
+		if !reindex && !vault.IsReadOnly() {
+			if reindex, err = vault.SearchIndexStale(); err != nil {
+				return err
+			}
+		}
+		if reindex {
+			n, err := vault.Reindex()
+			if err != nil {
+				return fmt.Errorf("failed to rebuild search index: %w", err)
+			}
+			log.Info().Int("records", n).Msg("Search index rebuilt")
+		}
+
+		search := vault.Search
+		if c.Bool("semantic") {
+			search = vault.SemanticSearch
+		}
+		results, err := search(c.Args().Get(1), c.Int("limit"))
+		if err != nil {
+			return fmt.Errorf("search failed: %w", err)
+		}
+		for _, r := range results {
+			fmt.Printf("%s\t%.3f\n", r.Key, r.Score)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/n1/n1/internal/log"
+
+	"github.com/urfave/cli/v2"
+)
+
+var tagCmd = &cli.Command{
+	Name:  "tag",
+	Usage: "tag <subcommand> <vault.db> – manage record tags and name=value labels",
+	Subcommands: []*cli.Command{
+		tagAddCmd,
+		tagRemoveCmd,
+		tagListCmd,
+	},
+}
+
+var tagAddCmd = &cli.Command{
+	Name:      "add",
+	Usage:     "add <vault.db> <key> <tag>...  – attach tags or name=value labels to a record",
+	ArgsUsage: "<path> <key> <tag>...",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Allow tagging a record in a read-only scope",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 3 {
+			return cli.Exit("Usage: tag add [--force] <vault.db> <key> <tag>...", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if c.Bool("force") {
+			vault = vault.WithForce()
+		}
+
+		key, tags := c.Args().Get(1), c.Args().Slice()[2:]
+		if err := vault.Tag(key, tags...); err != nil {
+			return fmt.Errorf("failed to tag %s: %w", key, err)
+		}
+
+		log.Info().Str("key", key).Strs("tags", tags).Msg("Tags added")
+		return nil
+	},
+}
+
+var tagRemoveCmd = &cli.Command{
+	Name:      "rm",
+	Usage:     "rm <vault.db> <key> <tag>...  – remove tags or labels from a record",
+	ArgsUsage: "<path> <key> <tag>...",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "Allow untagging a record in a read-only scope",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 3 {
+			return cli.Exit("Usage: tag rm [--force] <vault.db> <key> <tag>...", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if c.Bool("force") {
+			vault = vault.WithForce()
+		}
+
+		key, tags := c.Args().Get(1), c.Args().Slice()[2:]
+		if err := vault.Untag(key, tags...); err != nil {
+			return fmt.Errorf("failed to untag %s: %w", key, err)
+		}
+
+		log.Info().Str("key", key).Strs("tags", tags).Msg("Tags removed")
+		return nil
+	},
+}
+
+var tagListCmd = &cli.Command{
+	Name:      "ls",
+	Usage:     "ls <vault.db> <key>  – print the tags of a record, one per line",
+	ArgsUsage: "<path> <key>",
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.Exit("Usage: tag ls <vault.db> <key>", 1)
+		}
+		_, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		key := c.Args().Get(1)
+		if _, err := vault.ScopeOf(key); err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		labels, err := vault.Labels(key)
+		if err != nil {
+			return err
+		}
+		for _, l := range labels {
+			fmt.Println(l)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/n1/n1/internal/backup"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/log"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/n1/n1/internal/rotate"
+	"github.com/n1/n1/internal/secretstore"
+	"github.com/n1/n1/internal/sqlite"
+)
+
+// openVault resolves the vault path, fetches its master key from the secret
+// store and opens the database. Callers must close the returned handle.
+func openVault(arg string) (string, *sql.DB, *dao.SecureVaultDAO, error) {
+	path, err := filepath.Abs(arg)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	mk, err := secretstore.Default.Get(path)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to get key from secret store (run 'bosr doctor %s' for help): %w", path, err)
+	}
+
+	db, readOnly, err := openDB(path, mk)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	vault := dao.NewSecureVaultDAO(db, mk)
+	// Rows not yet re-encrypted by an unfinished online rotation still need
+	// the retired key
+	if retired := rotate.RetiredKey(path, secretstore.Default); retired != nil {
+		vault = vault.WithRetiredKey(retired)
+	}
+	if readOnly != nil {
+		vault = vault.ReadOnly(readOnly)
+	}
+
+	// Keep the pinned rollback counter in step with every committed write
+	_, pinned, err := integrity.Pinned(secretstore.Default, path)
+	if err != nil {
+		db.Close()
+		return "", nil, nil, err
+	}
+	if pinned {
+		vault.OnCommit(func(e *dao.Event) {
+			if err := integrity.Pin(secretstore.Default, path, e.Seq); err != nil {
+				log.Warn().Err(err).Msg("Failed to advance pinned rollback counter")
+			}
+		})
+	}
+
+	return path, db, vault, nil
+}
+
+// openDB opens the vault database and brings older vaults up to the current
+// schema. Vaults written by a newer bosr whose format is still understood
+// are opened read-only instead; readOnly then explains why.
+func openDB(path string, mk []byte) (db *sql.DB, readOnly error, err error) {
+	db, err = sqlite.OpenFile(path, mk, sqlite.DefaultOptions())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database file '%s': %w", path, err)
+	}
+
+	compat, err := migrateVault(path, db, mk)
+	if err == nil {
+		return db, nil, nil
+	}
+	db.Close()
+	if !errors.Is(err, migrations.ErrNewerSchema) || !compat.Readable() {
+		return nil, nil, fmt.Errorf("failed to migrate vault schema: %w", err)
+	}
+
+	log.Warn().Err(err).Msg("Opening vault read-only")
+	opts := sqlite.DefaultOptions()
+	opts.ReadOnly = true
+	db, openErr := sqlite.OpenFile(path, mk, opts)
+	if openErr != nil {
+		return nil, nil, fmt.Errorf("failed to open database file '%s' read-only: %w", path, openErr)
+	}
+	return db, err, nil
+}
+
+// migrateVault applies pending schema and data migrations, logging the
+// progress of long-running data migrations. Vaults that already hold data are
+// backed up next to the vault file first. Vaults written by a newer bosr are
+// left untouched and reported with migrations.ErrNewerSchema.
+func migrateVault(path string, db *sql.DB, mk []byte) (migrations.Compatibility, error) {
+	runner := migrations.NewVaultRunner(db).WithKey(mk).OnProgress(logMigrationProgress)
+	compat, err := runner.Compatibility()
+	if err != nil {
+		return compat, err
+	}
+	if err := compat.Err(); err != nil {
+		return compat, err
+	}
+	if compat.Pending == 0 {
+		return compat, nil
+	}
+
+	if compat.Version > 0 {
+		backupPath := fmt.Sprintf("%s.schema-v%d.bak", path, compat.Version)
+		if _, err := os.Stat(backupPath); err == nil {
+			// An earlier, interrupted upgrade already saved the pre-upgrade state
+			log.Info().Str("backup_path", backupPath).Msg("Keeping existing pre-migration backup")
+		} else {
+			if err := backup.Create(path, backupPath, mk); err != nil {
+				return compat, fmt.Errorf("failed to back up vault before migrating: %w", err)
+			}
+			log.Info().Str("backup_path", backupPath).Int("from", compat.Version).Int("to", compat.Latest).
+				Msg("Backed up vault before migrating its schema")
+		}
+	}
+	return compat, runner.Run()
+}
+
+func logMigrationProgress(m migrations.Migration, done, total int64) {
+	log.Info().Int("version", m.Version).Msgf("%s... %d / %d", m.Description, done, total)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/log"
+	"github.com/n1/n1/internal/rotate"
+	"github.com/n1/n1/internal/secretstore"
+	"github.com/n1/n1/internal/verify"
+
+	"github.com/urfave/cli/v2"
+)
+
+var verifyCmd = &cli.Command{
+	Name:      "verify",
+	Usage:     "verify <vault.db>  – check the database, schema, event log, every encrypted row, integrity seal and rollback counter",
+	ArgsUsage: "<path>",
+	Description: "Runs SQLite's integrity check, checks the schema, migrations and vault metadata, and\n" +
+		"decrypts every encrypted row in parallel. --json writes the report to stdout. Rows that\n" +
+		"do not decrypt can be moved into the vault's quarantine table with --quarantine; the\n" +
+		"records, labels, links and search index are then rebuilt from the event log. Unreadable\n" +
+		"events cannot be moved aside: restore such a vault from a backup.",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Write a machine-readable report to stdout",
+		},
+		&cli.BoolFlag{
+			Name:  "quarantine",
+			Usage: "Move unreadable rows into the quarantine table and rebuild them from the event log",
+		},
+		&cli.IntFlag{
+			Name:  "workers",
+			Usage: "Decrypt rows with `N` goroutines (default: one per CPU)",
+		},
+		&cli.BoolFlag{
+			Name:  "pin",
+			Usage: "Pin the current seal counter in the secret store to detect future rollbacks",
+		},
+		&cli.BoolFlag{
+			Name:  "unpin",
+			Usage: "Remove the pinned rollback counter",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.Exit("Usage: verify [--json] [--quarantine] [--pin|--unpin] <vault.db>", 1)
+		}
+		if c.Bool("pin") && c.Bool("unpin") {
+			return cli.Exit("--pin and --unpin are mutually exclusive", 1)
+		}
+		path, db, vault, err := openVault(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		workers := c.Int("workers")
+		report, err := verify.Run(db, vault, workers)
+		if err != nil {
+			return fmt.Errorf("failed to verify vault: %w", err)
+		}
+		if c.Bool("quarantine") && len(report.Rows.Unreadable) > 0 {
+			moved, err := vault.Quarantine(report.Rows.Unreadable)
+			if err != nil {
+				return fmt.Errorf("failed to quarantine unreadable rows: %w", err)
+			}
+			log.Warn().Int("rows", moved).Msg("Moved unreadable rows to the quarantine table and rebuilt them from the event log")
+			if report, err = verify.Run(db, vault, workers); err != nil {
+				return fmt.Errorf("failed to verify vault: %w", err)
+			}
+		}
+
+		// A vault whose rows fail is bound to fail its seal too; report both
+		seal, err := checkIntegrity(path, db, vault.Key())
+		if err != nil {
+			report.Fail(verify.CheckSeal, "%v", err)
+		}
+
+		if c.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			logReport(report)
+		}
+		if !report.OK {
+			return fmt.Errorf("vault verification failed with %d problems", len(report.Problems))
+		}
+
+		switch {
+		case c.Bool("pin"):
+			if err := integrity.Pin(secretstore.Default, path, seal.Counter); err != nil {
+				return err
+			}
+			log.Info().Int64("counter", seal.Counter).Msg("Rollback counter pinned")
+		case c.Bool("unpin"):
+			if err := integrity.Unpin(secretstore.Default, path); err != nil {
+				return fmt.Errorf("failed to remove pinned rollback counter: %w", err)
+			}
+			log.Info().Msg("Rollback counter unpinned")
+		}
+		return nil
+	},
+}
+
+// logReport logs the outcome of each check of a verification
+func logReport(r *verify.Report) {
+	failed := make(map[string]bool)
+	for _, p := range r.Problems {
+		failed[p.Check] = true
+		log.Error().Str("check", p.Check).Msg(p.Message)
+	}
+	for _, w := range r.Warnings {
+		log.Warn().Str("check", w.Check).Msg(w.Message)
+	}
+	if !failed[verify.CheckSQLite] {
+		log.Info().Msg("✓ SQLite integrity check passed")
+	}
+	if !failed[verify.CheckSchema] && !failed[verify.CheckMetadata] {
+		log.Info().Int("version", r.Schema.Version).Int("format", r.Schema.Format).Msg("✓ Schema and metadata verified")
+	}
+	if !failed[verify.CheckEvents] {
+		log.Info().Int64("events", r.Events).Msg("✓ Event log hash chain verified")
+	}
+	if !failed[verify.CheckRows] {
+		var rows int64
+		for _, n := range r.Rows.Checked {
+			rows += n
+		}
+		log.Info().Int64("rows", rows).Msg("✓ Every encrypted row decrypted")
+	}
+}
+
+// checkIntegrity verifies the vault's seal and rollback counter. A missing
+// seal means it was stripped, unless the vault has never been written, in
+// which case it is sealed now. Vaults that predate seals are sealed when they
+// are migrated.
+func checkIntegrity(path string, db *sql.DB, mk []byte) (*integrity.Seal, error) {
+	pinned, pinEnabled, err := integrity.Pinned(secretstore.Default, path)
+	if err != nil {
+		return nil, fmt.Errorf("vault integrity check failed: %w", err)
+	}
+
+	seal, err := integrity.Verify(db, mk)
+	// An online rotation that stopped right after switching keys has not
+	// resealed the vault with the new key yet
+	if retired := rotate.RetiredKey(path, secretstore.Default); errors.Is(err, integrity.ErrTampered) && retired != nil {
+		if sealed, _ := integrity.SealedWith(db, retired); sealed {
+			log.Warn().Msg("Vault is still sealed with the retired key; run 'bosr key rotate --online' to finish the rotation")
+			seal, err = integrity.Verify(db, retired)
+		}
+	}
+	switch {
+	case errors.Is(err, integrity.ErrUnsealed) && pinEnabled:
+		return nil, fmt.Errorf("vault integrity check failed: seal missing but rollback counter %d is pinned", pinned)
+	case errors.Is(err, integrity.ErrUnsealed):
+		if err := integrity.CheckUnsealed(db); err != nil {
+			return nil, fmt.Errorf("vault integrity check failed: %w", err)
+		}
+		log.Warn().Msg("Vault has no integrity seal yet; sealing the empty vault")
+		if seal, err = integrity.Update(db, mk); err != nil {
+			return nil, fmt.Errorf("failed to seal vault: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("vault integrity check failed: %w", err)
+	}
+	log.Info().Int64("counter", seal.Counter).Msg("✓ Integrity seal verified")
+
+	if err := integrity.CheckRollback(secretstore.Default, path, seal); err != nil {
+		return nil, fmt.Errorf("vault integrity check failed: %w", err)
+	}
+	return seal, nil
+}
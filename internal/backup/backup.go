@@ -0,0 +1,257 @@
+// Package backup takes consistent copies of a vault while it is in use and
+// restores them.
+//
+// Backups are written with SQLite's online backup API (see sqlite.Copy), so a
+// backup is a snapshot of committed transactions even while another process
+// writes to the vault, and it includes what is still in the write-ahead log.
+// A backup of a fully encrypted vault is fully encrypted under the same key.
+// Every backup is verified before it is given its final name, and again
+// before it is restored.
+package backup
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/sqlite"
+)
+
+// Suffix ends the names of timestamped backups
+const Suffix = ".backup"
+
+// timeLayout is the timestamp in backup names. It sorts chronologically and
+// is precise enough for a backup taken right before a restore.
+const timeLayout = "20060102T150405.000Z"
+
+// ErrCorrupt is returned by Verify for backups that fail SQLite's integrity
+// check or whose contents do not match their seal
+var ErrCorrupt = errors.New("backup is corrupt")
+
+// Backup is a timestamped backup of a vault
+type Backup struct {
+	Path string
+	Time time.Time
+}
+
+// Name returns the path of the backup of the vault at vaultPath taken at the
+// given time, in dir
+func Name(dir, vaultPath string, at time.Time) string {
+	return filepath.Join(dir, filepath.Base(vaultPath)+"."+at.UTC().Format(timeLayout)+Suffix)
+}
+
+// List returns the timestamped backups of the vault at vaultPath in dir,
+// newest first
+func List(dir, vaultPath string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	prefix := filepath.Base(vaultPath) + "."
+	var backups []Backup
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, Suffix); !ok {
+			continue
+		}
+		at, err := time.Parse(timeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Path: filepath.Join(dir, e.Name()), Time: at})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+// Create writes a verified backup of the vault at vaultPath to dest, which
+// must not exist yet. The backup is built under a temporary name and only
+// renamed to dest once it has been verified, so dest is never a partial copy.
+func Create(vaultPath, dest string, masterKey []byte) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("backup %s already exists", dest)
+	}
+	encrypted, err := sqlite.IsEncrypted(vaultPath)
+	if err != nil {
+		return err
+	}
+	opts := sqlite.DefaultOptions()
+	opts.ReadOnly = true
+	src, err := sqlite.OpenFile(vaultPath, masterKey, opts)
+	if err != nil {
+		return fmt.Errorf("failed to open vault: %w", err)
+	}
+	defer src.Close()
+
+	partial := dest + ".partial"
+	if err := removeDatabase(partial); err != nil {
+		return err
+	}
+	if err := write(src, partial, masterKey, encrypted); err != nil {
+		_ = removeDatabase(partial)
+		return err
+	}
+	// Backups hold the same secrets as the vault
+	if err := os.Chmod(partial, 0600); err != nil {
+		_ = removeDatabase(partial)
+		return fmt.Errorf("failed to restrict backup permissions: %w", err)
+	}
+	if _, err := Verify(partial, masterKey); err != nil {
+		_ = removeDatabase(partial)
+		return err
+	}
+	if err := os.Rename(partial, dest); err != nil {
+		_ = removeDatabase(partial)
+		return fmt.Errorf("failed to name backup: %w", err)
+	}
+	syncDir(filepath.Dir(dest))
+	return nil
+}
+
+// write copies src into a new database file at path
+func write(src *sql.DB, path string, masterKey []byte, encrypted bool) (err error) {
+	opts := sqlite.DefaultOptions()
+	opts.Synchronous = sqlite.SyncFull
+	opts.MaxOpenConns = 1
+	var dst *sql.DB
+	if encrypted {
+		dst, err = sqlite.OpenEncrypted(path, masterKey, opts)
+	} else {
+		dst, err = sqlite.OpenWith(path, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer func() {
+		// Closing the last connection checkpoints the copy into one file
+		if closeErr := dst.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close backup file: %w", closeErr)
+		}
+	}()
+	if err := sqlite.Copy(dst, src); err != nil {
+		return fmt.Errorf("failed to back up vault: %w", err)
+	}
+	if encrypted {
+		return nil
+	}
+	// The copy takes over the vault's WAL mode. A backup is not written to
+	// again, and in rollback journal mode opening it read-only to verify or
+	// restore it leaves no -wal and -shm files behind.
+	if _, err := dst.Exec("PRAGMA journal_mode = DELETE"); err != nil {
+		return fmt.Errorf("failed to leave WAL mode in backup file: %w", err)
+	}
+	return nil
+}
+
+// Verify checks that the backup at path passes SQLite's integrity check and
+// opens with masterKey as integrity.Authenticate decides: its seal must
+// verify under the key, and a backup without a seal is only accepted if it
+// has never been written or predates seals. It returns the seal, or nil for
+// unsealed backups.
+func Verify(path string, masterKey []byte) (*integrity.Seal, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	opts := sqlite.DefaultOptions()
+	opts.ReadOnly = true
+	db, err := sqlite.OpenFile(path, masterKey, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if result != "ok" {
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, result)
+	}
+
+	seal, err := integrity.Authenticate(db, masterKey)
+	if errors.Is(err, integrity.ErrTampered) {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("backup does not open with the vault key: %w", err)
+	}
+	return seal, nil
+}
+
+// Restore replaces the contents of the vault at vaultPath with the backup at
+// backupPath, after verifying that the backup opens with masterKey. The
+// vault is overwritten through SQLite, so processes that have a plaintext
+// vault open see the restored contents instead of a file swapped under them;
+// those holding a fully encrypted vault in memory fail with
+// sqlite.ErrConcurrentWrite on their next write. A vault that does not exist
+// yet is created, fully encrypted if the backup is.
+func Restore(backupPath, vaultPath string, masterKey []byte) (*integrity.Seal, error) {
+	seal, err := Verify(backupPath, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	opts := sqlite.DefaultOptions()
+	opts.ReadOnly = true
+	src, err := sqlite.OpenFile(backupPath, masterKey, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer src.Close()
+
+	var dst *sql.DB
+	if _, statErr := os.Stat(vaultPath); errors.Is(statErr, fs.ErrNotExist) {
+		encrypted, err := sqlite.IsEncrypted(backupPath)
+		if err != nil {
+			return nil, err
+		}
+		if encrypted {
+			dst, err = sqlite.OpenEncrypted(vaultPath, masterKey, sqlite.DefaultOptions())
+		} else {
+			dst, err = sqlite.Open(vaultPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create vault: %w", err)
+		}
+	} else if dst, err = sqlite.OpenFile(vaultPath, masterKey, sqlite.DefaultOptions()); err != nil {
+		return nil, fmt.Errorf("failed to open vault: %w", err)
+	}
+	if err := sqlite.Copy(dst, src); err != nil {
+		dst.Close()
+		return nil, fmt.Errorf("failed to restore vault: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close restored vault: %w", err)
+	}
+	if _, err := Verify(vaultPath, masterKey); err != nil {
+		return nil, fmt.Errorf("restored vault failed verification: %w", err)
+	}
+	return seal, nil
+}
+
+// removeDatabase removes a database file along with its journal files
+func removeDatabase(path string) error {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", path+suffix, err)
+		}
+	}
+	return nil
+}
+
+// syncDir makes renames in dir durable where the platform allows it
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/n1/n1/internal/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupVault creates a vault holding one record and returns it open
+func setupVault(t *testing.T, encrypted bool) (string, []byte, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vault.db")
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+
+	var db *sql.DB
+	if encrypted {
+		db, err = sqlite.OpenEncrypted(path, key, sqlite.DefaultOptions())
+	} else {
+		db, err = sqlite.Open(path)
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, migrations.MigrateVault(db, key))
+	require.NoError(t, dao.NewSecureVaultDAO(db, key).Put("bank", []byte("hunter2")))
+	return path, key, db
+}
+
+func get(t *testing.T, path string, key []byte, name string) string {
+	t.Helper()
+	db, err := sqlite.OpenFile(path, key, sqlite.DefaultOptions())
+	require.NoError(t, err)
+	defer db.Close()
+	value, err := dao.NewSecureVaultDAO(db, key).Get(name)
+	require.NoError(t, err)
+	return string(value)
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plaintext", true: "encrypted"}[encrypted], func(t *testing.T) {
+			path, key, db := setupVault(t, encrypted)
+			vault := dao.NewSecureVaultDAO(db, key)
+
+			dest := Name(filepath.Dir(path), path, timeAt(t, "2024-03-01T10:00:00Z"))
+			require.NoError(t, Create(path, dest, key))
+			assert.NoFileExists(t, dest+".partial")
+			backupEncrypted, err := sqlite.IsEncrypted(dest)
+			require.NoError(t, err)
+			assert.Equal(t, encrypted, backupEncrypted)
+			assert.Error(t, Create(path, dest, key), "an existing backup is never overwritten")
+
+			require.NoError(t, vault.Put("bank", []byte("changed")))
+			require.NoError(t, vault.Put("mail", []byte("added")))
+
+			seal, err := Restore(dest, path, key)
+			require.NoError(t, err)
+			require.NotNil(t, seal)
+			// Verifying and restoring leave nothing beside the backup
+			for _, name := range []string{dest + ".partial", dest} {
+				for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+					assert.NoFileExists(t, name+suffix)
+				}
+			}
+			assert.Equal(t, "hunter2", get(t, path, key, "bank"))
+			if !encrypted {
+				// Open handles see the restored vault
+				value, err := vault.Get("bank")
+				require.NoError(t, err)
+				assert.Equal(t, "hunter2", string(value))
+				_, err = vault.Get("mail")
+				assert.ErrorIs(t, err, dao.ErrNotFound)
+			}
+		})
+	}
+}
+
+// TestCreateWhileWriting backs a vault up while another handle writes to it:
+// every backup is a consistent, verified snapshot
+func TestCreateWhileWriting(t *testing.T) {
+	path, key, db := setupVault(t, false)
+	vault := dao.NewSecureVaultDAO(db, key)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			assert.NoError(t, vault.Put(fmt.Sprintf("key-%d", i), []byte("value")))
+		}
+	}()
+	dir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		dest := filepath.Join(dir, fmt.Sprintf("backup-%d.db", i))
+		require.NoError(t, Create(path, dest, key))
+		_, err := Verify(dest, key)
+		require.NoError(t, err)
+	}
+	wg.Wait()
+
+	// Committed writes still in the write-ahead log are part of the backup
+	dest := filepath.Join(dir, "final.db")
+	require.NoError(t, Create(path, dest, key))
+	assert.Equal(t, "value", get(t, dest, key, "key-49"))
+}
+
+func TestVerify(t *testing.T) {
+	path, key, _ := setupVault(t, false)
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	require.NoError(t, Create(path, dest, key))
+
+	otherKey, err := crypto.Generate(32)
+	require.NoError(t, err)
+	_, err = Verify(dest, otherKey)
+	assert.Error(t, err, "a backup must not pass for another vault's")
+	_, err = Restore(dest, path, otherKey)
+	assert.Error(t, err)
+	assert.Equal(t, "hunter2", get(t, path, key, "bank"), "a refused restore leaves the vault alone")
+
+	// Tampering with the records breaks the seal
+	tampered, err := sqlite.Open(dest)
+	require.NoError(t, err)
+	_, err = tampered.Exec("DELETE FROM vault")
+	require.NoError(t, err)
+	require.NoError(t, tampered.Close())
+	_, err = Verify(dest, key)
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	require.NoError(t, os.WriteFile(dest, []byte("not a database"), 0600))
+	_, err = Verify(dest, key)
+	assert.Error(t, err)
+}
+
+// TestRestoreStrippedSeal verifies that removing the seal from a tampered
+// backup does not get it restored
+func TestRestoreStrippedSeal(t *testing.T) {
+	path, key, db := setupVault(t, false)
+	require.NoError(t, dao.NewSecureVaultDAO(db, key).Put("mail", []byte("secret")))
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	require.NoError(t, Create(path, dest, key))
+
+	tampered, err := sqlite.Open(dest)
+	require.NoError(t, err)
+	_, err = tampered.Exec("DELETE FROM vault_meta WHERE name = 'integrity.seal'; DELETE FROM vault WHERE key = 'mail'")
+	require.NoError(t, err)
+	require.NoError(t, tampered.Close())
+
+	_, err = Verify(dest, key)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	_, err = Restore(dest, path, key)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.Equal(t, "secret", get(t, path, key, "mail"), "a refused restore leaves the vault alone")
+}
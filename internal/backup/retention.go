@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+)
+
+// Policy says which timestamped backups to keep. The newest backup is always
+// kept; a zero Policy keeps everything.
+type Policy struct {
+	// Daily keeps the newest backup of each of the last Daily days that
+	// have one
+	Daily int
+	// Weekly keeps the newest backup of each of the last Weekly ISO weeks
+	// that have one
+	Weekly int
+}
+
+// Expired returns the backups, newest first as returned by List, that the
+// policy does not keep
+func (p Policy) Expired(backups []Backup) []Backup {
+	if p.Daily <= 0 && p.Weekly <= 0 {
+		return nil
+	}
+	keep := make([]bool, len(backups))
+	if len(backups) > 0 {
+		keep[0] = true
+	}
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for i, b := range backups {
+		day := b.Time.UTC().Format("2006-01-02")
+		if !days[day] && len(days) < p.Daily {
+			days[day] = true
+			keep[i] = true
+		}
+		year, week := b.Time.UTC().ISOWeek()
+		key := fmt.Sprintf("%d-W%02d", year, week)
+		if !weeks[key] && len(weeks) < p.Weekly {
+			weeks[key] = true
+			keep[i] = true
+		}
+	}
+
+	var expired []Backup
+	for i, b := range backups {
+		if !keep[i] {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// Prune deletes the timestamped backups of the vault at vaultPath in dir
+// that the policy does not keep, and returns them
+func Prune(dir, vaultPath string, p Policy) ([]Backup, error) {
+	backups, err := List(dir, vaultPath)
+	if err != nil {
+		return nil, err
+	}
+	expired := p.Expired(backups)
+	for i, b := range expired {
+		if err := os.Remove(b.Path); err != nil {
+			return expired[:i], fmt.Errorf("failed to remove expired backup: %w", err)
+		}
+	}
+	return expired, nil
+}
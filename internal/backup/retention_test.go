@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func timeAt(t *testing.T, s string) time.Time {
+	t.Helper()
+	at, err := time.Parse(time.RFC3339, s)
+	require.NoError(t, err)
+	return at
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	vaultPath := filepath.Join(dir, "vault.db")
+	older := Name(dir, vaultPath, timeAt(t, "2024-03-01T10:00:00Z"))
+	newer := Name(dir, vaultPath, timeAt(t, "2024-03-02T10:00:00Z"))
+	for _, name := range []string{older, newer, vaultPath, vaultPath + ".bak", Name(dir, "other.db", time.Now())} {
+		require.NoError(t, os.WriteFile(name, nil, 0600))
+	}
+
+	backups, err := List(dir, vaultPath)
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	assert.Equal(t, newer, backups[0].Path)
+	assert.Equal(t, older, backups[1].Path)
+	assert.True(t, backups[1].Time.Equal(timeAt(t, "2024-03-01T10:00:00Z")))
+}
+
+func TestPolicyExpired(t *testing.T) {
+	var backups []Backup
+	for _, s := range []string{
+		"2024-03-20T18:00:00Z", // Wednesday, week 12
+		"2024-03-20T09:00:00Z",
+		"2024-03-19T09:00:00Z",
+		"2024-03-18T09:00:00Z", // Monday, week 12
+		"2024-03-15T09:00:00Z", // week 11
+		"2024-03-14T09:00:00Z",
+		"2024-03-06T09:00:00Z", // week 10
+		"2024-02-01T09:00:00Z", // week 5
+	} {
+		backups = append(backups, Backup{Path: s, Time: timeAt(t, s)})
+	}
+	paths := func(bs []Backup) []string {
+		var out []string
+		for _, b := range bs {
+			out = append(out, b.Path)
+		}
+		return out
+	}
+
+	assert.Empty(t, Policy{}.Expired(backups), "a zero policy keeps everything")
+	assert.Equal(t, paths(backups[1:]), paths(Policy{Daily: 1}.Expired(backups)))
+	assert.Equal(t,
+		[]string{"2024-03-20T09:00:00Z", "2024-03-15T09:00:00Z", "2024-03-14T09:00:00Z", "2024-03-06T09:00:00Z", "2024-02-01T09:00:00Z"},
+		paths(Policy{Daily: 3}.Expired(backups)))
+	assert.Equal(t,
+		[]string{"2024-03-20T09:00:00Z", "2024-03-19T09:00:00Z", "2024-03-18T09:00:00Z", "2024-03-14T09:00:00Z", "2024-02-01T09:00:00Z"},
+		paths(Policy{Weekly: 3}.Expired(backups)))
+	assert.Equal(t,
+		[]string{"2024-03-20T09:00:00Z", "2024-03-18T09:00:00Z", "2024-03-14T09:00:00Z", "2024-02-01T09:00:00Z"},
+		paths(Policy{Daily: 2, Weekly: 3}.Expired(backups)))
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	vaultPath := filepath.Join(dir, "vault.db")
+	for _, s := range []string{"2024-03-01T10:00:00Z", "2024-03-01T11:00:00Z", "2024-03-02T10:00:00Z"} {
+		require.NoError(t, os.WriteFile(Name(dir, vaultPath, timeAt(t, s)), nil, 0600))
+	}
+
+	removed, err := Prune(dir, vaultPath, Policy{Daily: 7})
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.NoFileExists(t, Name(dir, vaultPath, timeAt(t, "2024-03-01T10:00:00Z")))
+	backups, err := List(dir, vaultPath)
+	require.NoError(t, err)
+	assert.Len(t, backups, 2)
+}
@@ -0,0 +1,342 @@
+// Package bundle exports a vault to a portable, encrypted archive and imports
+// it into another vault.
+//
+// A bundle is a tar archive encrypted with age (https://age-encryption.org),
+// to X25519 recipients or to a passphrase. It is self-contained and does not
+// depend on the vault's master key: record values, scopes, labels and links
+// are stored in the clear inside the encryption, along with the full event
+// history. Importing into an empty vault replays the history, so the result
+// matches the exported vault event for event; importing into a vault that
+// already holds records merges the current records under a conflict policy.
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"filippo.io/age"
+	"github.com/n1/n1/internal/dao"
+)
+
+// FormatVersion is the bundle layout written by Export
+const FormatVersion = 1
+
+// Archive members, in the order Export writes them
+const (
+	manifestName = "manifest.json"
+	scopesName   = "scopes.json"
+	recordsName  = "records.jsonl"
+	linksName    = "links.jsonl"
+	historyName  = "history.jsonl"
+)
+
+// ErrFormat is returned for archives that are not bundles or that use a
+// newer format than this build understands
+var ErrFormat = errors.New("not a valid bundle")
+
+// Manifest describes a bundle
+type Manifest struct {
+	Format    int       `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+	Records   int       `json:"records"`
+	Links     int       `json:"links"`
+	Events    int       `json:"events"`
+}
+
+// Record is a vault record as stored in a bundle
+type Record struct {
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"`
+	Scope     string    `json:"scope"`
+	Labels    []string  `json:"labels,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Link is a link between two records as stored in a bundle
+type Link struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Type   string  `json:"type"`
+	Weight float64 `json:"weight"`
+}
+
+// Event is an entry of the vault's history as stored in a bundle
+type Event struct {
+	Seq       int64         `json:"seq"`
+	Type      dao.EventType `json:"type"`
+	Key       string        `json:"key"`
+	Value     []byte        `json:"value,omitempty"`
+	Scope     string        `json:"scope,omitempty"`
+	Labels    []string      `json:"labels,omitempty"`
+	Link      *Link         `json:"link,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// Bundle is the decrypted contents of a bundle
+type Bundle struct {
+	Manifest Manifest
+	// Scopes are the user-defined scopes
+	Scopes []dao.Scope
+	// Records are the vault's records, without n1 bookkeeping, sorted by key
+	Records []Record
+	Links   []Link
+	History []Event
+}
+
+// Read collects the contents of the vault into a Bundle. db is the database
+// vault reads from, for record timestamps.
+func Read(db *sql.DB, vault *dao.SecureVaultDAO) (*Bundle, error) {
+	b := &Bundle{Manifest: Manifest{Format: FormatVersion, CreatedAt: time.Now().UTC()}}
+
+	scopes, err := vault.Scopes()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range scopes {
+		if !s.Builtin {
+			b.Scopes = append(b.Scopes, s)
+		}
+	}
+
+	keys, err := vault.List()
+	if err != nil {
+		return nil, err
+	}
+	records := dao.NewVaultDAO(db)
+	for _, key := range keys {
+		if dao.IsInternalKey(key) {
+			continue
+		}
+		row, err := records.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := vault.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		labels, err := vault.Labels(key)
+		if err != nil {
+			return nil, err
+		}
+		b.Records = append(b.Records, Record{
+			Key:       key,
+			Value:     value,
+			Scope:     row.Scope,
+			Labels:    labels,
+			CreatedAt: row.CreatedAt.UTC(),
+			UpdatedAt: row.UpdatedAt.UTC(),
+		})
+	}
+	sort.Slice(b.Records, func(i, j int) bool { return b.Records[i].Key < b.Records[j].Key })
+
+	links, err := vault.AllLinks()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range links {
+		b.Links = append(b.Links, Link(l))
+	}
+
+	err = vault.Events().Each(func(e *dao.Event) error {
+		event := Event{
+			Seq:       e.Seq,
+			Type:      e.Type,
+			Key:       e.Key,
+			Value:     e.Value,
+			Scope:     e.Scope,
+			Labels:    e.Labels,
+			CreatedAt: e.CreatedAt.UTC(),
+		}
+		if e.Link != nil {
+			l := Link(*e.Link)
+			l.From = e.Key
+			event.Link = &l
+		}
+		b.History = append(b.History, event)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	b.Manifest.Records, b.Manifest.Links, b.Manifest.Events = len(b.Records), len(b.Links), len(b.History)
+	return b, nil
+}
+
+// Export writes the vault as a bundle encrypted to the recipients
+func Export(w io.Writer, db *sql.DB, vault *dao.SecureVaultDAO, recipients ...age.Recipient) error {
+	b, err := Read(db, vault)
+	if err != nil {
+		return err
+	}
+	return b.Encrypt(w, recipients...)
+}
+
+// Encrypt writes the bundle encrypted to the recipients
+func (b *Bundle) Encrypt(w io.Writer, recipients ...age.Recipient) error {
+	if len(recipients) == 0 {
+		return errors.New("no recipients to encrypt the bundle to")
+	}
+	enc, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt bundle: %w", err)
+	}
+	if err := b.WriteArchive(enc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt bundle: %w", err)
+	}
+	return nil
+}
+
+// WriteArchive writes the bundle as an unencrypted tar archive
+func (b *Bundle) WriteArchive(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: b.Manifest.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return nil
+	}
+
+	manifest, err := json.MarshalIndent(b.Manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	scopes, err := json.MarshalIndent(b.Scopes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode scopes: %w", err)
+	}
+	records, err := jsonLines(b.Records)
+	if err != nil {
+		return err
+	}
+	links, err := jsonLines(b.Links)
+	if err != nil {
+		return err
+	}
+	history, err := jsonLines(b.History)
+	if err != nil {
+		return err
+	}
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{
+		{manifestName, manifest},
+		{scopesName, scopes},
+		{recordsName, records},
+		{linksName, links},
+		{historyName, history},
+	} {
+		if err := add(m.name, m.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	return nil
+}
+
+// Open decrypts a bundle with the first of the identities that matches
+func Open(r io.Reader, identities ...age.Identity) (*Bundle, error) {
+	dec, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt bundle: %w", err)
+	}
+	return ReadArchive(dec)
+}
+
+// ReadArchive reads an unencrypted bundle archive
+func ReadArchive(r io.Reader) (*Bundle, error) {
+	b := &Bundle{}
+	seen := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", hdr.Name, err)
+		}
+		switch hdr.Name {
+		case manifestName:
+			err = json.Unmarshal(data, &b.Manifest)
+			if err == nil && b.Manifest.Format > FormatVersion {
+				return nil, fmt.Errorf("%w: format %d is newer than this build supports (%d)", ErrFormat, b.Manifest.Format, FormatVersion)
+			}
+		case scopesName:
+			err = json.Unmarshal(data, &b.Scopes)
+		case recordsName:
+			err = readLines(data, &b.Records)
+		case linksName:
+			err = readLines(data, &b.Links)
+		case historyName:
+			err = readLines(data, &b.History)
+		default:
+			// Members added by later format versions are skipped
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrFormat, hdr.Name, err)
+		}
+		seen[hdr.Name] = true
+	}
+	if !seen[manifestName] || b.Manifest.Format < 1 {
+		return nil, fmt.Errorf("%w: missing manifest", ErrFormat)
+	}
+	if len(b.Records) != b.Manifest.Records || len(b.Links) != b.Manifest.Links || len(b.History) != b.Manifest.Events {
+		return nil, fmt.Errorf("%w: contents do not match the manifest", ErrFormat)
+	}
+	return b, nil
+}
+
+// jsonLines encodes each element of a slice as one line of JSON
+func jsonLines[T any](items []T) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			return nil, fmt.Errorf("failed to encode bundle entry: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// readLines decodes one line of JSON per element into items
+func readLines[T any](data []byte, items *[]T) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var item T
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			return err
+		}
+		*items = append(*items, item)
+	}
+	return scanner.Err()
+}
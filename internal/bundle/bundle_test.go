@@ -0,0 +1,237 @@
+package bundle
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/holdr"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupVault(t *testing.T) (*sql.DB, *dao.SecureVaultDAO) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "vault.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	require.NoError(t, migrations.MigrateVault(db, key))
+	return db, dao.NewSecureVaultDAO(db, key)
+}
+
+// setupSource builds a vault using records, scopes, labels, links and holds
+func setupSource(t *testing.T) (*sql.DB, *dao.SecureVaultDAO) {
+	t.Helper()
+	db, vault := setupVault(t)
+	require.NoError(t, vault.WithInternalKeys().Put("__n1_canary__", []byte("ok")))
+	require.NoError(t, vault.DefineScope(dao.Scope{Name: "work", Policy: dao.PolicyOpen}))
+	require.NoError(t, vault.Put("bank", []byte("hunter2")))
+	require.NoError(t, vault.Put("bank", []byte("hunter3")))
+	require.NoError(t, vault.Tag("bank", "finance", "personal"))
+	require.NoError(t, vault.Move("bank", "safebox"))
+	require.NoError(t, vault.Put("mail", []byte("secret")))
+	require.NoError(t, vault.Move("mail", "work"))
+	require.NoError(t, vault.Put("gone", []byte("deleted")))
+	require.NoError(t, vault.Delete("gone"))
+	require.NoError(t, vault.Link(dao.Link{From: "mail", To: "bank", Type: dao.LinkRelated, Weight: 2}))
+	require.NoError(t, holdr.NewRepository(vault).Create(&holdr.Hold{Kind: "note", Body: json.RawMessage(`{"text":"hi"}`)}))
+	return db, vault
+}
+
+func export(t *testing.T, db *sql.DB, vault *dao.SecureVaultDAO) *Bundle {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, db, vault, identity.Recipient()))
+	b, err := Open(&buf, identity)
+	require.NoError(t, err)
+	return b
+}
+
+func TestExportOpen(t *testing.T) {
+	db, vault := setupSource(t)
+	b := export(t, db, vault)
+
+	assert.Equal(t, FormatVersion, b.Manifest.Format)
+	assert.Equal(t, []dao.Scope{{Name: "work", Policy: dao.PolicyOpen}}, b.Scopes)
+	require.Len(t, b.Records, 3, "bookkeeping and deleted records are left out")
+	bank := b.Records[0]
+	assert.Equal(t, "bank", bank.Key)
+	assert.Equal(t, []byte("hunter3"), bank.Value)
+	assert.Equal(t, "safebox", bank.Scope)
+	assert.Equal(t, []string{"finance", "personal"}, bank.Labels)
+	assert.False(t, bank.CreatedAt.IsZero())
+	assert.Equal(t, []Link{{From: "mail", To: "bank", Type: dao.LinkRelated, Weight: 2}}, b.Links)
+
+	count, err := vault.Events().Count()
+	require.NoError(t, err)
+	assert.Len(t, b.History, int(count))
+	assert.Equal(t, dao.EventPut, b.History[0].Type)
+	assert.Equal(t, "__n1_canary__", b.History[0].Key)
+}
+
+func TestOpenWithPassphrase(t *testing.T) {
+	db, vault := setupSource(t)
+	recipient, err := age.NewScryptRecipient("correct horse")
+	require.NoError(t, err)
+	recipient.SetWorkFactor(10)
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, db, vault, recipient))
+	sealed := buf.Bytes()
+
+	wrong, err := age.NewScryptIdentity("battery staple")
+	require.NoError(t, err)
+	_, err = Open(bytes.NewReader(sealed), wrong)
+	assert.Error(t, err)
+
+	identity, err := age.NewScryptIdentity("correct horse")
+	require.NoError(t, err)
+	b, err := Open(bytes.NewReader(sealed), identity)
+	require.NoError(t, err)
+	assert.Len(t, b.Records, 3)
+}
+
+func TestReadArchiveRejectsInvalid(t *testing.T) {
+	_, err := ReadArchive(bytes.NewReader([]byte("not a tar archive")))
+	assert.ErrorIs(t, err, ErrFormat)
+
+	var buf bytes.Buffer
+	b := &Bundle{Manifest: Manifest{Format: FormatVersion + 1}}
+	require.NoError(t, b.WriteArchive(&buf))
+	_, err = ReadArchive(&buf)
+	assert.ErrorIs(t, err, ErrFormat, "newer formats are refused")
+
+	buf.Reset()
+	b = &Bundle{Manifest: Manifest{Format: FormatVersion, Records: 1}}
+	require.NoError(t, b.WriteArchive(&buf))
+	_, err = ReadArchive(&buf)
+	assert.ErrorIs(t, err, ErrFormat, "truncated contents are refused")
+}
+
+func TestImportReplaysIntoEmptyVault(t *testing.T) {
+	db, source := setupSource(t)
+	b := export(t, db, source)
+
+	targetDB, target := setupVault(t)
+	report, err := Import(target, b, ConflictSkip)
+	require.NoError(t, err)
+	assert.Equal(t, len(b.History), report.Replayed)
+	assert.Len(t, report.Imported, 3)
+
+	// Same records, timestamps and history as the source
+	assert.Equal(t, b.Records, export(t, targetDB, target).Records)
+	assert.Equal(t, b.History, export(t, targetDB, target).History)
+	value, err := target.Get("__n1_canary__")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(value))
+	require.NoError(t, target.Events().Verify())
+}
+
+func TestImportMerge(t *testing.T) {
+	db, source := setupSource(t)
+	b := export(t, db, source)
+
+	for _, tc := range []struct {
+		policy  Conflict
+		bank    string
+		renamed map[string]string
+	}{
+		{ConflictSkip, "mine", map[string]string{}},
+		{ConflictOverwrite, "hunter3", map[string]string{}},
+		{ConflictRename, "mine", map[string]string{"bank": "bank.imported-2"}},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			_, target := setupVault(t)
+			require.NoError(t, target.Put("bank", []byte("mine")))
+			require.NoError(t, target.Tag("bank", "stale"))
+			require.NoError(t, target.Put("bank.imported", []byte("taken")))
+			require.NoError(t, target.Put("mail", []byte("secret")))
+
+			report, err := Import(target.WithForce(), b, tc.policy)
+			require.NoError(t, err)
+			assert.Zero(t, report.Replayed)
+			assert.Equal(t, []string{"mail"}, report.Unchanged)
+			assert.Equal(t, tc.renamed, report.Renamed)
+			assert.Len(t, report.Imported, 1, "the hold is new")
+
+			value, err := target.Get("bank")
+			require.NoError(t, err)
+			assert.Equal(t, tc.bank, string(value))
+
+			scopes, err := target.Scopes()
+			require.NoError(t, err)
+			assert.Contains(t, scopes, dao.Scope{Name: "work", Policy: dao.PolicyOpen}, "missing scopes are defined")
+
+			links, err := target.Links("mail")
+			require.NoError(t, err)
+			switch tc.policy {
+			case ConflictSkip:
+				assert.Equal(t, []string{"bank"}, report.Skipped)
+				require.Len(t, links, 1)
+				assert.Equal(t, "bank", links[0].To)
+			case ConflictOverwrite:
+				assert.Equal(t, []string{"bank"}, report.Overwritten)
+				labels, err := target.Labels("bank")
+				require.NoError(t, err)
+				assert.Equal(t, []string{"finance", "personal"}, labels)
+				scope, err := target.ScopeOf("bank")
+				require.NoError(t, err)
+				assert.Equal(t, "safebox", scope)
+			case ConflictRename:
+				value, err := target.Get("bank.imported-2")
+				require.NoError(t, err)
+				assert.Equal(t, "hunter3", string(value))
+				scope, err := target.ScopeOf("bank.imported-2")
+				require.NoError(t, err)
+				assert.Equal(t, "safebox", scope)
+				require.Len(t, links, 1)
+				assert.Equal(t, "bank.imported-2", links[0].To, "links follow renamed records")
+			}
+			require.NoError(t, target.Events().Verify())
+		})
+	}
+}
+
+func TestImportMergeRespectsReadOnlyScopes(t *testing.T) {
+	db, source := setupSource(t)
+	b := export(t, db, source)
+
+	_, target := setupVault(t)
+	require.NoError(t, target.Put("bank", []byte("mine")))
+	require.NoError(t, target.Move("bank", "safebox"))
+	_, err := Import(target, b, ConflictOverwrite)
+	assert.ErrorIs(t, err, dao.ErrReadOnlyScope)
+
+	_, err = ParseConflict("merge")
+	assert.Error(t, err)
+}
+
+func TestImportMergeSkipsHeldLinks(t *testing.T) {
+	db, source := setupSource(t)
+	b := export(t, db, source)
+
+	targetDB, target := setupVault(t)
+	_, err := Import(target, b, ConflictSkip)
+	require.NoError(t, err)
+	history := export(t, targetDB, target).History
+
+	report, err := Import(target, b, ConflictSkip)
+	require.NoError(t, err)
+	assert.Zero(t, report.Replayed)
+	assert.Zero(t, report.Links, "links already held are not counted")
+	assert.Equal(t, history, export(t, targetDB, target).History, "re-import adds no events")
+
+	links, err := target.Links("mail")
+	require.NoError(t, err)
+	assert.Len(t, links, 1)
+}
@@ -0,0 +1,272 @@
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/holdr"
+)
+
+// Conflict says what Import does with a record whose key already exists in
+// the vault with a different value
+type Conflict string
+
+// Conflict policies
+const (
+	// ConflictSkip keeps the vault's record and drops the imported one
+	ConflictSkip Conflict = "skip"
+	// ConflictOverwrite replaces the vault's record with the imported one
+	ConflictOverwrite Conflict = "overwrite"
+	// ConflictRename imports the record under a new key, see RenamedKey
+	ConflictRename Conflict = "rename"
+)
+
+// ParseConflict parses a conflict policy name
+func ParseConflict(s string) (Conflict, error) {
+	switch c := Conflict(s); c {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q: use skip, overwrite or rename", s)
+}
+
+// Report summarises an import
+type Report struct {
+	// Replayed is the number of events replayed into an empty vault. It is
+	// zero when the bundle was merged.
+	Replayed int
+	// Imported lists the keys of records added to the vault
+	Imported []string
+	// Unchanged lists the keys of records the vault already held as is
+	Unchanged []string
+	// Skipped lists the keys of conflicting records left as they were
+	Skipped []string
+	// Overwritten lists the keys of conflicting records replaced
+	Overwritten []string
+	// Renamed maps the keys of conflicting records to the keys they were
+	// imported under
+	Renamed map[string]string
+	// Links is the number of links added
+	Links int
+}
+
+// Import writes the bundle into the vault. A vault without any history
+// receives the bundle's history, replayed with its original timestamps.
+// Otherwise the bundle's records are merged into the vault and conflicts are
+// resolved according to the policy; links follow renamed records and are
+// dropped with skipped ones. Scopes missing from the vault are defined.
+//
+// Writes go through vault, so its scope policies apply: use a forced DAO to
+// overwrite records in read-only scopes. On error, the report covers what was
+// imported before it.
+func Import(vault *dao.SecureVaultDAO, b *Bundle, policy Conflict) (*Report, error) {
+	if _, err := ParseConflict(string(policy)); err != nil {
+		return nil, err
+	}
+	events, err := vault.Events().Count()
+	if err != nil {
+		return nil, err
+	}
+	if events == 0 && len(b.History) > 0 {
+		return replay(vault, b)
+	}
+	return merge(vault, b, policy)
+}
+
+// replay applies the bundle's history to an empty vault
+func replay(vault *dao.SecureVaultDAO, b *Bundle) (*Report, error) {
+	report := &Report{}
+	// The history already went through the source vault's scope policies,
+	// and carries its scope definitions and canary
+	forced := vault.WithForce().WithInternalKeys()
+	for _, e := range b.History {
+		event := &dao.Event{
+			Type:      e.Type,
+			Key:       e.Key,
+			Value:     e.Value,
+			Scope:     e.Scope,
+			Labels:    e.Labels,
+			CreatedAt: e.CreatedAt,
+		}
+		if e.Link != nil {
+			event.Link = &dao.Link{From: e.Key, To: e.Link.To, Type: e.Link.Type, Weight: e.Link.Weight}
+		}
+		if err := forced.Apply(event); err != nil {
+			return report, fmt.Errorf("failed to replay event %d: %w", e.Seq, err)
+		}
+		report.Replayed++
+	}
+
+	// The replayed vault must hold exactly the exported records
+	for _, r := range b.Records {
+		value, err := vault.Get(r.Key)
+		if err != nil || !bytes.Equal(value, r.Value) {
+			return report, fmt.Errorf("%w: replayed history does not produce record %s", ErrFormat, r.Key)
+		}
+		report.Imported = append(report.Imported, r.Key)
+	}
+	report.Links = len(b.Links)
+	return report, nil
+}
+
+// merge adds the bundle's current records to a vault with its own history
+func merge(vault *dao.SecureVaultDAO, b *Bundle, policy Conflict) (*Report, error) {
+	report := &Report{Renamed: make(map[string]string)}
+
+	existing, err := vault.Scopes()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range b.Scopes {
+		if !slices.ContainsFunc(existing, func(e dao.Scope) bool { return e.Name == s.Name }) {
+			if err := vault.DefineScope(s); err != nil {
+				return report, fmt.Errorf("failed to define scope %s: %w", s.Name, err)
+			}
+		}
+	}
+
+	skipped := make(map[string]bool)
+	for _, r := range b.Records {
+		if dao.IsInternalKey(r.Key) {
+			continue
+		}
+		current, err := vault.Get(r.Key)
+		switch {
+		case errors.Is(err, dao.ErrNotFound):
+			if err := create(vault, r.Key, r); err != nil {
+				return report, err
+			}
+			report.Imported = append(report.Imported, r.Key)
+		case err != nil:
+			return report, err
+		case bytes.Equal(current, r.Value):
+			report.Unchanged = append(report.Unchanged, r.Key)
+		case policy == ConflictSkip:
+			skipped[r.Key] = true
+			report.Skipped = append(report.Skipped, r.Key)
+		case policy == ConflictOverwrite:
+			if err := overwrite(vault, r); err != nil {
+				return report, err
+			}
+			report.Overwritten = append(report.Overwritten, r.Key)
+		case policy == ConflictRename:
+			key, err := RenamedKey(vault, r.Key)
+			if err != nil {
+				return report, err
+			}
+			if err := create(vault, key, r); err != nil {
+				return report, err
+			}
+			report.Renamed[r.Key] = key
+		}
+	}
+
+	for _, l := range b.Links {
+		if skipped[l.From] {
+			continue
+		}
+		link := dao.Link{From: l.From, To: l.To, Type: l.Type, Weight: l.Weight}
+		if key, ok := report.Renamed[l.From]; ok {
+			link.From = key
+		}
+		if key, ok := report.Renamed[l.To]; ok {
+			link.To = key
+		}
+		// Re-importing a bundle must not log the links it already holds again
+		held, err := vault.Links(link.From)
+		if err != nil {
+			return report, err
+		}
+		if slices.Contains(held, link) {
+			continue
+		}
+		if err := vault.Link(link); err != nil {
+			return report, fmt.Errorf("failed to link %s to %s: %w", link.From, link.To, err)
+		}
+		report.Links++
+	}
+	return report, nil
+}
+
+// create writes r as a new record under key, with r's labels and in r's
+// scope. The record is moved last: read-only scopes take moves but no edits.
+func create(vault *dao.SecureVaultDAO, key string, r Record) error {
+	eventType := dao.EventPut
+	if key == r.Key && strings.HasPrefix(key, holdr.KeyPrefix) {
+		eventType = dao.EventCreateHold
+	}
+	// New records cannot be created in a read-only scope, only moved there
+	if err := vault.Apply(&dao.Event{Type: eventType, Key: key, Value: r.Value}); err != nil {
+		return fmt.Errorf("failed to import %s: %w", key, err)
+	}
+	if len(r.Labels) > 0 {
+		if err := vault.Tag(key, r.Labels...); err != nil {
+			return fmt.Errorf("failed to tag %s: %w", key, err)
+		}
+	}
+	if r.Scope != "" && r.Scope != dao.DefaultScope {
+		if err := vault.Move(key, r.Scope); err != nil {
+			return fmt.Errorf("failed to file %s under %s: %w", key, r.Scope, err)
+		}
+	}
+	return nil
+}
+
+// overwrite replaces the value, scope and labels of an existing record
+func overwrite(vault *dao.SecureVaultDAO, r Record) error {
+	if err := vault.Put(r.Key, r.Value); err != nil {
+		return fmt.Errorf("failed to overwrite %s: %w", r.Key, err)
+	}
+	scope, err := vault.ScopeOf(r.Key)
+	if err != nil {
+		return err
+	}
+	labels, err := vault.Labels(r.Key)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, l := range labels {
+		if !slices.Contains(r.Labels, l) {
+			stale = append(stale, l)
+		}
+	}
+	if len(stale) > 0 {
+		if err := vault.Untag(r.Key, stale...); err != nil {
+			return fmt.Errorf("failed to untag %s: %w", r.Key, err)
+		}
+	}
+	if len(r.Labels) > 0 {
+		if err := vault.Tag(r.Key, r.Labels...); err != nil {
+			return fmt.Errorf("failed to tag %s: %w", r.Key, err)
+		}
+	}
+	if r.Scope != "" && r.Scope != scope {
+		if err := vault.Move(r.Key, r.Scope); err != nil {
+			return fmt.Errorf("failed to file %s under %s: %w", r.Key, r.Scope, err)
+		}
+	}
+	return nil
+}
+
+// RenamedKey returns the first of key.imported, key.imported-2, ... that is
+// free in the vault
+func RenamedKey(vault *dao.SecureVaultDAO, key string) (string, error) {
+	for i := 1; ; i++ {
+		candidate := key + ".imported"
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", candidate, i)
+		}
+		_, err := vault.Get(candidate)
+		if errors.Is(err, dao.ErrNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
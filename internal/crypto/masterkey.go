@@ -1,6 +1,12 @@
 package crypto
 
-import "crypto/rand"
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// fingerprintContext derives key fingerprints
+const fingerprintContext = "n1/key/fingerprint/v1"
 
 // Generate returns n random bytes.
 func Generate(n int) ([]byte, error) {
@@ -8,3 +14,13 @@ func Generate(n int) ([]byte, error) {
 	_, err := rand.Read(buf)
 	return buf, err
 }
+
+// Fingerprint returns a short identifier for key, safe to log or store next
+// to the vault: it is derived with HKDF, so it reveals nothing about the key.
+func Fingerprint(key []byte) string {
+	id, err := DeriveHKDF(key, fingerprintContext, 8)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
@@ -11,3 +11,14 @@ func TestGenerate(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, key, 32)
 }
+
+func TestFingerprint(t *testing.T) {
+	key, err := Generate(32)
+	require.NoError(t, err)
+	other, err := Generate(32)
+	require.NoError(t, err)
+
+	require.Len(t, Fingerprint(key), 16)
+	require.Equal(t, Fingerprint(key), Fingerprint(append([]byte(nil), key...)))
+	require.NotEqual(t, Fingerprint(key), Fingerprint(other))
+}
@@ -0,0 +1,284 @@
+package dao
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrChainBroken is returned when the event hash chain does not verify
+	ErrChainBroken = errors.New("event hash chain broken")
+)
+
+// EventType identifies the kind of change an event records
+type EventType string
+
+// Event types understood by the projector
+const (
+	EventPut        EventType = "put"
+	EventDelete     EventType = "delete"
+	EventCreateHold EventType = "create_hold"
+	EventMoveScope  EventType = "move_scope"
+	EventTag        EventType = "tag"
+	EventUntag      EventType = "untag"
+	EventLink       EventType = "link"
+	EventUnlink     EventType = "unlink"
+)
+
+// needsRecord reports whether events of this type act on an existing record
+func (t EventType) needsRecord() bool {
+	switch t {
+	case EventDelete, EventMoveScope, EventTag, EventUntag, EventLink, EventUnlink:
+		return true
+	}
+	return false
+}
+
+// genesisHash is the previous-hash value of the first event in a log
+var genesisHash = make([]byte, sha256.Size)
+
+// Event is a single entry in the append-only event log.
+// Key, Value, Scope, Labels and Link are stored encrypted inside the event payload.
+type Event struct {
+	Seq   int64
+	Type  EventType
+	Key   string
+	Value []byte
+	// Scope is the target scope of a move, or the initial scope of a new record
+	Scope string
+	// Labels are the tags added or removed by tag/untag events
+	Labels []string
+	// Link is the edge from Key added or removed by link/unlink events
+	Link      *Link
+	PrevHash  []byte
+	Hash      []byte
+	CreatedAt time.Time
+}
+
+// eventPayload is the plaintext JSON that gets encrypted into events.payload
+type eventPayload struct {
+	Key    string   `json:"key"`
+	Value  []byte   `json:"value,omitempty"`
+	Scope  string   `json:"scope,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	Link   *Link    `json:"link,omitempty"`
+}
+
+// EventLog provides access to the events table
+type EventLog struct {
+	db   *sql.DB
+	keys *keyring
+	// noKeyIDs appends to the events table as it was before key IDs were
+	// added, for the data migration seeding the log at that schema version
+	noKeyIDs bool
+}
+
+// NewEventLog creates a new EventLog
+func NewEventLog(db *sql.DB, key []byte) *EventLog {
+	return &EventLog{db: db, keys: newKeyring(key)}
+}
+
+// Head returns the sequence number and hash of the latest event.
+// An empty log has sequence 0 and the genesis hash.
+func (l *EventLog) Head() (int64, []byte, error) {
+	return eventHead(l.db)
+}
+
+func eventHead(q querier) (int64, []byte, error) {
+	var seq int64
+	var hash []byte
+	err := q.QueryRow("SELECT seq, hash FROM events ORDER BY seq DESC LIMIT 1").Scan(&seq, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, genesisHash, nil
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read event log head: %w", err)
+	}
+	return seq, hash, nil
+}
+
+// Append adds an event to the log in its own transaction.
+// A zero CreatedAt is replaced by the current time.
+func (l *EventLog) Append(e *Event) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := l.append(tx, e); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// append assigns the next sequence number, links the event into the hash
+// chain, encrypts the payload and inserts it using tx
+func (l *EventLog) append(tx *sql.Tx, e *Event) error {
+	seq, prev, err := eventHead(tx)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(eventPayload{Key: e.Key, Value: e.Value, Scope: e.Scope, Labels: e.Labels, Link: e.Link})
+	if err != nil {
+		return fmt.Errorf("failed to encode event payload: %w", err)
+	}
+	payload, err := l.keys.encrypt(plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt event payload: %w", err)
+	}
+
+	e.Seq = seq + 1
+	e.PrevHash = prev
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	e.CreatedAt = e.CreatedAt.UTC()
+	e.Hash = eventHash(e.Seq, e.Type, payload, prev, e.CreatedAt)
+
+	if l.noKeyIDs {
+		_, err = tx.Exec(
+			"INSERT INTO events (seq, type, payload, prev_hash, hash, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			e.Seq, string(e.Type), payload, e.PrevHash, e.Hash, e.CreatedAt,
+		)
+	} else {
+		_, err = tx.Exec(
+			"INSERT INTO events (seq, type, payload, key_id, prev_hash, hash, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			e.Seq, string(e.Type), payload, l.keys.id, e.PrevHash, e.Hash, e.CreatedAt,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to append event %d: %w", e.Seq, err)
+	}
+	return nil
+}
+
+// Count returns the number of events in the log
+func (l *EventLog) Count() (int64, error) {
+	var n int64
+	if err := l.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count events: %w", err)
+	}
+	return n, nil
+}
+
+// Each decrypts every event in sequence order and passes it to fn,
+// verifying the hash chain along the way. Iteration stops at the first error.
+func (l *EventLog) Each(fn func(*Event) error) error {
+	return l.each(l.db, fn)
+}
+
+func (l *EventLog) each(q querier, fn func(*Event) error) error {
+	// An online rotation relinks the chain in batches; the seam between the
+	// relinked and the original part of the log is bridged
+	rotation, err := loadRotation(q)
+	if err != nil {
+		return err
+	}
+	var seam *chainSeam
+	if rotation != nil && rotation.Seam != nil {
+		if err := rotation.Seam.verify(l.keys.current); err != nil {
+			return err
+		}
+		seam = rotation.Seam
+	}
+
+	rows, err := q.Query("SELECT seq, type, payload, key_id, prev_hash, hash, created_at FROM events ORDER BY seq")
+	if err != nil {
+		return fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	// Decode everything up front: fn may write through the same connection
+	var events []*Event
+	var payloads [][]byte
+	var keyIDs []string
+	expectedSeq, expectedPrev := int64(1), genesisHash
+	for rows.Next() {
+		var e Event
+		var eventType, keyID string
+		var payload []byte
+		if err := rows.Scan(&e.Seq, &eventType, &payload, &keyID, &e.PrevHash, &e.Hash, &e.CreatedAt); err != nil {
+			return fmt.Errorf("failed to scan event: %w", err)
+		}
+		e.Type = EventType(eventType)
+		e.CreatedAt = e.CreatedAt.UTC()
+
+		if e.Seq != expectedSeq {
+			return fmt.Errorf("%w: expected event %d, found %d", ErrChainBroken, expectedSeq, e.Seq)
+		}
+		if seam != nil && e.Seq == seam.Seq+1 && !bytes.Equal(e.PrevHash, expectedPrev) {
+			if !bytes.Equal(expectedPrev, seam.NewHash) {
+				return fmt.Errorf("%w: relinked events end at a different hash than recorded", ErrChainBroken)
+			}
+			expectedPrev = seam.OldHash
+		}
+		if !bytes.Equal(e.PrevHash, expectedPrev) {
+			return fmt.Errorf("%w: event %d does not link to its predecessor", ErrChainBroken, e.Seq)
+		}
+		if !bytes.Equal(e.Hash, eventHash(e.Seq, e.Type, payload, e.PrevHash, e.CreatedAt)) {
+			return fmt.Errorf("%w: event %d hash mismatch", ErrChainBroken, e.Seq)
+		}
+		expectedSeq, expectedPrev = e.Seq+1, e.Hash
+
+		events = append(events, &e)
+		payloads = append(payloads, payload)
+		keyIDs = append(keyIDs, keyID)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating events: %w", err)
+	}
+	rows.Close()
+
+	for i, e := range events {
+		if err := l.decodePayload(e, keyIDs[i], payloads[i]); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify walks the whole log, checking the hash chain and that every payload decrypts
+func (l *EventLog) Verify() error {
+	return l.Each(func(*Event) error { return nil })
+}
+
+func (l *EventLog) decodePayload(e *Event, keyID string, payload []byte) error {
+	plaintext, err := l.keys.decrypt(keyID, payload)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt event %d: %w", e.Seq, err)
+	}
+	var p eventPayload
+	if err := json.Unmarshal(plaintext, &p); err != nil {
+		return fmt.Errorf("failed to decode event %d: %w", e.Seq, err)
+	}
+	e.Key, e.Value, e.Scope, e.Labels, e.Link = p.Key, p.Value, p.Scope, p.Labels, p.Link
+	if e.Value == nil && (e.Type == EventPut || e.Type == EventCreateHold) {
+		e.Value = []byte{}
+	}
+	return nil
+}
+
+// eventHash links an event to its predecessor. The encrypted payload is hashed
+// rather than the plaintext so the chain can be checked without the master key.
+func eventHash(seq int64, t EventType, payload, prev []byte, createdAt time.Time) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	var seqBuf [8]byte
+	binary.BigEndian.PutUint64(seqBuf[:], uint64(seq))
+	h.Write(seqBuf[:])
+	h.Write([]byte(t))
+	h.Write([]byte{0})
+	h.Write(payload)
+	h.Write([]byte(createdAt.UTC().Format(time.RFC3339Nano)))
+	return h.Sum(nil)
+}
@@ -0,0 +1,218 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritesAppendEvents(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err, "Failed to generate key")
+	vault := NewSecureVaultDAO(db, key)
+
+	require.NoError(t, vault.Put("a", []byte("1")))
+	require.NoError(t, vault.Put("b", []byte("2")))
+	require.NoError(t, vault.Put("a", []byte("3")))
+	require.NoError(t, vault.Delete("b"))
+
+	// Deleting a missing key must not leave a trace in the log
+	assert.ErrorIs(t, vault.Delete("missing"), ErrNotFound)
+
+	var seen []Event
+	err = vault.Events().Each(func(e *Event) error {
+		seen = append(seen, *e)
+		return nil
+	})
+	require.NoError(t, err, "Iterating events failed")
+	require.Len(t, seen, 4, "Expected one event per successful write")
+
+	assert.Equal(t, EventPut, seen[0].Type)
+	assert.Equal(t, "a", seen[0].Key)
+	assert.Equal(t, []byte("1"), seen[0].Value)
+	assert.Equal(t, EventDelete, seen[3].Type)
+	assert.Equal(t, "b", seen[3].Key)
+	for i, e := range seen {
+		assert.Equal(t, int64(i+1), e.Seq, "Sequence numbers should be contiguous")
+	}
+
+	// Payloads must not contain plaintext
+	var payload []byte
+	require.NoError(t, db.QueryRow("SELECT payload FROM events WHERE seq = 1").Scan(&payload))
+	assert.NotContains(t, string(payload), `"key":"a"`, "Event payload should be encrypted")
+}
+
+func TestEventLogIsAppendOnly(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	require.NoError(t, NewSecureVaultDAO(db, key).Put("a", []byte("1")))
+
+	_, err = db.Exec("UPDATE events SET type = 'delete'")
+	assert.Error(t, err, "Updating events should be refused")
+	_, err = db.Exec("DELETE FROM events")
+	assert.Error(t, err, "Deleting events should be refused")
+}
+
+func TestEventChainDetectsTampering(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	vault := NewSecureVaultDAO(db, key)
+	require.NoError(t, vault.Put("a", []byte("1")))
+	require.NoError(t, vault.Put("b", []byte("2")))
+	require.NoError(t, vault.Events().Verify(), "Untouched chain should verify")
+
+	// Bypass the append-only triggers the way an attacker with file access could
+	_, err = db.Exec("DROP TRIGGER trig_events_no_update")
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE events SET type = 'delete' WHERE seq = 1")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, vault.Events().Verify(), ErrChainBroken)
+}
+
+func TestReplayRebuildsVault(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	vault := NewSecureVaultDAO(db, key)
+
+	require.NoError(t, vault.Put("keep", []byte("v1")))
+	require.NoError(t, vault.Put("gone", []byte("x")))
+	require.NoError(t, vault.Put("keep", []byte("v2")))
+	require.NoError(t, vault.Delete("gone"))
+
+	before, err := NewVaultDAO(db).Get("keep")
+	require.NoError(t, err)
+
+	// Wreck the projection, then rebuild it from the log
+	_, err = db.Exec("DELETE FROM vault")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO vault (key, value) VALUES ('stray', x'00')")
+	require.NoError(t, err)
+
+	applied, err := NewProjector(db, key).Replay()
+	require.NoError(t, err, "Replay failed")
+	assert.Equal(t, 4, applied, "Replay should apply every event")
+
+	keys, err := vault.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"keep"}, keys, "Replay should reproduce exactly the current state")
+
+	value, err := vault.Get("keep")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), value)
+
+	after, err := NewVaultDAO(db).Get("keep")
+	require.NoError(t, err)
+	assert.True(t, before.CreatedAt.Equal(after.CreatedAt), "Replay should preserve created_at")
+	assert.True(t, before.UpdatedAt.Equal(after.UpdatedAt), "Replay should preserve updated_at")
+}
+
+func TestLegacyVaultIsSeeded(t *testing.T) {
+	db := setupLegacyDB(t)
+	defer db.Close()
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+
+	// Simulate a vault written before the event log was seeded
+	ciphertext, err := crypto.EncryptBlob(key, []byte("legacy"))
+	require.NoError(t, err)
+	require.NoError(t, NewVaultDAO(db).Put("old", ciphertext))
+
+	// Seeding needs the key to decrypt the rows it turns into events
+	assert.ErrorIs(t, migrations.BootstrapVault(db), migrations.ErrKeyRequired)
+
+	require.NoError(t, migrations.MigrateVault(db, key))
+	vault := NewSecureVaultDAO(db, key)
+	require.NoError(t, vault.Put("new", []byte("fresh")))
+
+	count, err := vault.Events().Count()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count, "Legacy row should be seeded before the first new event")
+
+	_, err = NewProjector(db, key).Replay()
+	require.NoError(t, err)
+	value, err := vault.Get("old")
+	require.NoError(t, err, "Legacy record should survive replay")
+	assert.Equal(t, []byte("legacy"), value)
+}
+
+func TestSeedingResumes(t *testing.T) {
+	db := setupLegacyDB(t)
+	defer db.Close()
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	rows := seedBatchSize*2 + 5
+	for i := 0; i < rows; i++ {
+		ciphertext, err := crypto.EncryptBlob(key, []byte(fmt.Sprintf("value-%d", i)))
+		require.NoError(t, err)
+		require.NoError(t, NewVaultDAO(db).Put(fmt.Sprintf("key-%03d", i), ciphertext))
+	}
+
+	// Interrupt seeding after the first batch by corrupting a row in the second
+	_, err = db.Exec("UPDATE vault SET value = x'00' WHERE key = ?", fmt.Sprintf("key-%03d", seedBatchSize+1))
+	require.NoError(t, err)
+	require.Error(t, migrations.MigrateVault(db, key))
+
+	statuses, err := migrations.NewVaultRunner(db).Status()
+	require.NoError(t, err)
+	var seed migrations.Status
+	for _, s := range statuses {
+		if s.Version == SeedEventsVersion {
+			seed = s
+		}
+	}
+	assert.False(t, seed.Applied)
+	assert.Equal(t, strconv.Itoa(seedBatchSize), seed.Cursor, "The first batch should be committed")
+
+	// Repair the row and resume: the first batch is not seeded twice
+	ciphertext, err := crypto.EncryptBlob(key, []byte("repaired"))
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE vault SET value = ? WHERE key = ?", ciphertext, fmt.Sprintf("key-%03d", seedBatchSize+1))
+	require.NoError(t, err)
+
+	var progress []int64
+	err = migrations.NewVaultRunner(db).WithKey(key).OnProgress(func(_ migrations.Migration, done, total int64) {
+		assert.Equal(t, int64(rows), total)
+		progress = append(progress, done)
+	}).Run()
+	require.NoError(t, err)
+	assert.Equal(t, []int64{int64(seedBatchSize * 2), int64(rows)}, progress)
+
+	count, err := NewEventLog(db, key).Count()
+	require.NoError(t, err)
+	assert.Equal(t, int64(rows), count, "Every row should be seeded exactly once")
+
+	// The seal covers the seeded log
+	_, err = integrity.Verify(db, key)
+	assert.NoError(t, err)
+}
+
+// setupLegacyDB creates a vault at the schema version preceding event seeding
+func setupLegacyDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "legacy.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrations.NewVaultRunner(db).MigrateTo(SeedEventsVersion-1))
+	return db
+}
@@ -0,0 +1,110 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/integrity"
+)
+
+// Every encrypted row records the fingerprint of the master key it was
+// written with in a key_id column. Normally that is the vault's only key, but
+// during an online rotation rows written with the retired key remain until
+// the re-encryption pass reaches them. Rows written before key IDs existed
+// have an empty key ID and are tried against every key.
+
+// keyring holds the master key the DAO encrypts with and the retired key, if
+// any, that older rows may still be encrypted with
+type keyring struct {
+	current   []byte
+	id        string
+	retired   []byte
+	retiredID string
+}
+
+func newKeyring(key []byte) *keyring {
+	return &keyring{current: key, id: crypto.Fingerprint(key)}
+}
+
+// withRetired returns a copy of k that can also read rows written with key
+func (k *keyring) withRetired(key []byte) *keyring {
+	ring := *k
+	ring.retired, ring.retiredID = key, crypto.Fingerprint(key)
+	return &ring
+}
+
+// all returns every key, current first
+func (k *keyring) all() [][]byte {
+	if k.retired == nil {
+		return [][]byte{k.current}
+	}
+	return [][]byte{k.current, k.retired}
+}
+
+// encrypt encrypts plaintext with the current key
+func (k *keyring) encrypt(plaintext []byte) ([]byte, error) {
+	return crypto.EncryptBlob(k.current, plaintext)
+}
+
+// decrypt decrypts a row written with the key identified by keyID
+func (k *keyring) decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	switch keyID {
+	case k.id:
+		return crypto.DecryptBlob(k.current, ciphertext)
+	case "":
+		var err error
+		for _, key := range k.all() {
+			var plaintext []byte
+			if plaintext, err = crypto.DecryptBlob(key, ciphertext); err == nil {
+				return plaintext, nil
+			}
+		}
+		return nil, err
+	}
+	if k.retired != nil && keyID == k.retiredID {
+		return crypto.DecryptBlob(k.retired, ciphertext)
+	}
+	return nil, fmt.Errorf("row is encrypted with unknown key %s", keyID)
+}
+
+// derive derives a subkey for context from every key, current first
+func (k *keyring) derive(context string) ([][]byte, error) {
+	var keys [][]byte
+	for _, key := range k.all() {
+		sub, err := crypto.DeriveHKDF(key, context, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive %s key: %w", context, err)
+		}
+		keys = append(keys, sub)
+	}
+	return keys, nil
+}
+
+// blinds returns the blind index of value under every key, current first
+func blinds(blindKeys [][]byte, value string) []interface{} {
+	out := make([]interface{}, len(blindKeys))
+	for i, key := range blindKeys {
+		out[i] = blindIndex(key, value)
+	}
+	return out
+}
+
+// sealKey returns the key the vault's seal was made with: the current key,
+// or the retired key if a rotation has switched keys but not resealed yet
+func (k *keyring) sealKey(q querier) ([]byte, error) {
+	if k.retired == nil {
+		return k.current, nil
+	}
+	sealed, err := integrity.SealedWith(q, k.current)
+	if errors.Is(err, integrity.ErrUnsealed) || sealed {
+		return k.current, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sealed, err := integrity.SealedWith(q, k.retired); err == nil && sealed {
+		return k.retired, nil
+	}
+	return k.current, nil
+}
@@ -0,0 +1,197 @@
+package dao
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// blindContext is the HKDF context for the blind index key, keeping it
+// independent of the master key used for encryption
+const blindContext = "n1/labels/blind/v1"
+
+var (
+	// ErrInvalidLabel is returned for empty or malformed tags and labels
+	ErrInvalidLabel = errors.New("invalid label")
+)
+
+// Labels are attached to records as free-form tags ("prod") or key/value
+// labels ("env=prod"); both are stored the same way. The labels table holds
+// each label encrypted together with a blind index: an HMAC of the label under
+// a key derived from the master key. Lookups compare blind indexes, so the
+// database never contains label plaintext yet queries stay indexed.
+
+// TagQuery selects records by their labels. A record matches when it carries
+// every label in All, at least one in Any (if Any is non-empty) and none in None.
+type TagQuery struct {
+	All  []string
+	Any  []string
+	None []string
+}
+
+// ValidateLabel checks that a tag or key=value label is usable
+func ValidateLabel(label string) error {
+	if label == "" || strings.ContainsAny(label, " \t\r\n,") {
+		return fmt.Errorf("%w: %q must be non-empty and contain no whitespace or commas", ErrInvalidLabel, label)
+	}
+	if strings.HasPrefix(label, "=") || strings.HasSuffix(label, "=") {
+		return fmt.Errorf("%w: %q must be a tag or name=value", ErrInvalidLabel, label)
+	}
+	return nil
+}
+
+// Tag attaches labels to a record. Labels the record already carries are ignored.
+func (d *SecureVaultDAO) Tag(key string, labels ...string) error {
+	labels, err := normaliseLabels(labels)
+	if err != nil {
+		return err
+	}
+	return d.Apply(&Event{Type: EventTag, Key: key, Labels: labels})
+}
+
+// Untag removes labels from a record. Labels the record does not carry are ignored.
+func (d *SecureVaultDAO) Untag(key string, labels ...string) error {
+	labels, err := normaliseLabels(labels)
+	if err != nil {
+		return err
+	}
+	return d.Apply(&Event{Type: EventUntag, Key: key, Labels: labels})
+}
+
+// Labels returns the labels attached to a record, sorted
+func (d *SecureVaultDAO) Labels(key string) ([]string, error) {
+	rows, err := d.db.Query("SELECT label, key_id FROM labels WHERE record_key = ?", key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query labels of %s: %w", key, err)
+	}
+	defer rows.Close()
+
+	var labels []string
+	for rows.Next() {
+		var ciphertext []byte
+		var keyID string
+		if err := rows.Scan(&ciphertext, &keyID); err != nil {
+			return nil, fmt.Errorf("failed to scan label: %w", err)
+		}
+		plaintext, err := d.keys.decrypt(keyID, ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt label of %s: %w", key, err)
+		}
+		labels = append(labels, string(plaintext))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating labels: %w", err)
+	}
+	sort.Strings(labels)
+	return labels, nil
+}
+
+// FindByTags returns the keys of all records matching q, sorted
+func (d *SecureVaultDAO) FindByTags(q TagQuery) ([]string, error) {
+	blindKeys, err := d.keys.derive(blindContext)
+	if err != nil {
+		return nil, err
+	}
+
+	var where []string
+	var args []interface{}
+	subquery := func(labels []string) string {
+		var marks []string
+		for _, l := range labels {
+			for _, blind := range blinds(blindKeys, l) {
+				marks = append(marks, "?")
+				args = append(args, blind)
+			}
+		}
+		return "(SELECT record_key FROM labels WHERE blind IN (" + strings.Join(marks, ", ") + "))"
+	}
+	for _, l := range q.All {
+		where = append(where, "key IN "+subquery([]string{l}))
+	}
+	if len(q.Any) > 0 {
+		where = append(where, "key IN "+subquery(q.Any))
+	}
+	if len(q.None) > 0 {
+		where = append(where, "key NOT IN "+subquery(q.None))
+	}
+
+	query := "SELECT key FROM vault"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	return d.dao.listKeys(query+" ORDER BY key", args...)
+}
+
+// applyLabels adds or removes the labels carried by a tag/untag event using tx
+func (p *Projector) applyLabels(vault *VaultDAO, e *Event) error {
+	blindKeys, err := p.keys.derive(blindContext)
+	if err != nil {
+		return err
+	}
+	for _, l := range e.Labels {
+		// The label may still be indexed under the retired key of an online
+		// rotation: drop that copy, so a record never carries a label twice
+		all := blinds(blindKeys, l)
+		stale := all[1:]
+		if e.Type == EventUntag {
+			stale = all
+		}
+		for _, blind := range stale {
+			if _, err := vault.db.Exec("DELETE FROM labels WHERE record_key = ? AND blind = ?", e.Key, blind); err != nil {
+				return fmt.Errorf("failed to remove label from %s: %w", e.Key, err)
+			}
+		}
+		if e.Type == EventUntag {
+			continue
+		}
+		ciphertext, err := p.keys.encrypt([]byte(l))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt label for %s: %w", e.Key, err)
+		}
+		_, err = vault.db.Exec(
+			"INSERT INTO labels (record_key, blind, label, key_id) VALUES (?, ?, ?, ?) ON CONFLICT(record_key, blind) DO NOTHING",
+			e.Key, all[0], ciphertext, p.keys.id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add label to %s: %w", e.Key, err)
+		}
+	}
+	return nil
+}
+
+// clearLabels removes all labels of a deleted record
+func clearLabels(vault *VaultDAO, key string) error {
+	if _, err := vault.db.Exec("DELETE FROM labels WHERE record_key = ?", key); err != nil {
+		return fmt.Errorf("failed to remove labels of %s: %w", key, err)
+	}
+	return nil
+}
+
+func normaliseLabels(labels []string) ([]string, error) {
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("%w: no labels given", ErrInvalidLabel)
+	}
+	seen := make(map[string]bool, len(labels))
+	var out []string
+	for _, l := range labels {
+		if err := ValidateLabel(l); err != nil {
+			return nil, err
+		}
+		if !seen[l] {
+			seen[l] = true
+			out = append(out, l)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// blindIndex is the deterministic, keyed lookup token for a label
+func blindIndex(blindKey []byte, label string) []byte {
+	m := hmac.New(sha256.New, blindKey)
+	m.Write([]byte(label))
+	return m.Sum(nil)
+}
@@ -0,0 +1,125 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTaggedVault(t *testing.T) *SecureVaultDAO {
+	vault := setupSecureVault(t)
+	for key, labels := range map[string][]string{
+		"prod-db":   {"prod", "db", "env=prod"},
+		"prod-api":  {"prod", "api", "env=prod"},
+		"stage-db":  {"stage", "db", "env=stage"},
+		"untouched": nil,
+	} {
+		require.NoError(t, vault.Put(key, []byte("secret")))
+		if labels != nil {
+			require.NoError(t, vault.Tag(key, labels...))
+		}
+	}
+	return vault
+}
+
+func TestFindByTags(t *testing.T) {
+	vault := setupTaggedVault(t)
+
+	tests := []struct {
+		name  string
+		query TagQuery
+		want  []string
+	}{
+		{"and", TagQuery{All: []string{"prod", "db"}}, []string{"prod-db"}},
+		{"or", TagQuery{Any: []string{"api", "stage"}}, []string{"prod-api", "stage-db"}},
+		{"not", TagQuery{All: []string{"db"}, None: []string{"prod"}}, []string{"stage-db"}},
+		{"label", TagQuery{All: []string{"env=stage"}}, []string{"stage-db"}},
+		{"combined", TagQuery{All: []string{"env=prod"}, Any: []string{"db", "cache"}, None: []string{"api"}}, []string{"prod-db"}},
+		{"no match", TagQuery{All: []string{"missing"}}, nil},
+		{"none only", TagQuery{None: []string{"db", "api"}}, []string{"untouched"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := vault.FindByTags(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys)
+		})
+	}
+}
+
+func TestTagAndUntag(t *testing.T) {
+	vault := setupTaggedVault(t)
+
+	require.NoError(t, vault.Tag("prod-db", "prod", "critical"), "Re-adding an existing label should be harmless")
+	labels, err := vault.Labels("prod-db")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"critical", "db", "env=prod", "prod"}, labels)
+
+	require.NoError(t, vault.Untag("prod-db", "critical", "never-there"))
+	labels, err = vault.Labels("prod-db")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db", "env=prod", "prod"}, labels)
+
+	assert.ErrorIs(t, vault.Tag("missing", "prod"), ErrNotFound)
+	assert.ErrorIs(t, vault.Tag("prod-db", "has space"), ErrInvalidLabel)
+	assert.ErrorIs(t, vault.Tag("prod-db"), ErrInvalidLabel)
+
+	require.NoError(t, vault.Delete("prod-db"))
+	labels, err = vault.Labels("prod-db")
+	require.NoError(t, err)
+	assert.Empty(t, labels, "Deleting a record should drop its labels")
+}
+
+func TestLabelsAreNotStoredInPlaintext(t *testing.T) {
+	vault := setupTaggedVault(t)
+	blindKey, err := crypto.DeriveHKDF(vault.Key(), blindContext, 32)
+	require.NoError(t, err)
+	other, err := crypto.Generate(32)
+	require.NoError(t, err)
+
+	rows, err := vault.db.Query("SELECT record_key, blind, label FROM labels")
+	require.NoError(t, err)
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var key string
+		var blind, label []byte
+		require.NoError(t, rows.Scan(&key, &blind, &label))
+
+		// The label only decrypts with the vault's key
+		plain, err := crypto.DecryptBlob(vault.Key(), label)
+		require.NoError(t, err)
+		assert.NotEqual(t, plain, label, "Label should be encrypted")
+		_, err = crypto.DecryptBlob(other, label)
+		assert.Error(t, err, "Label should not decrypt with another key")
+
+		// The blind index is the keyed HMAC of the label, not the label
+		assert.NotEqual(t, plain, blind)
+		assert.Equal(t, blindIndex(blindKey, string(plain)), blind)
+		count++
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, 9, count)
+}
+
+func TestReplayRestoresLabels(t *testing.T) {
+	vault := setupTaggedVault(t)
+	require.NoError(t, vault.Untag("stage-db", "db"))
+
+	_, err := vault.Replay()
+	require.NoError(t, err, "Replay failed")
+
+	keys, err := vault.FindByTags(TagQuery{All: []string{"db"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod-db"}, keys)
+}
+
+func TestTaggingRespectsScopePolicy(t *testing.T) {
+	vault := setupTaggedVault(t)
+	require.NoError(t, vault.Move("prod-db", "safebox"))
+
+	assert.ErrorIs(t, vault.Tag("prod-db", "frozen"), ErrReadOnlyScope)
+	require.NoError(t, vault.WithForce().Tag("prod-db", "frozen"))
+}
@@ -0,0 +1,179 @@
+package dao
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+)
+
+// Well-known link types. Any lowercase, dash-separated name is accepted.
+const (
+	LinkCredentialFor = "credential-for"
+	LinkDerivedFrom   = "derived-from"
+	LinkBlocks        = "blocks"
+	LinkRelated       = "related"
+)
+
+// linkBlindContext is the HKDF context for the blind index of link types
+const linkBlindContext = "n1/links/blind/v1"
+
+var (
+	// ErrInvalidLink is returned for malformed links
+	ErrInvalidLink = errors.New("invalid link")
+
+	linkTypePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+)
+
+// Link is a typed, weighted, directed edge between two records. The weight is
+// a cost: graph traversals prefer paths with a lower total weight.
+type Link struct {
+	From   string  `json:"-"`
+	To     string  `json:"to"`
+	Type   string  `json:"type"`
+	Weight float64 `json:"weight"`
+}
+
+// The links table stores record keys in the clear, like the vault table,
+// while the link type and weight are encrypted. A blind index of the type
+// keeps (from, to, type) unique without revealing it.
+
+// Link adds or reweights a link between two existing records
+func (d *SecureVaultDAO) Link(l Link) error {
+	if !linkTypePattern.MatchString(l.Type) {
+		return fmt.Errorf("%w: type %q must be lowercase letters, digits or dashes", ErrInvalidLink, l.Type)
+	}
+	if l.Weight <= 0 || math.IsInf(l.Weight, 0) || math.IsNaN(l.Weight) {
+		return fmt.Errorf("%w: weight must be a positive number", ErrInvalidLink)
+	}
+	if l.From == l.To {
+		return fmt.Errorf("%w: %s cannot link to itself", ErrInvalidLink, l.From)
+	}
+	if _, err := d.dao.Get(l.To); err != nil {
+		return fmt.Errorf("link target %s: %w", l.To, err)
+	}
+	return d.Apply(&Event{Type: EventLink, Key: l.From, Link: &Link{To: l.To, Type: l.Type, Weight: l.Weight}})
+}
+
+// Unlink removes the link of the given type between two records, or all
+// links between them if linkType is empty
+func (d *SecureVaultDAO) Unlink(from, to, linkType string) error {
+	return d.Apply(&Event{Type: EventUnlink, Key: from, Link: &Link{To: to, Type: linkType}})
+}
+
+// Links returns the links leaving a record
+func (d *SecureVaultDAO) Links(key string) ([]Link, error) {
+	return d.queryLinks("WHERE from_key = ?", key)
+}
+
+// Backlinks returns the links pointing at a record
+func (d *SecureVaultDAO) Backlinks(key string) ([]Link, error) {
+	return d.queryLinks("WHERE to_key = ?", key)
+}
+
+// AllLinks returns every link in the vault
+func (d *SecureVaultDAO) AllLinks() ([]Link, error) {
+	return d.queryLinks("")
+}
+
+func (d *SecureVaultDAO) queryLinks(where string, args ...interface{}) ([]Link, error) {
+	rows, err := d.db.Query("SELECT from_key, to_key, data, key_id FROM links "+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []Link
+	for rows.Next() {
+		var l Link
+		var ciphertext []byte
+		var keyID string
+		if err := rows.Scan(&l.From, &l.To, &ciphertext, &keyID); err != nil {
+			return nil, fmt.Errorf("failed to scan link: %w", err)
+		}
+		plaintext, err := d.keys.decrypt(keyID, ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt link %s -> %s: %w", l.From, l.To, err)
+		}
+		if err := json.Unmarshal(plaintext, &l); err != nil {
+			return nil, fmt.Errorf("failed to decode link %s -> %s: %w", l.From, l.To, err)
+		}
+		links = append(links, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating links: %w", err)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].From != links[j].From {
+			return links[i].From < links[j].From
+		}
+		if links[i].To != links[j].To {
+			return links[i].To < links[j].To
+		}
+		return links[i].Type < links[j].Type
+	})
+	return links, nil
+}
+
+// applyLink adds or removes the link carried by a link/unlink event
+func (p *Projector) applyLink(vault *VaultDAO, e *Event) error {
+	if e.Link == nil {
+		return fmt.Errorf("%s event at seq %d carries no link", e.Type, e.Seq)
+	}
+	blindKeys, err := p.keys.derive(linkBlindContext)
+	if err != nil {
+		return err
+	}
+
+	if e.Type == EventUnlink && e.Link.Type == "" {
+		if _, err := vault.db.Exec("DELETE FROM links WHERE from_key = ? AND to_key = ?", e.Key, e.Link.To); err != nil {
+			return fmt.Errorf("failed to remove link from %s: %w", e.Key, err)
+		}
+		return nil
+	}
+
+	// The link may still be indexed under the retired key of an online
+	// rotation: unlinking removes both copies, linking replaces the old one
+	all := blinds(blindKeys, e.Link.Type)
+	stale := all[1:]
+	if e.Type == EventUnlink {
+		stale = all
+	}
+	for _, blind := range stale {
+		_, err := vault.db.Exec("DELETE FROM links WHERE from_key = ? AND to_key = ? AND type_blind = ?", e.Key, e.Link.To, blind)
+		if err != nil {
+			return fmt.Errorf("failed to remove link from %s: %w", e.Key, err)
+		}
+	}
+	if e.Type == EventUnlink {
+		return nil
+	}
+
+	plaintext, err := json.Marshal(e.Link)
+	if err != nil {
+		return fmt.Errorf("failed to encode link: %w", err)
+	}
+	ciphertext, err := p.keys.encrypt(plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt link from %s: %w", e.Key, err)
+	}
+	_, err = vault.db.Exec(
+		`INSERT INTO links (from_key, to_key, type_blind, data, key_id) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(from_key, to_key, type_blind) DO UPDATE SET data = excluded.data, key_id = excluded.key_id`,
+		e.Key, e.Link.To, all[0], ciphertext, p.keys.id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add link from %s: %w", e.Key, err)
+	}
+	return nil
+}
+
+// clearLinks removes all links from and to a deleted record
+func clearLinks(vault *VaultDAO, key string) error {
+	if _, err := vault.db.Exec("DELETE FROM links WHERE from_key = ? OR to_key = ?", key, key); err != nil {
+		return fmt.Errorf("failed to remove links of %s: %w", key, err)
+	}
+	return nil
+}
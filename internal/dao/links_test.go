@@ -0,0 +1,72 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinksAndBacklinks(t *testing.T) {
+	vault := setupSecureVault(t)
+	for _, key := range []string{"github-token", "github", "ci"} {
+		require.NoError(t, vault.Put(key, []byte("x")))
+	}
+
+	require.NoError(t, vault.Link(Link{From: "github-token", To: "github", Type: LinkCredentialFor, Weight: 1}))
+	require.NoError(t, vault.Link(Link{From: "ci", To: "github", Type: LinkRelated, Weight: 2}))
+	require.NoError(t, vault.Link(Link{From: "ci", To: "github", Type: LinkBlocks, Weight: 1}))
+	require.NoError(t, vault.Link(Link{From: "ci", To: "github", Type: LinkBlocks, Weight: 3}), "Relinking should update the weight")
+
+	links, err := vault.Links("ci")
+	require.NoError(t, err)
+	assert.Equal(t, []Link{
+		{From: "ci", To: "github", Type: LinkBlocks, Weight: 3},
+		{From: "ci", To: "github", Type: LinkRelated, Weight: 2},
+	}, links)
+
+	backlinks, err := vault.Backlinks("github")
+	require.NoError(t, err)
+	assert.Len(t, backlinks, 3)
+
+	require.NoError(t, vault.Unlink("ci", "github", LinkBlocks))
+	links, err = vault.Links("ci")
+	require.NoError(t, err)
+	assert.Equal(t, []Link{{From: "ci", To: "github", Type: LinkRelated, Weight: 2}}, links)
+
+	require.NoError(t, vault.Unlink("ci", "github", ""), "An empty type removes all links between the records")
+	links, err = vault.Links("ci")
+	require.NoError(t, err)
+	assert.Empty(t, links)
+
+	require.NoError(t, vault.Delete("github"))
+	all, err := vault.AllLinks()
+	require.NoError(t, err)
+	assert.Empty(t, all, "Deleting a record should drop links from and to it")
+}
+
+func TestLinkValidation(t *testing.T) {
+	vault := setupSecureVault(t)
+	require.NoError(t, vault.Put("a", []byte("x")))
+	require.NoError(t, vault.Put("b", []byte("y")))
+
+	assert.ErrorIs(t, vault.Link(Link{From: "a", To: "missing", Type: LinkRelated, Weight: 1}), ErrNotFound)
+	assert.ErrorIs(t, vault.Link(Link{From: "missing", To: "a", Type: LinkRelated, Weight: 1}), ErrNotFound)
+	assert.ErrorIs(t, vault.Link(Link{From: "a", To: "b", Type: "Not Valid", Weight: 1}), ErrInvalidLink)
+	assert.ErrorIs(t, vault.Link(Link{From: "a", To: "b", Type: LinkRelated, Weight: 0}), ErrInvalidLink)
+	assert.ErrorIs(t, vault.Link(Link{From: "a", To: "a", Type: LinkRelated, Weight: 1}), ErrInvalidLink)
+}
+
+func TestReplayRestoresLinks(t *testing.T) {
+	vault := setupSecureVault(t)
+	require.NoError(t, vault.Put("a", []byte("x")))
+	require.NoError(t, vault.Put("b", []byte("y")))
+	require.NoError(t, vault.Link(Link{From: "a", To: "b", Type: LinkDerivedFrom, Weight: 1.5}))
+
+	_, err := vault.Replay()
+	require.NoError(t, err)
+
+	links, err := vault.AllLinks()
+	require.NoError(t, err)
+	assert.Equal(t, []Link{{From: "a", To: "b", Type: LinkDerivedFrom, Weight: 1.5}}, links)
+}
@@ -0,0 +1,116 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/migrations"
+)
+
+// Data migrations owned by the DAO. They are registered with the vault schema
+// so that every runner in a binary linking this package knows them.
+const (
+	// SeedEventsVersion backfills the event log of vaults created before it existed
+	SeedEventsVersion = 12
+)
+
+// seedBatchSize is the number of vault rows seeded per transaction
+const seedBatchSize = 100
+
+func init() {
+	migrations.RegisterVaultMigration(migrations.DataMigration(
+		SeedEventsVersion,
+		"Seed event log from vault rows",
+		seedEvents,
+		// Seeded events are the record history from then on; rolling back
+		// leaves them in place, and seeding again finds the log non-empty
+		func(*migrations.Context, string) (string, bool, error) { return "", true, nil },
+	))
+}
+
+// seedEvents backfills the event log of a vault created before the log
+// existed: when there are no events but the vault has rows, each row becomes
+// a put event so that history starts from the current state instead of being
+// lost on replay. The cursor is the id of the last seeded row.
+func seedEvents(ctx *migrations.Context, cursor string) (string, bool, error) {
+	tx := ctx.Tx
+	var after int64
+	if cursor == "" {
+		var hasEvents bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM events)").Scan(&hasEvents); err != nil {
+			return "", false, fmt.Errorf("failed to inspect event log: %w", err)
+		}
+		if hasEvents {
+			return "", true, nil
+		}
+	} else {
+		var err error
+		if after, err = strconv.ParseInt(cursor, 10, 64); err != nil {
+			return "", false, fmt.Errorf("invalid seeding cursor %q: %w", cursor, err)
+		}
+	}
+
+	var total, seeded int64
+	err := tx.QueryRow("SELECT COUNT(*), COUNT(CASE WHEN id <= ? THEN 1 END) FROM vault", after).Scan(&total, &seeded)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to inspect vault table: %w", err)
+	}
+	if total == 0 {
+		return "", true, nil
+	}
+	if err := ctx.RequireKey(); err != nil {
+		return "", false, err
+	}
+	if cursor == "" {
+		// Never give a tampered vault a history that vouches for it
+		if _, err := integrity.Verify(tx, ctx.Key); err != nil && !errors.Is(err, integrity.ErrUnsealed) {
+			return "", false, fmt.Errorf("refusing to seed events: %w", err)
+		}
+	}
+
+	rows, err := tx.Query("SELECT id, key, value, created_at FROM vault WHERE id > ? ORDER BY id LIMIT ?", after, seedBatchSize)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read vault rows for seeding: %w", err)
+	}
+	var seeds []*Event
+	for rows.Next() {
+		var e Event
+		var ciphertext []byte
+		if err := rows.Scan(&after, &e.Key, &ciphertext, &e.CreatedAt); err != nil {
+			rows.Close()
+			return "", false, fmt.Errorf("failed to scan vault row for seeding: %w", err)
+		}
+		plaintext, err := ctx.Decrypt(ciphertext)
+		if err != nil {
+			rows.Close()
+			return "", false, fmt.Errorf("failed to decrypt value for key %s while seeding events: %w", e.Key, err)
+		}
+		e.Type = EventPut
+		e.Value = plaintext
+		seeds = append(seeds, &e)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return "", false, fmt.Errorf("error iterating vault rows for seeding: %w", err)
+	}
+	rows.Close()
+
+	log := &EventLog{keys: newKeyring(ctx.Key), noKeyIDs: true}
+	for _, e := range seeds {
+		if err := log.append(tx, e); err != nil {
+			return "", false, err
+		}
+	}
+	seeded += int64(len(seeds))
+	ctx.Progress(seeded, total)
+
+	if len(seeds) < seedBatchSize {
+		if _, err := integrity.Update(tx, ctx.Key); err != nil {
+			return "", false, fmt.Errorf("failed to reseal vault: %w", err)
+		}
+		return "", true, nil
+	}
+	return strconv.FormatInt(after, 10), false, nil
+}
@@ -0,0 +1,131 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/search"
+)
+
+// Projector materializes the vault, labels, links and search index tables from the event log.
+// The event log is the source of truth; the tables are a cache of its
+// current state that can always be rebuilt with Replay.
+type Projector struct {
+	db       *sql.DB
+	log      *EventLog
+	keys     *keyring
+	embedder search.Embedder
+}
+
+// NewProjector creates a new Projector that embeds records with search.DefaultEmbedder
+func NewProjector(db *sql.DB, key []byte) *Projector {
+	log := NewEventLog(db, key)
+	return &Projector{db: db, log: log, keys: log.keys, embedder: search.DefaultEmbedder}
+}
+
+// apply folds a single event into the projected tables using tx
+func (p *Projector) apply(tx *sql.Tx, e *Event) error {
+	vault := newTxVaultDAO(tx)
+	switch e.Type {
+	case EventPut, EventCreateHold:
+		ciphertext, err := p.keys.encrypt(e.Value)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt value for key %s: %w", e.Key, err)
+		}
+		if err := vault.putAt(e.Key, ciphertext, p.keys.id, e.CreatedAt); err != nil {
+			return err
+		}
+		if e.Scope != "" {
+			if err := vault.setScope(e.Key, e.Scope); err != nil {
+				return err
+			}
+		}
+		return p.index(vault, e.Key, e.Value)
+	case EventMoveScope:
+		if err := vault.setScope(e.Key, e.Scope); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	case EventTag, EventUntag:
+		return p.applyLabels(vault, e)
+	case EventLink, EventUnlink:
+		return p.applyLink(vault, e)
+	case EventDelete:
+		if err := vault.Delete(e.Key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if err := clearLabels(vault, e.Key); err != nil {
+			return err
+		}
+		if err := clearLinks(vault, e.Key); err != nil {
+			return err
+		}
+		return removeDocument(vault, e.Key)
+	default:
+		return fmt.Errorf("unknown event type %q at seq %d", e.Type, e.Seq)
+	}
+}
+
+// index updates the full-text and semantic indexes for a record
+func (p *Projector) index(vault *VaultDAO, key string, value []byte) error {
+	if err := indexDocument(vault, p.keys, key, value); err != nil {
+		return err
+	}
+	return embedDocument(vault, p.keys, p.embedder, key, value)
+}
+
+// Replay discards the projected tables and rebuilds them from scratch by applying
+// every event in order. It returns the number of events applied.
+func (p *Projector) Replay() (int, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	applied, err := p.replay(tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit replay: %w", err)
+	}
+	return applied, nil
+}
+
+// replay rebuilds the projected tables and reseals the vault within tx
+func (p *Projector) replay(tx *sql.Tx) (int, error) {
+	sealKey, err := p.keys.sealKey(tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := integrity.VerifyEventHead(tx, sealKey); err != nil {
+		return 0, fmt.Errorf("refusing to replay: %w", err)
+	}
+	for _, table := range []string{"vault", "labels", "links"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return 0, fmt.Errorf("failed to clear %s table: %w", table, err)
+		}
+	}
+	if err := clearSearchIndex(tx); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	err = p.log.each(tx, func(e *Event) error {
+		if err := p.apply(tx, e); err != nil {
+			return fmt.Errorf("failed to apply event %d: %w", e.Seq, err)
+		}
+		applied++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := integrity.Update(tx, p.keys.current); err != nil {
+		return 0, fmt.Errorf("failed to reseal vault: %w", err)
+	}
+	return applied, nil
+}
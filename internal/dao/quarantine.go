@@ -0,0 +1,231 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Rows of the projected tables that no longer decrypt can be moved aside
+// into the quarantine table and the tables rebuilt from the event log.
+// Events cannot be: the log is append-only and each event is chained to the
+// next, so a vault with unreadable events has to be restored from a backup.
+
+// ErrUnreadableEvents is returned by Quarantine when the event log itself
+// holds rows that do not decrypt
+var ErrUnreadableEvents = errors.New("the event log has unreadable events; restore the vault from a backup")
+
+// UnreadableRow is an encrypted row that does not decrypt with the vault's
+// keys, or whose plaintext does not decode
+type UnreadableRow struct {
+	Table string `json:"table"`
+	RowID int64  `json:"rowid"`
+	// Name identifies the row: the record key, or the sequence number of an event
+	Name  string `json:"name"`
+	KeyID string `json:"key_id,omitempty"`
+	Error string `json:"error"`
+}
+
+// RowCheck is the result of CheckRows
+type RowCheck struct {
+	// Checked counts the rows checked per table
+	Checked    map[string]int64 `json:"checked"`
+	Unreadable []UnreadableRow  `json:"unreadable,omitempty"`
+}
+
+// decodeChecks validate the plaintext of tables holding structured data
+var decodeChecks = map[string]func(plaintext []byte) error{
+	"events": func(plaintext []byte) error { return json.Unmarshal(plaintext, &eventPayload{}) },
+	"links":  func(plaintext []byte) error { return json.Unmarshal(plaintext, &Link{}) },
+}
+
+// CheckRows decrypts every row of every encrypted column of the vault with
+// workers goroutines, or one per CPU if workers is not positive, and returns
+// the rows that fail in table and row order
+func (d *SecureVaultDAO) CheckRows(workers int) (*RowCheck, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	type job struct {
+		table      string
+		row        UnreadableRow
+		ciphertext []byte
+	}
+	jobs := make(chan job, workers*4)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	check := &RowCheck{Checked: make(map[string]int64)}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				plaintext, err := d.keys.decrypt(j.row.KeyID, j.ciphertext)
+				if decode := decodeChecks[j.table]; err == nil && decode != nil {
+					if err = decode(plaintext); err != nil {
+						err = fmt.Errorf("failed to decode: %w", err)
+					}
+				}
+				if err != nil {
+					j.row.Error = err.Error()
+					mu.Lock()
+					check.Unreadable = append(check.Unreadable, j.row)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	err := d.eachEncryptedRow(func(table string, row UnreadableRow, ciphertext []byte) {
+		check.Checked[table]++
+		jobs <- job{table: table, row: row, ciphertext: ciphertext}
+	})
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	order := make(map[string]int, len(encryptedColumns))
+	for i, c := range encryptedColumns {
+		order[c.Table] = i
+	}
+	sort.Slice(check.Unreadable, func(i, j int) bool {
+		a, b := check.Unreadable[i], check.Unreadable[j]
+		if a.Table != b.Table {
+			return order[a.Table] < order[b.Table]
+		}
+		return a.RowID < b.RowID
+	})
+	return check, nil
+}
+
+// eachEncryptedRow passes every row of every encrypted column to fn
+func (d *SecureVaultDAO) eachEncryptedRow(fn func(table string, row UnreadableRow, ciphertext []byte)) error {
+	for _, c := range encryptedColumns {
+		rows, err := d.db.Query("SELECT rowid, " + c.name + ", " + c.Column + ", key_id FROM " + c.Table + " ORDER BY rowid")
+		if err != nil {
+			return fmt.Errorf("failed to query %s table: %w", c.Table, err)
+		}
+		for rows.Next() {
+			row := UnreadableRow{Table: c.Table}
+			var ciphertext []byte
+			if err := rows.Scan(&row.RowID, &row.Name, &ciphertext, &row.KeyID); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan %s row: %w", c.Table, err)
+			}
+			fn(c.Table, row, ciphertext)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("error iterating %s table: %w", c.Table, err)
+		}
+	}
+	return nil
+}
+
+// Quarantine moves the given rows of the projected tables into the
+// quarantine table and rebuilds the projected tables from the event log,
+// in one transaction. It returns the number of rows moved. Unreadable
+// events are refused with ErrUnreadableEvents.
+func (d *SecureVaultDAO) Quarantine(bad []UnreadableRow) (int, error) {
+	if d.readOnly != nil {
+		return 0, d.readOnly
+	}
+	declared := make(map[string]bool, len(encryptedColumns))
+	for _, c := range encryptedColumns {
+		declared[c.Table] = true
+	}
+	for _, row := range bad {
+		if row.Table == "events" {
+			return 0, ErrUnreadableEvents
+		}
+		if !declared[row.Table] {
+			return 0, fmt.Errorf("cannot quarantine rows of unknown table %q", row.Table)
+		}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	moved := 0
+	for _, row := range bad {
+		data, err := rowJSON(tx, row.Table, row.RowID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO quarantine (source_table, source_rowid, name, row, reason) VALUES (?, ?, ?, ?, ?)",
+			row.Table, row.RowID, row.Name, data, row.Error,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to quarantine %s row of %s: %w", row.Table, row.Name, err)
+		}
+		if _, err := tx.Exec("DELETE FROM "+row.Table+" WHERE rowid = ?", row.RowID); err != nil {
+			return 0, fmt.Errorf("failed to remove %s row of %s: %w", row.Table, row.Name, err)
+		}
+		moved++
+	}
+
+	// Corrupt rows break the seal's Merkle root but not the event log head it
+	// authenticates, so the log can still be replayed and the vault resealed
+	if _, err := d.projector.replay(tx); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit quarantine: %w", err)
+	}
+	return moved, nil
+}
+
+// Quarantined returns the number of rows in the quarantine table
+func (d *SecureVaultDAO) Quarantined() (int64, error) {
+	var n int64
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM quarantine").Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count quarantined rows: %w", err)
+	}
+	return n, nil
+}
+
+// rowJSON encodes every column of a row as a JSON object; blobs become base64
+func rowJSON(q querier, table string, rowid int64) ([]byte, error) {
+	rows, err := q.Query("SELECT * FROM "+table+" WHERE rowid = ?", rowid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s row %d: %w", table, rowid, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s row %d: %w", table, rowid, err)
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s row %d: %w", table, rowid, err)
+		}
+		return nil, sql.ErrNoRows
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, fmt.Errorf("failed to read %s row %d: %w", table, rowid, err)
+	}
+	object := make(map[string]interface{}, len(columns))
+	for i, name := range columns {
+		object[name] = values[i]
+	}
+	return json.Marshal(object)
+}
@@ -0,0 +1,93 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/integrity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRowsAndQuarantine(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	vault := NewSecureVaultDAO(db, key)
+	require.NoError(t, vault.Put("db", []byte("postgres password hunter2")))
+	require.NoError(t, vault.Put("api", []byte("stripe token sk_live")))
+	require.NoError(t, vault.Tag("db", "prod"))
+	require.NoError(t, vault.Link(Link{From: "api", To: "db", Type: LinkCredentialFor, Weight: 1}))
+
+	check, err := vault.CheckRows(4)
+	require.NoError(t, err)
+	assert.Empty(t, check.Unreadable)
+	assert.Equal(t, int64(4), check.Checked["events"])
+	assert.Equal(t, int64(2), check.Checked["vault"])
+	assert.Equal(t, int64(1), check.Checked["labels"])
+
+	// Append a byte to a record's ciphertext and garble a label
+	_, err = db.Exec("UPDATE vault SET value = CAST(value AS BLOB) || x'00' WHERE key = 'db'")
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE labels SET label = x'0102' WHERE record_key = 'db'")
+	require.NoError(t, err)
+
+	check, err = vault.CheckRows(0)
+	require.NoError(t, err)
+	require.Len(t, check.Unreadable, 2)
+	assert.Equal(t, "vault", check.Unreadable[0].Table)
+	assert.Equal(t, "db", check.Unreadable[0].Name)
+	assert.Equal(t, "labels", check.Unreadable[1].Table)
+	_, err = vault.Get("db")
+	assert.Error(t, err)
+
+	moved, err := vault.Quarantine(check.Unreadable)
+	require.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	n, err := vault.Quarantined()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// The projected rows are rebuilt from the event log and the vault resealed
+	value, err := vault.Get("db")
+	require.NoError(t, err)
+	assert.Equal(t, "postgres password hunter2", string(value))
+	labels, err := vault.Labels("db")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod"}, labels)
+	_, err = integrity.Verify(db, key)
+	assert.NoError(t, err)
+	check, err = vault.CheckRows(2)
+	require.NoError(t, err)
+	assert.Empty(t, check.Unreadable)
+
+	var reason string
+	require.NoError(t, db.QueryRow("SELECT reason FROM quarantine WHERE source_table = 'vault'").Scan(&reason))
+	assert.NotEmpty(t, reason)
+}
+
+func TestQuarantineRefusesEvents(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	vault := NewSecureVaultDAO(db, key)
+	require.NoError(t, vault.Put("db", []byte("hunter2")))
+
+	// Read the log with a different key: every event is unreadable
+	other, err := crypto.Generate(32)
+	require.NoError(t, err)
+	stranger := NewSecureVaultDAO(db, other)
+	check, err := stranger.CheckRows(1)
+	require.NoError(t, err)
+	require.NotEmpty(t, check.Unreadable)
+	assert.Equal(t, "events", check.Unreadable[0].Table)
+	assert.Equal(t, "1", check.Unreadable[0].Name)
+
+	_, err = stranger.Quarantine(check.Unreadable)
+	assert.ErrorIs(t, err, ErrUnreadableEvents)
+	value, err := vault.Get("db")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", string(value))
+}
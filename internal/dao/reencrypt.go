@@ -0,0 +1,305 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/n1/n1/internal/crypto"
+)
+
+// Every column of the vault schema holding data encrypted with the master
+// key is declared in encryptedColumns, and its table records the key of each
+// row in a key_id column. Re-encrypting a vault walks the declared columns;
+// a table with a key_id column that is not declared makes it refuse to
+// start, so a new table cannot be left behind under the retired key.
+
+// EncryptedColumn describes a column of the vault schema holding data
+// encrypted with the master key
+type EncryptedColumn struct {
+	Table  string
+	Column string
+	// Derived lists the columns computed from the data under the key, such
+	// as blind indexes and hashes, which change along with the ciphertext
+	Derived []string
+	// Rebuilt is set if the rows are rebuilt from their record instead of
+	// re-encrypted in place, so that their row IDs change
+	Rebuilt bool
+}
+
+// encryptedColumn declares an encrypted column and how to re-encrypt it
+type encryptedColumn struct {
+	EncryptedColumn
+	// name is the column identifying a row in errors
+	name string
+	// blindOf returns the value indexed in the blind column Derived[0],
+	// under a key derived with blindContext
+	blindOf      func(plaintext []byte) (string, error)
+	blindContext string
+	// rebuild and count replace re-encrypting rows in place and counting
+	// the rows left, for columns that cannot be re-encrypted row by row
+	rebuild func(d *SecureVaultDAO, tx *sql.Tx, r *onlineRotation, limit int) (int, error)
+	count   func(q querier, r *onlineRotation) (int64, error)
+}
+
+// encryptedColumns are re-encrypted in order: the event log first, as the
+// source of truth
+var encryptedColumns = []encryptedColumn{
+	{
+		EncryptedColumn: EncryptedColumn{Table: "events", Column: "payload", Derived: []string{"prev_hash", "hash"}},
+		name:            "seq",
+		rebuild:         (*SecureVaultDAO).relinkEvents,
+		count: func(q querier, r *onlineRotation) (int64, error) {
+			var relinked int64
+			if r.Seam != nil {
+				relinked = r.Seam.Seq
+			}
+			var n int64
+			err := q.QueryRow("SELECT COUNT(*) FROM events WHERE seq > ?", relinked).Scan(&n)
+			return n, err
+		},
+	},
+	{
+		EncryptedColumn: EncryptedColumn{Table: "vault", Column: "value"},
+		name:            "key",
+	},
+	{
+		EncryptedColumn: EncryptedColumn{Table: "labels", Column: "label", Derived: []string{"blind"}},
+		name:            "record_key",
+		blindContext:    blindContext,
+		blindOf:         func(label []byte) (string, error) { return string(label), nil },
+	},
+	{
+		EncryptedColumn: EncryptedColumn{Table: "links", Column: "data", Derived: []string{"type_blind"}},
+		name:            "from_key",
+		blindContext:    linkBlindContext,
+		blindOf: func(data []byte) (string, error) {
+			var l Link
+			if err := json.Unmarshal(data, &l); err != nil {
+				return "", fmt.Errorf("failed to decode link: %w", err)
+			}
+			return l.Type, nil
+		},
+	},
+	{
+		EncryptedColumn: EncryptedColumn{Table: "vectors", Column: "vector"},
+		name:            "record_key",
+	},
+	{
+		EncryptedColumn: EncryptedColumn{Table: "vector_buckets", Column: "bucket", Derived: []string{"blind"}},
+		name:            "record_key",
+		blindContext:    lshBlindContext,
+		blindOf:         func(bucket []byte) (string, error) { return string(bucket), nil },
+	},
+	{
+		EncryptedColumn: EncryptedColumn{Table: "search_postings", Column: "positions", Derived: []string{"blind"}, Rebuilt: true},
+		name:            "record_key",
+		rebuild:         (*SecureVaultDAO).reindexPostings,
+		count: func(q querier, r *onlineRotation) (int64, error) {
+			var n int64
+			err := q.QueryRow("SELECT COUNT(DISTINCT record_key) FROM search_postings WHERE key_id != ?", r.To).Scan(&n)
+			return n, err
+		},
+	},
+}
+
+// EncryptedColumns returns every encrypted column of the vault schema
+func EncryptedColumns() []EncryptedColumn {
+	columns := make([]EncryptedColumn, len(encryptedColumns))
+	for i, c := range encryptedColumns {
+		columns[i] = c.EncryptedColumn
+	}
+	return columns
+}
+
+// CheckEncryptedColumns checks that every table of the vault schema with
+// encrypted rows is declared, and that every declared table has key IDs
+func CheckEncryptedColumns(db *sql.DB) error {
+	return checkEncryptedColumns(db)
+}
+
+// checkEncryptedColumns checks that the tables with encrypted rows in the
+// vault schema are exactly the tables of encryptedColumns
+func checkEncryptedColumns(q querier) error {
+	rows, err := q.Query(
+		`SELECT m.name FROM sqlite_master m JOIN pragma_table_info(m.name) p
+		WHERE m.type = 'table' AND p.name = 'key_id' ORDER BY m.name`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to inspect vault schema: %w", err)
+	}
+	defer rows.Close()
+	declared := make(map[string]bool)
+	for _, c := range encryptedColumns {
+		declared[c.Table] = true
+	}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return fmt.Errorf("failed to inspect vault schema: %w", err)
+		}
+		if !declared[table] {
+			return fmt.Errorf("table %s holds encrypted rows that cannot be re-encrypted", table)
+		}
+		delete(declared, table)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect vault schema: %w", err)
+	}
+	if len(declared) > 0 {
+		var missing []string
+		for table := range declared {
+			missing = append(missing, table)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("vault schema lacks key IDs in %v; migrate it first", missing)
+	}
+	return nil
+}
+
+// reencrypt migrates up to limit rows of the column and returns how many it
+// migrated; fewer than limit means none are left
+func (c *encryptedColumn) reencrypt(d *SecureVaultDAO, tx *sql.Tx, r *onlineRotation, limit int) (int, error) {
+	if c.rebuild != nil {
+		return c.rebuild(d, tx, r, limit)
+	}
+	return c.reencryptInPlace(d, tx, r, limit)
+}
+
+// remaining counts the rows of the column left to migrate
+func (c *encryptedColumn) remaining(q querier, r *onlineRotation) (int64, error) {
+	if c.count != nil {
+		return c.count(q, r)
+	}
+	var n int64
+	err := q.QueryRow("SELECT COUNT(*) FROM "+c.Table+" WHERE key_id != ?", r.To).Scan(&n)
+	return n, err
+}
+
+// reencryptInPlace re-encrypts the rows after the table's cursor that are
+// not encrypted with the current key, keeping their row IDs and every other
+// column, and recomputes their blind index if the column has one
+func (c *encryptedColumn) reencryptInPlace(d *SecureVaultDAO, tx *sql.Tx, r *onlineRotation, limit int) (int, error) {
+	var blindKey []byte
+	if c.blindOf != nil {
+		var err error
+		if blindKey, err = crypto.DeriveHKDF(d.keys.current, c.blindContext, 32); err != nil {
+			return 0, fmt.Errorf("failed to derive %s index key: %w", c.Table, err)
+		}
+	}
+
+	rows, err := tx.Query(
+		"SELECT rowid, "+c.name+", "+c.Column+", key_id FROM "+c.Table+" WHERE rowid > ? AND key_id != ? ORDER BY rowid LIMIT ?",
+		r.Cursors[c.Table], d.keys.id, limit,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s table: %w", c.Table, err)
+	}
+	type row struct {
+		rowid      int64
+		name       string
+		ciphertext []byte
+		keyID      string
+	}
+	var pending []row
+	for rows.Next() {
+		var p row
+		if err := rows.Scan(&p.rowid, &p.name, &p.ciphertext, &p.keyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan %s row: %w", c.Table, err)
+		}
+		pending = append(pending, p)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("error iterating %s table: %w", c.Table, err)
+	}
+	rows.Close()
+
+	for _, p := range pending {
+		plaintext, err := d.keys.decrypt(p.keyID, p.ciphertext)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt %s row of %s: %w", c.Table, p.name, err)
+		}
+		ciphertext, err := d.keys.encrypt(plaintext)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt %s row of %s: %w", c.Table, p.name, err)
+		}
+		if blindKey == nil {
+			_, err = tx.Exec(
+				"UPDATE "+c.Table+" SET "+c.Column+" = ?, key_id = ? WHERE rowid = ?",
+				ciphertext, d.keys.id, p.rowid,
+			)
+		} else {
+			var indexed string
+			if indexed, err = c.blindOf(plaintext); err != nil {
+				return 0, fmt.Errorf("failed to re-encrypt %s row of %s: %w", c.Table, p.name, err)
+			}
+			// The row may have been written again with the current key
+			// meanwhile, under the new blind index
+			_, err = tx.Exec(
+				"UPDATE OR REPLACE "+c.Table+" SET "+c.Column+" = ?, key_id = ?, "+c.Derived[0]+" = ? WHERE rowid = ?",
+				ciphertext, d.keys.id, blindIndex(blindKey, indexed), p.rowid,
+			)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to re-encrypt %s row of %s: %w", c.Table, p.name, err)
+		}
+		r.Cursors[c.Table] = p.rowid
+	}
+	return len(pending), nil
+}
+
+// reindexPostings rebuilds the postings of records indexed with the retired
+// key. Their blind indexes cannot be recomputed from the postings alone, so
+// each record is indexed again from its value; its term count is unchanged.
+// The cursor is the vault row id of the last record reindexed.
+func (d *SecureVaultDAO) reindexPostings(tx *sql.Tx, r *onlineRotation, limit int) (int, error) {
+	const table = "search_postings"
+	rows, err := tx.Query(
+		`SELECT id, key FROM vault v WHERE id > ? AND EXISTS (
+			SELECT 1 FROM search_postings p WHERE p.record_key = v.key AND p.key_id != ?
+		) ORDER BY id LIMIT ?`,
+		r.Cursors[table], d.keys.id, limit,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query search index: %w", err)
+	}
+	var ids []int64
+	var keys []string
+	for rows.Next() {
+		var id int64
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan vault row: %w", err)
+		}
+		ids, keys = append(ids, id), append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("error iterating vault rows: %w", err)
+	}
+	rows.Close()
+
+	vault := newTxVaultDAO(tx)
+	for i, key := range keys {
+		record, err := vault.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		value, err := d.keys.decrypt(record.KeyID, record.Value)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt value for key %s: %w", key, err)
+		}
+		if _, err := tx.Exec("DELETE FROM search_postings WHERE record_key = ?", key); err != nil {
+			return 0, fmt.Errorf("failed to remove %s from search index: %w", key, err)
+		}
+		if _, err := writePostings(vault, d.keys, key, value); err != nil {
+			return 0, err
+		}
+		r.Cursors[table] = ids[i]
+	}
+	return len(keys), nil
+}
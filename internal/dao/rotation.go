@@ -0,0 +1,351 @@
+package dao
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/integrity"
+)
+
+// An online key rotation switches a vault to a new master key while it stays
+// in use. Once it has begun, every write uses the new key, and Reencrypt
+// migrates the rows written with the retired key in small transactions,
+// column by column of encryptedColumns. Blind indexes are recomputed under
+// the new key along the way; until then lookups try the blind indexes of
+// both keys.
+//
+// Re-encrypting an event changes its hash and so the hash of every later
+// event. Reencrypt relinks the log from the start, batch by batch; the seam
+// between the relinked events and the rest is recorded in the rotation
+// state, MAC'd with the new key, so the chain verifies between batches.
+
+// rotationMetaName is the vault_meta row holding the state of an online
+// rotation. The events table only accepts re-encrypted payloads while it exists.
+const rotationMetaName = "rotation.online"
+
+// seamContext is the HKDF context for the MAC over the chain seam
+const seamContext = "n1/rotation/seam/v1"
+
+var (
+	// ErrNoRotation is returned by Reencrypt when no online rotation is in progress
+	ErrNoRotation = errors.New("no online key rotation in progress")
+)
+
+// onlineRotation is the state of an online rotation, kept in vault_meta
+type onlineRotation struct {
+	// From and To are the fingerprints of the retired and the new key
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	StartedAt time.Time `json:"started_at"`
+	// Cursors hold the last row re-encrypted per table
+	Cursors map[string]int64 `json:"cursors"`
+	// Seam is where the relinked part of the event log ends
+	Seam *chainSeam `json:"seam,omitempty"`
+}
+
+// chainSeam joins the relinked part of the event log to the rest: the event
+// at Seq now hashes to NewHash, while its successor still links to OldHash
+type chainSeam struct {
+	Seq     int64  `json:"seq"`
+	OldHash []byte `json:"old_hash"`
+	NewHash []byte `json:"new_hash"`
+	MAC     []byte `json:"mac"`
+}
+
+// RotationStatus describes an online key rotation in progress
+type RotationStatus struct {
+	// From and To are the fingerprints of the retired and the new key
+	From      string
+	To        string
+	StartedAt time.Time
+	// Remaining is the number of rows left to re-encrypt or relink
+	Remaining int64
+}
+
+func loadRotation(q querier) (*onlineRotation, error) {
+	var data []byte
+	err := q.QueryRow("SELECT value FROM vault_meta WHERE name = ?", rotationMetaName).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key rotation state: %w", err)
+	}
+	var r onlineRotation
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to decode key rotation state: %w", err)
+	}
+	if r.Cursors == nil {
+		r.Cursors = make(map[string]int64)
+	}
+	return &r, nil
+}
+
+func (r *onlineRotation) save(q querier) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode key rotation state: %w", err)
+	}
+	_, err = q.Exec(
+		`INSERT INTO vault_meta (name, value) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET value = excluded.value`,
+		rotationMetaName, data,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store key rotation state: %w", err)
+	}
+	return nil
+}
+
+func (s *chainSeam) mac(masterKey []byte) ([]byte, error) {
+	macKey, err := crypto.DeriveHKDF(masterKey, seamContext, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive seam key: %w", err)
+	}
+	m := hmac.New(sha256.New, macKey)
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], uint64(s.Seq))
+	m.Write(seq[:])
+	m.Write(s.OldHash)
+	m.Write(s.NewHash)
+	return m.Sum(nil), nil
+}
+
+func (s *chainSeam) verify(masterKey []byte) error {
+	expected, err := s.mac(masterKey)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(s.MAC, expected) {
+		return fmt.Errorf("%w: seam of the key rotation at event %d is not authentic", ErrChainBroken, s.Seq)
+	}
+	return nil
+}
+
+// Rotation returns the status of the vault's online key rotation, or nil if
+// none is in progress
+func (d *SecureVaultDAO) Rotation() (*RotationStatus, error) {
+	r, err := loadRotation(d.db)
+	if err != nil || r == nil {
+		return nil, err
+	}
+	status := &RotationStatus{From: r.From, To: r.To, StartedAt: r.StartedAt}
+
+	for _, c := range encryptedColumns {
+		n, err := c.remaining(d.db, r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s rows left to re-encrypt: %w", c.Table, err)
+		}
+		status.Remaining += n
+	}
+	return status, nil
+}
+
+// BeginRotation starts an online rotation from the retired key (see
+// WithRetiredKey) to the current key: it records the rotation in the vault
+// and reseals it with the current key. Beginning the rotation that is
+// already in progress does nothing.
+func (d *SecureVaultDAO) BeginRotation() error {
+	if d.readOnly != nil {
+		return d.readOnly
+	}
+	if d.keys.retired == nil {
+		return errors.New("an online rotation needs the key it retires")
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	r, err := loadRotation(tx)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		if r.From == d.keys.retiredID && r.To == d.keys.id {
+			return nil
+		}
+		return fmt.Errorf("a rotation from key %s to key %s is already in progress", r.From, r.To)
+	}
+
+	if err := checkEncryptedColumns(tx); err != nil {
+		return err
+	}
+	// Never reseal a tampered vault under the new key
+	sealKey, err := d.keys.sealKey(tx)
+	if err != nil {
+		return err
+	}
+	if err := verifySeal(tx, sealKey); err != nil {
+		return fmt.Errorf("refusing to rotate: %w", err)
+	}
+	r = &onlineRotation{From: d.keys.retiredID, To: d.keys.id, StartedAt: time.Now().UTC(), Cursors: make(map[string]int64)}
+	if err := r.save(tx); err != nil {
+		return err
+	}
+	if _, err := integrity.Update(tx, d.keys.current); err != nil {
+		return fmt.Errorf("failed to reseal vault: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit start of key rotation: %w", err)
+	}
+	return nil
+}
+
+// Reencrypt migrates up to limit rows of the online rotation in progress
+// to the current key in one transaction and returns how many it migrated.
+// Once no rows are left it ends the rotation and reports done: from then on
+// the retired key is no longer needed.
+func (d *SecureVaultDAO) Reencrypt(limit int) (migrated int, done bool, err error) {
+	if d.readOnly != nil {
+		return 0, false, d.readOnly
+	}
+	if limit <= 0 {
+		return 0, false, fmt.Errorf("invalid batch size %d", limit)
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	r, err := loadRotation(tx)
+	if err != nil {
+		return 0, false, err
+	}
+	if r == nil {
+		return 0, false, ErrNoRotation
+	}
+	if r.To != d.keys.id || r.From != d.keys.retiredID {
+		return 0, false, fmt.Errorf("vault is rotating from key %s to key %s, not from %s to %s", r.From, r.To, d.keys.retiredID, d.keys.id)
+	}
+	if err := verifySeal(tx, d.keys.current); err != nil {
+		return 0, false, fmt.Errorf("refusing to re-encrypt: %w", err)
+	}
+
+	budget := limit
+	for _, c := range encryptedColumns {
+		n, err := c.reencrypt(d, tx, r, budget)
+		if err != nil {
+			return 0, false, err
+		}
+		migrated += n
+		if budget -= n; budget == 0 {
+			break
+		}
+	}
+
+	if budget > 0 {
+		// Every stage ran dry
+		if _, err := tx.Exec("DELETE FROM vault_meta WHERE name = ?", rotationMetaName); err != nil {
+			return 0, false, fmt.Errorf("failed to end key rotation: %w", err)
+		}
+		done = true
+	} else if err := r.save(tx); err != nil {
+		return 0, false, err
+	}
+	if _, err := integrity.Update(tx, d.keys.current); err != nil {
+		return 0, false, fmt.Errorf("failed to reseal vault: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("failed to commit re-encrypted rows: %w", err)
+	}
+	return migrated, done, nil
+}
+
+// relinkEvents re-encrypts the events after the seam and relinks them to
+// the relinked part of the chain. Events appended during the rotation are
+// already encrypted with the current key but may still need relinking.
+func (d *SecureVaultDAO) relinkEvents(tx *sql.Tx, r *onlineRotation, limit int) (int, error) {
+	after, prev, oldPrev := int64(0), genesisHash, genesisHash
+	if r.Seam != nil {
+		after, prev, oldPrev = r.Seam.Seq, r.Seam.NewHash, r.Seam.OldHash
+	}
+
+	type row struct {
+		seq       int64
+		eventType EventType
+		payload   []byte
+		keyID     string
+		prevHash  []byte
+		hash      []byte
+		createdAt time.Time
+	}
+	rows, err := tx.Query(
+		"SELECT seq, type, payload, key_id, prev_hash, hash, created_at FROM events WHERE seq > ? ORDER BY seq LIMIT ?",
+		after, limit,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query events: %w", err)
+	}
+	var events []row
+	for rows.Next() {
+		var e row
+		var eventType string
+		if err := rows.Scan(&e.seq, &eventType, &e.payload, &e.keyID, &e.prevHash, &e.hash, &e.createdAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		e.eventType = EventType(eventType)
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, fmt.Errorf("error iterating events: %w", err)
+	}
+	rows.Close()
+
+	for _, e := range events {
+		// Relinking a broken chain would vouch for it
+		if e.seq != after+1 {
+			return 0, fmt.Errorf("%w: expected event %d, found %d", ErrChainBroken, after+1, e.seq)
+		}
+		if !bytes.Equal(e.prevHash, oldPrev) && !bytes.Equal(e.prevHash, prev) {
+			return 0, fmt.Errorf("%w: event %d does not link to its predecessor", ErrChainBroken, e.seq)
+		}
+		if !bytes.Equal(e.hash, eventHash(e.seq, e.eventType, e.payload, e.prevHash, e.createdAt)) {
+			return 0, fmt.Errorf("%w: event %d hash mismatch", ErrChainBroken, e.seq)
+		}
+
+		payload, keyID := e.payload, e.keyID
+		if keyID != d.keys.id {
+			plaintext, err := d.keys.decrypt(keyID, payload)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decrypt event %d: %w", e.seq, err)
+			}
+			if payload, err = d.keys.encrypt(plaintext); err != nil {
+				return 0, fmt.Errorf("failed to encrypt event %d: %w", e.seq, err)
+			}
+			keyID = d.keys.id
+		}
+		hash := eventHash(e.seq, e.eventType, payload, prev, e.createdAt)
+		if !bytes.Equal(hash, e.hash) || !bytes.Equal(prev, e.prevHash) {
+			_, err := tx.Exec(
+				"UPDATE events SET payload = ?, key_id = ?, prev_hash = ?, hash = ? WHERE seq = ?",
+				payload, keyID, prev, hash, e.seq,
+			)
+			if err != nil {
+				return 0, fmt.Errorf("failed to re-encrypt event %d: %w", e.seq, err)
+			}
+		}
+		after, prev, oldPrev = e.seq, hash, e.hash
+	}
+
+	if len(events) > 0 {
+		seam := &chainSeam{Seq: after, OldHash: oldPrev, NewHash: prev}
+		if seam.MAC, err = seam.mac(d.keys.current); err != nil {
+			return 0, err
+		}
+		r.Seam = seam
+	}
+	return len(events), nil
+}
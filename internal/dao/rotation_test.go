@@ -0,0 +1,213 @@
+package dao
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/integrity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupRotatingVault fills a vault under one key and begins an online
+// rotation to a second key
+func setupRotatingVault(t *testing.T) (*sql.DB, *SecureVaultDAO, []byte) {
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	oldKey, err := crypto.Generate(32)
+	require.NoError(t, err)
+	old := NewSecureVaultDAO(db, oldKey)
+
+	require.NoError(t, old.Put("db", []byte("postgres password hunter2")))
+	require.NoError(t, old.Put("api", []byte("stripe token sk_live")))
+	require.NoError(t, old.Put("mail", []byte("smtp relay password")))
+	require.NoError(t, old.Tag("db", "prod", "env=prod"))
+	require.NoError(t, old.Tag("api", "prod"))
+	require.NoError(t, old.Link(Link{From: "api", To: "db", Type: LinkCredentialFor, Weight: 1}))
+	require.NoError(t, old.DefineScope(Scope{Name: "work", Policy: PolicyOpen}))
+	require.NoError(t, old.Move("mail", "work"))
+
+	newKey, err := crypto.Generate(32)
+	require.NoError(t, err)
+	vault := NewSecureVaultDAO(db, newKey).WithRetiredKey(oldKey)
+	require.NoError(t, vault.BeginRotation())
+	return db, vault, oldKey
+}
+
+// requireReadable checks that every kind of row reads back through vault
+func requireReadable(t *testing.T, vault *SecureVaultDAO) {
+	t.Helper()
+	value, err := vault.Get("db")
+	require.NoError(t, err)
+	assert.Equal(t, "postgres password hunter2", string(value))
+
+	labels, err := vault.Labels("db")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env=prod", "prod"}, labels)
+	keys, err := vault.FindByTags(TagQuery{All: []string{"prod"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "db"}, keys)
+
+	results, err := vault.Search("password", 0)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	results, err = vault.Search("pass*", 0)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	similar, err := vault.SemanticSearch("stripe token", 1)
+	require.NoError(t, err)
+	require.Len(t, similar, 1)
+	assert.Equal(t, "api", similar[0].Key)
+
+	links, err := vault.Links("api")
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, LinkCredentialFor, links[0].Type)
+
+	scope, err := vault.ScopeOf("mail")
+	require.NoError(t, err)
+	assert.Equal(t, "work", scope)
+	require.NoError(t, vault.Events().Verify())
+}
+
+// staleRows counts the encrypted rows not written with key
+func staleRows(t *testing.T, db *sql.DB, key []byte) int {
+	t.Helper()
+	total := 0
+	for _, table := range []string{"vault", "events", "labels", "search_postings", "vectors", "vector_buckets", "links"} {
+		var n int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE key_id != ?", crypto.Fingerprint(key)).Scan(&n))
+		total += n
+	}
+	return total
+}
+
+func TestOnlineRotation(t *testing.T) {
+	db, vault, oldKey := setupRotatingVault(t)
+	newKey := vault.Key()
+
+	status, err := vault.Rotation()
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.Equal(t, crypto.Fingerprint(oldKey), status.From)
+	assert.Equal(t, crypto.Fingerprint(newKey), status.To)
+	assert.Positive(t, status.Remaining)
+
+	// Part way through, readers see every row and writers use the new key
+	migrated, done, err := vault.Reencrypt(3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, migrated)
+	assert.False(t, done)
+	requireReadable(t, vault)
+
+	require.NoError(t, vault.Put("new", []byte("written during rotation")))
+	require.NoError(t, vault.Tag("db", "prod"))
+	require.NoError(t, vault.Link(Link{From: "api", To: "db", Type: LinkCredentialFor, Weight: 2}))
+	requireReadable(t, vault)
+	links, err := vault.Links("api")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, links[0].Weight, "relinking under the new key replaces the old link")
+
+	for !done {
+		_, done, err = vault.Reencrypt(2)
+		require.NoError(t, err)
+		requireReadable(t, vault)
+	}
+
+	assert.Zero(t, staleRows(t, db, newKey))
+	status, err = vault.Rotation()
+	require.NoError(t, err)
+	assert.Nil(t, status)
+
+	// The retired key is no longer needed
+	rotated := NewSecureVaultDAO(db, newKey)
+	requireReadable(t, rotated)
+	_, err = integrity.Verify(db, newKey)
+	require.NoError(t, err)
+	_, err = NewSecureVaultDAO(db, oldKey).Get("db")
+	assert.Error(t, err)
+
+	_, err = db.Exec("UPDATE events SET payload = payload")
+	assert.Error(t, err, "events are append-only again once the rotation ends")
+	_, _, err = rotated.Reencrypt(10)
+	assert.ErrorIs(t, err, ErrNoRotation)
+}
+
+func TestOnlineRotationPreservesTimestamps(t *testing.T) {
+	db, vault, _ := setupRotatingVault(t)
+	before, err := NewVaultDAO(db).Get("db")
+	require.NoError(t, err)
+
+	for done := false; !done; {
+		_, done, err = vault.Reencrypt(100)
+		require.NoError(t, err)
+	}
+
+	after, err := NewVaultDAO(db).Get("db")
+	require.NoError(t, err)
+	assert.Equal(t, before.ID, after.ID)
+	assert.True(t, before.CreatedAt.Equal(after.CreatedAt))
+	assert.True(t, before.UpdatedAt.Equal(after.UpdatedAt), "re-encrypting is not an update")
+	assert.NotEqual(t, before.Value, after.Value)
+}
+
+func TestReplayDuringOnlineRotation(t *testing.T) {
+	db, vault, _ := setupRotatingVault(t)
+	_, _, err := vault.Reencrypt(4)
+	require.NoError(t, err)
+
+	_, err = vault.Replay()
+	require.NoError(t, err)
+	requireReadable(t, vault)
+
+	for done := false; !done; {
+		_, done, err = vault.Reencrypt(5)
+		require.NoError(t, err)
+	}
+	assert.Zero(t, staleRows(t, db, vault.Key()))
+	requireReadable(t, NewSecureVaultDAO(db, vault.Key()))
+}
+
+func TestOnlineRotationDetectsForgedSeam(t *testing.T) {
+	db, vault, _ := setupRotatingVault(t)
+	_, _, err := vault.Reencrypt(2)
+	require.NoError(t, err)
+	require.NoError(t, vault.Events().Verify())
+
+	// Whoever lacks the new key cannot move the seam
+	r, err := loadRotation(db)
+	require.NoError(t, err)
+	r.Seam.Seq++
+	require.NoError(t, r.save(db))
+	assert.ErrorIs(t, vault.Events().Verify(), ErrChainBroken)
+}
+
+func TestBeginRotationIsIdempotent(t *testing.T) {
+	db, vault, oldKey := setupRotatingVault(t)
+	require.NoError(t, vault.BeginRotation())
+
+	otherKey, err := crypto.Generate(32)
+	require.NoError(t, err)
+	assert.Error(t, NewSecureVaultDAO(db, otherKey).WithRetiredKey(oldKey).BeginRotation(),
+		"a second rotation must not start over an unfinished one")
+	assert.Error(t, NewSecureVaultDAO(db, vault.Key()).BeginRotation(), "beginning needs the retired key")
+}
+
+func TestEncryptedColumnsMatchSchema(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	require.NoError(t, checkEncryptedColumns(db))
+
+	// A new table of encrypted rows must be declared before a rotation can
+	// leave it behind under the retired key
+	_, err := db.Exec("CREATE TABLE secrets (name TEXT PRIMARY KEY, data BLOB NOT NULL, key_id TEXT NOT NULL DEFAULT '')")
+	require.NoError(t, err)
+	assert.ErrorContains(t, checkEncryptedColumns(db), "secrets")
+
+	oldKey, err := crypto.Generate(32)
+	require.NoError(t, err)
+	newKey, err := crypto.Generate(32)
+	require.NoError(t, err)
+	assert.Error(t, NewSecureVaultDAO(db, newKey).WithRetiredKey(oldKey).BeginRotation())
+}
@@ -0,0 +1,224 @@
+package dao
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultScope is the scope new records land in
+const DefaultScope = "inbox"
+
+// scopeKeyPrefix namespaces user-defined scope definitions within the vault,
+// so they are encrypted, event-sourced and sealed like any other record
+const scopeKeyPrefix = "__n1_scope__/"
+
+var (
+	// ErrUnknownScope is returned when a scope has not been defined
+	ErrUnknownScope = errors.New("unknown scope")
+	// ErrReadOnlyScope is returned when writing to a read-only scope without force
+	ErrReadOnlyScope = errors.New("scope is read-only")
+	// ErrScopeInUse is returned when removing a scope that still holds records
+	ErrScopeInUse = errors.New("scope still holds records")
+	// ErrInternalKey is returned when writing to a key reserved for n1's own
+	// bookkeeping without WithInternalKeys
+	ErrInternalKey = errors.New("key is reserved for n1")
+
+	scopeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+)
+
+// Policy governs what may happen to records within a scope
+type Policy string
+
+// Scope policies
+const (
+	// PolicyOpen allows all writes
+	PolicyOpen Policy = "open"
+	// PolicyReadOnly refuses changes to records in the scope unless forced.
+	// Records may still be moved into the scope.
+	PolicyReadOnly Policy = "read-only"
+)
+
+// Scope is an attention/permission zone records are filed under
+type Scope struct {
+	Name    string `json:"name"`
+	Policy  Policy `json:"policy"`
+	Builtin bool   `json:"-"`
+}
+
+// BuiltinScopes are available in every vault and cannot be removed
+var BuiltinScopes = []Scope{
+	{Name: "inbox", Policy: PolicyOpen, Builtin: true},
+	{Name: "sandbox", Policy: PolicyOpen, Builtin: true},
+	{Name: "safebox", Policy: PolicyReadOnly, Builtin: true},
+	{Name: "trashbox", Policy: PolicyOpen, Builtin: true},
+}
+
+// IsInternalKey reports whether a vault key holds n1 bookkeeping rather than user data
+func IsInternalKey(key string) bool {
+	return strings.HasPrefix(key, "__n1_")
+}
+
+// Scopes returns the built-in scopes followed by user-defined ones
+func (d *SecureVaultDAO) Scopes() ([]Scope, error) {
+	return scopes(d.dao, d.keys)
+}
+
+func scopes(vault *VaultDAO, ring *keyring) ([]Scope, error) {
+	all := append([]Scope(nil), BuiltinScopes...)
+
+	keys, err := vault.List()
+	if err != nil {
+		return nil, err
+	}
+	var custom []Scope
+	for _, k := range keys {
+		if !strings.HasPrefix(k, scopeKeyPrefix) {
+			continue
+		}
+		record, err := vault.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := ring.decrypt(record.KeyID, record.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt scope definition %s: %w", k, err)
+		}
+		var s Scope
+		if err := json.Unmarshal(plaintext, &s); err != nil {
+			return nil, fmt.Errorf("failed to decode scope definition %s: %w", k, err)
+		}
+		custom = append(custom, s)
+	}
+	sort.Slice(custom, func(i, j int) bool { return custom[i].Name < custom[j].Name })
+	return append(all, custom...), nil
+}
+
+func lookupScope(vault *VaultDAO, ring *keyring, name string) (Scope, error) {
+	all, err := scopes(vault, ring)
+	if err != nil {
+		return Scope{}, err
+	}
+	for _, s := range all {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Scope{}, fmt.Errorf("%w: %q", ErrUnknownScope, name)
+}
+
+// DefineScope adds a user-defined scope
+func (d *SecureVaultDAO) DefineScope(s Scope) error {
+	if !scopeNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("invalid scope name %q: use lowercase letters, digits or dashes", s.Name)
+	}
+	if s.Policy != PolicyOpen && s.Policy != PolicyReadOnly {
+		return fmt.Errorf("invalid scope policy %q", s.Policy)
+	}
+	for _, b := range BuiltinScopes {
+		if b.Name == s.Name {
+			return fmt.Errorf("scope %q is built in", s.Name)
+		}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode scope: %w", err)
+	}
+	return d.WithInternalKeys().Put(scopeKeyPrefix+s.Name, data)
+}
+
+// RemoveScope deletes a user-defined scope that no longer holds records
+func (d *SecureVaultDAO) RemoveScope(name string) error {
+	s, err := lookupScope(d.dao, d.keys, name)
+	if err != nil {
+		return err
+	}
+	if s.Builtin {
+		return fmt.Errorf("scope %q is built in", name)
+	}
+	keys, err := d.dao.ListScope(name)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return fmt.Errorf("%w: %s has %d records", ErrScopeInUse, name, len(keys))
+	}
+	return d.WithInternalKeys().Delete(scopeKeyPrefix + name)
+}
+
+// Move files a record under another scope
+func (d *SecureVaultDAO) Move(key, scope string) error {
+	return d.Apply(&Event{Type: EventMoveScope, Key: key, Scope: scope})
+}
+
+// ScopeOf returns the scope a record is filed under
+func (d *SecureVaultDAO) ScopeOf(key string) (string, error) {
+	record, err := d.dao.Get(key)
+	if err != nil {
+		return "", err
+	}
+	return record.Scope, nil
+}
+
+// ListScope returns the keys of all records in a scope
+func (d *SecureVaultDAO) ListScope(scope string) ([]string, error) {
+	return d.dao.ListScope(scope)
+}
+
+// WithForce returns a DAO that may write to read-only scopes
+func (d *SecureVaultDAO) WithForce() *SecureVaultDAO {
+	forced := *d
+	forced.force = true
+	return &forced
+}
+
+// WithInternalKeys returns a DAO that may write n1's bookkeeping records,
+// such as scope definitions and the canary. Writes to them through any other
+// DAO fail with ErrInternalKey, so user input cannot corrupt them.
+func (d *SecureVaultDAO) WithInternalKeys() *SecureVaultDAO {
+	internal := *d
+	internal.internal = true
+	return &internal
+}
+
+// checkPolicy enforces scope policies for an event about to be applied.
+// existing is the current record for the key, or nil if there is none.
+func (d *SecureVaultDAO) checkPolicy(vault *VaultDAO, e *Event, existing *VaultRecord) error {
+	if IsInternalKey(e.Key) && !d.internal {
+		return fmt.Errorf("%w: %s", ErrInternalKey, e.Key)
+	}
+	if e.Scope != "" {
+		if _, err := lookupScope(vault, d.keys, e.Scope); err != nil {
+			return err
+		}
+	}
+	if d.force || IsInternalKey(e.Key) {
+		return nil
+	}
+
+	if existing != nil {
+		current, err := lookupScope(vault, d.keys, existing.Scope)
+		if err != nil {
+			return err
+		}
+		if current.Policy == PolicyReadOnly {
+			return fmt.Errorf("%w: %s is in %s (use force to override)", ErrReadOnlyScope, e.Key, current.Name)
+		}
+		return nil
+	}
+
+	// New records may not be written straight into a read-only scope
+	if e.Scope != "" {
+		target, err := lookupScope(vault, d.keys, e.Scope)
+		if err != nil {
+			return err
+		}
+		if target.Policy == PolicyReadOnly && e.Type != EventMoveScope {
+			return fmt.Errorf("%w: cannot create %s in %s (use force to override)", ErrReadOnlyScope, e.Key, target.Name)
+		}
+	}
+	return nil
+}
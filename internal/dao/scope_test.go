@@ -0,0 +1,132 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSecureVault(t *testing.T) *SecureVaultDAO {
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	key, err := crypto.Generate(32)
+	require.NoError(t, err, "Failed to generate key")
+	return NewSecureVaultDAO(db, key)
+}
+
+func TestRecordsDefaultToInbox(t *testing.T) {
+	vault := setupSecureVault(t)
+
+	require.NoError(t, vault.Put("note", []byte("hello")))
+	scope, err := vault.ScopeOf("note")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultScope, scope)
+
+	keys, err := vault.ListScope(DefaultScope)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"note"}, keys)
+}
+
+func TestSafeboxIsReadOnly(t *testing.T) {
+	vault := setupSecureVault(t)
+
+	require.NoError(t, vault.Put("db-password", []byte("v1")))
+	require.NoError(t, vault.Move("db-password", "safebox"), "Moving into a read-only scope should be allowed")
+
+	assert.ErrorIs(t, vault.Put("db-password", []byte("v2")), ErrReadOnlyScope)
+	assert.ErrorIs(t, vault.Delete("db-password"), ErrReadOnlyScope)
+	assert.ErrorIs(t, vault.Move("db-password", "inbox"), ErrReadOnlyScope)
+	assert.ErrorIs(t, vault.Apply(&Event{Type: EventPut, Key: "new", Value: []byte("x"), Scope: "safebox"}), ErrReadOnlyScope,
+		"New records cannot be written straight into a read-only scope")
+
+	value, err := vault.Get("db-password")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value, "Refused writes must not change the record")
+
+	forced := vault.WithForce()
+	require.NoError(t, forced.Put("db-password", []byte("v2")), "Forced write should succeed")
+	value, err = vault.Get("db-password")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), value)
+	require.NoError(t, forced.Move("db-password", "trashbox"), "Forced move out of safebox should succeed")
+}
+
+func TestMoveValidatesScopeAndKey(t *testing.T) {
+	vault := setupSecureVault(t)
+
+	assert.ErrorIs(t, vault.Move("missing", "sandbox"), ErrNotFound)
+	require.NoError(t, vault.Put("note", []byte("hello")))
+	assert.ErrorIs(t, vault.Move("note", "nowhere"), ErrUnknownScope)
+}
+
+func TestUserDefinedScopes(t *testing.T) {
+	vault := setupSecureVault(t)
+
+	require.NoError(t, vault.DefineScope(Scope{Name: "archive", Policy: PolicyReadOnly}))
+	require.NoError(t, vault.DefineScope(Scope{Name: "projects", Policy: PolicyOpen}))
+	assert.Error(t, vault.DefineScope(Scope{Name: "safebox", Policy: PolicyOpen}), "Built-in scopes cannot be redefined")
+	assert.Error(t, vault.DefineScope(Scope{Name: "Bad Name", Policy: PolicyOpen}))
+
+	all, err := vault.Scopes()
+	require.NoError(t, err)
+	var names []string
+	for _, s := range all {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"inbox", "sandbox", "safebox", "trashbox", "archive", "projects"}, names)
+
+	require.NoError(t, vault.Put("report", []byte("q3")))
+	require.NoError(t, vault.Move("report", "archive"))
+	assert.ErrorIs(t, vault.Put("report", []byte("q4")), ErrReadOnlyScope, "User-defined read-only scopes should be enforced")
+
+	assert.ErrorIs(t, vault.RemoveScope("archive"), ErrScopeInUse)
+	require.NoError(t, vault.RemoveScope("projects"))
+	assert.Error(t, vault.RemoveScope("inbox"), "Built-in scopes cannot be removed")
+
+	keys, err := vault.List()
+	require.NoError(t, err)
+	assert.Contains(t, keys, scopeKeyPrefix+"archive", "Scope definitions are stored as internal records")
+	assert.True(t, IsInternalKey(scopeKeyPrefix+"archive"))
+}
+
+func TestReplayRestoresScopes(t *testing.T) {
+	vault := setupSecureVault(t)
+
+	require.NoError(t, vault.Put("a", []byte("1")))
+	require.NoError(t, vault.Put("b", []byte("2")))
+	require.NoError(t, vault.Move("b", "sandbox"))
+	require.NoError(t, vault.Apply(&Event{Type: EventPut, Key: "c", Value: []byte("3"), Scope: "trashbox"}))
+
+	_, err := vault.Replay()
+	require.NoError(t, err, "Replay failed")
+
+	for key, want := range map[string]string{"a": "inbox", "b": "sandbox", "c": "trashbox"} {
+		scope, err := vault.ScopeOf(key)
+		require.NoError(t, err)
+		assert.Equal(t, want, scope, "Scope of %s should survive replay", key)
+	}
+}
+
+// TestInternalKeysAreReserved verifies that user writes cannot reach n1's
+// bookkeeping records, which scope lookups and the canary depend on
+func TestInternalKeysAreReserved(t *testing.T) {
+	vault := setupSecureVault(t)
+	require.NoError(t, vault.DefineScope(Scope{Name: "work", Policy: PolicyOpen}))
+
+	const evil = scopeKeyPrefix + "evil"
+	assert.ErrorIs(t, vault.Put(evil, []byte("garbage")), ErrInternalKey)
+	assert.ErrorIs(t, vault.WithForce().Put(evil, []byte("garbage")), ErrInternalKey, "Force only overrides scope policies")
+	assert.ErrorIs(t, vault.Delete(scopeKeyPrefix+"work"), ErrInternalKey)
+	assert.ErrorIs(t, vault.Move(scopeKeyPrefix+"work", "sandbox"), ErrInternalKey)
+	assert.ErrorIs(t, vault.Tag(scopeKeyPrefix+"work", "x"), ErrInternalKey)
+
+	// Scope lookups still work, so moving records does too
+	require.NoError(t, vault.Put("note", []byte("hello")))
+	require.NoError(t, vault.Move("note", "work"))
+	require.NoError(t, vault.Move("note", DefaultScope))
+	require.NoError(t, vault.RemoveScope("work"))
+
+	require.NoError(t, vault.WithInternalKeys().Put("__n1_canary__", []byte("ok")))
+}
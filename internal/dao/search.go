@@ -0,0 +1,403 @@
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/search"
+)
+
+// searchBlindContext is the HKDF context for the full-text blind index key
+const searchBlindContext = "n1/search/blind/v1"
+
+// The full-text index is an inverted index in the search_postings table. Each
+// posting maps the blind index of a term (an HMAC under a key derived from the
+// master key) to a record and the encrypted positions of the term within it.
+// Prefixes of every term are indexed the same way so that prefix queries stay
+// indexed lookups. search_docs holds the term count of every indexed record
+// for ranking. Like the labels table, the index is a projection of the event
+// log and is rebuilt by Replay.
+//
+// SQLite FTS5 is deliberately not used, even over blinded tokens. An FTS5
+// table needs each record as a token sequence in order, and its shadow tables
+// keep positions and per-record counts in the clear. Blinding the tokens would
+// still reveal which records share words, where and how often, and the token
+// order of every record. The postings here encrypt positions, so only the set
+// of blinded terms and the term count of each record are visible. FTS5 also
+// needs the sqlite_fts5 build tag, which the default go-sqlite3 build does not
+// set. The query features FTS5 would provide (phrases, prefixes, BM25) are
+// implemented on the postings instead.
+
+// SearchResult is a record matching a full-text query
+type SearchResult struct {
+	Key   string
+	Score float64
+}
+
+// Search runs a full-text query (see search.Parse for the syntax) and returns
+// up to limit matching records, best first. A limit of 0 returns all matches.
+func (d *SecureVaultDAO) Search(q string, limit int) ([]SearchResult, error) {
+	query, err := search.Parse(q)
+	if err != nil {
+		return nil, err
+	}
+	blindKeys, err := d.keys.derive(searchBlindContext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Term frequency per record for every clause
+	matches := make([]map[string]int, len(query.Clauses))
+	for i, c := range query.Clauses {
+		matches[i], err = d.matchClause(blindKeys, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var candidates map[string]bool
+	for i, c := range query.Clauses {
+		if c.Exclude {
+			continue
+		}
+		next := make(map[string]bool)
+		for key := range matches[i] {
+			if candidates == nil || candidates[key] {
+				next[key] = true
+			}
+		}
+		candidates = next
+	}
+	for i, c := range query.Clauses {
+		if c.Exclude {
+			for key := range matches[i] {
+				delete(candidates, key)
+			}
+		}
+	}
+
+	stats, err := d.searchStats()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, 0, len(candidates))
+	for key := range candidates {
+		var length int
+		if err := d.db.QueryRow("SELECT length FROM search_docs WHERE record_key = ?", key).Scan(&length); err != nil {
+			return nil, fmt.Errorf("failed to read search statistics for %s: %w", key, err)
+		}
+		r := SearchResult{Key: key}
+		for i, c := range query.Clauses {
+			if !c.Exclude {
+				r.Score += search.BM25(matches[i][key], len(matches[i]), length, stats)
+			}
+		}
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Key < results[j].Key
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// matchClause returns how often each record matches a query clause
+func (d *SecureVaultDAO) matchClause(blindKeys [][]byte, c search.Clause) (map[string]int, error) {
+	switch {
+	case c.Prefix:
+		return d.matchPrefix(blindKeys, c.Terms[0])
+	case c.Phrase():
+		return d.matchPhrase(blindKeys, c.Terms)
+	default:
+		postings, err := d.postings(blindKeys, termToken(c.Terms[0]))
+		if err != nil {
+			return nil, err
+		}
+		tf := make(map[string]int, len(postings))
+		for key, positions := range postings {
+			tf[key] = len(positions)
+		}
+		return tf, nil
+	}
+}
+
+func (d *SecureVaultDAO) matchPrefix(blindKeys [][]byte, prefix string) (map[string]int, error) {
+	stored := prefix
+	if runes := []rune(prefix); len(runes) > search.MaxPrefix {
+		stored = string(runes[:search.MaxPrefix])
+	}
+	postings, err := d.postings(blindKeys, prefixToken(stored))
+	if err != nil {
+		return nil, err
+	}
+
+	tf := make(map[string]int, len(postings))
+	for key, positions := range postings {
+		if stored == prefix {
+			tf[key] = len(positions)
+			continue
+		}
+		// The index only knows the truncated prefix: check the content itself
+		value, err := d.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range search.Tokenize(search.Document(key, value)) {
+			if strings.HasPrefix(t.Term, prefix) {
+				tf[key]++
+			}
+		}
+		if tf[key] == 0 {
+			delete(tf, key)
+		}
+	}
+	return tf, nil
+}
+
+func (d *SecureVaultDAO) matchPhrase(blindKeys [][]byte, terms []string) (map[string]int, error) {
+	postings := make([]map[string][]int, len(terms))
+	for i, term := range terms {
+		var err error
+		if postings[i], err = d.postings(blindKeys, termToken(term)); err != nil {
+			return nil, err
+		}
+	}
+
+	tf := make(map[string]int)
+	for key, starts := range postings[0] {
+		following := make([]map[int]bool, len(terms))
+		for i := 1; i < len(terms); i++ {
+			following[i] = make(map[int]bool, len(postings[i][key]))
+			for _, pos := range postings[i][key] {
+				following[i][pos] = true
+			}
+		}
+	start:
+		for _, pos := range starts {
+			for i := 1; i < len(terms); i++ {
+				if !following[i][pos+i] {
+					continue start
+				}
+			}
+			tf[key]++
+		}
+	}
+	return tf, nil
+}
+
+// postings returns the decrypted positions of an index token in every
+// record containing it. Records indexed under the retired key of an online
+// rotation are found through their own blind index.
+func (d *SecureVaultDAO) postings(blindKeys [][]byte, token string) (map[string][]int, error) {
+	args := blinds(blindKeys, token)
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := d.db.Query("SELECT record_key, positions, key_id FROM search_postings WHERE blind IN ("+marks+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query search index: %w", err)
+	}
+	defer rows.Close()
+
+	postings := make(map[string][]int)
+	for rows.Next() {
+		var key, keyID string
+		var ciphertext []byte
+		if err := rows.Scan(&key, &ciphertext, &keyID); err != nil {
+			return nil, fmt.Errorf("failed to scan search posting: %w", err)
+		}
+		plaintext, err := d.keys.decrypt(keyID, ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt search posting for %s: %w", key, err)
+		}
+		var positions []int
+		if err := json.Unmarshal(plaintext, &positions); err != nil {
+			return nil, fmt.Errorf("failed to decode search posting for %s: %w", key, err)
+		}
+		postings[key] = positions
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search postings: %w", err)
+	}
+	return postings, nil
+}
+
+func (d *SecureVaultDAO) searchStats() (search.Stats, error) {
+	var s search.Stats
+	var avg *float64
+	if err := d.db.QueryRow("SELECT COUNT(*), AVG(length) FROM search_docs").Scan(&s.Docs, &avg); err != nil {
+		return s, fmt.Errorf("failed to read search statistics: %w", err)
+	}
+	if avg != nil {
+		s.AvgLength = *avg
+	}
+	return s, nil
+}
+
+// SearchIndexStale reports whether some records are missing from the search
+// indexes, as in vaults written before the indexes existed, or were embedded
+// with a different model than the configured one
+func (d *SecureVaultDAO) SearchIndexStale() (bool, error) {
+	keys, err := d.dao.List()
+	if err != nil {
+		return false, err
+	}
+	indexable := 0
+	for _, k := range keys {
+		if !IsInternalKey(k) {
+			indexable++
+		}
+	}
+	var indexed, embedded int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM search_docs").Scan(&indexed); err != nil {
+		return false, fmt.Errorf("failed to count indexed records: %w", err)
+	}
+	err = d.db.QueryRow("SELECT COUNT(*) FROM vectors WHERE model = ?", d.projector.embedder.Name()).Scan(&embedded)
+	if err != nil {
+		return false, fmt.Errorf("failed to count embedded records: %w", err)
+	}
+	var bucketed int
+	if err := d.db.QueryRow("SELECT COUNT(DISTINCT record_key) FROM vector_buckets").Scan(&bucketed); err != nil {
+		return false, fmt.Errorf("failed to count records in the vector index: %w", err)
+	}
+	return indexed != indexable || embedded != indexable || bucketed != embedded, nil
+}
+
+// Reindex rebuilds the full-text and semantic indexes from the vault table
+// and returns the number of records indexed
+func (d *SecureVaultDAO) Reindex() (int, error) {
+	if d.readOnly != nil {
+		return 0, d.readOnly
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := clearSearchIndex(tx); err != nil {
+		return 0, err
+	}
+	vault := newTxVaultDAO(tx)
+	keys, err := vault.List()
+	if err != nil {
+		return 0, err
+	}
+	indexed := 0
+	for _, k := range keys {
+		if IsInternalKey(k) {
+			continue
+		}
+		record, err := vault.Get(k)
+		if err != nil {
+			return 0, err
+		}
+		plaintext, err := d.keys.decrypt(record.KeyID, record.Value)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt value for key %s: %w", k, err)
+		}
+		if err := d.projector.index(vault, k, plaintext); err != nil {
+			return 0, err
+		}
+		indexed++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit search index: %w", err)
+	}
+	return indexed, nil
+}
+
+// indexDocument replaces the index entries of a record with those for value
+func indexDocument(vault *VaultDAO, keys *keyring, key string, value []byte) error {
+	if err := removeDocument(vault, key); err != nil {
+		return err
+	}
+	if IsInternalKey(key) {
+		return nil
+	}
+	length, err := writePostings(vault, keys, key, value)
+	if err != nil {
+		return err
+	}
+	if _, err := vault.db.Exec("INSERT INTO search_docs (record_key, length) VALUES (?, ?)", key, length); err != nil {
+		return fmt.Errorf("failed to index %s: %w", key, err)
+	}
+	return nil
+}
+
+// writePostings adds the postings of a record with the current key and
+// returns its number of tokens
+func writePostings(vault *VaultDAO, keys *keyring, key string, value []byte) (int, error) {
+	blindKey, err := crypto.DeriveHKDF(keys.current, searchBlindContext, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to derive search index key: %w", err)
+	}
+
+	tokens := search.Tokenize(search.Document(key, value))
+	terms := search.Positions(tokens)
+	entries := make(map[string][]int, len(terms))
+	for term, positions := range terms {
+		entries[termToken(term)] = positions
+		for _, p := range search.Prefixes(term) {
+			entries[prefixToken(p)] = append(entries[prefixToken(p)], positions...)
+		}
+	}
+
+	for token, positions := range entries {
+		sort.Ints(positions)
+		plaintext, err := json.Marshal(positions)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode search posting: %w", err)
+		}
+		ciphertext, err := keys.encrypt(plaintext)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt search posting for %s: %w", key, err)
+		}
+		_, err = vault.db.Exec(
+			"INSERT INTO search_postings (blind, record_key, positions, key_id) VALUES (?, ?, ?, ?)",
+			blindIndex(blindKey, token), key, ciphertext, keys.id,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to index %s: %w", key, err)
+		}
+	}
+	return len(tokens), nil
+}
+
+// searchTables are the tables making up the full-text and semantic indexes
+var searchTables = []string{"search_postings", "search_docs", "vectors", "vector_buckets"}
+
+// removeDocument drops a record from the search indexes
+func removeDocument(vault *VaultDAO, key string) error {
+	for _, table := range searchTables {
+		if _, err := vault.db.Exec("DELETE FROM "+table+" WHERE record_key = ?", key); err != nil {
+			return fmt.Errorf("failed to remove %s from search index: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func clearSearchIndex(q querier) error {
+	for _, table := range searchTables {
+		if _, err := q.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to clear %s table: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// termToken and prefixToken keep whole-term and prefix entries apart in the index
+func termToken(term string) string {
+	return "t\x00" + term
+}
+
+func prefixToken(prefix string) string {
+	return "p\x00" + prefix
+}
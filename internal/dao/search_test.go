@@ -0,0 +1,133 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSearchVault(t *testing.T) *SecureVaultDAO {
+	vault := setupSecureVault(t)
+	for key, value := range map[string]string{
+		"tax-2024":   "Tax return 2024 filed with the tax office. Tax refund expected.",
+		"tax-2023":   "Tax return 2023, paid in full",
+		"recipes":    "Return the pasta machine to Anna",
+		"passwords":  "Router password and passport number",
+		"long-notes": "international internationalisation notes",
+	} {
+		require.NoError(t, vault.Put(key, []byte(value)))
+	}
+	return vault
+}
+
+func searchKeys(t *testing.T, vault *SecureVaultDAO, q string) []string {
+	results, err := vault.Search(q, 0)
+	require.NoError(t, err, "Search %q failed", q)
+	var keys []string
+	for _, r := range results {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+func TestSearch(t *testing.T) {
+	vault := setupSearchVault(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// tax-2024 mentions tax more often and ranks first
+		{"tax", []string{"tax-2024", "tax-2023"}},
+		{"TAX 2023", []string{"tax-2023"}},
+		{`"return 2023"`, []string{"tax-2023"}},
+		{`"2023 return"`, nil},
+		{"return -tax", []string{"recipes"}},
+		{"pass*", []string{"passwords"}},
+		{"internationalis*", []string{"long-notes"}},
+		{"recip*", []string{"recipes"}},
+		{"nothing", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.want, searchKeys(t, vault, tt.query))
+		})
+	}
+
+	results, err := vault.Search("tax", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1, "Limit should cap results")
+	assert.Greater(t, results[0].Score, 0.0)
+}
+
+func TestSearchIndexIsIncremental(t *testing.T) {
+	vault := setupSearchVault(t)
+
+	require.NoError(t, vault.Put("tax-2023", []byte("superseded")))
+	assert.Nil(t, searchKeys(t, vault, "paid"), "Updated records should be reindexed")
+	assert.Equal(t, []string{"tax-2023"}, searchKeys(t, vault, "superseded"))
+
+	require.NoError(t, vault.Delete("tax-2024"))
+	assert.Nil(t, searchKeys(t, vault, "refund"), "Deleted records should leave the index")
+
+	require.NoError(t, vault.DefineScope(Scope{Name: "archive", Policy: PolicyOpen}))
+	assert.Nil(t, searchKeys(t, vault, "archive"), "Internal records should not be indexed")
+
+	stale, err := vault.SearchIndexStale()
+	require.NoError(t, err)
+	assert.False(t, stale)
+}
+
+func TestSearchIndexIsBlinded(t *testing.T) {
+	vault := setupSearchVault(t)
+	blindKey, err := crypto.DeriveHKDF(vault.Key(), searchBlindContext, 32)
+	require.NoError(t, err)
+	other, err := crypto.Generate(32)
+	require.NoError(t, err)
+
+	blinds := make(map[string]bool)
+	rows, err := vault.db.Query("SELECT blind, positions FROM search_postings")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var blind, positions []byte
+		require.NoError(t, rows.Scan(&blind, &positions))
+		blinds[string(blind)] = true
+
+		// Positions only decrypt with the vault's key
+		plain, err := crypto.DecryptBlob(vault.Key(), positions)
+		require.NoError(t, err)
+		assert.NotEqual(t, plain, positions)
+		_, err = crypto.DecryptBlob(other, positions)
+		assert.Error(t, err)
+	}
+	require.NoError(t, rows.Err())
+
+	// Terms are stored as their keyed HMAC, never as the term
+	for _, term := range []string{"tax", "return", "password"} {
+		assert.True(t, blinds[string(blindIndex(blindKey, termToken(term)))], "term %q should be indexed", term)
+		assert.False(t, blinds[term])
+		assert.False(t, blinds[termToken(term)])
+	}
+}
+
+func TestReindexAndReplayRebuildSearchIndex(t *testing.T) {
+	vault := setupSearchVault(t)
+
+	_, err := vault.db.Exec("DELETE FROM search_docs WHERE record_key = 'recipes'")
+	require.NoError(t, err)
+	stale, err := vault.SearchIndexStale()
+	require.NoError(t, err)
+	assert.True(t, stale, "A missing document should be detected")
+
+	n, err := vault.Reindex()
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []string{"recipes"}, searchKeys(t, vault, "pasta"))
+
+	_, err = vault.Replay()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"recipes"}, searchKeys(t, vault, "pasta"), "Replay should rebuild the index")
+}
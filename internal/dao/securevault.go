@@ -2,25 +2,72 @@ package dao
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
-	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/search"
 )
 
-// SecureVaultDAO wraps VaultDAO with encryption/decryption
+// ErrReadOnlyVault is returned for writes to a vault opened read-only
+var ErrReadOnlyVault = errors.New("vault is open read-only")
+
+// SecureVaultDAO wraps VaultDAO with encryption/decryption.
+// Writes are recorded in the event log first and then projected into the
+// vault table within the same transaction.
 type SecureVaultDAO struct {
-	dao *VaultDAO
-	key []byte
+	db        *sql.DB
+	dao       *VaultDAO
+	log       *EventLog
+	projector *Projector
+	keys      *keyring
+	force     bool
+	internal  bool
+	readOnly  error
+	onCommit  []func(*Event)
 }
 
 // NewSecureVaultDAO creates a new SecureVaultDAO
 func NewSecureVaultDAO(db *sql.DB, key []byte) *SecureVaultDAO {
+	return newSecureVaultDAO(db, newKeyring(key))
+}
+
+func newSecureVaultDAO(db *sql.DB, keys *keyring) *SecureVaultDAO {
+	log := &EventLog{db: db, keys: keys}
 	return &SecureVaultDAO{
-		dao: NewVaultDAO(db),
-		key: key,
+		db:        db,
+		dao:       NewVaultDAO(db),
+		log:       log,
+		projector: &Projector{db: db, log: log, keys: keys, embedder: search.DefaultEmbedder},
+		keys:      keys,
 	}
 }
 
+// WithRetiredKey returns a DAO that can also read rows still encrypted with
+// the key an online rotation is retiring. It writes with the current key.
+func (d *SecureVaultDAO) WithRetiredKey(key []byte) *SecureVaultDAO {
+	rotating := *d
+	rotating.keys = d.keys.withRetired(key)
+	rotating.log = &EventLog{db: d.db, keys: rotating.keys}
+	projector := *d.projector
+	projector.log, projector.keys = rotating.log, rotating.keys
+	rotating.projector = &projector
+	return &rotating
+}
+
+// ReadOnly returns a DAO that refuses every write with ErrReadOnlyVault,
+// explained by reason (e.g. why the vault cannot be written to)
+func (d *SecureVaultDAO) ReadOnly(reason error) *SecureVaultDAO {
+	readOnly := *d
+	readOnly.readOnly = fmt.Errorf("%w: %w", ErrReadOnlyVault, reason)
+	return &readOnly
+}
+
+// IsReadOnly reports whether writes are refused
+func (d *SecureVaultDAO) IsReadOnly() bool {
+	return d.readOnly != nil
+}
+
 // Get retrieves and decrypts a record by key
 func (d *SecureVaultDAO) Get(key string) ([]byte, error) {
 	record, err := d.dao.Get(key)
@@ -29,7 +76,7 @@ func (d *SecureVaultDAO) Get(key string) ([]byte, error) {
 	}
 
 	// Decrypt the value
-	plaintext, err := crypto.DecryptBlob(d.key, record.Value)
+	plaintext, err := d.keys.decrypt(record.KeyID, record.Value)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt value for key %s: %w", key, err)
 	}
@@ -39,19 +86,81 @@ func (d *SecureVaultDAO) Get(key string) ([]byte, error) {
 
 // Put encrypts and stores a record
 func (d *SecureVaultDAO) Put(key string, value []byte) error {
-	// Encrypt the value
-	ciphertext, err := crypto.EncryptBlob(d.key, value)
+	return d.Apply(&Event{Type: EventPut, Key: key, Value: value})
+}
+
+// Delete removes a record by key
+func (d *SecureVaultDAO) Delete(key string) error {
+	return d.Apply(&Event{Type: EventDelete, Key: key})
+}
+
+// Apply appends an event to the log and projects it into the vault table
+// atomically, after checking scope policies. Deleting, moving or tagging a
+// missing key returns ErrNotFound and records nothing.
+func (d *SecureVaultDAO) Apply(e *Event) error {
+	if d.readOnly != nil {
+		return d.readOnly
+	}
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	vault := newTxVaultDAO(tx)
+	existing, err := vault.Get(e.Key)
+	if errors.Is(err, ErrNotFound) {
+		if e.Type.needsRecord() {
+			return err
+		}
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+	if err := d.checkPolicy(vault, e, existing); err != nil {
+		return err
+	}
+
+	// Refuse to write over a tampered vault: resealing would launder the change
+	sealKey, err := d.keys.sealKey(tx)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt value for key %s: %w", key, err)
+		return err
+	}
+	if err := verifySeal(tx, sealKey); err != nil {
+		return fmt.Errorf("refusing to write: %w", err)
+	}
+	if err := d.log.append(tx, e); err != nil {
+		return err
+	}
+	if err := d.projector.apply(tx, e); err != nil {
+		return fmt.Errorf("failed to project event for key %s: %w", e.Key, err)
+	}
+	if _, err := integrity.Update(tx, d.keys.current); err != nil {
+		return fmt.Errorf("failed to reseal vault: %w", err)
 	}
 
-	// Store the encrypted value
-	return d.dao.Put(key, ciphertext)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit event for key %s: %w", e.Key, err)
+	}
+	for _, fn := range d.onCommit {
+		fn(e)
+	}
+	return nil
 }
 
-// Delete removes a record by key
-func (d *SecureVaultDAO) Delete(key string) error {
-	return d.dao.Delete(key)
+// verifySeal checks the seal before a write reseals the vault. A vault
+// without a seal passes only if it has never been written.
+func verifySeal(q integrity.Querier, key []byte) error {
+	_, err := integrity.Verify(q, key)
+	if errors.Is(err, integrity.ErrUnsealed) {
+		return integrity.CheckUnsealed(q)
+	}
+	return err
+}
+
+// OnCommit registers a callback that runs after each write has been committed
+func (d *SecureVaultDAO) OnCommit(fn func(*Event)) {
+	d.onCommit = append(d.onCommit, fn)
 }
 
 // List returns all keys in the vault
@@ -59,5 +168,23 @@ func (d *SecureVaultDAO) List() ([]string, error) {
 	return d.dao.List()
 }
 
-// Note: Key rotation functionality has been moved to the CLI implementation
-// in cmd/bosr/main.go for more robust handling with backup and atomic operations
+// Events returns the event log backing this vault
+func (d *SecureVaultDAO) Events() *EventLog {
+	return d.log
+}
+
+// Key returns the master key the DAO encrypts with
+func (d *SecureVaultDAO) Key() []byte {
+	return d.keys.current
+}
+
+// Replay rebuilds the vault table from the event log
+func (d *SecureVaultDAO) Replay() (int, error) {
+	if d.readOnly != nil {
+		return 0, d.readOnly
+	}
+	return d.projector.Replay()
+}
+
+// Note: Offline key rotation lives in internal/rotate, which re-encrypts into a
+// fresh vault file. Online rotation re-encrypts in place; see rotation.go.
@@ -0,0 +1,200 @@
+package dao
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/search"
+)
+
+// lshBlindContext is the HKDF context for the blind index of LSH buckets
+const lshBlindContext = "n1/search/lsh/v1"
+
+// Every indexed record has an embedding vector in the vectors table, encrypted
+// with the master key and tagged with the name of the model that produced it.
+// The vector_buckets table is a persistent LSH index over them (search.LSH):
+// it files each record under one bucket per hash table, stored encrypted with
+// a blind index (an HMAC under a key derived from the master key) for
+// lookups, and is updated along with the vector on every write. A query
+// decrypts only the vectors in the buckets it probes. The blind indexes
+// reveal which records share a bucket, and so roughly which are similar, but
+// not the vectors or the buckets themselves.
+
+// WithEmbedder returns a DAO that embeds records and queries with e instead
+// of search.DefaultEmbedder. Records embedded with another model are picked
+// up again by Reindex.
+func (d *SecureVaultDAO) WithEmbedder(e search.Embedder) *SecureVaultDAO {
+	projector := *d.projector
+	projector.embedder = e
+	configured := *d
+	configured.projector = &projector
+	return &configured
+}
+
+// minCandidates is the fewest vectors a semantic query must find in its LSH
+// buckets to rank only those. Below it, as in small vaults, ranking every
+// vector is cheap and exact.
+const minCandidates = 64
+
+// SemanticSearch returns up to limit records (all matches if limit is not
+// positive) whose content is most similar to the query text, best first.
+// Only the records in the LSH buckets probed for the query are ranked, so a
+// similar record may be missed. Every vector of the configured model is
+// ranked instead if all matches are wanted, or if the buckets hold fewer
+// than minCandidates vectors or yield fewer than limit matches.
+func (d *SecureVaultDAO) SemanticSearch(query string, limit int) ([]SearchResult, error) {
+	embedder := d.projector.embedder
+	target, err := embedder.Embed(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+
+	var found []search.Neighbour
+	candidates := 0
+	if limit > 0 {
+		blindKeys, err := d.keys.derive(lshBlindContext)
+		if err != nil {
+			return nil, err
+		}
+		var args []interface{}
+		for _, b := range lshFor(embedder.Dimensions()).Probes(target) {
+			args = append(args, blinds(blindKeys, bucketName(embedder, b))...)
+		}
+		if len(args) > 0 {
+			marks := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+			found, candidates, err = d.rankVectors(target, limit,
+				"AND record_key IN (SELECT record_key FROM vector_buckets WHERE blind IN ("+marks+"))", args...)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if limit <= 0 || candidates < minCandidates || len(found) < limit {
+		if found, _, err = d.rankVectors(target, limit, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []SearchResult
+	for _, n := range found {
+		results = append(results, SearchResult{Key: n.Key, Score: n.Score})
+	}
+	return results, nil
+}
+
+// rankVectors decrypts the vectors of the configured model matching the
+// extra condition, ranks them by similarity to target and returns the best
+// and the number of vectors ranked
+func (d *SecureVaultDAO) rankVectors(target []float32, limit int, where string, args ...interface{}) ([]search.Neighbour, int, error) {
+	args = append([]interface{}{d.projector.embedder.Name()}, args...)
+	rows, err := d.db.Query("SELECT record_key, vector, key_id FROM vectors WHERE model = ? "+where+" ORDER BY record_key", args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query vectors: %w", err)
+	}
+	defer rows.Close()
+
+	ranking := search.NewRanking(target, limit)
+	ranked := 0
+	for rows.Next() {
+		var key, keyID string
+		var ciphertext []byte
+		if err := rows.Scan(&key, &ciphertext, &keyID); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan vector: %w", err)
+		}
+		plaintext, err := d.keys.decrypt(keyID, ciphertext)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decrypt vector for %s: %w", key, err)
+		}
+		ranking.Add(key, decodeVector(plaintext))
+		ranked++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating vectors: %w", err)
+	}
+	return ranking.Results(), ranked, nil
+}
+
+// embedDocument stores the encrypted embedding of a record, replacing any
+// previous one. removeDocument must have been called first.
+func embedDocument(vault *VaultDAO, keys *keyring, embedder search.Embedder, key string, value []byte) error {
+	if IsInternalKey(key) {
+		return nil
+	}
+	vec, err := embedder.Embed(search.Document(key, value))
+	if err != nil {
+		return fmt.Errorf("failed to embed %s: %w", key, err)
+	}
+	ciphertext, err := keys.encrypt(encodeVector(vec))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt vector for %s: %w", key, err)
+	}
+	_, err = vault.db.Exec(
+		"INSERT INTO vectors (record_key, model, vector, key_id) VALUES (?, ?, ?, ?)",
+		key, embedder.Name(), ciphertext, keys.id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store vector for %s: %w", key, err)
+	}
+	return writeBuckets(vault, keys, embedder, key, vec)
+}
+
+// writeBuckets files a record under its LSH buckets with the current key
+func writeBuckets(vault *VaultDAO, keys *keyring, embedder search.Embedder, key string, vec []float32) error {
+	blindKey, err := crypto.DeriveHKDF(keys.current, lshBlindContext, 32)
+	if err != nil {
+		return fmt.Errorf("failed to derive vector index key: %w", err)
+	}
+	for _, b := range lshFor(embedder.Dimensions()).Buckets(vec) {
+		name := bucketName(embedder, b)
+		ciphertext, err := keys.encrypt([]byte(name))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt vector bucket for %s: %w", key, err)
+		}
+		_, err = vault.db.Exec(
+			"INSERT INTO vector_buckets (blind, record_key, bucket, key_id) VALUES (?, ?, ?, ?)",
+			blindIndex(blindKey, name), key, ciphertext, keys.id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to index vector for %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// bucketName identifies an LSH bucket of the embedder's vectors; buckets of
+// different models never match
+func bucketName(embedder search.Embedder, b search.Bucket) string {
+	return embedder.Name() + "\x00" + b.String()
+}
+
+// lshs caches the hyperplanes of each vector dimension
+var lshs sync.Map
+
+func lshFor(dims int) *search.LSH {
+	if l, ok := lshs.Load(dims); ok {
+		return l.(*search.LSH)
+	}
+	l, _ := lshs.LoadOrStore(dims, search.NewLSH(dims))
+	return l.(*search.LSH)
+}
+
+// encodeVector serialises a vector as little-endian float32 values
+func encodeVector(vec []float32) []byte {
+	buf := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	vec := make([]float32, len(buf)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vec
+}
@@ -0,0 +1,122 @@
+package dao
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/n1/n1/internal/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemanticSearch(t *testing.T) {
+	vault := setupSearchVault(t)
+
+	results, err := vault.SemanticSearch("tax documents from last year", 2)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Contains(t, []string{"tax-2023", "tax-2024"}, results[0].Key)
+	assert.Contains(t, []string{"tax-2023", "tax-2024"}, results[1].Key)
+	assert.Greater(t, results[0].Score, 0.0)
+
+	// Scores are exact cosine similarities, not approximations
+	values := map[string]string{
+		"tax-2024": "Tax return 2024 filed with the tax office. Tax refund expected.",
+		"tax-2023": "Tax return 2023, paid in full",
+	}
+	query, err := search.DefaultEmbedder.Embed("tax documents from last year")
+	require.NoError(t, err)
+	for _, r := range results {
+		doc, err := search.DefaultEmbedder.Embed(search.Document(r.Key, []byte(values[r.Key])))
+		require.NoError(t, err)
+		assert.InDelta(t, search.Cosine(query, doc), r.Score, 1e-9)
+	}
+
+	require.NoError(t, vault.Delete("tax-2023"))
+	require.NoError(t, vault.Delete("tax-2024"))
+	results, err = vault.SemanticSearch("tax documents from last year", 0)
+	require.NoError(t, err)
+	for _, r := range results {
+		assert.NotContains(t, r.Key, "tax", "Deleted records should lose their vectors")
+	}
+}
+
+func TestVectorsAreEncrypted(t *testing.T) {
+	vault := setupSearchVault(t)
+
+	// The plaintext vector of a known record must not appear in the database
+	vec, err := search.DefaultEmbedder.Embed(search.Document("recipes", []byte("Return the pasta machine to Anna")))
+	require.NoError(t, err)
+	plain := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(plain[4*i:], math.Float32bits(v))
+	}
+
+	var stored []byte
+	require.NoError(t, vault.db.QueryRow("SELECT vector FROM vectors WHERE record_key = 'recipes'").Scan(&stored))
+	assert.False(t, bytes.Contains(stored, plain[:16]), "Vectors should be stored encrypted")
+}
+
+func TestChangingEmbedderRequiresReindex(t *testing.T) {
+	vault := setupSearchVault(t)
+	small := vault.WithEmbedder(search.NewHashEmbedder(128))
+
+	stale, err := small.SearchIndexStale()
+	require.NoError(t, err)
+	assert.True(t, stale, "Vectors from another model should be considered stale")
+	results, err := small.SemanticSearch("pasta", 0)
+	require.NoError(t, err)
+	assert.Empty(t, results, "Vectors from another model must not be compared")
+
+	_, err = small.Reindex()
+	require.NoError(t, err)
+	results, err = small.SemanticSearch("pasta machine", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "recipes", results[0].Key)
+}
+
+func TestVectorIndex(t *testing.T) {
+	vault := setupSearchVault(t)
+	for i := 0; i < 3*minCandidates; i++ {
+		require.NoError(t, vault.Put(fmt.Sprintf("note-%03d", i), []byte(fmt.Sprintf("meeting note %d about project %d", i, i%7))))
+	}
+	countBuckets := func(key string) int {
+		var n int
+		require.NoError(t, vault.db.QueryRow("SELECT COUNT(*) FROM vector_buckets WHERE record_key = ?", key).Scan(&n))
+		return n
+	}
+	search1 := func(q string) string {
+		results, err := vault.SemanticSearch(q, 1)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		return results[0].Key
+	}
+
+	// Every record is filed under encrypted buckets, which change with it
+	before := countBuckets("recipes")
+	assert.Positive(t, before)
+	var bucket []byte
+	require.NoError(t, vault.db.QueryRow("SELECT bucket FROM vector_buckets WHERE record_key = 'recipes' LIMIT 1").Scan(&bucket))
+	assert.NotContains(t, string(bucket), search.DefaultEmbedder.Name(), "Buckets should be stored encrypted")
+	require.NoError(t, vault.Put("recipes", []byte("Pasta machine manual")))
+	assert.Equal(t, before, countBuckets("recipes"))
+	assert.Equal(t, "recipes", search1("pasta machine manual"))
+
+	// Queries rank the records in their buckets, not every vector
+	_, err := vault.db.Exec("DELETE FROM vector_buckets WHERE record_key = 'note-042'")
+	require.NoError(t, err)
+	assert.NotEqual(t, "note-042", search1("meeting note 42 about project 0"), "Records outside the probed buckets are not found")
+	stale, err := vault.SearchIndexStale()
+	require.NoError(t, err)
+	assert.True(t, stale, "Records missing from the vector index make it stale")
+	_, err = vault.Reindex()
+	require.NoError(t, err)
+	assert.Equal(t, "note-042", search1("meeting note 42 about project 0"))
+
+	require.NoError(t, vault.Delete("recipes"))
+	assert.Zero(t, countBuckets("recipes"), "Deleted records should leave the vector index")
+}
@@ -12,16 +12,28 @@ var (
 	ErrNotFound = errors.New("record not found")
 )
 
+// querier is the subset of *sql.DB and *sql.Tx used by the DAOs, so the same
+// code can run standalone or inside a caller's transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 // VaultDAO provides access to the vault table
 type VaultDAO struct {
-	db *sql.DB
+	db querier
 }
 
 // VaultRecord represents a record in the vault table
 type VaultRecord struct {
-	ID        int64
-	Key       string
-	Value     []byte
+	ID    int64
+	Key   string
+	Value []byte
+	// KeyID is the fingerprint of the master key Value is encrypted with,
+	// or empty for records written before key IDs existed
+	KeyID     string
+	Scope     string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -31,13 +43,18 @@ func NewVaultDAO(db *sql.DB) *VaultDAO {
 	return &VaultDAO{db: db}
 }
 
+// newTxVaultDAO creates a VaultDAO bound to a transaction
+func newTxVaultDAO(tx *sql.Tx) *VaultDAO {
+	return &VaultDAO{db: tx}
+}
+
 // Get retrieves a record by key
 func (d *VaultDAO) Get(key string) (*VaultRecord, error) {
 	var record VaultRecord
 	err := d.db.QueryRow(
-		"SELECT id, key, value, created_at, updated_at FROM vault WHERE key = ?",
+		"SELECT id, key, value, key_id, scope, created_at, updated_at FROM vault WHERE key = ?",
 		key,
-	).Scan(&record.ID, &record.Key, &record.Value, &record.CreatedAt, &record.UpdatedAt)
+	).Scan(&record.ID, &record.Key, &record.Value, &record.KeyID, &record.Scope, &record.CreatedAt, &record.UpdatedAt)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -52,24 +69,24 @@ func (d *VaultDAO) Get(key string) (*VaultRecord, error) {
 // Put inserts or updates a record
 func (d *VaultDAO) Put(key string, value []byte) error {
 	// Check if record exists
-	_, err := d.Get(key)
-	if err != nil {
-		if errors.Is(err, ErrNotFound) {
-			// Insert new record
-			_, err = d.db.Exec(
-				"INSERT INTO vault (key, value) VALUES (?, ?)",
-				key, value,
-			)
-			if err != nil {
-				return fmt.Errorf("failed to insert vault record: %w", err)
-			}
-			return nil
+	var exists bool
+	if err := d.db.QueryRow("SELECT EXISTS(SELECT 1 FROM vault WHERE key = ?)", key).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to get vault record: %w", err)
+	}
+	if !exists {
+		// Insert new record
+		_, err := d.db.Exec(
+			"INSERT INTO vault (key, value) VALUES (?, ?)",
+			key, value,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert vault record: %w", err)
 		}
-		return err
+		return nil
 	}
 
 	// Update existing record
-	_, err = d.db.Exec(
+	_, err := d.db.Exec(
 		"UPDATE vault SET value = ? WHERE key = ?",
 		value, key,
 	)
@@ -80,6 +97,36 @@ func (d *VaultDAO) Put(key string, value []byte) error {
 	return nil
 }
 
+// putAt inserts or updates a record, stamping it with the given time instead
+// of the current one. Used when projecting events so replays keep timestamps.
+func (d *VaultDAO) putAt(key string, value []byte, keyID string, at time.Time) error {
+	_, err := d.db.Exec(
+		`INSERT INTO vault (key, value, key_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, key_id = excluded.key_id, updated_at = excluded.updated_at`,
+		key, value, keyID, at, at,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to upsert vault record: %w", err)
+	}
+	return nil
+}
+
+// setScope moves a record to another scope
+func (d *VaultDAO) setScope(key, scope string) error {
+	result, err := d.db.Exec("UPDATE vault SET scope = ? WHERE key = ?", scope, key)
+	if err != nil {
+		return fmt.Errorf("failed to update vault record scope: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // Delete removes a record by key
 func (d *VaultDAO) Delete(key string) error {
 	result, err := d.db.Exec("DELETE FROM vault WHERE key = ?", key)
@@ -101,7 +148,16 @@ func (d *VaultDAO) Delete(key string) error {
 
 // List returns all keys in the vault
 func (d *VaultDAO) List() ([]string, error) {
-	rows, err := d.db.Query("SELECT key FROM vault ORDER BY key")
+	return d.listKeys("SELECT key FROM vault ORDER BY key")
+}
+
+// ListScope returns the keys of all records in a scope
+func (d *VaultDAO) ListScope(scope string) ([]string, error) {
+	return d.listKeys("SELECT key FROM vault WHERE scope = ? ORDER BY key", scope)
+}
+
+func (d *VaultDAO) listKeys(query string, args ...interface{}) ([]string, error) {
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query vault keys: %w", err)
 	}
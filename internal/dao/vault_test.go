@@ -2,11 +2,14 @@ package dao
 
 import (
 	"database/sql"
+	"errors"
 	"path/filepath"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/migrations"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -21,22 +24,8 @@ func setupTestDB(t *testing.T) *sql.DB {
 	db, err := sql.Open("sqlite3", dbPath)
 	require.NoError(t, err, "Opening database failed")
 
-	// Create the vault table
-	_, err = db.Exec(`
-		CREATE TABLE vault (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			key TEXT NOT NULL,
-			value BLOB NOT NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-		);
-		CREATE UNIQUE INDEX idx_vault_key ON vault(key);
-		CREATE TRIGGER trig_vault_updated_at 
-		AFTER UPDATE ON vault
-		BEGIN
-			UPDATE vault SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
-		END;
-	`)
+	// Create the vault schema, including the event log
+	err = migrations.BootstrapVault(db)
 	require.NoError(t, err, "Creating vault table failed")
 
 	return db
@@ -157,5 +146,83 @@ func TestSecureVaultDAO(t *testing.T) {
 	assert.ErrorIs(t, err, ErrNotFound, "Expected ErrNotFound after delete")
 }
 
+func TestSecureVaultDAORefusesTamperedVault(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err, "Failed to generate key")
+	dao := NewSecureVaultDAO(db, key)
+
+	require.NoError(t, dao.Put("a", []byte("1")))
+	require.NoError(t, dao.Put("b", []byte("2")))
+
+	// Deleting a row behind the DAO's back breaks the seal...
+	_, err = db.Exec("DELETE FROM vault WHERE key = 'b'")
+	require.NoError(t, err)
+
+	// ...and further writes must not reseal over it
+	err = dao.Put("c", []byte("3"))
+	assert.ErrorIs(t, err, integrity.ErrTampered, "Writes to a tampered vault should be refused")
+
+	// Rebuilding from the intact event log repairs the projection
+	_, err = dao.Replay()
+	require.NoError(t, err, "Replay failed")
+	require.NoError(t, dao.Put("c", []byte("3")), "Writes should succeed after repair")
+
+	value, err := dao.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+}
+
+func TestSecureVaultDAORefusesStrippedSeal(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err, "Failed to generate key")
+	dao := NewSecureVaultDAO(db, key)
+	require.NoError(t, dao.Put("a", []byte("1")), "An empty vault is sealed by its first write")
+	require.NoError(t, dao.Put("b", []byte("2")))
+
+	// Deleting the seal along with a row must not pass the vault off as unsealed
+	_, err = db.Exec("DELETE FROM vault_meta WHERE name = 'integrity.seal'")
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM vault WHERE key = 'b'")
+	require.NoError(t, err)
+
+	err = dao.Put("c", []byte("3"))
+	assert.ErrorIs(t, err, integrity.ErrTampered, "Writes to a vault without its seal should be refused")
+	_, err = dao.Replay()
+	assert.ErrorIs(t, err, integrity.ErrTampered, "A vault without its seal should not be replayed and resealed")
+}
+
+func TestSecureVaultDAOReadOnly(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err, "Failed to generate key")
+	writable := NewSecureVaultDAO(db, key)
+	require.NoError(t, writable.Put("a", []byte("1")))
+
+	reason := errors.New("written by a newer version")
+	vault := writable.ReadOnly(reason)
+	assert.True(t, vault.IsReadOnly())
+	assert.False(t, writable.IsReadOnly(), "ReadOnly should return a copy")
+
+	value, err := vault.Get("a")
+	require.NoError(t, err, "Reads should still work")
+	assert.Equal(t, []byte("1"), value)
+
+	err = vault.Put("b", []byte("2"))
+	assert.ErrorIs(t, err, ErrReadOnlyVault)
+	assert.ErrorIs(t, err, reason, "The reason should be part of the error")
+	_, err = vault.Replay()
+	assert.ErrorIs(t, err, ErrReadOnlyVault)
+	_, err = vault.Reindex()
+	assert.ErrorIs(t, err, ErrReadOnlyVault)
+}
+
 // Note: TestSecureVaultDAORotateKey has been removed as the RotateKey method
 // has been moved to the CLI implementation for more robust handling
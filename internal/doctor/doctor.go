@@ -0,0 +1,379 @@
+// Package doctor diagnoses why a vault cannot be opened or rotated, and says
+// how to fix it.
+//
+// Unlike verify, which checks the contents of a vault that opens, doctor
+// looks at everything around it: the secret store and the key it holds, file
+// permissions, files left behind by interrupted rotations, migrations and
+// backups, the schema version, free disk space and SQLite's journal files.
+// It never changes the vault, and only writes a short-lived probe entry to
+// the secret store.
+package doctor
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/n1/n1/internal/backup"
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/n1/n1/internal/rotate"
+	"github.com/n1/n1/internal/secretstore"
+	"github.com/n1/n1/internal/sqlite"
+)
+
+// Status is the outcome of a check
+type Status string
+
+// Statuses, from best to worst
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// Finding is the result of one check, with the steps that fix it
+type Finding struct {
+	Check   string   `json:"check"`
+	Status  Status   `json:"status"`
+	Message string   `json:"message"`
+	Fix     []string `json:"fix,omitempty"`
+}
+
+// Report lists the findings for one vault
+type Report struct {
+	Path     string    `json:"path"`
+	Findings []Finding `json:"findings"`
+}
+
+// Failed reports whether any check failed
+func (r *Report) Failed() bool {
+	for _, f := range r.Findings {
+		if f.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) add(check string, status Status, message string, fix ...string) {
+	r.Findings = append(r.Findings, Finding{Check: check, Status: status, Message: message, Fix: fix})
+}
+
+// Run diagnoses the vault at path, whose key is kept in store. The path
+// should be absolute, as keys are stored under the absolute vault path.
+func Run(path string, store secretstore.Store) *Report {
+	r := &Report{Path: path}
+	exists := checkFile(r, path)
+	storeOK := checkStore(r, path, store)
+	var key []byte
+	if storeOK {
+		key = checkKey(r, path, store, exists)
+	}
+	checkRotation(r, path, store)
+	checkLeftovers(r, path)
+	if exists {
+		checkSchema(r, path, key)
+		checkJournal(r, path)
+		checkDiskSpace(r, path)
+	}
+	return r
+}
+
+// checkFile checks that the vault exists and only its owner can read it
+func checkFile(r *Report, path string) bool {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		fix := []string{"Check the path: keys are stored under the vault's absolute path, so a moved vault must be moved back"}
+		if _, err := os.Stat(rotate.BackupPath(path)); err == nil {
+			fix = append(fix, fmt.Sprintf("A key rotation left %s behind; run 'bosr recover %s'", rotate.BackupPath(path), path))
+		}
+		r.add("file", StatusFail, "vault file does not exist", fix...)
+		return false
+	case err != nil:
+		r.add("file", StatusFail, fmt.Sprintf("cannot access vault file: %v", err),
+			"Check the permissions of the directories leading to "+path)
+		return false
+	case !info.Mode().IsRegular():
+		r.add("file", StatusFail, "vault path is not a regular file")
+		return false
+	}
+
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		r.add("permissions", StatusWarn, fmt.Sprintf("vault file is accessible to other users (mode %04o)", perm),
+			fmt.Sprintf("chmod 600 %s", path))
+	} else if perm&0o600 != 0o600 {
+		r.add("permissions", StatusFail, fmt.Sprintf("vault file is not readable and writable by its owner (mode %04o)", perm),
+			fmt.Sprintf("chmod 600 %s", path))
+	} else {
+		r.add("permissions", StatusOK, fmt.Sprintf("vault file mode is %04o", perm))
+	}
+	if dir, err := os.Stat(filepath.Dir(path)); err == nil && dir.Mode().Perm()&0o002 != 0 && dir.Mode()&fs.ModeSticky == 0 {
+		r.add("permissions", StatusWarn, "the vault's directory is writable by every user, who could replace the vault",
+			fmt.Sprintf("chmod o-w %s, or move the vault to a private directory", filepath.Dir(path)))
+	}
+	return true
+}
+
+// checkStore checks that the secret store can be read and written, with a
+// probe entry that is removed again
+func checkStore(r *Report, path string, store secretstore.Store) bool {
+	probe := path + "#doctor-probe"
+	err := store.Put(probe, []byte("ok"))
+	if err == nil {
+		var value []byte
+		value, err = store.Get(probe)
+		if err == nil && string(value) != "ok" {
+			err = errors.New("probe entry read back differently")
+		}
+		if deleteErr := store.Delete(probe); err == nil {
+			err = deleteErr
+		}
+	}
+	if err != nil {
+		r.add("secret store", StatusFail, fmt.Sprintf("secret store is not usable: %v", err), storeFix()...)
+		return false
+	}
+	r.add("secret store", StatusOK, "reachable")
+	return true
+}
+
+// storeFix explains how to reach the secret store of the platform
+func storeFix() []string {
+	switch runtime.GOOS {
+	case "linux":
+		return []string{"Keys are files under ~/.n1-secrets: make sure it exists, belongs to you and has mode 0700"}
+	case "darwin":
+		return []string{"Unlock the login keychain (Keychain Access) and allow bosr to access it"}
+	case "windows":
+		return []string{"Run bosr as the Windows user who created the vault; its keys are protected with that user's DPAPI key"}
+	}
+	return nil
+}
+
+// checkKey checks that the store holds a key for the vault and that it is
+// the vault's key. It returns the key, or nil.
+func checkKey(r *Report, path string, store secretstore.Store, exists bool) []byte {
+	key, err := store.Get(path)
+	if err != nil {
+		fix := []string{
+			"Keys are stored under the vault's absolute path: if the vault was moved or renamed since 'bosr init', move it back",
+			"Restore the key from a backup of your secret store",
+		}
+		if staged, err := store.Get(rotate.StagedKeyName(path)); err == nil && len(staged) > 0 {
+			fix = append([]string{fmt.Sprintf("An interrupted key rotation staged a new key; run 'bosr recover %s'", path)}, fix...)
+		}
+		r.add("key", StatusFail, "no key for this vault in the secret store", fix...)
+		return nil
+	}
+	if len(key) != 32 {
+		r.add("key", StatusFail, fmt.Sprintf("stored key is %d bytes instead of 32", len(key)),
+			"Restore the key from a backup of your secret store")
+		return nil
+	}
+	fingerprint := crypto.Fingerprint(key)
+	if !exists {
+		r.add("key", StatusOK, "secret store holds key "+fingerprint)
+		return key
+	}
+	verifyErr := rotate.VerifyKey(path, key)
+	if verifyErr == nil {
+		r.add("key", StatusOK, fmt.Sprintf("key %s opens the vault", fingerprint))
+		return key
+	}
+
+	fix := []string{
+		fmt.Sprintf("Run 'bosr recover %s' to find out which key opens the vault", path),
+		fmt.Sprintf("If the key is right, the vault was changed: 'bosr verify %s' says how", path),
+	}
+	if staged, err := store.Get(rotate.StagedKeyName(path)); err == nil && rotate.VerifyKey(path, staged) == nil {
+		fix = []string{fmt.Sprintf("The vault was already switched to the key staged by an interrupted rotation; run 'bosr recover %s'", path)}
+	}
+	message := fmt.Sprintf("key %s does not open the vault (%v)", fingerprint, verifyErr)
+	if ids := rowKeyIDs(path); len(ids) > 0 {
+		message += fmt.Sprintf("; its rows were written with %s", strings.Join(ids, ", "))
+	}
+	r.add("key", StatusFail, message, fix...)
+	return nil
+}
+
+// rowKeyIDs returns the fingerprints of the keys the rows of a plaintext
+// vault file were written with. Fully encrypted files do not reveal them.
+func rowKeyIDs(path string) []string {
+	if encrypted, err := sqlite.IsEncrypted(path); err != nil || encrypted {
+		return nil
+	}
+	db, err := openReadOnly(path, nil)
+	if err != nil {
+		return nil
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT DISTINCT key_id FROM vault WHERE key_id != '' ORDER BY key_id")
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// checkRotation reports offline and online key rotations that did not finish
+func checkRotation(r *Report, path string, store secretstore.Store) {
+	j, err := rotate.LoadJournal(path)
+	switch {
+	case errors.Is(err, rotate.ErrNoJournal):
+	case err != nil:
+		r.add("rotation", StatusFail, err.Error(),
+			fmt.Sprintf("Inspect %s; run 'bosr recover %s' once it is readable", rotate.JournalPath(path), path))
+	default:
+		r.add("rotation", StatusFail,
+			fmt.Sprintf("key rotation from %s to %s was interrupted after phase %q", j.OldKey, j.NewKey, j.Phase),
+			fmt.Sprintf("bosr recover %s", path))
+	}
+	if retired, err := store.Get(rotate.RetiredKeyName(path)); err == nil && len(retired) > 0 {
+		r.add("rotation", StatusWarn,
+			fmt.Sprintf("online key rotation away from %s has not finished", crypto.Fingerprint(retired)),
+			fmt.Sprintf("bosr key rotate --online %s", path))
+	}
+}
+
+// checkLeftovers lists files next to the vault that earlier operations left
+func checkLeftovers(r *Report, path string) {
+	_, journalErr := rotate.LoadJournal(path)
+	for _, leftover := range rotate.Leftovers(path) {
+		if !errors.Is(journalErr, rotate.ErrNoJournal) {
+			// Files of an interrupted rotation are the journal's business
+			break
+		}
+		r.add("leftovers", StatusFail,
+			fmt.Sprintf("%s was left behind by a failed key rotation; 'bosr key rotate' refuses to run while it exists", leftover),
+			fmt.Sprintf("Run 'bosr recover %s' to work out which file and key belong together", path),
+			fmt.Sprintf("Or, once 'bosr open %s' succeeds, remove %s", path, leftover))
+	}
+
+	matches, _ := filepath.Glob(path + ".schema-v*.bak")
+	sort.Strings(matches)
+	for _, m := range matches {
+		r.add("leftovers", StatusWarn, fmt.Sprintf("%s is a copy taken before a schema upgrade", m),
+			fmt.Sprintf("Once 'bosr verify %s' succeeds, remove %s", path, m))
+	}
+	partials, _ := filepath.Glob(path + "*.partial")
+	for _, p := range partials {
+		r.add("leftovers", StatusWarn, fmt.Sprintf("%s is an incomplete backup or export", p), "Remove "+p)
+	}
+
+	if backups, err := backup.List(filepath.Dir(path), path); err == nil && len(backups) > 0 {
+		r.add("backups", StatusOK, fmt.Sprintf("%d backups next to the vault, the newest taken %s",
+			len(backups), backups[0].Time.Format("2006-01-02 15:04:05 UTC")))
+	}
+}
+
+// checkSchema compares the vault's migrations with this version of n1
+func checkSchema(r *Report, path string, key []byte) {
+	encrypted, err := sqlite.IsEncrypted(path)
+	if err != nil {
+		r.add("schema", StatusFail, fmt.Sprintf("cannot read vault file: %v", err))
+		return
+	}
+	if encrypted && key == nil {
+		r.add("schema", StatusWarn, "vault is fully encrypted; its schema cannot be read without its key")
+		return
+	}
+	db, err := openReadOnly(path, key)
+	if err != nil {
+		r.add("schema", StatusFail, fmt.Sprintf("cannot open vault database: %v", err),
+			fmt.Sprintf("Run 'bosr verify %s' once it opens, or restore it from a backup with 'bosr restore'", path))
+		return
+	}
+	defer db.Close()
+	compat, err := migrations.NewVaultRunner(db).Compatibility()
+	if err != nil {
+		r.add("schema", StatusFail, fmt.Sprintf("cannot read migrations: %v", err),
+			fmt.Sprintf("bosr db migrate status %s", path))
+		return
+	}
+	switch {
+	case compat.Err() != nil && !compat.Readable():
+		r.add("schema", StatusFail, compat.Err().Error(), "Upgrade bosr")
+	case compat.Err() != nil:
+		r.add("schema", StatusWarn, compat.Err().Error()+"; it opens read-only", "Upgrade bosr to write to it")
+	case compat.Pending > 0:
+		r.add("schema", StatusWarn,
+			fmt.Sprintf("schema is at version %d of %d; the next 'bosr open' upgrades it after backing it up", compat.Version, compat.Latest),
+			fmt.Sprintf("bosr db migrate up %s", path))
+	default:
+		r.add("schema", StatusOK, fmt.Sprintf("schema is at version %d, format %d", compat.Version, compat.Format))
+	}
+}
+
+// checkJournal reports SQLite journal files: a write-ahead log with frames
+// not yet checkpointed, or a rollback journal left by an interrupted write
+func checkJournal(r *Report, path string) {
+	if info, err := os.Stat(path + "-journal"); err == nil && info.Size() > 0 {
+		r.add("journal", StatusWarn,
+			fmt.Sprintf("%s-journal holds an interrupted write (%d bytes)", path, info.Size()),
+			"Do not delete it: the next 'bosr open' rolls the write back",
+			"Never copy the vault without its -journal file")
+		return
+	}
+	if info, err := os.Stat(path + "-wal"); err == nil && info.Size() > 0 {
+		r.add("journal", StatusOK,
+			fmt.Sprintf("write-ahead log holds %d bytes not yet checkpointed into the vault file", info.Size()),
+			"Never copy the vault without its -wal file; 'bosr backup' takes consistent copies")
+		return
+	}
+	r.add("journal", StatusOK, "no pending SQLite journal")
+}
+
+// checkDiskSpace checks that a key rotation or backup would fit
+func checkDiskSpace(r *Report, path string) {
+	space, err := rotate.MeasureSpace(path)
+	if err != nil {
+		r.add("disk space", StatusWarn, err.Error())
+		return
+	}
+	switch {
+	case space.Free < uint64(space.Size):
+		r.add("disk space", StatusFail, fmt.Sprintf("%s free, less than the vault's %s", formatBytes(space.Free), formatBytes(uint64(space.Size))),
+			"Free disk space: SQLite may be unable to commit writes, and backups will fail")
+	case !space.Enough():
+		r.add("disk space", StatusWarn, fmt.Sprintf("%s free; a key rotation needs about %s", formatBytes(space.Free), formatBytes(space.Need)),
+			"Free disk space before running 'bosr key rotate'")
+	default:
+		r.add("disk space", StatusOK, formatBytes(space.Free)+" free")
+	}
+}
+
+// formatBytes formats a size with a binary unit
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// openReadOnly opens a vault file without changing it
+func openReadOnly(path string, key []byte) (*sql.DB, error) {
+	opts := sqlite.DefaultOptions()
+	opts.ReadOnly = true
+	return sqlite.OpenFile(path, key, opts)
+}
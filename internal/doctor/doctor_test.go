@@ -0,0 +1,211 @@
+package doctor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/n1/n1/internal/rotate"
+	"github.com/n1/n1/internal/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errNotFound = errors.New("secret not found")
+
+type testStore map[string][]byte
+
+func (m testStore) Put(n string, d []byte) error { m[n] = d; return nil }
+
+func (m testStore) Get(n string) ([]byte, error) {
+	d, ok := m[n]
+	if !ok {
+		return nil, errNotFound
+	}
+	return d, nil
+}
+
+func (m testStore) Delete(n string) error { delete(m, n); return nil }
+
+// brokenStore fails every operation, like a locked keychain
+type brokenStore struct{}
+
+func (brokenStore) Put(string, []byte) error   { return errors.New("keychain is locked") }
+func (brokenStore) Get(string) ([]byte, error) { return nil, errors.New("keychain is locked") }
+func (brokenStore) Delete(string) error        { return errors.New("keychain is locked") }
+
+// setupVault creates a vault with one record and its key in a test store
+func setupVault(t *testing.T) (string, []byte, testStore) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vault.db")
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	db, err := sqlite.OpenFile(path, key, sqlite.DefaultOptions())
+	require.NoError(t, err)
+	require.NoError(t, migrations.MigrateVault(db, key))
+	require.NoError(t, dao.NewSecureVaultDAO(db, key).WithInternalKeys().Put("__n1_canary__", []byte("ok")))
+	require.NoError(t, db.Close())
+	require.NoError(t, os.Chmod(path, 0600))
+	return path, key, testStore{path: key}
+}
+
+// setupLegacyVault creates a vault in the schema of the previous release,
+// with no event log, seal or checksummed migrations, and its key in a test
+// store
+func setupLegacyVault(t *testing.T) (string, testStore) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vault.db")
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	db, err := sqlite.Open(path)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE _migrations (version INTEGER PRIMARY KEY, description TEXT NOT NULL, applied_at TIMESTAMP NOT NULL);
+		CREATE TABLE vault (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key TEXT NOT NULL,
+			value BLOB NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE UNIQUE INDEX idx_vault_key ON vault(key);
+		INSERT INTO _migrations (version, description, applied_at) VALUES
+			(1, 'Create vault table', CURRENT_TIMESTAMP),
+			(2, 'Create index on vault key', CURRENT_TIMESTAMP)`)
+	require.NoError(t, err)
+	canary, err := crypto.EncryptBlob(key, []byte("ok"))
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO vault (key, value) VALUES ('__n1_canary__', ?)", canary)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	require.NoError(t, os.Chmod(path, 0600))
+	return path, testStore{path: key}
+}
+
+// findings returns the findings of a check
+func findings(r *Report, check string) []Finding {
+	var out []Finding
+	for _, f := range r.Findings {
+		if f.Check == check {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func TestRunHealthyVault(t *testing.T) {
+	path, key, store := setupVault(t)
+	r := Run(path, store)
+	assert.False(t, r.Failed(), "findings: %+v", r.Findings)
+	for _, f := range r.Findings {
+		assert.Equal(t, StatusOK, f.Status, "%+v", f)
+	}
+	require.Len(t, findings(r, "key"), 1)
+	assert.Contains(t, findings(r, "key")[0].Message, crypto.Fingerprint(key))
+	assert.Len(t, findings(r, "schema"), 1)
+	assert.Len(t, findings(r, "disk space"), 1)
+	assert.NotContains(t, store, path+"#doctor-probe", "the probe entry is removed")
+}
+
+// TestRunLegacyVault checks a vault written by the previous release before
+// its first 'bosr open' upgrades it: nothing fails, and doctor changes nothing
+func TestRunLegacyVault(t *testing.T) {
+	path, store := setupLegacyVault(t)
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	r := Run(path, store)
+	assert.False(t, r.Failed(), "findings: %+v", r.Findings)
+	require.Len(t, findings(r, "key"), 1)
+	assert.Equal(t, StatusOK, findings(r, "key")[0].Status)
+	require.Len(t, findings(r, "schema"), 1)
+	assert.Equal(t, StatusWarn, findings(r, "schema")[0].Status)
+	assert.Contains(t, findings(r, "schema")[0].Message, "schema is at version 2")
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, before, after, "doctor must not change the vault")
+}
+
+func TestRunFindsProblems(t *testing.T) {
+	path, key, store := setupVault(t)
+	require.NoError(t, os.Chmod(path, 0644))
+	require.NoError(t, os.WriteFile(rotate.BackupPath(path), []byte("old"), 0600))
+	require.NoError(t, os.WriteFile(path+".schema-v13.bak", []byte("old"), 0600))
+
+	// The store holds some other key
+	other, err := crypto.Generate(32)
+	require.NoError(t, err)
+	store[path] = other
+
+	r := Run(path, store)
+	assert.True(t, r.Failed())
+	perms := findings(r, "permissions")
+	require.Len(t, perms, 1)
+	assert.Equal(t, StatusWarn, perms[0].Status)
+	assert.Equal(t, []string{"chmod 600 " + path}, perms[0].Fix)
+
+	keys := findings(r, "key")
+	require.Len(t, keys, 1)
+	assert.Equal(t, StatusFail, keys[0].Status)
+	assert.Contains(t, keys[0].Message, "rows were written with "+crypto.Fingerprint(key))
+	assert.Contains(t, keys[0].Fix[0], "bosr recover")
+
+	leftovers := findings(r, "leftovers")
+	require.Len(t, leftovers, 2)
+	assert.Equal(t, StatusFail, leftovers[0].Status)
+	assert.Contains(t, leftovers[0].Message, ".bak")
+	assert.Equal(t, StatusWarn, leftovers[1].Status)
+	assert.Contains(t, leftovers[1].Message, ".schema-v13.bak")
+
+	delete(store, path)
+	keys = findings(Run(path, store), "key")
+	require.Len(t, keys, 1)
+	assert.Equal(t, "no key for this vault in the secret store", keys[0].Message)
+}
+
+func TestRunInterruptedRotation(t *testing.T) {
+	path, key, store := setupVault(t)
+	require.NoError(t, os.WriteFile(rotate.BackupPath(path), []byte("old"), 0600))
+	require.NoError(t, os.WriteFile(rotate.JournalPath(path), []byte(`{"phase": "backed-up", "old_key": "aa", "new_key": "bb"}`), 0600))
+	store[rotate.RetiredKeyName(path)] = key
+
+	r := Run(path, store)
+	rotation := findings(r, "rotation")
+	require.Len(t, rotation, 2)
+	assert.Equal(t, StatusFail, rotation[0].Status)
+	assert.Equal(t, []string{"bosr recover " + path}, rotation[0].Fix)
+	assert.Equal(t, StatusWarn, rotation[1].Status)
+	assert.Contains(t, rotation[1].Fix[0], "--online")
+	assert.Empty(t, findings(r, "leftovers"), "the journal accounts for the rotation's files")
+}
+
+func TestRunStoreUnreachable(t *testing.T) {
+	path, _, _ := setupVault(t)
+	r := Run(path, brokenStore{})
+	store := findings(r, "secret store")
+	require.Len(t, store, 1)
+	assert.Equal(t, StatusFail, store[0].Status)
+	assert.Contains(t, store[0].Message, "keychain is locked")
+	assert.Empty(t, findings(r, "key"))
+}
+
+func TestRunMissingVault(t *testing.T) {
+	_, _, store := setupVault(t)
+	path := filepath.Join(t.TempDir(), "moved.db")
+	r := Run(path, store)
+	file := findings(r, "file")
+	require.Len(t, file, 1)
+	assert.Equal(t, StatusFail, file[0].Status)
+	assert.Empty(t, findings(r, "schema"))
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "79.2 GiB", formatBytes(85043363840))
+}
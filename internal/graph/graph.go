@@ -0,0 +1,301 @@
+// Package graph provides a directed, weighted multigraph of typed edges
+// between records, with traversal queries and Graphviz rendering. It knows
+// nothing about storage: callers load edges (e.g. vault links and Hold
+// references) and query the resulting graph in memory.
+package graph
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoPath is returned when two nodes are not connected
+	ErrNoPath = errors.New("no path between nodes")
+	// ErrUnknownNode is returned for nodes that are not in the graph
+	ErrUnknownNode = errors.New("unknown node")
+)
+
+// Edge is a typed, directed edge. Weight is the cost of traversing it.
+type Edge struct {
+	From   string
+	To     string
+	Type   string
+	Weight float64
+}
+
+// Graph is a directed multigraph. Traversals follow edges in both directions,
+// since a link is equally meaningful from either end (a credential is
+// reachable from the service it is for and vice versa).
+type Graph struct {
+	nodes map[string]bool
+	out   map[string][]Edge
+	in    map[string][]Edge
+}
+
+// New creates an empty graph
+func New() *Graph {
+	return &Graph{
+		nodes: make(map[string]bool),
+		out:   make(map[string][]Edge),
+		in:    make(map[string][]Edge),
+	}
+}
+
+// AddNode adds a node without edges. Adding an existing node is a no-op.
+func (g *Graph) AddNode(node string) {
+	g.nodes[node] = true
+}
+
+// AddEdge adds an edge, creating its nodes as needed
+func (g *Graph) AddEdge(e Edge) {
+	g.AddNode(e.From)
+	g.AddNode(e.To)
+	g.out[e.From] = append(g.out[e.From], e)
+	g.in[e.To] = append(g.in[e.To], e)
+}
+
+// Has reports whether node is in the graph
+func (g *Graph) Has(node string) bool {
+	return g.nodes[node]
+}
+
+// Nodes returns all nodes, sorted
+func (g *Graph) Nodes() []string {
+	nodes := make([]string, 0, len(g.nodes))
+	for n := range g.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Edges returns all edges sorted by source, target and type
+func (g *Graph) Edges() []Edge {
+	var edges []Edge
+	for _, es := range g.out {
+		edges = append(edges, es...)
+	}
+	sortEdges(edges)
+	return edges
+}
+
+// Outgoing returns the edges leaving node
+func (g *Graph) Outgoing(node string) []Edge {
+	edges := append([]Edge(nil), g.out[node]...)
+	sortEdges(edges)
+	return edges
+}
+
+// Incoming returns the edges pointing at node (its backlinks)
+func (g *Graph) Incoming(node string) []Edge {
+	edges := append([]Edge(nil), g.in[node]...)
+	sortEdges(edges)
+	return edges
+}
+
+// Neighbours returns the nodes within depth hops of node in either
+// direction, excluding node itself, sorted
+func (g *Graph) Neighbours(node string, depth int) ([]string, error) {
+	if !g.Has(node) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownNode, node)
+	}
+	seen := map[string]bool{node: true}
+	frontier := []string{node}
+	var found []string
+	for hop := 0; hop < depth && len(frontier) > 0; hop++ {
+		var next []string
+		for _, n := range frontier {
+			for _, adj := range g.adjacent(n) {
+				if !seen[adj.node] {
+					seen[adj.node] = true
+					next = append(next, adj.node)
+					found = append(found, adj.node)
+				}
+			}
+		}
+		frontier = next
+	}
+	sort.Strings(found)
+	return found, nil
+}
+
+// Subgraph returns the graph induced by nodes
+func (g *Graph) Subgraph(nodes []string) *Graph {
+	keep := make(map[string]bool, len(nodes))
+	sub := New()
+	for _, n := range nodes {
+		if g.Has(n) {
+			keep[n] = true
+			sub.AddNode(n)
+		}
+	}
+	for _, e := range g.Edges() {
+		if keep[e.From] && keep[e.To] {
+			sub.AddEdge(e)
+		}
+	}
+	return sub
+}
+
+// ShortestPath returns the edges of the cheapest path from one node to
+// another, following edges in either direction, and its total weight
+func (g *Graph) ShortestPath(from, to string) ([]Edge, float64, error) {
+	for _, n := range []string{from, to} {
+		if !g.Has(n) {
+			return nil, 0, fmt.Errorf("%w: %s", ErrUnknownNode, n)
+		}
+	}
+
+	dist := map[string]float64{from: 0}
+	via := make(map[string]Edge)
+	done := make(map[string]bool)
+	queue := &pathQueue{{node: from}}
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(pathItem)
+		if done[current.node] {
+			continue
+		}
+		done[current.node] = true
+		if current.node == to {
+			break
+		}
+		for _, adj := range g.adjacent(current.node) {
+			d := current.dist + adj.edge.Weight
+			if known, ok := dist[adj.node]; !ok || d < known {
+				dist[adj.node] = d
+				via[adj.node] = adj.edge
+				heap.Push(queue, pathItem{node: adj.node, dist: d})
+			}
+		}
+	}
+	if !done[to] {
+		return nil, 0, fmt.Errorf("%w: %s and %s", ErrNoPath, from, to)
+	}
+
+	var path []Edge
+	for n := to; n != from; {
+		e := via[n]
+		path = append([]Edge{e}, path...)
+		if e.To == n {
+			n = e.From
+		} else {
+			n = e.To
+		}
+	}
+	return path, dist[to], nil
+}
+
+// Components returns the connected components of the graph, ignoring edge
+// direction. Each component is sorted; components are ordered by size,
+// largest first, then by their first node.
+func (g *Graph) Components() [][]string {
+	seen := make(map[string]bool)
+	var components [][]string
+	for _, start := range g.Nodes() {
+		if seen[start] {
+			continue
+		}
+		seen[start] = true
+		component := []string{start}
+		stack := []string{start}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, adj := range g.adjacent(n) {
+				if !seen[adj.node] {
+					seen[adj.node] = true
+					component = append(component, adj.node)
+					stack = append(stack, adj.node)
+				}
+			}
+		}
+		sort.Strings(component)
+		components = append(components, component)
+	}
+	sort.SliceStable(components, func(i, j int) bool {
+		if len(components[i]) != len(components[j]) {
+			return len(components[i]) > len(components[j])
+		}
+		return components[i][0] < components[j][0]
+	})
+	return components
+}
+
+// WriteDOT renders the graph in Graphviz DOT format
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph n1 {\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, n := range g.Nodes() {
+		fmt.Fprintf(&b, "  %s;\n", strconv.Quote(n))
+	}
+	for _, e := range g.Edges() {
+		label := e.Type
+		if e.Weight != 1 {
+			label += " (" + strconv.FormatFloat(e.Weight, 'g', -1, 64) + ")"
+		}
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), strconv.Quote(label))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type adjacency struct {
+	node string
+	edge Edge
+}
+
+// adjacent lists the nodes reachable from node over one edge in either direction
+func (g *Graph) adjacent(node string) []adjacency {
+	var adj []adjacency
+	for _, e := range g.Outgoing(node) {
+		adj = append(adj, adjacency{node: e.To, edge: e})
+	}
+	for _, e := range g.Incoming(node) {
+		adj = append(adj, adjacency{node: e.From, edge: e})
+	}
+	return adj
+}
+
+func sortEdges(edges []Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].To != edges[j].To {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].Type < edges[j].Type
+	})
+}
+
+type pathItem struct {
+	node string
+	dist float64
+}
+
+// pathQueue is a min-heap of nodes by tentative distance
+type pathQueue []pathItem
+
+func (q pathQueue) Len() int { return len(q) }
+func (q pathQueue) Less(i, j int) bool {
+	if q[i].dist != q[j].dist {
+		return q[i].dist < q[j].dist
+	}
+	return q[i].node < q[j].node
+}
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
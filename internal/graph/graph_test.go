@@ -0,0 +1,109 @@
+package graph
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGraph:
+//
+//	github-token --credential-for--> github --related--> gitlab
+//	ci --blocks(5)--> deploy <--derived-from-- release
+//	ci --related--> github
+//	lonely
+func testGraph() *Graph {
+	g := New()
+	g.AddEdge(Edge{From: "github-token", To: "github", Type: "credential-for", Weight: 1})
+	g.AddEdge(Edge{From: "github", To: "gitlab", Type: "related", Weight: 1})
+	g.AddEdge(Edge{From: "ci", To: "deploy", Type: "blocks", Weight: 5})
+	g.AddEdge(Edge{From: "release", To: "deploy", Type: "derived-from", Weight: 1})
+	g.AddEdge(Edge{From: "ci", To: "github", Type: "related", Weight: 1})
+	g.AddEdge(Edge{From: "github-token", To: "ci", Type: "credential-for", Weight: 1})
+	g.AddNode("lonely")
+	return g
+}
+
+func TestEdgesAndBacklinks(t *testing.T) {
+	g := testGraph()
+
+	assert.Len(t, g.Edges(), 6)
+	assert.Equal(t, []Edge{
+		{From: "ci", To: "github", Type: "related", Weight: 1},
+		{From: "github-token", To: "github", Type: "credential-for", Weight: 1},
+	}, g.Incoming("github"))
+	assert.Len(t, g.Outgoing("github-token"), 2)
+	assert.Empty(t, g.Incoming("lonely"))
+}
+
+func TestNeighbours(t *testing.T) {
+	g := testGraph()
+
+	one, err := g.Neighbours("github", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ci", "github-token", "gitlab"}, one)
+
+	two, err := g.Neighbours("github", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ci", "deploy", "github-token", "gitlab"}, two)
+
+	_, err = g.Neighbours("missing", 1)
+	assert.ErrorIs(t, err, ErrUnknownNode)
+}
+
+func TestShortestPath(t *testing.T) {
+	g := testGraph()
+
+	path, cost, err := g.ShortestPath("gitlab", "release")
+	require.NoError(t, err)
+	assert.Equal(t, 8.0, cost)
+	var hops []string
+	for _, e := range path {
+		hops = append(hops, e.From+"->"+e.To)
+	}
+	assert.Equal(t, []string{"github->gitlab", "ci->github", "ci->deploy", "release->deploy"}, hops)
+
+	// Adding a cheaper detour changes the route
+	g.AddEdge(Edge{From: "github", To: "release", Type: "related", Weight: 2})
+	_, cost, err = g.ShortestPath("gitlab", "release")
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, cost)
+
+	_, _, err = g.ShortestPath("github", "lonely")
+	assert.ErrorIs(t, err, ErrNoPath)
+	_, _, err = g.ShortestPath("github", "missing")
+	assert.ErrorIs(t, err, ErrUnknownNode)
+
+	path, cost, err = g.ShortestPath("ci", "ci")
+	require.NoError(t, err)
+	assert.Empty(t, path)
+	assert.Zero(t, cost)
+}
+
+func TestComponents(t *testing.T) {
+	g := testGraph()
+	g.AddEdge(Edge{From: "a", To: "b", Type: "related", Weight: 1})
+
+	assert.Equal(t, [][]string{
+		{"ci", "deploy", "github", "github-token", "gitlab", "release"},
+		{"a", "b"},
+		{"lonely"},
+	}, g.Components())
+}
+
+func TestSubgraphAndDOT(t *testing.T) {
+	sub := testGraph().Subgraph([]string{"ci", "deploy", "missing"})
+	assert.Equal(t, []string{"ci", "deploy"}, sub.Nodes())
+
+	var buf bytes.Buffer
+	require.NoError(t, sub.WriteDOT(&buf))
+	assert.Equal(t, `digraph n1 {
+  node [shape=box];
+  "ci";
+  "deploy";
+  "ci" -> "deploy" [label="blocks (5)"];
+}
+`, buf.String())
+}
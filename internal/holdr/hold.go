@@ -1,4 +1,226 @@
 package holdr
 
-// TODO(M1): domain model for note/hold records.
-type Keep struct{}
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/n1/n1/internal/dao"
+)
+
+// DefaultScope is the scope assigned to Holds created without one
+const DefaultScope = dao.DefaultScope
+
+// idLength is the number of hex characters in a Hold ID (128 bits)
+const idLength = 32
+
+var (
+	// ErrInvalidHold is returned when a Hold fails validation
+	ErrInvalidHold = errors.New("invalid hold")
+	// ErrIDMismatch is returned when a stored Hold's content does not match its ID
+	ErrIDMismatch = errors.New("hold content does not match its id")
+
+	kindPattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	idPattern   = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+// Kind classifies a Hold (note, credential, task, ...)
+type Kind string
+
+// Hold is the atomic, immutable unit of information in n1.
+// Its ID is derived from its canonical encoding, so any change to the content
+// yields a different Hold; edits are expressed as a new Hold that Amends the old one.
+// Scope is where the Hold is filed rather than part of its content: it is not
+// covered by the ID and can change with Repository.Move.
+type Hold struct {
+	ID        string          `json:"id"`
+	Kind      Kind            `json:"kind"`
+	Body      json.RawMessage `json:"body"`
+	Tags      []string        `json:"tags,omitempty"`
+	Scope     string          `json:"scope"`
+	CreatedAt time.Time       `json:"created_at"`
+	// Refs lists IDs of other Holds this one refers to
+	Refs []string `json:"refs,omitempty"`
+	// Amends is the ID of the Hold this one supersedes, if any
+	Amends string `json:"amends,omitempty"`
+}
+
+// New creates a Hold of the given kind and body with defaults filled in.
+// The returned Hold is sealed: its ID is computed and it passes Validate.
+func New(kind Kind, body json.RawMessage) (*Hold, error) {
+	h := &Hold{
+		Kind:      kind,
+		Body:      body,
+		Scope:     DefaultScope,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := h.Seal(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Seal normalises the Hold and computes its ID from the canonical encoding
+func (h *Hold) Seal() error {
+	if h.Scope == "" {
+		h.Scope = DefaultScope
+	}
+	if h.CreatedAt.IsZero() {
+		h.CreatedAt = time.Now().UTC()
+	}
+	h.CreatedAt = h.CreatedAt.UTC()
+	h.Tags = normaliseList(h.Tags)
+	h.Refs = normaliseList(h.Refs)
+
+	if err := h.validateContent(); err != nil {
+		return err
+	}
+	body, err := canonicalJSON(h.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidHold, err)
+	}
+	h.Body = body
+
+	id, err := h.computeID()
+	if err != nil {
+		return err
+	}
+	h.ID = id
+	return nil
+}
+
+// Validate checks the Hold's fields and that its ID matches its content
+func (h *Hold) Validate() error {
+	if err := h.validateContent(); err != nil {
+		return err
+	}
+	id, err := h.computeID()
+	if err != nil {
+		return err
+	}
+	if h.ID != id {
+		return fmt.Errorf("%w: have %s, content hashes to %s", ErrIDMismatch, h.ID, id)
+	}
+	return nil
+}
+
+func (h *Hold) validateContent() error {
+	if !kindPattern.MatchString(string(h.Kind)) {
+		return fmt.Errorf("%w: kind %q must be lowercase letters, digits or dashes", ErrInvalidHold, h.Kind)
+	}
+	if len(h.Body) == 0 || !json.Valid(h.Body) {
+		return fmt.Errorf("%w: body must be valid JSON", ErrInvalidHold)
+	}
+	if h.Scope == "" {
+		return fmt.Errorf("%w: scope is required", ErrInvalidHold)
+	}
+	if h.CreatedAt.IsZero() {
+		return fmt.Errorf("%w: created_at is required", ErrInvalidHold)
+	}
+	for _, tag := range h.Tags {
+		if tag == "" || strings.ContainsAny(tag, " \t\r\n") {
+			return fmt.Errorf("%w: tag %q must be non-empty and contain no whitespace", ErrInvalidHold, tag)
+		}
+	}
+	for _, ref := range h.Refs {
+		if !IsID(ref) {
+			return fmt.Errorf("%w: reference %q is not a hold id", ErrInvalidHold, ref)
+		}
+	}
+	if h.Amends != "" && !IsID(h.Amends) {
+		return fmt.Errorf("%w: amends %q is not a hold id", ErrInvalidHold, h.Amends)
+	}
+	return nil
+}
+
+// computeID hashes the canonical encoding of the Hold without its ID or scope
+func (h *Hold) computeID() (string, error) {
+	unsealed := *h
+	unsealed.ID = ""
+	unsealed.Scope = ""
+	data, err := unsealed.Canonical()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:idLength], nil
+}
+
+// Canonical returns the deterministic JSON encoding of the Hold: fixed field
+// order, sorted object keys in the body, UTC timestamps and no insignificant whitespace.
+func (h *Hold) Canonical() ([]byte, error) {
+	body, err := canonicalJSON(h.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHold, err)
+	}
+	c := *h
+	c.Body = body
+	c.CreatedAt = h.CreatedAt.UTC()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(c); err != nil {
+		return nil, fmt.Errorf("failed to encode hold: %w", err)
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// Decode parses and validates a Hold from its JSON encoding
+func Decode(data []byte) (*Hold, error) {
+	var h Hold
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("failed to decode hold: %w", err)
+	}
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// IsID reports whether s is syntactically a Hold ID
+func IsID(s string) bool {
+	return idPattern.MatchString(s)
+}
+
+// canonicalJSON re-encodes a JSON value with sorted keys and compact spacing.
+// Numbers are kept verbatim so no precision is lost.
+func canonicalJSON(raw json.RawMessage) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// normaliseList sorts and de-duplicates a string list, returning nil when empty
+func normaliseList(in []string) []string {
+	if len(in) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,80 @@
+package holdr
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSealsHold(t *testing.T) {
+	h, err := New("note", json.RawMessage(`{"text": "hello", "a": 1}`))
+	require.NoError(t, err, "New failed")
+
+	assert.True(t, IsID(h.ID), "ID should be a 32 character hex string")
+	assert.Equal(t, DefaultScope, h.Scope, "Scope should default to inbox")
+	assert.Equal(t, json.RawMessage(`{"a":1,"text":"hello"}`), h.Body, "Body should be canonicalised")
+	require.NoError(t, h.Validate(), "Sealed hold should validate")
+}
+
+func TestCanonicalEncodingIsDeterministic(t *testing.T) {
+	created := time.Date(2025, 4, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	a := &Hold{Kind: "note", Body: json.RawMessage(`{"b":2,"a":1}`), Tags: []string{"y", "x", "y"}, CreatedAt: created}
+	b := &Hold{Kind: "note", Body: json.RawMessage(`{ "a": 1, "b": 2 }`), Tags: []string{"x", "y"}, CreatedAt: created.UTC()}
+	require.NoError(t, a.Seal())
+	require.NoError(t, b.Seal())
+
+	assert.Equal(t, a.ID, b.ID, "Equivalent holds should have the same ID")
+
+	ca, err := a.Canonical()
+	require.NoError(t, err)
+	cb, err := b.Canonical()
+	require.NoError(t, err)
+	assert.Equal(t, string(ca), string(cb), "Canonical encodings should match")
+	assert.Contains(t, string(ca), `"created_at":"2025-04-01T10:00:00Z"`, "Timestamps should be encoded in UTC")
+}
+
+func TestDecodeDetectsTampering(t *testing.T) {
+	h, err := New("note", json.RawMessage(`{"text":"original"}`))
+	require.NoError(t, err)
+	data, err := h.Canonical()
+	require.NoError(t, err)
+
+	decoded, err := Decode(data)
+	require.NoError(t, err, "Decoding an untouched hold should succeed")
+	assert.Equal(t, h.ID, decoded.ID)
+
+	var tampered map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &tampered))
+	tampered["body"] = map[string]string{"text": "forged"}
+	forged, err := json.Marshal(tampered)
+	require.NoError(t, err)
+
+	_, err = Decode(forged)
+	assert.ErrorIs(t, err, ErrIDMismatch, "Changed content should no longer match the ID")
+}
+
+func TestValidateRejectsInvalidHolds(t *testing.T) {
+	testCases := []struct {
+		name string
+		hold Hold
+	}{
+		{name: "Empty kind", hold: Hold{Body: json.RawMessage(`{}`)}},
+		{name: "Uppercase kind", hold: Hold{Kind: "Note", Body: json.RawMessage(`{}`)}},
+		{name: "Invalid body", hold: Hold{Kind: "note", Body: json.RawMessage(`{`)}},
+		{name: "Missing body", hold: Hold{Kind: "note"}},
+		{name: "Whitespace tag", hold: Hold{Kind: "note", Body: json.RawMessage(`{}`), Tags: []string{"two words"}}},
+		{name: "Bad reference", hold: Hold{Kind: "note", Body: json.RawMessage(`{}`), Refs: []string{"nope"}}},
+		{name: "Bad amends", hold: Hold{Kind: "note", Body: json.RawMessage(`{}`), Amends: "nope"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := tc.hold
+			assert.ErrorIs(t, h.Seal(), ErrInvalidHold)
+		})
+	}
+}
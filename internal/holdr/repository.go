@@ -0,0 +1,199 @@
+package holdr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/n1/n1/internal/dao"
+)
+
+// KeyPrefix namespaces Hold records within the vault table
+const KeyPrefix = "hold/"
+
+var (
+	// ErrNotFound is returned when no Hold exists with the requested ID
+	ErrNotFound = errors.New("hold not found")
+	// ErrExists is returned when creating a Hold whose ID is already stored
+	ErrExists = errors.New("hold already exists")
+)
+
+// Repository stores Holds as encrypted vault records
+type Repository struct {
+	vault *dao.SecureVaultDAO
+}
+
+// NewRepository creates a new Repository on top of a SecureVaultDAO
+func NewRepository(vault *dao.SecureVaultDAO) *Repository {
+	return &Repository{vault: vault}
+}
+
+// Key returns the vault key under which a Hold with the given ID is stored
+func Key(id string) string {
+	return KeyPrefix + id
+}
+
+// Create seals and stores a new Hold. Existing Holds are never overwritten.
+func (r *Repository) Create(h *Hold) error {
+	if err := h.Seal(); err != nil {
+		return err
+	}
+	for _, ref := range h.refsAndAmends() {
+		if _, err := r.Get(ref); err != nil {
+			return fmt.Errorf("referenced hold %s: %w", ref, err)
+		}
+	}
+
+	if _, err := r.vault.Get(Key(h.ID)); err == nil {
+		return fmt.Errorf("%w: %s", ErrExists, h.ID)
+	} else if !errors.Is(err, dao.ErrNotFound) {
+		return fmt.Errorf("failed to check for existing hold %s: %w", h.ID, err)
+	}
+
+	data, err := h.Canonical()
+	if err != nil {
+		return err
+	}
+	if err := r.vault.Apply(&dao.Event{Type: dao.EventCreateHold, Key: Key(h.ID), Value: data, Scope: h.Scope}); err != nil {
+		return fmt.Errorf("failed to store hold %s: %w", h.ID, err)
+	}
+	return nil
+}
+
+// Get retrieves and verifies a Hold by ID
+func (r *Repository) Get(id string) (*Hold, error) {
+	if !IsID(id) {
+		return nil, fmt.Errorf("%w: %q is not a hold id", ErrNotFound, id)
+	}
+	data, err := r.vault.Get(Key(id))
+	if err != nil {
+		if errors.Is(err, dao.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to read hold %s: %w", id, err)
+	}
+	h, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("hold %s: %w", id, err)
+	}
+	if h.ID != id {
+		return nil, fmt.Errorf("hold %s: %w: stored under %s", id, ErrIDMismatch, h.ID)
+	}
+	// The vault tracks where the Hold is filed now
+	scope, err := r.vault.ScopeOf(Key(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scope of hold %s: %w", id, err)
+	}
+	h.Scope = scope
+	return h, nil
+}
+
+// Move files the Hold with the given ID under another scope
+func (r *Repository) Move(id, scope string) error {
+	if _, err := r.Get(id); err != nil {
+		return err
+	}
+	if err := r.vault.Move(Key(id), scope); err != nil {
+		return fmt.Errorf("failed to move hold %s: %w", id, err)
+	}
+	return nil
+}
+
+// List returns all Holds ordered by creation time
+func (r *Repository) List() ([]*Hold, error) {
+	keys, err := r.vault.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vault keys: %w", err)
+	}
+
+	var holds []*Hold
+	for _, k := range keys {
+		if !strings.HasPrefix(k, KeyPrefix) {
+			continue
+		}
+		h, err := r.Get(strings.TrimPrefix(k, KeyPrefix))
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+
+	sort.SliceStable(holds, func(i, j int) bool {
+		if holds[i].CreatedAt.Equal(holds[j].CreatedAt) {
+			return holds[i].ID < holds[j].ID
+		}
+		return holds[i].CreatedAt.Before(holds[j].CreatedAt)
+	})
+	return holds, nil
+}
+
+// Current returns the Holds that have not been superseded by an amendment
+func (r *Repository) Current() ([]*Hold, error) {
+	holds, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	amended := make(map[string]bool)
+	for _, h := range holds {
+		if h.Amends != "" {
+			amended[h.Amends] = true
+		}
+	}
+	var current []*Hold
+	for _, h := range holds {
+		if !amended[h.ID] {
+			current = append(current, h)
+		}
+	}
+	return current, nil
+}
+
+// Amend creates a new Hold that supersedes the Hold with the given ID.
+// The amendment inherits kind, tags, scope and references and carries the new body.
+func (r *Repository) Amend(id string, body json.RawMessage) (*Hold, error) {
+	original, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	amended := &Hold{
+		Kind:   original.Kind,
+		Body:   body,
+		Tags:   append([]string(nil), original.Tags...),
+		Scope:  original.Scope,
+		Refs:   append([]string(nil), original.Refs...),
+		Amends: original.ID,
+	}
+	if err := r.Create(amended); err != nil {
+		return nil, err
+	}
+	return amended, nil
+}
+
+// History returns the chain of Holds leading to id, newest first
+func (r *Repository) History(id string) ([]*Hold, error) {
+	var chain []*Hold
+	seen := make(map[string]bool)
+	for id != "" {
+		if seen[id] {
+			return nil, fmt.Errorf("amendment cycle detected at hold %s", id)
+		}
+		seen[id] = true
+		h, err := r.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, h)
+		id = h.Amends
+	}
+	return chain, nil
+}
+
+func (h *Hold) refsAndAmends() []string {
+	ids := append([]string(nil), h.Refs...)
+	if h.Amends != "" {
+		ids = append(ids, h.Amends)
+	}
+	return ids
+}
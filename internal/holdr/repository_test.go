@@ -0,0 +1,106 @@
+package holdr
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupRepository(t *testing.T) (*Repository, *dao.SecureVaultDAO) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "holdr_test.db"))
+	require.NoError(t, err, "Opening database failed")
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, migrations.BootstrapVault(db), "Bootstrapping vault failed")
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err, "Failed to generate key")
+	vault := dao.NewSecureVaultDAO(db, key)
+	return NewRepository(vault), vault
+}
+
+func TestRepositoryCreateGetList(t *testing.T) {
+	repo, vault := setupRepository(t)
+
+	// Unrelated vault records must not show up as holds
+	require.NoError(t, vault.Put("plain_key", []byte("plain")))
+
+	first := &Hold{Kind: "note", Body: json.RawMessage(`{"text":"first"}`), Tags: []string{"todo"}}
+	require.NoError(t, repo.Create(first), "Create failed")
+
+	second := &Hold{Kind: "task", Body: json.RawMessage(`{"text":"second"}`), Refs: []string{first.ID}}
+	require.NoError(t, repo.Create(second), "Create with reference failed")
+
+	got, err := repo.Get(first.ID)
+	require.NoError(t, err, "Get failed")
+	assert.Equal(t, first.ID, got.ID)
+	assert.Equal(t, []string{"todo"}, got.Tags)
+
+	holds, err := repo.List()
+	require.NoError(t, err, "List failed")
+	require.Len(t, holds, 2, "List should only contain holds")
+
+	// Holds are immutable: storing the same content again is refused
+	dup := *first
+	assert.ErrorIs(t, repo.Create(&dup), ErrExists)
+
+	// References must point at existing holds
+	dangling := &Hold{Kind: "note", Body: json.RawMessage(`{}`), Refs: []string{"0123456789abcdef0123456789abcdef"}}
+	assert.ErrorIs(t, repo.Create(dangling), ErrNotFound)
+
+	_, err = repo.Get("0123456789abcdef0123456789abcdef")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRepositoryAmend(t *testing.T) {
+	repo, _ := setupRepository(t)
+
+	original := &Hold{Kind: "note", Body: json.RawMessage(`{"text":"draft"}`), Scope: "sandbox", Tags: []string{"wip"}}
+	require.NoError(t, repo.Create(original))
+
+	amended, err := repo.Amend(original.ID, json.RawMessage(`{"text":"final"}`))
+	require.NoError(t, err, "Amend failed")
+	assert.NotEqual(t, original.ID, amended.ID, "Amendment should be a new hold")
+	assert.Equal(t, original.ID, amended.Amends)
+	assert.Equal(t, "sandbox", amended.Scope, "Amendment should inherit scope")
+	assert.Equal(t, []string{"wip"}, amended.Tags, "Amendment should inherit tags")
+
+	// The original remains readable and unchanged
+	stillThere, err := repo.Get(original.ID)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"text":"draft"}`, string(stillThere.Body))
+
+	current, err := repo.Current()
+	require.NoError(t, err)
+	require.Len(t, current, 1, "Only the amendment should be current")
+	assert.Equal(t, amended.ID, current[0].ID)
+
+	history, err := repo.History(amended.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, amended.ID, history[0].ID)
+	assert.Equal(t, original.ID, history[1].ID)
+}
+
+func TestRepositoryMove(t *testing.T) {
+	repo, _ := setupRepository(t)
+
+	h := &Hold{Kind: "note", Body: json.RawMessage(`{"text":"file me"}`)}
+	require.NoError(t, repo.Create(h))
+	id := h.ID
+
+	require.NoError(t, repo.Move(id, "sandbox"), "Move failed")
+	moved, err := repo.Get(id)
+	require.NoError(t, err, "Moved hold should still verify against its ID")
+	assert.Equal(t, "sandbox", moved.Scope)
+
+	assert.ErrorIs(t, repo.Move(id, "nowhere"), dao.ErrUnknownScope)
+	assert.ErrorIs(t, repo.Move("0123456789abcdef0123456789abcdef", "sandbox"), ErrNotFound)
+}
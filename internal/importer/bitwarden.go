@@ -0,0 +1,139 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Bitwarden item types
+const (
+	bitwardenLogin      = 1
+	bitwardenSecureNote = 2
+	bitwardenCard       = 3
+	bitwardenIdentity   = 4
+	bitwardenSSHKey     = 5
+)
+
+// bitwardenExport is Bitwarden's unencrypted JSON export
+type bitwardenExport struct {
+	Encrypted bool `json:"encrypted"`
+	Folders   []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []bitwardenItem `json:"items"`
+}
+
+type bitwardenItem struct {
+	Type     int     `json:"type"`
+	Name     string  `json:"name"`
+	FolderID *string `json:"folderId"`
+	Notes    string  `json:"notes"`
+	Fields   []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"fields"`
+	Login *struct {
+		URIs []struct {
+			URI string `json:"uri"`
+		} `json:"uris"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		TOTP     string `json:"totp"`
+	} `json:"login"`
+	Card *struct {
+		CardholderName string `json:"cardholderName"`
+		Brand          string `json:"brand"`
+		Number         string `json:"number"`
+		ExpMonth       string `json:"expMonth"`
+		ExpYear        string `json:"expYear"`
+		Code           string `json:"code"`
+	} `json:"card"`
+	SSHKey *struct {
+		PrivateKey     string `json:"privateKey"`
+		PublicKey      string `json:"publicKey"`
+		KeyFingerprint string `json:"keyFingerprint"`
+	} `json:"sshKey"`
+	Identity map[string]*string `json:"identity"`
+}
+
+func readBitwarden(path string) ([]item, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var export bitwardenExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("not a Bitwarden JSON export: %w", err)
+	}
+	if export.Encrypted {
+		return nil, errors.New("the export is encrypted: export it from Bitwarden as unencrypted JSON")
+	}
+	folders := make(map[string][]string, len(export.Folders))
+	for _, f := range export.Folders {
+		// Bitwarden nests folders by naming them "Parent/Child"
+		folders[f.ID] = strings.Split(f.Name, "/")
+	}
+
+	var items []item
+	for _, bw := range export.Items {
+		var folder []string
+		if bw.FolderID != nil {
+			folder = folders[*bw.FolderID]
+		}
+		it := newItem(kindLogin, folder, bw.Name)
+		it.notes = bw.Notes
+		switch bw.Type {
+		case bitwardenSecureNote, bitwardenIdentity:
+			it.kind = kindNote
+		case bitwardenCard:
+			it.kind = kindCard
+		case bitwardenSSHKey:
+			it.kind = kindSSHKey
+		}
+
+		if l := bw.Login; l != nil {
+			it.set("user", l.Username)
+			it.set("password", l.Password)
+			it.set("totp", l.TOTP)
+			for i, u := range l.URIs {
+				if i == 0 {
+					it.set("url", u.URI)
+				} else {
+					it.addExtra("url", u.URI)
+				}
+			}
+		}
+		if c := bw.Card; c != nil {
+			it.set("holder", c.CardholderName)
+			it.set("number", c.Number)
+			it.set("cvv", c.Code)
+			if c.ExpMonth != "" && c.ExpYear != "" {
+				month := c.ExpMonth
+				if len(month) == 1 {
+					month = "0" + month
+				}
+				it.set("expiry", month+"/"+c.ExpYear)
+			}
+			it.addExtra("brand", c.Brand)
+		}
+		if k := bw.SSHKey; k != nil {
+			it.set("private_key", k.PrivateKey)
+			it.set("public_key", k.PublicKey)
+			it.addExtra("fingerprint", k.KeyFingerprint)
+		}
+		for _, name := range sortedKeys(bw.Identity) {
+			if v := bw.Identity[name]; v != nil {
+				it.addExtra(name, *v)
+			}
+		}
+		for _, f := range bw.Fields {
+			it.addExtra(f.Name, f.Value)
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
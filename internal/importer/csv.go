@@ -0,0 +1,189 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// readCSV reads a CSV export with a header row into one map per row, keyed
+// by the lowercased column names
+func readCSV(path string) ([]string, []map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	// Exports written on Windows may start with a byte order mark
+	br := bufio.NewReader(f)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\ufeff" {
+		_, _ = br.Discard(3)
+	}
+	r := csv.NewReader(br)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, errors.New("the export is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a CSV export: %w", err)
+	}
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(h))
+	}
+
+	var rows []map[string]string
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		row := make(map[string]string, len(header))
+		for i, value := range record {
+			if i < len(header) {
+				row[header[i]] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return header, rows, nil
+}
+
+// onePasswordColumns maps 1Password's CSV columns onto record fields
+var onePasswordColumns = map[string]string{
+	"username":          "user",
+	"password":          "password",
+	"url":               "url",
+	"website":           "url",
+	"otpauth":           "totp",
+	"one-time password": "totp",
+}
+
+// onePasswordIgnored are 1Password columns that hold no data worth keeping
+var onePasswordIgnored = map[string]bool{"favorite": true, "archived": true}
+
+func read1Password(path string) ([]item, error) {
+	header, rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []item
+	for _, row := range rows {
+		var folder []string
+		for _, column := range []string{"vault", "folder"} {
+			if row[column] != "" {
+				folder = strings.Split(row[column], "/")
+				break
+			}
+		}
+		it := newItem(kindLogin, folder, firstOf(row, "title", "name"))
+		for _, column := range header {
+			value := row[column]
+			switch field, ok := onePasswordColumns[column]; {
+			case column == "title", column == "name", column == "vault", column == "folder", onePasswordIgnored[column]:
+			case column == "notes", column == "notesplain":
+				it.notes = value
+			case column == "tags":
+				it.labels = strings.Split(value, ",")
+			case column == "type" && strings.Contains(strings.ToLower(value), "note"):
+				it.kind = kindNote
+			case column == "type":
+			case ok:
+				it.set(field, value)
+			default:
+				it.addExtra(column, value)
+			}
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// lastPassNoteURL marks secure notes in LastPass exports
+const lastPassNoteURL = "http://sn"
+
+// lastPassNoteFields maps the fields of LastPass's structured secure notes
+// onto record fields
+var lastPassNoteFields = map[string]string{
+	"Name on Card":    "holder",
+	"Number":          "number",
+	"Security Code":   "cvv",
+	"Expiration Date": "expiry",
+	"Private Key":     "private_key",
+	"Public Key":      "public_key",
+	"Passphrase":      "passphrase",
+}
+
+func readLastPass(path string) ([]item, error) {
+	_, rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []item
+	for _, row := range rows {
+		// LastPass nests folders with backslashes
+		var folder []string
+		if row["grouping"] != "" {
+			folder = strings.FieldsFunc(row["grouping"], func(r rune) bool { return r == '\\' || r == '/' })
+		}
+		it := newItem(kindLogin, folder, row["name"])
+		if row["url"] != lastPassNoteURL {
+			it.set("user", row["username"])
+			it.set("password", row["password"])
+			it.set("url", row["url"])
+			it.set("totp", row["totp"])
+			it.notes = row["extra"]
+			items = append(items, it)
+			continue
+		}
+
+		it.kind = kindNote
+		noteType, fields, ok := strings.Cut(row["extra"], "\n")
+		if !ok || !strings.HasPrefix(noteType, "NoteType:") {
+			it.notes = row["extra"]
+			items = append(items, it)
+			continue
+		}
+		switch strings.TrimPrefix(noteType, "NoteType:") {
+		case "Credit Card":
+			it.kind = kindCard
+		case "SSH Key":
+			it.kind = kindSSHKey
+		}
+		// Structured notes are "Name:value" lines, with free text last
+		lines := strings.Split(fields, "\n")
+		for i, line := range lines {
+			name, value, _ := strings.Cut(line, ":")
+			if name == "Notes" {
+				it.notes = strings.Join(append([]string{value}, lines[i+1:]...), "\n")
+				break
+			}
+			if field, ok := lastPassNoteFields[name]; ok && it.kind != kindNote {
+				it.set(field, value)
+			} else {
+				it.addExtra(name, value)
+			}
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// firstOf returns the first non-empty of the named columns
+func firstOf(row map[string]string, columns ...string) string {
+	for _, c := range columns {
+		if row[c] != "" {
+			return row[c]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,333 @@
+// Package importer reads the exports of other password managers and maps
+// their entries onto typed records.
+//
+// Every format is first read into items that carry the usual fields of a
+// password manager entry; items then become login, token, note, card or
+// ssh-key records depending on what they hold. Folders become key prefixes:
+// an entry "GitHub" in the folder "Work/Dev" is imported as "Work/Dev/GitHub".
+// Fields a record type has no place for are kept in its notes.
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/record"
+)
+
+// Format identifies the export format of a password manager
+type Format string
+
+// Supported formats
+const (
+	// FormatKeePass is the KeePass 2.x XML export (also written by KeePassXC)
+	FormatKeePass Format = "keepass"
+	// FormatBitwarden is Bitwarden's unencrypted JSON export
+	FormatBitwarden Format = "bitwarden"
+	// Format1Password is 1Password's CSV export
+	Format1Password Format = "1password"
+	// FormatLastPass is LastPass's CSV export
+	FormatLastPass Format = "lastpass"
+	// FormatPass is a pass (password-store) directory of gpg-encrypted files
+	FormatPass Format = "pass"
+)
+
+// Formats lists the supported formats
+var Formats = []Format{FormatKeePass, FormatBitwarden, Format1Password, FormatLastPass, FormatPass}
+
+// ParseFormat parses a format name
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown import format %q", s)
+}
+
+// Options tune how exports are read
+type Options struct {
+	// GPG is the gpg command that decrypts pass entries. Empty means "gpg".
+	GPG string
+}
+
+// Entry is a record ready to be stored in a vault
+type Entry struct {
+	Key    string
+	Type   record.Type
+	Value  []byte
+	Labels []string
+}
+
+// Result is what Read found in an export
+type Result struct {
+	Entries []Entry
+	// Skipped lists the keys of entries without anything to import
+	Skipped []string
+}
+
+// Read reads the export at path, a file or, for pass, the store directory
+func Read(format Format, path string, opts Options) (*Result, error) {
+	var items []item
+	var err error
+	switch format {
+	case FormatKeePass:
+		items, err = readKeePass(path)
+	case FormatBitwarden:
+		items, err = readBitwarden(path)
+	case Format1Password:
+		items, err = read1Password(path)
+	case FormatLastPass:
+		items, err = readLastPass(path)
+	case FormatPass:
+		items, err = readPass(path, opts)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s export: %w", format, err)
+	}
+	return entries(items)
+}
+
+// kind is the kind of secret an item holds, as far as the format says
+type kind int
+
+const (
+	kindLogin kind = iota
+	kindNote
+	kindCard
+	kindSSHKey
+)
+
+// item is an entry of a password manager export
+type item struct {
+	kind   kind
+	folder []string
+	name   string
+	// fields holds the item's values under record field names (user,
+	// password, url, totp, holder, number, ...)
+	fields map[string]string
+	notes  string
+	// extra holds fields without a record field, in the export's order
+	extra  []extraField
+	labels []string
+}
+
+type extraField struct {
+	name, value string
+}
+
+func newItem(k kind, folder []string, name string) item {
+	return item{kind: k, folder: folder, name: name, fields: make(map[string]string)}
+}
+
+// set assigns a field, ignoring empty values
+func (it *item) set(name, value string) {
+	if value = strings.TrimSpace(value); value != "" {
+		it.fields[name] = value
+	}
+}
+
+// addExtra keeps a field that has no record field
+func (it *item) addExtra(name, value string) {
+	if value = strings.TrimSpace(value); value != "" {
+		it.extra = append(it.extra, extraField{name: strings.TrimSpace(name), value: value})
+	}
+}
+
+// entries maps items onto records under unique keys
+func entries(items []item) (*Result, error) {
+	result := &Result{}
+	used := make(map[string]bool)
+	for _, it := range items {
+		key := uniqueKey(used, itemKey(it))
+		r, err := it.record()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if r == nil {
+			result.Skipped = append(result.Skipped, key)
+			continue
+		}
+		value, err := record.Encode(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		result.Entries = append(result.Entries, Entry{Key: key, Type: r.Type, Value: value, Labels: labels(it.labels)})
+	}
+	return result, nil
+}
+
+// record picks the record type that fits what the item holds, or returns
+// nil for items that hold nothing
+func (it item) record() (*record.Record, error) {
+	f := it.fields
+	switch {
+	case it.kind == kindCard && f["holder"] != "" && f["number"] != "" && f["expiry"] != "":
+		return it.build(record.TypeCard, "holder", "number", "expiry", "cvv", "pin")
+	case it.kind == kindSSHKey && f["private_key"] != "":
+		return it.build(record.TypeSSHKey, "private_key", "public_key", "passphrase")
+	case f["user"] != "" && f["password"] != "":
+		return it.build(record.TypeLogin, "user", "password", "url", "totp")
+	case f["password"] != "":
+		// A password without a user name is a token for the URL's service
+		r, err := it.build(record.TypeToken)
+		if err != nil {
+			return nil, err
+		}
+		r.Fields["token"] = f["password"]
+		if f["url"] != "" {
+			r.Fields["service"] = f["url"]
+		}
+		return r, nil
+	}
+
+	text := it.notesWith(it.fields)
+	if text == "" {
+		return nil, nil
+	}
+	r, err := record.New(record.TypeNote)
+	if err != nil {
+		return nil, err
+	}
+	r.Fields["text"] = text
+	if it.name != "" {
+		r.Fields["title"] = it.name
+	}
+	return r, nil
+}
+
+// fieldLabels names record fields that end up in notes when the record type
+// has no place for them
+var fieldLabels = map[string]string{
+	"user":        "user",
+	"password":    "password",
+	"url":         "url",
+	"totp":        "totp",
+	"holder":      "card holder",
+	"number":      "card number",
+	"expiry":      "expiry",
+	"cvv":         "security code",
+	"pin":         "pin",
+	"private_key": "private key",
+	"public_key":  "public key",
+	"passphrase":  "passphrase",
+}
+
+// build creates a record of type t with the named fields; every other field
+// the item holds goes into the notes
+func (it item) build(t record.Type, names ...string) (*record.Record, error) {
+	r, err := record.New(t)
+	if err != nil {
+		return nil, err
+	}
+	rest := make(map[string]string, len(it.fields))
+	for name, value := range it.fields {
+		rest[name] = value
+	}
+	for _, name := range names {
+		if value, ok := rest[name]; ok {
+			r.Fields[name] = value
+			delete(rest, name)
+		}
+	}
+	if t == record.TypeToken {
+		delete(rest, "password")
+		delete(rest, "url")
+	}
+	if notes := it.notesWith(rest); notes != "" {
+		// SSH keys keep their notes as the key comment
+		field := "notes"
+		if t == record.TypeSSHKey {
+			field = "comment"
+		}
+		r.Fields[field] = notes
+	}
+	return r, nil
+}
+
+// notesWith returns the item's notes followed by "name: value" lines for the
+// given leftover fields and the extra fields
+func (it item) notesWith(rest map[string]string) string {
+	var lines []string
+	if notes := strings.TrimSpace(it.notes); notes != "" {
+		lines = append(lines, notes)
+	}
+	names := make([]string, 0, len(rest))
+	for name := range rest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		label := fieldLabels[name]
+		if label == "" {
+			label = name
+		}
+		lines = append(lines, label+": "+rest[name])
+	}
+	for _, e := range it.extra {
+		lines = append(lines, e.name+": "+e.value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// itemKey joins the item's folders and name into a vault key
+func itemKey(it item) string {
+	var parts []string
+	for _, f := range it.folder {
+		if f = keyPart(f); f != "" {
+			parts = append(parts, f)
+		}
+	}
+	name := keyPart(it.name)
+	if name == "" {
+		name = "untitled"
+	}
+	return strings.Join(append(parts, name), "/")
+}
+
+// keyPart cleans a folder or entry name for use in a key: slashes inside a
+// name would read as folders
+func keyPart(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.ReplaceAll(s, "/", "-")
+}
+
+// uniqueKey returns key, or key-2, key-3, ... if it is already taken
+func uniqueKey(used map[string]bool, key string) string {
+	candidate := key
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d", key, i)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+// labels turns the tags of an export into valid vault labels
+func labels(tags []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, t := range tags {
+		t = strings.Join(strings.Fields(strings.ReplaceAll(t, ",", " ")), "-")
+		if t == "" || seen[t] || dao.ValidateLabel(t) != nil {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
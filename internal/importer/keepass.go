@@ -0,0 +1,87 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keePassFile is the part of the KeePass 2.x XML export that holds entries.
+// Entry history is left out: only the current version of each entry is
+// imported.
+type keePassFile struct {
+	XMLName xml.Name     `xml:"KeePassFile"`
+	Root    keePassGroup `xml:"Root>Group"`
+}
+
+type keePassGroup struct {
+	Name    string         `xml:"Name"`
+	Entries []keePassEntry `xml:"Entry"`
+	Groups  []keePassGroup `xml:"Group"`
+}
+
+type keePassEntry struct {
+	Strings []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"String"`
+	Tags string `xml:"Tags"`
+}
+
+// keePassRecycleBin is the default name of the group KeePass moves deleted
+// entries to
+const keePassRecycleBin = "Recycle Bin"
+
+// keePassFields maps KeePass's standard strings onto record fields
+var keePassFields = map[string]string{
+	"UserName":  "user",
+	"Password":  "password",
+	"URL":       "url",
+	"otp":       "totp",
+	"TOTP Seed": "totp",
+}
+
+func readKeePass(path string) ([]item, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keePassFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("not a KeePass XML export: %w", err)
+	}
+	// The root group is the database itself, not a folder
+	var items []item
+	file.Root.collect(nil, &items)
+	return items, nil
+}
+
+// collect appends the entries of the group and its subgroups
+func (g keePassGroup) collect(folder []string, items *[]item) {
+	for _, e := range g.Entries {
+		it := newItem(kindLogin, folder, "")
+		for _, s := range e.Strings {
+			switch field, ok := keePassFields[s.Key]; {
+			case s.Key == "Title":
+				it.name = s.Value
+			case s.Key == "Notes":
+				it.notes = s.Value
+			case ok:
+				it.set(field, s.Value)
+			default:
+				it.addExtra(s.Key, s.Value)
+			}
+		}
+		if e.Tags != "" {
+			it.labels = strings.FieldsFunc(e.Tags, func(r rune) bool { return r == ';' || r == ',' })
+		}
+		*items = append(*items, it)
+	}
+	for _, sub := range g.Groups {
+		if sub.Name == keePassRecycleBin {
+			continue
+		}
+		sub.collect(append(folder[:len(folder):len(folder)], sub.Name), items)
+	}
+}
@@ -0,0 +1,95 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// passFields maps the "name: value" lines pass users commonly add below the
+// password onto record fields
+var passFields = map[string]string{
+	"user":     "user",
+	"username": "user",
+	"login":    "user",
+	"email":    "user",
+	"url":      "url",
+	"website":  "url",
+}
+
+// readPass decrypts every entry of a password store with gpg. The first line
+// of an entry is its password; "name: value" lines below it are fields, an
+// otpauth:// URI is its TOTP secret and anything else is kept as notes.
+func readPass(dir string, opts Options) ([]item, error) {
+	gpg := opts.GPG
+	if gpg == "" {
+		gpg = "gpg"
+	}
+	var items []item
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Skip the store's git repository and other hidden directories
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".gpg" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, strings.TrimSuffix(path, ".gpg"))
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		plaintext, err := decryptPass(gpg, path)
+		if err != nil {
+			return err
+		}
+		items = append(items, parsePass(parts[:len(parts)-1], parts[len(parts)-1], plaintext))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func decryptPass(gpg, path string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(gpg, "--quiet", "--batch", "--decrypt", path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func parsePass(folder []string, name string, plaintext []byte) item {
+	it := newItem(kindLogin, folder, name)
+	lines := strings.Split(strings.ReplaceAll(string(plaintext), "\r\n", "\n"), "\n")
+	it.set("password", lines[0])
+
+	var notes []string
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "otpauth://") {
+			it.set("totp", line)
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if field, known := passFields[strings.ToLower(strings.TrimSpace(key))]; ok && known && it.fields[field] == "" {
+			it.set(field, value)
+			continue
+		}
+		notes = append(notes, line)
+	}
+	it.notes = strings.Join(notes, "\n")
+	return it
+}
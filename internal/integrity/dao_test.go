@@ -0,0 +1,5 @@
+package integrity_test
+
+// Vault migration 12 is implemented by the dao package; linking it lets the
+// tests bootstrap vaults at the current schema.
+import _ "github.com/n1/n1/internal/dao"
@@ -0,0 +1,52 @@
+package integrity
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Domain separation prefixes prevent a leaf from being passed off as an inner node
+const (
+	leafPrefix  = 0x00
+	innerPrefix = 0x01
+)
+
+// LeafHash hashes a single vault row. The key is length-prefixed so that
+// ("ab", "c") and ("a", "bc") produce different leaves.
+func LeafHash(key string, value []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(key)))
+	h.Write(n[:])
+	h.Write([]byte(key))
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+// MerkleRoot folds leaf hashes pairwise into a single root. An odd node at the
+// end of a level is promoted unchanged. The root of no leaves is SHA-256 of nothing.
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	}
+
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write([]byte{innerPrefix})
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+	return level[0]
+}
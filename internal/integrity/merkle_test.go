@@ -0,0 +1,23 @@
+package integrity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	a := LeafHash("a", []byte("1"))
+	b := LeafHash("b", []byte("2"))
+	c := LeafHash("c", []byte("3"))
+
+	assert.Len(t, MerkleRoot(nil), 32, "Empty tree should still have a root")
+	assert.Equal(t, a, MerkleRoot([][]byte{a}), "Single leaf is its own root")
+	assert.NotEqual(t, MerkleRoot([][]byte{a, b}), MerkleRoot([][]byte{b, a}), "Order matters")
+	assert.NotEqual(t, MerkleRoot([][]byte{a, b}), MerkleRoot([][]byte{a, b, c}), "Extra leaf changes root")
+	assert.Equal(t, MerkleRoot([][]byte{a, b, c}), MerkleRoot([][]byte{a, b, c}), "Root is deterministic")
+}
+
+func TestLeafHashIsUnambiguous(t *testing.T) {
+	assert.NotEqual(t, LeafHash("ab", []byte("c")), LeafHash("a", []byte("bc")))
+}
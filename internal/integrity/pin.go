@@ -0,0 +1,92 @@
+package integrity
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/n1/n1/internal/secretstore"
+)
+
+// pinSuffix is appended to the vault path to name the pinned counter in the secret store
+const pinSuffix = "#rollback-counter"
+
+var (
+	// ErrRollback is returned when a vault's seal is older than the pinned counter
+	ErrRollback = errors.New("vault has been rolled back")
+)
+
+// Pinned returns the rollback counter pinned for a vault and whether pinning
+// is enabled. Only a missing pin means it is not; a secret store that cannot
+// be read or a pin that does not parse is an error, never a silent opt-out.
+func Pinned(store secretstore.Store, vaultPath string) (int64, bool, error) {
+	data, err := store.Get(vaultPath + pinSuffix)
+	if errors.Is(err, secretstore.ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read pinned rollback counter: %w", err)
+	}
+	counter, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("pinned rollback counter %q is unreadable: %w", data, err)
+	}
+	return counter, true, nil
+}
+
+// Pin records counter as the lowest acceptable seal counter for the vault.
+// The pin never moves backwards.
+func Pin(store secretstore.Store, vaultPath string, counter int64) error {
+	pinned, ok, err := Pinned(store, vaultPath)
+	if err != nil {
+		return err
+	}
+	if ok && pinned >= counter {
+		return nil
+	}
+	if err := store.Put(vaultPath+pinSuffix, []byte(strconv.FormatInt(counter, 10))); err != nil {
+		return fmt.Errorf("failed to pin rollback counter: %w", err)
+	}
+	return nil
+}
+
+// Repin moves the pinned counter from one value to another, backwards too,
+// for a deliberate rollback such as restoring a backup. The pin is
+// overwritten in place, never removed, and only if it still holds from, so
+// a pin advanced in the meantime is kept.
+func Repin(store secretstore.Store, vaultPath string, from, to int64) error {
+	pinned, ok, err := Pinned(store, vaultPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no rollback counter is pinned for %s", vaultPath)
+	}
+	if pinned != from {
+		return fmt.Errorf("pinned rollback counter is %d, not %d", pinned, from)
+	}
+	if err := store.Put(vaultPath+pinSuffix, []byte(strconv.FormatInt(to, 10))); err != nil {
+		return fmt.Errorf("failed to move pinned rollback counter: %w", err)
+	}
+	return nil
+}
+
+// Unpin disables rollback detection for the vault
+func Unpin(store secretstore.Store, vaultPath string) error {
+	return store.Delete(vaultPath + pinSuffix)
+}
+
+// CheckRollback compares a verified seal against the pinned counter, if any
+func CheckRollback(store secretstore.Store, vaultPath string, s *Seal) error {
+	pinned, ok, err := Pinned(store, vaultPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if s.Counter < pinned {
+		return fmt.Errorf("%w: seal counter %d is below pinned counter %d", ErrRollback, s.Counter, pinned)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package integrity
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/n1/n1/internal/secretstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memStore map[string][]byte
+
+func (m memStore) Put(n string, d []byte) error { m[n] = d; return nil }
+
+func (m memStore) Get(n string) ([]byte, error) {
+	d, ok := m[n]
+	if !ok {
+		return nil, secretstore.ErrNotFound
+	}
+	return d, nil
+}
+
+func (m memStore) Delete(n string) error { delete(m, n); return nil }
+
+func TestRollbackCounter(t *testing.T) {
+	store := memStore{}
+	const path = "/vaults/test.db"
+
+	// Without a pin every seal is accepted
+	_, enabled, err := Pinned(store, path)
+	require.NoError(t, err)
+	assert.False(t, enabled)
+	assert.NoError(t, CheckRollback(store, path, &Seal{Counter: 1}))
+
+	require.NoError(t, Pin(store, path, 5))
+	assert.NoError(t, CheckRollback(store, path, &Seal{Counter: 5}))
+	assert.NoError(t, CheckRollback(store, path, &Seal{Counter: 7}), "Newer vaults are fine")
+	assert.ErrorIs(t, CheckRollback(store, path, &Seal{Counter: 4}), ErrRollback)
+
+	// The pin never moves backwards
+	require.NoError(t, Pin(store, path, 3))
+	pinned, _, err := Pinned(store, path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), pinned)
+
+	require.NoError(t, Unpin(store, path))
+	assert.NoError(t, CheckRollback(store, path, &Seal{Counter: 1}))
+}
+
+// failingStore rejects every write, like a locked keychain
+type failingStore struct{ memStore }
+
+func (failingStore) Put(string, []byte) error { return errors.New("keychain is locked") }
+
+func TestRepin(t *testing.T) {
+	store := memStore{}
+	const path = "/vaults/test.db"
+	assert.Error(t, Repin(store, path, 5, 3), "Nothing to move without a pin")
+
+	require.NoError(t, Pin(store, path, 5))
+	assert.Error(t, Repin(store, path, 4, 3), "A pin that moved in the meantime is kept")
+	require.NoError(t, Repin(store, path, 5, 3))
+	pinned, _, err := Pinned(store, path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), pinned)
+
+	// A failed move leaves the old pin in place rather than none
+	failing := failingStore{store}
+	assert.Error(t, Repin(failing, path, 3, 1))
+	pinned, enabled, err := Pinned(failing, path)
+	require.NoError(t, err)
+	assert.True(t, enabled)
+	assert.Equal(t, int64(3), pinned)
+}
+
+// lockedStore cannot be read, like a keychain the user has not unlocked
+type lockedStore struct{ memStore }
+
+func (lockedStore) Get(string) ([]byte, error) { return nil, errors.New("keychain is locked") }
+
+// TestPinnedErrors verifies that a pin that cannot be read never turns
+// rollback detection off
+func TestPinnedErrors(t *testing.T) {
+	const path = "/vaults/test.db"
+	locked := lockedStore{memStore{}}
+	_, _, err := Pinned(locked, path)
+	assert.Error(t, err)
+	assert.Error(t, CheckRollback(locked, path, &Seal{Counter: 1}))
+	assert.Error(t, Pin(locked, path, 1))
+
+	corrupt := memStore{path + pinSuffix: []byte("five")}
+	_, _, err = Pinned(corrupt, path)
+	assert.Error(t, err)
+	assert.Error(t, CheckRollback(corrupt, path, &Seal{Counter: 1}))
+	assert.Error(t, Repin(corrupt, path, 5, 1))
+}
@@ -0,0 +1,295 @@
+package integrity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/n1/n1/internal/crypto"
+)
+
+// macContext is the HKDF context for the seal MAC key, keeping it independent
+// of the master key used for record encryption
+const macContext = "n1/integrity/seal/v1"
+
+// metaName is the vault_meta row holding the current seal
+const metaName = "integrity.seal"
+
+var (
+	// ErrUnsealed is returned when a vault has no seal yet
+	ErrUnsealed = errors.New("vault has no integrity seal")
+	// ErrTampered is returned when the vault contents do not match the seal
+	ErrTampered = errors.New("vault contents do not match integrity seal")
+	// ErrPreSeal is returned by CheckUnsealed for a vault written before
+	// seals existed, which the migration seeding its event log seals
+	ErrPreSeal = errors.New("vault predates integrity seals")
+)
+
+// Querier is the subset of *sql.DB and *sql.Tx needed to compute and store seals
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Seal authenticates the complete state of a vault: the Merkle root over all
+// vault rows plus the head of the event log, MAC'd with a key derived from the
+// master key. Counter is the event sequence number and only ever grows, which
+// makes it usable as a rollback counter.
+type Seal struct {
+	Counter   int64  `json:"counter"`
+	EventHash []byte `json:"event_hash"`
+	Root      []byte `json:"root"`
+	MAC       []byte `json:"mac"`
+}
+
+// Compute derives the seal for the current contents of the vault
+func Compute(q Querier, masterKey []byte) (*Seal, error) {
+	s := &Seal{}
+	err := q.QueryRow("SELECT seq, hash FROM events ORDER BY seq DESC LIMIT 1").Scan(&s.Counter, &s.EventHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.Counter, s.EventHash = 0, make([]byte, sha256.Size)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read event log head: %w", err)
+	}
+
+	root, err := vaultRoot(q)
+	if err != nil {
+		return nil, err
+	}
+	s.Root = root
+
+	mac, err := s.mac(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	s.MAC = mac
+	return s, nil
+}
+
+// Update recomputes the seal and stores it in vault_meta
+func Update(q Querier, masterKey []byte) (*Seal, error) {
+	s, err := Compute(q, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode seal: %w", err)
+	}
+	_, err = q.Exec(
+		`INSERT INTO vault_meta (name, value) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET value = excluded.value`,
+		metaName, data,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store seal: %w", err)
+	}
+	return s, nil
+}
+
+// Load reads the stored seal without checking it. Vaults from before the
+// vault_meta table existed have no seal.
+func Load(q Querier) (*Seal, error) {
+	exists, err := tableExists(q, "vault_meta")
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrUnsealed
+	}
+	var data []byte
+	err = q.QueryRow("SELECT value FROM vault_meta WHERE name = ?", metaName).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnsealed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seal: %w", err)
+	}
+	var s Seal
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%w: seal is unreadable: %v", ErrTampered, err)
+	}
+	return &s, nil
+}
+
+// Verify checks the stored seal's MAC and that it matches the vault contents.
+// It returns the verified seal.
+func Verify(q Querier, masterKey []byte) (*Seal, error) {
+	stored, err := loadAuthentic(q, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	current, err := Compute(q, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := stored.compareHead(current); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(current.Root, stored.Root) {
+		return nil, fmt.Errorf("%w: vault records differ from sealed Merkle root", ErrTampered)
+	}
+	return stored, nil
+}
+
+// VerifyEventHead checks the stored seal's MAC and that the event log head still
+// matches it, ignoring the vault table. Used before rebuilding the vault table
+// from the log, so that a truncated log cannot be replayed and resealed.
+// Vaults without a seal pass only if CheckUnsealed allows it.
+func VerifyEventHead(q Querier, masterKey []byte) error {
+	stored, err := loadAuthentic(q, masterKey)
+	if errors.Is(err, ErrUnsealed) {
+		return CheckUnsealed(q)
+	}
+	if err != nil {
+		return err
+	}
+	current, err := Compute(q, masterKey)
+	if err != nil {
+		return err
+	}
+	return stored.compareHead(current)
+}
+
+// CheckUnsealed decides whether a vault without a seal may be sealed as it
+// is. Only a vault that has never been written qualifies. Every write
+// appends an event and seals the vault, so a vault with events but no seal
+// has had its seal stripped. Records without any events are a vault from
+// before the event log, which is ErrPreSeal: only the migration seeding its
+// log may seal it, and until then nothing vouches for its contents.
+func CheckUnsealed(q Querier) error {
+	var events, records int64
+	logged, err := tableExists(q, "events")
+	if err != nil {
+		return err
+	}
+	if logged {
+		if err := q.QueryRow("SELECT COUNT(*) FROM events").Scan(&events); err != nil {
+			return fmt.Errorf("failed to inspect unsealed vault: %w", err)
+		}
+	}
+	if err := q.QueryRow("SELECT COUNT(*) FROM vault").Scan(&records); err != nil {
+		return fmt.Errorf("failed to inspect unsealed vault: %w", err)
+	}
+	switch {
+	case events > 0:
+		return fmt.Errorf("%w: seal is missing from a vault with %d events and %d records", ErrTampered, events, records)
+	case records > 0:
+		return fmt.Errorf("%w: %d records have no event log yet", ErrPreSeal, records)
+	}
+	return nil
+}
+
+// Authenticate checks that masterKey is the key of the vault. The seal must
+// verify under it; a vault without one must pass CheckUnsealed. Nothing
+// vouches for a vault from before seals, so its first record must decrypt
+// instead. It returns the verified seal, or nil for unsealed vaults.
+func Authenticate(q Querier, masterKey []byte) (*Seal, error) {
+	seal, err := Verify(q, masterKey)
+	if !errors.Is(err, ErrUnsealed) {
+		return seal, err
+	}
+	if err := CheckUnsealed(q); !errors.Is(err, ErrPreSeal) {
+		return nil, err
+	}
+	var ciphertext []byte
+	if err := q.QueryRow("SELECT value FROM vault ORDER BY id LIMIT 1").Scan(&ciphertext); err != nil {
+		return nil, fmt.Errorf("failed to read a record: %w", err)
+	}
+	if _, err := crypto.DecryptBlob(masterKey, ciphertext); err != nil {
+		return nil, fmt.Errorf("failed to decrypt a record: %w", err)
+	}
+	return nil, nil
+}
+
+// SealedWith reports whether the stored seal was MAC'd under masterKey,
+// without checking it against the vault contents. Vaults without a seal
+// return ErrUnsealed.
+func SealedWith(q Querier, masterKey []byte) (bool, error) {
+	_, err := loadAuthentic(q, masterKey)
+	if errors.Is(err, ErrTampered) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// loadAuthentic loads the stored seal and checks its MAC
+func loadAuthentic(q Querier, masterKey []byte) (*Seal, error) {
+	stored, err := Load(q)
+	if err != nil {
+		return nil, err
+	}
+	expectedMAC, err := stored.mac(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(stored.MAC, expectedMAC) {
+		return nil, fmt.Errorf("%w: seal MAC is invalid (wrong key or forged seal)", ErrTampered)
+	}
+	return stored, nil
+}
+
+// compareHead checks that current covers the same event log head as s
+func (s *Seal) compareHead(current *Seal) error {
+	if current.Counter != s.Counter {
+		return fmt.Errorf("%w: event log is at %d but seal covers %d", ErrTampered, current.Counter, s.Counter)
+	}
+	if !hmac.Equal(current.EventHash, s.EventHash) {
+		return fmt.Errorf("%w: event log head differs from sealed head", ErrTampered)
+	}
+	return nil
+}
+
+// mac authenticates the seal fields with a key derived from the master key
+func (s *Seal) mac(masterKey []byte) ([]byte, error) {
+	macKey, err := crypto.DeriveHKDF(masterKey, macContext, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive seal key: %w", err)
+	}
+	m := hmac.New(sha256.New, macKey)
+	m.Write([]byte(macContext))
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(s.Counter))
+	m.Write(counter[:])
+	m.Write(s.EventHash)
+	m.Write(s.Root)
+	return m.Sum(nil), nil
+}
+
+// tableExists reports whether the vault has a table called name
+func tableExists(q Querier, name string) (bool, error) {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", name).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect vault schema: %w", err)
+	}
+	return exists, nil
+}
+
+// vaultRoot computes the Merkle root over all vault rows in key order
+func vaultRoot(q Querier) ([]byte, error) {
+	rows, err := q.Query("SELECT key, value FROM vault ORDER BY key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault rows: %w", err)
+	}
+	defer rows.Close()
+
+	var leaves [][]byte
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan vault row: %w", err)
+		}
+		leaves = append(leaves, LeafHash(key, value))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating vault rows: %w", err)
+	}
+	return MerkleRoot(leaves), nil
+}
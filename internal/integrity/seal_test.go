@@ -0,0 +1,181 @@
+package integrity
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSealedDB(t *testing.T) (*sql.DB, []byte) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "integrity_test.db"))
+	require.NoError(t, err, "Opening database failed")
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, migrations.BootstrapVault(db), "Bootstrapping vault failed")
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err, "Failed to generate key")
+
+	_, err = db.Exec(`INSERT INTO vault (key, value) VALUES ('a', x'01'), ('b', x'02'), ('c', x'03')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO events (seq, type, payload, prev_hash, hash, created_at)
+		VALUES (1, 'put', x'00', x'00', x'aa', CURRENT_TIMESTAMP)`)
+	require.NoError(t, err)
+
+	_, err = Update(db, key)
+	require.NoError(t, err, "Sealing failed")
+	return db, key
+}
+
+func TestVerifyUntouchedVault(t *testing.T) {
+	db, key := setupSealedDB(t)
+
+	seal, err := Verify(db, key)
+	require.NoError(t, err, "Untouched vault should verify")
+	assert.Equal(t, int64(1), seal.Counter)
+}
+
+func TestVerifyUnsealedVault(t *testing.T) {
+	db, key := setupSealedDB(t)
+	_, err := db.Exec("DELETE FROM vault_meta")
+	require.NoError(t, err)
+
+	_, err = Verify(db, key)
+	assert.ErrorIs(t, err, ErrUnsealed)
+	assert.ErrorIs(t, CheckUnsealed(db), ErrTampered, "A written vault without a seal had it stripped")
+	assert.ErrorIs(t, VerifyEventHead(db, key), ErrTampered, "A vault without its seal may not be replayed")
+}
+
+func TestCheckUnsealedNewVault(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "integrity_test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, migrations.BootstrapVault(db))
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+
+	// A vault that has never been written has nothing to vouch for yet
+	assert.NoError(t, CheckUnsealed(db))
+	assert.NoError(t, VerifyEventHead(db, key))
+
+	_, err = db.Exec(`INSERT INTO events (seq, type, payload, prev_hash, hash, created_at)
+		VALUES (1, 'put', x'00', x'00', x'aa', CURRENT_TIMESTAMP)`)
+	require.NoError(t, err)
+	assert.ErrorIs(t, CheckUnsealed(db), ErrTampered)
+}
+
+func TestAuthenticatePreSealVault(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "integrity_test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	// The schema of vaults written before events and seals existed
+	_, err = db.Exec(`CREATE TABLE vault (id INTEGER PRIMARY KEY AUTOINCREMENT, key TEXT NOT NULL, value BLOB NOT NULL)`)
+	require.NoError(t, err)
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+
+	_, err = Verify(db, key)
+	assert.ErrorIs(t, err, ErrUnsealed, "A vault without vault_meta has no seal")
+	seal, err := Authenticate(db, key)
+	assert.NoError(t, err, "An empty vault opens with any key")
+	assert.Nil(t, seal)
+
+	ciphertext, err := crypto.EncryptBlob(key, []byte("legacy"))
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO vault (key, value) VALUES ('a', ?)", ciphertext)
+	require.NoError(t, err)
+	assert.ErrorIs(t, CheckUnsealed(db), ErrPreSeal)
+	_, err = Authenticate(db, key)
+	assert.NoError(t, err)
+
+	other, err := crypto.Generate(32)
+	require.NoError(t, err)
+	_, err = Authenticate(db, other)
+	assert.Error(t, err)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	testCases := []struct {
+		name   string
+		tamper string
+	}{
+		{name: "Row deleted", tamper: "DELETE FROM vault WHERE key = 'b'"},
+		{name: "Row added", tamper: "INSERT INTO vault (key, value) VALUES ('d', x'04')"},
+		{name: "Value swapped", tamper: "UPDATE vault SET value = x'03' WHERE key = 'a'"},
+		{name: "Row renamed", tamper: "UPDATE vault SET key = 'z' WHERE key = 'a'"},
+		{name: "Event appended", tamper: `INSERT INTO events (seq, type, payload, prev_hash, hash, created_at)
+			VALUES (2, 'put', x'00', x'aa', x'bb', CURRENT_TIMESTAMP)`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, key := setupSealedDB(t)
+			_, err := db.Exec(tc.tamper)
+			require.NoError(t, err)
+
+			_, err = Verify(db, key)
+			assert.ErrorIs(t, err, ErrTampered)
+		})
+	}
+}
+
+func TestVerifyEventHeadIgnoresVaultTable(t *testing.T) {
+	db, key := setupSealedDB(t)
+	_, err := db.Exec("DELETE FROM vault")
+	require.NoError(t, err)
+	assert.NoError(t, VerifyEventHead(db, key), "A damaged projection can be rebuilt from an intact log")
+
+	_, err = db.Exec(`INSERT INTO events (seq, type, payload, prev_hash, hash, created_at)
+		VALUES (2, 'put', x'00', x'aa', x'bb', CURRENT_TIMESTAMP)`)
+	require.NoError(t, err)
+	assert.ErrorIs(t, VerifyEventHead(db, key), ErrTampered)
+}
+
+func TestVerifyWithWrongKey(t *testing.T) {
+	db, _ := setupSealedDB(t)
+	wrongKey, err := crypto.Generate(32)
+	require.NoError(t, err)
+
+	_, err = Verify(db, wrongKey)
+	assert.ErrorIs(t, err, ErrTampered)
+}
+
+func TestSealedWith(t *testing.T) {
+	db, key := setupSealedDB(t)
+	otherKey, err := crypto.Generate(32)
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM vault WHERE key = 'b'")
+	require.NoError(t, err)
+
+	sealed, err := SealedWith(db, key)
+	require.NoError(t, err)
+	assert.True(t, sealed, "SealedWith checks the MAC, not the contents")
+	sealed, err = SealedWith(db, otherKey)
+	require.NoError(t, err)
+	assert.False(t, sealed)
+
+	_, err = db.Exec("DELETE FROM vault_meta")
+	require.NoError(t, err)
+	_, err = SealedWith(db, key)
+	assert.ErrorIs(t, err, ErrUnsealed)
+}
+
+func TestForgedSealIsRejected(t *testing.T) {
+	db, key := setupSealedDB(t)
+
+	// An attacker without the master key can recompute the root but not the MAC
+	attackerKey, err := crypto.Generate(32)
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM vault WHERE key = 'b'")
+	require.NoError(t, err)
+	_, err = Update(db, attackerKey)
+	require.NoError(t, err)
+
+	_, err = Verify(db, key)
+	assert.ErrorIs(t, err, ErrTampered)
+}
@@ -0,0 +1,104 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// FormatVersion is the newest vault format this version of n1 can read.
+// Migrations that add tables or columns older binaries can safely ignore
+// leave it alone; a migration that changes how existing data must be read
+// (e.g. a new ciphertext layout) bumps it, and records the new value in
+// vault_meta under FormatMetaKey.
+const FormatVersion = 2
+
+// FormatMetaKey names the vault_meta entry holding the vault's format version
+const FormatMetaKey = "format.version"
+
+// ErrNewerSchema is returned for vaults written by a newer version of n1
+var ErrNewerSchema = errors.New("vault was written by a newer version of n1")
+
+// Compatibility compares a database's schema with what the runner knows
+type Compatibility struct {
+	// Version is the highest migration applied to the database
+	Version int
+	// Latest is the highest migration known to the runner
+	Latest int
+	// Pending is the number of known migrations not yet applied
+	Pending int
+	// Format is the format version recorded in the database, or 0
+	Format int
+}
+
+// Newer reports whether the database has migrations the runner does not know
+func (c Compatibility) Newer() bool {
+	return c.Version > c.Latest
+}
+
+// Readable reports whether this version of n1 understands the data format.
+// A newer but readable vault may be read, but must not be written: its
+// newer schema may carry invariants this version would violate.
+func (c Compatibility) Readable() bool {
+	return c.Format <= FormatVersion
+}
+
+// Err explains why the database cannot be written (or read, if it is not
+// Readable) by this version of n1, or returns nil
+func (c Compatibility) Err() error {
+	if !c.Readable() {
+		return fmt.Errorf("%w: it uses format version %d but this version of n1 reads up to %d; upgrade n1 to open it",
+			ErrNewerSchema, c.Format, FormatVersion)
+	}
+	if c.Newer() {
+		return fmt.Errorf("%w: its schema is at version %d but this version of n1 knows up to %d; upgrade n1 to write to it",
+			ErrNewerSchema, c.Version, c.Latest)
+	}
+	return nil
+}
+
+// Compatibility inspects the database with plain reads, as Status does, so it
+// neither changes a newer vault's schema before refusing it nor fails on a
+// read-only handle. Missing migrations tables count as nothing applied.
+func (r *Runner) Compatibility() (Compatibility, error) {
+	statuses, err := r.Status()
+	if err != nil {
+		return Compatibility{}, err
+	}
+	c := Compatibility{Latest: r.Latest()}
+	for _, s := range statuses {
+		if s.Applied && s.Version > c.Version {
+			c.Version = s.Version
+		}
+		if !s.Applied && !s.Unknown {
+			c.Pending++
+		}
+	}
+	if c.Format, err = formatVersion(r.db); err != nil {
+		return Compatibility{}, err
+	}
+	return c, nil
+}
+
+// formatVersion reads the format version recorded in vault_meta, if any
+func formatVersion(db *sql.DB) (int, error) {
+	var hasMeta bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'vault_meta')").Scan(&hasMeta)
+	if err != nil || !hasMeta {
+		return 0, err
+	}
+	var value []byte
+	err = db.QueryRow("SELECT value FROM vault_meta WHERE name = ?", FormatMetaKey).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read vault format version: %w", err)
+	}
+	format, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid vault format version %q: %w", value, err)
+	}
+	return format, nil
+}
@@ -0,0 +1,206 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/n1/n1/internal/crypto"
+)
+
+// ErrKeyRequired is returned by data migrations that need the master key
+// when the runner was not given one
+var ErrKeyRequired = errors.New("migration requires the vault master key")
+
+// Step performs one batch of a data migration inside ctx.Tx. It is called
+// with the cursor returned by the previous batch ("" for the first) and
+// returns the cursor to resume from, or done once the migration is complete.
+//
+// Each batch is committed together with its cursor, so an interrupted
+// migration resumes after the last committed batch instead of starting over.
+// Steps should keep batches small enough to hold one transaction.
+type Step func(ctx *Context, cursor string) (next string, done bool, err error)
+
+// Context gives a data migration step its transaction and access to the
+// vault's master key
+type Context struct {
+	Tx *sql.Tx
+	// Key is the master key, or nil if the runner was not given one
+	Key []byte
+
+	progress func(done, total int64)
+}
+
+// RequireKey returns ErrKeyRequired if no master key is available
+func (c *Context) RequireKey() error {
+	if c.Key == nil {
+		return ErrKeyRequired
+	}
+	return nil
+}
+
+// Encrypt encrypts plaintext with the master key
+func (c *Context) Encrypt(plaintext []byte) ([]byte, error) {
+	if err := c.RequireKey(); err != nil {
+		return nil, err
+	}
+	return crypto.EncryptBlob(c.Key, plaintext)
+}
+
+// Decrypt decrypts ciphertext with the master key
+func (c *Context) Decrypt(ciphertext []byte) ([]byte, error) {
+	if err := c.RequireKey(); err != nil {
+		return nil, err
+	}
+	return crypto.DecryptBlob(c.Key, ciphertext)
+}
+
+// Progress reports how much of the migration is complete
+func (c *Context) Progress(done, total int64) {
+	if c.progress != nil {
+		c.progress(done, total)
+	}
+}
+
+// ProgressFunc receives progress reports from data migrations
+type ProgressFunc func(m Migration, done, total int64)
+
+// AddDataMigration adds a migration implemented in Go. down may be nil, in
+// which case the migration cannot be rolled back.
+func (r *Runner) AddDataMigration(version int, description string, up, down Step) {
+	r.migrations = append(r.migrations, DataMigration(version, description, up, down))
+}
+
+// DataMigration builds a migration implemented in Go
+func DataMigration(version int, description string, up, down Step) Migration {
+	return Migration{
+		Version:     version,
+		Description: description,
+		Step:        up,
+		DownStep:    down,
+	}
+}
+
+// WithKey gives data migrations access to the master key
+func (r *Runner) WithKey(key []byte) *Runner {
+	r.key = key
+	return r
+}
+
+// OnProgress registers a function receiving progress reports from data
+// migrations
+func (r *Runner) OnProgress(fn ProgressFunc) *Runner {
+	r.progress = fn
+	return r
+}
+
+// ensureProgressTable creates the table holding the cursors of interrupted
+// data migrations
+func (r *Runner) ensureProgressTable() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS _migration_progress (
+			version INTEGER PRIMARY KEY,
+			cursor TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	return err
+}
+
+// cursors returns the resume points of interrupted data migrations by
+// version; there are none before the progress table exists
+func (r *Runner) cursors() (map[int]string, error) {
+	columns, err := r.columns("_migration_progress")
+	if err != nil {
+		return nil, err
+	}
+	cursors := make(map[int]string)
+	if !columns["cursor"] {
+		return cursors, nil
+	}
+	rows, err := r.db.Query("SELECT version, cursor FROM _migration_progress")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var cursor string
+		if err := rows.Scan(&version, &cursor); err != nil {
+			return nil, err
+		}
+		cursors[version] = cursor
+	}
+	return cursors, rows.Err()
+}
+
+// runSteps calls step batch by batch, committing the cursor after each one,
+// and calls finish in the transaction of the last batch
+func (r *Runner) runSteps(migration Migration, step Step, finish func(tx *sql.Tx) error) error {
+	cursors, err := r.cursors()
+	if err != nil {
+		return fmt.Errorf("failed to read progress of migration %d: %w", migration.Version, err)
+	}
+	cursor := cursors[migration.Version]
+
+	for {
+		result, err := r.runStep(migration, step, cursor, finish)
+		if err != nil {
+			return err
+		}
+		if result.finished {
+			return nil
+		}
+		cursor = result.cursor
+	}
+}
+
+type stepResult struct {
+	cursor   string
+	finished bool
+}
+
+func (r *Runner) runStep(migration Migration, step Step, cursor string, finish func(tx *sql.Tx) error) (stepResult, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return stepResult{}, fmt.Errorf("failed to begin transaction for migration %d: %w", migration.Version, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	ctx := &Context{Tx: tx, Key: r.key}
+	if r.progress != nil {
+		ctx.progress = func(done, total int64) { r.progress(migration, done, total) }
+	}
+	next, finished, err := step(ctx, cursor)
+	if err != nil {
+		return stepResult{}, fmt.Errorf("failed to execute migration %d: %w", migration.Version, err)
+	}
+
+	if finished {
+		if _, err := tx.Exec("DELETE FROM _migration_progress WHERE version = ?", migration.Version); err != nil {
+			return stepResult{}, fmt.Errorf("failed to clear progress of migration %d: %w", migration.Version, err)
+		}
+		if err := finish(tx); err != nil {
+			return stepResult{}, err
+		}
+	} else {
+		if next == cursor {
+			return stepResult{}, fmt.Errorf("migration %d made no progress at cursor %q", migration.Version, cursor)
+		}
+		_, err := tx.Exec(
+			`INSERT INTO _migration_progress (version, cursor, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT(version) DO UPDATE SET cursor = excluded.cursor, updated_at = excluded.updated_at`,
+			migration.Version, next, time.Now().UTC(),
+		)
+		if err != nil {
+			return stepResult{}, fmt.Errorf("failed to record progress of migration %d: %w", migration.Version, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return stepResult{}, fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+	}
+	return stepResult{cursor: next, finished: finished}, nil
+}
@@ -0,0 +1,91 @@
+package migrations
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// migrationFile matches NNNN_name.up.sql and NNNN_name.down.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// LoadFS reads SQL migrations from the numbered files in dir of fsys.
+// NNNN_name.up.sql holds a migration and must start with a "-- " comment
+// line giving its description; NNNN_name.down.sql, if present, reverts it.
+// Other files are ignored. Migrations are returned sorted by version.
+func LoadFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory %s: %w", dir, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	names := make(map[int]string)
+	downs := make(map[int]string)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: %s has an invalid version", ErrInvalidMigrations, entry.Name())
+		}
+		if name, ok := names[version]; ok && name != match[2] {
+			return nil, fmt.Errorf("%w: version %d is used by both %s and %s", ErrInvalidMigrations, version, name, match[2])
+		}
+		names[version] = match[2]
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		if match[3] == "down" {
+			downs[version] = string(content)
+			continue
+		}
+		description, ok := descriptionOf(string(content))
+		if !ok {
+			return nil, fmt.Errorf("%w: %s must start with a \"-- description\" line", ErrInvalidMigrations, entry.Name())
+		}
+		byVersion[version] = &Migration{Version: version, Description: description, SQL: string(content)}
+	}
+
+	for version, down := range downs {
+		m, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: down migration %d has no up migration", ErrInvalidMigrations, version)
+		}
+		m.Down = down
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sortMigrations(migrations)
+	return migrations, nil
+}
+
+// descriptionOf returns the text of the leading "-- " comment line
+func descriptionOf(sql string) (string, bool) {
+	first, _, _ := strings.Cut(strings.TrimLeft(sql, "\n"), "\n")
+	description, ok := strings.CutPrefix(strings.TrimSpace(first), "--")
+	description = strings.TrimSpace(description)
+	return description, ok && description != ""
+}
+
+// normaliseSQL strips comment lines, whitespace differences and a trailing
+// semicolon, so that only changes to the statements themselves count
+func normaliseSQL(sql string) string {
+	var lines []string
+	for _, line := range strings.Split(sql, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	normalised := strings.Join(strings.Fields(strings.Join(lines, "\n")), " ")
+	return strings.TrimRight(normalised, "; ")
+}
@@ -1,22 +1,95 @@
 package migrations
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
+var (
+	// ErrChecksumMismatch is returned when the SQL of an applied migration has
+	// changed since it was applied
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+	// ErrIrreversible is returned when rolling back a migration without down SQL
+	ErrIrreversible = errors.New("migration cannot be rolled back")
+	// ErrUnknownVersion is returned when migrating to a version that does not exist
+	ErrUnknownVersion = errors.New("unknown migration version")
+	// ErrInvalidMigrations is returned when migrations are malformed, or their
+	// versions are duplicated or not contiguous from 1
+	ErrInvalidMigrations = errors.New("invalid migrations")
+	// ErrUnregistered is returned when migrating would have to pass a
+	// reserved migration whose implementation is not linked into this binary
+	ErrUnregistered = errors.New("migration is not available in this build of n1")
+)
+
 // Migration represents a single database migration
 type Migration struct {
 	Version     int
 	Description string
 	SQL         string
+	// Down reverts SQL. Migrations without it cannot be rolled back.
+	Down string
+	// Step and DownStep implement data migrations in Go instead of SQL
+	Step     Step
+	DownStep Step
+}
+
+// Checksum identifies the migration's SQL. Comments and whitespace are
+// normalised away so that documenting or re-indenting a migration does not
+// count as a change. Data migrations are identified by their description,
+// since their code cannot be hashed.
+func (m Migration) Checksum() string {
+	source := normaliseSQL(m.SQL)
+	if m.Step != nil {
+		source = "data: " + m.Description
+	}
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// Reversible reports whether the migration can be rolled back
+func (m Migration) Reversible() bool {
+	if m.Step != nil {
+		return m.DownStep != nil
+	}
+	return strings.TrimSpace(m.Down) != ""
+}
+
+// Status describes a migration known to the runner or recorded in the database
+type Status struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+	// Checksum of the migration's current SQL; empty for unknown migrations
+	Checksum string
+	// StoredChecksum is the checksum recorded when the migration was applied
+	StoredChecksum string
+	// Drift is set when an applied migration's SQL has changed since
+	Drift bool
+	// Unknown is set for migrations recorded in the database but not known
+	// to the runner, e.g. applied by a newer version of n1
+	Unknown bool
+	// Reserved is set for migrations implemented by a package that is not
+	// linked into this binary; the runner never applies them
+	Reserved   bool
+	Reversible bool
+	// Cursor is the resume point of an interrupted data migration
+	Cursor string
 }
 
 // Runner manages database migrations
 type Runner struct {
 	db         *sql.DB
 	migrations []Migration
+	reserved   map[int]string
+	key        []byte
+	progress   ProgressFunc
 }
 
 // NewRunner creates a new migrations runner
@@ -29,102 +102,445 @@ func NewRunner(db *sql.DB) *Runner {
 
 // AddMigration adds a migration to the runner
 func (r *Runner) AddMigration(version int, description, sql string) {
+	r.AddReversibleMigration(version, description, sql, "")
+}
+
+// AddReversibleMigration adds a migration with down SQL that reverts it
+func (r *Runner) AddReversibleMigration(version int, description, up, down string) {
 	r.migrations = append(r.migrations, Migration{
 		Version:     version,
 		Description: description,
-		SQL:         sql,
+		SQL:         up,
+		Down:        down,
 	})
 }
 
-// ensureMigrationsTable creates the migrations table if it doesn't exist
+// AddMigrations adds already built migrations to the runner
+func (r *Runner) AddMigrations(migrations ...Migration) {
+	r.migrations = append(r.migrations, migrations...)
+}
+
+// Reserve accounts for a version whose migration is implemented elsewhere
+// and not available to this runner. Reserved migrations only keep the gap
+// check from failing and are listed by Status; they are never run, and
+// MigrateTo refuses to migrate past one that has not been applied.
+func (r *Runner) Reserve(version int, description string) {
+	if r.reserved == nil {
+		r.reserved = make(map[int]string)
+	}
+	r.reserved[version] = description
+}
+
+// validate checks that versions are unique and contiguous from 1, so that a
+// missing or misnumbered migration file is caught before anything runs
+func (r *Runner) validate() error {
+	versions := make([]int, 0, len(r.migrations)+len(r.reserved))
+	for _, m := range r.migrations {
+		if m.SQL == "" && m.Step == nil {
+			return fmt.Errorf("%w: migration %d does nothing", ErrInvalidMigrations, m.Version)
+		}
+		versions = append(versions, m.Version)
+	}
+	for version := range r.reserved {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	for i, version := range versions {
+		switch {
+		case version == i:
+			return fmt.Errorf("%w: version %d is used more than once", ErrInvalidMigrations, version)
+		case version != i+1:
+			return fmt.Errorf("%w: version %d is missing", ErrInvalidMigrations, i+1)
+		}
+	}
+	return nil
+}
+
+// Latest returns the highest migration version known to the runner,
+// reserved ones included
+func (r *Runner) Latest() int {
+	latest := 0
+	for _, m := range r.migrations {
+		if m.Version > latest {
+			latest = m.Version
+		}
+	}
+	for version := range r.reserved {
+		if version > latest {
+			latest = version
+		}
+	}
+	return latest
+}
+
+// ensureMigrationsTable creates the migrations table if it doesn't exist and
+// adds the checksum column to tables created before checksums were recorded
 func (r *Runner) ensureMigrationsTable() error {
 	_, err := r.db.Exec(`
 		CREATE TABLE IF NOT EXISTS _migrations (
 			version INTEGER PRIMARY KEY,
 			description TEXT NOT NULL,
-			applied_at TIMESTAMP NOT NULL
+			applied_at TIMESTAMP NOT NULL,
+			checksum TEXT
 		)
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+
+	columns, err := r.columns("_migrations")
+	if err != nil {
+		return err
+	}
+	if !columns["checksum"] {
+		if _, err := r.db.Exec("ALTER TABLE _migrations ADD COLUMN checksum TEXT"); err != nil {
+			return err
+		}
+	}
+	return r.ensureProgressTable()
 }
 
-// getAppliedMigrations returns a map of already applied migration versions
-func (r *Runner) getAppliedMigrations() (map[int]bool, error) {
-	rows, err := r.db.Query("SELECT version FROM _migrations")
+// columns returns the column names of table, or none if it does not exist
+func (r *Runner) columns(table string) (map[string]bool, error) {
+	rows, err := r.db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+type appliedMigration struct {
+	description string
+	appliedAt   time.Time
+	checksum    string
+}
+
+// getAppliedMigrations returns the already applied migrations by version.
+// It only reads: without a migrations table nothing is applied, and
+// migrations recorded before checksums were have none.
+func (r *Runner) getAppliedMigrations() (map[int]appliedMigration, error) {
+	columns, err := r.columns("_migrations")
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]appliedMigration)
+	if len(columns) == 0 {
+		return applied, nil
+	}
+	checksum := "''"
+	if columns["checksum"] {
+		checksum = "COALESCE(checksum, '')"
+	}
+	rows, err := r.db.Query("SELECT version, description, applied_at, " + checksum + " FROM _migrations")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	applied := make(map[int]bool)
 	for rows.Next() {
 		var version int
-		if err := rows.Scan(&version); err != nil {
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.description, &a.appliedAt, &a.checksum); err != nil {
 			return nil, err
 		}
-		applied[version] = true
+		applied[version] = a
 	}
 
 	return applied, rows.Err()
 }
 
+// Status reports every known migration in version order, followed by any
+// applied migrations the runner does not know about. It does not change the
+// database, so it works on read-only handles.
+func (r *Runner) Status() ([]Status, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	applied, err := r.getAppliedMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	cursors, err := r.cursors()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration progress: %w", err)
+	}
+
+	var statuses []Status
+	known := make(map[int]bool)
+	for _, m := range r.sorted() {
+		known[m.Version] = true
+		s := Status{
+			Version:     m.Version,
+			Description: m.Description,
+			Checksum:    m.Checksum(),
+			Reversible:  m.Reversible(),
+			Cursor:      cursors[m.Version],
+		}
+		if a, ok := applied[m.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.appliedAt
+			s.StoredChecksum = a.checksum
+			s.Drift = a.checksum != "" && a.checksum != s.Checksum
+		}
+		statuses = append(statuses, s)
+	}
+
+	for version, description := range r.reserved {
+		known[version] = true
+		s := Status{Version: version, Description: description, Reserved: true}
+		if a, ok := applied[version]; ok {
+			s.Applied = true
+			s.AppliedAt = a.appliedAt
+			s.StoredChecksum = a.checksum
+		}
+		statuses = append(statuses, s)
+	}
+	sortStatuses(statuses)
+
+	var unknown []Status
+	for version, a := range applied {
+		if !known[version] {
+			unknown = append(unknown, Status{
+				Version:        version,
+				Description:    a.description,
+				Applied:        true,
+				AppliedAt:      a.appliedAt,
+				StoredChecksum: a.checksum,
+				Unknown:        true,
+			})
+		}
+	}
+	sortStatuses(unknown)
+	return append(statuses, unknown...), nil
+}
+
+// Version returns the highest applied migration version, or 0
+func (r *Runner) Version() (int, error) {
+	statuses, err := r.Status()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for _, s := range statuses {
+		if s.Applied && s.Version > version {
+			version = s.Version
+		}
+	}
+	return version, nil
+}
+
 // Run executes all pending migrations
 func (r *Runner) Run() error {
-	// Ensure migrations table exists
+	return r.MigrateTo(r.Latest())
+}
+
+// MigrateTo applies pending migrations up to and including version, or rolls
+// back applied migrations above version, newest first. Each migration runs in
+// its own transaction. It refuses to do anything if an applied migration's
+// SQL has changed since it was applied.
+func (r *Runner) MigrateTo(version int) error {
+	if version != 0 && !r.known(version) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	if err := r.validate(); err != nil {
+		return err
+	}
 	if err := r.ensureMigrationsTable(); err != nil {
 		return fmt.Errorf("failed to create migrations table: %w", err)
 	}
-
-	// Get applied migrations
-	applied, err := r.getAppliedMigrations()
+	statuses, err := r.Status()
 	if err != nil {
-		return fmt.Errorf("failed to get applied migrations: %w", err)
+		return err
 	}
 
-	// Sort migrations by version (they should already be in order as added)
-	for _, migration := range r.migrations {
-		// Skip if already applied
-		if applied[migration.Version] {
-			continue
+	applied := make(map[int]bool)
+	for _, s := range statuses {
+		if s.Drift {
+			return fmt.Errorf("%w: migration %d (%s) was applied with checksum %s but is now %s",
+				ErrChecksumMismatch, s.Version, s.Description, s.StoredChecksum, s.Checksum)
 		}
-
-		// Begin transaction for this migration
-		tx, err := r.db.Begin()
-		if err != nil {
-			return fmt.Errorf("failed to begin transaction for migration %d: %w", migration.Version, err)
+		if s.Unknown && s.Version > version {
+			return fmt.Errorf("%w: migration %d (%s) is applied but unknown to this version of n1",
+				ErrIrreversible, s.Version, s.Description)
+		}
+		if s.Applied {
+			applied[s.Version] = true
+		}
+	}
+	// Passing a reserved migration would apply later migrations to a schema
+	// it has not prepared, or leave its changes behind when rolling back
+	for _, s := range statuses {
+		switch {
+		case s.Reserved && !s.Applied && s.Version <= version:
+			return fmt.Errorf("%w: migration %d (%s) must run before migrating to version %d",
+				ErrUnregistered, s.Version, s.Description, version)
+		case s.Reserved && s.Applied && s.Version > version:
+			return fmt.Errorf("%w: migration %d (%s) is not available in this build of n1",
+				ErrIrreversible, s.Version, s.Description)
 		}
+	}
+
+	// Migrations applied before checksums were recorded are trusted as they are
+	if err := r.backfillChecksums(); err != nil {
+		return err
+	}
 
-		// Execute migration SQL
-		if _, err := tx.Exec(migration.SQL); err != nil {
-			rollbackErr := tx.Rollback()
-			if rollbackErr != nil {
-				return fmt.Errorf("failed to execute migration %d and rollback failed: %v: %w",
-					migration.Version, rollbackErr, err)
+	sorted := r.sorted()
+	for i := len(sorted) - 1; i >= 0; i-- {
+		m := sorted[i]
+		if m.Version > version && applied[m.Version] {
+			if err := r.revert(m); err != nil {
+				return err
 			}
-			return fmt.Errorf("failed to execute migration %d: %w", migration.Version, err)
 		}
-
-		// Record migration as applied
-		_, err = tx.Exec(
-			"INSERT INTO _migrations (version, description, applied_at) VALUES (?, ?, ?)",
-			migration.Version,
-			migration.Description,
-			time.Now().UTC(),
-		)
-		if err != nil {
-			rollbackErr := tx.Rollback()
-			if rollbackErr != nil {
-				return fmt.Errorf("failed to record migration %d and rollback failed: %v: %w",
-					migration.Version, rollbackErr, err)
+	}
+	for _, m := range sorted {
+		if m.Version <= version && !applied[m.Version] {
+			if err := r.apply(m); err != nil {
+				return err
 			}
-			return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
 		}
+	}
+	return nil
+}
 
-		// Commit transaction
-		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+// apply executes a migration and records it in a single transaction, or in
+// the transaction of the last batch for data migrations
+func (r *Runner) apply(migration Migration) error {
+	if migration.Step != nil {
+		return r.runSteps(migration, migration.Step, func(tx *sql.Tx) error {
+			return record(tx, migration)
+		})
+	}
+
+	// Begin transaction for this migration
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for migration %d: %w", migration.Version, err)
+	}
+
+	// Execute migration SQL
+	if _, err := tx.Exec(migration.SQL); err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			return fmt.Errorf("failed to execute migration %d and rollback failed: %v: %w",
+				migration.Version, rollbackErr, err)
 		}
+		return fmt.Errorf("failed to execute migration %d: %w", migration.Version, err)
+	}
+
+	// Record migration as applied
+	if err := record(tx, migration); err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+	}
+	return nil
+}
+
+// record marks a migration as applied
+func record(tx *sql.Tx, migration Migration) error {
+	_, err := tx.Exec(
+		"INSERT INTO _migrations (version, description, applied_at, checksum) VALUES (?, ?, ?, ?)",
+		migration.Version,
+		migration.Description,
+		time.Now().UTC(),
+		migration.Checksum(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+	}
+	return nil
+}
+
+// forget marks a migration as no longer applied
+func forget(tx *sql.Tx, migration Migration) error {
+	if _, err := tx.Exec("DELETE FROM _migrations WHERE version = ?", migration.Version); err != nil {
+		return fmt.Errorf("failed to unrecord migration %d: %w", migration.Version, err)
+	}
+	return nil
+}
+
+// revert executes a migration's down SQL and forgets it in a single transaction
+func (r *Runner) revert(migration Migration) error {
+	if !migration.Reversible() {
+		return fmt.Errorf("%w: migration %d (%s) has no down migration", ErrIrreversible, migration.Version, migration.Description)
+	}
+	if migration.Step != nil {
+		return r.runSteps(migration, migration.DownStep, func(tx *sql.Tx) error {
+			return forget(tx, migration)
+		})
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction for rollback of migration %d: %w", migration.Version, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(migration.Down); err != nil {
+		return fmt.Errorf("failed to roll back migration %d: %w", migration.Version, err)
+	}
+	if err := forget(tx, migration); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rollback of migration %d: %w", migration.Version, err)
 	}
+	return nil
+}
 
+func (r *Runner) backfillChecksums() error {
+	for _, m := range r.migrations {
+		_, err := r.db.Exec("UPDATE _migrations SET checksum = ? WHERE version = ? AND checksum IS NULL", m.Checksum(), m.Version)
+		if err != nil {
+			return fmt.Errorf("failed to record checksum of migration %d: %w", m.Version, err)
+		}
+	}
 	return nil
 }
+
+// known reports whether version is a migration of the runner, reserved or not
+func (r *Runner) known(version int) bool {
+	if _, ok := r.reserved[version]; ok {
+		return true
+	}
+	for _, m := range r.migrations {
+		if m.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns the migrations in version order
+func (r *Runner) sorted() []Migration {
+	sorted := append([]Migration(nil), r.migrations...)
+	sortMigrations(sorted)
+	return sorted
+}
+
+func sortMigrations(migrations []Migration) {
+	sort.SliceStable(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+}
+
+func sortStatuses(statuses []Status) {
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+}
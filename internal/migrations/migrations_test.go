@@ -2,11 +2,13 @@ package migrations
 
 import (
 	"database/sql"
+	"fmt"
 	"path/filepath"
 	"testing"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // Import SQLite driver
+	"github.com/n1/n1/internal/crypto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -134,3 +136,349 @@ func TestBootstrapVault(t *testing.T) {
 	// Verify updated_at changed
 	assert.NotEqual(t, initialUpdatedAt, newUpdatedAt, "Expected updated_at to change after update")
 }
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err, "Opening database failed")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", name).Scan(&exists)
+	require.NoError(t, err)
+	return exists
+}
+
+func TestStatus(t *testing.T) {
+	db := openTestDB(t)
+	runner := NewRunner(db)
+	runner.AddReversibleMigration(1, "Create a", "CREATE TABLE a (id INTEGER)", "DROP TABLE a")
+	runner.AddMigration(2, "Create b", "CREATE TABLE b (id INTEGER)")
+
+	require.NoError(t, runner.MigrateTo(1))
+
+	statuses, err := runner.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.True(t, statuses[0].Reversible)
+	assert.Equal(t, statuses[0].Checksum, statuses[0].StoredChecksum)
+	assert.False(t, statuses[0].AppliedAt.IsZero())
+	assert.False(t, statuses[1].Applied)
+	assert.False(t, statuses[1].Reversible)
+
+	version, err := runner.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	err = runner.MigrateTo(3)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+}
+
+func TestMigrateDown(t *testing.T) {
+	db := openTestDB(t)
+	runner := NewRunner(db)
+	runner.AddReversibleMigration(1, "Create a", "CREATE TABLE a (id INTEGER)", "DROP TABLE a")
+	runner.AddReversibleMigration(2, "Create b", "CREATE TABLE b (id INTEGER)", "DROP TABLE b")
+	runner.AddMigration(3, "Create c", "CREATE TABLE c (id INTEGER)")
+	require.NoError(t, runner.MigrateTo(2))
+
+	require.NoError(t, runner.MigrateTo(1))
+	assert.True(t, tableExists(t, db, "a"))
+	assert.False(t, tableExists(t, db, "b"))
+
+	require.NoError(t, runner.MigrateTo(0))
+	assert.False(t, tableExists(t, db, "a"))
+	version, err := runner.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	// Migration 3 has no down SQL, so it cannot be rolled back
+	require.NoError(t, runner.Run())
+	err = runner.MigrateTo(2)
+	assert.ErrorIs(t, err, ErrIrreversible)
+	assert.True(t, tableExists(t, db, "c"))
+}
+
+func TestChecksumDrift(t *testing.T) {
+	db := openTestDB(t)
+	runner := NewRunner(db)
+	runner.AddMigration(1, "Create a", "CREATE TABLE a (id INTEGER)")
+	require.NoError(t, runner.Run())
+
+	// Re-indenting a migration is not drift
+	reformatted := NewRunner(db)
+	reformatted.AddMigration(1, "Create a", "\n\t\tCREATE TABLE a\n\t\t(id INTEGER)\n\t")
+	require.NoError(t, reformatted.Run())
+
+	edited := NewRunner(db)
+	edited.AddMigration(1, "Create a", "CREATE TABLE a (id INTEGER, name TEXT)")
+	edited.AddMigration(2, "Create b", "CREATE TABLE b (id INTEGER)")
+
+	statuses, err := edited.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[0].Drift)
+
+	err = edited.Run()
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.False(t, tableExists(t, db, "b"), "Nothing should run after drift is detected")
+}
+
+func TestLegacyMigrationsTable(t *testing.T) {
+	db := openTestDB(t)
+	// Migrations tables created before checksums were recorded lack the column
+	_, err := db.Exec(`CREATE TABLE _migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);
+	CREATE TABLE a (id INTEGER);
+	INSERT INTO _migrations (version, description, applied_at) VALUES (1, 'Create a', CURRENT_TIMESTAMP)`)
+	require.NoError(t, err)
+
+	runner := NewRunner(db)
+	runner.AddMigration(1, "Create a", "CREATE TABLE a (id INTEGER)")
+	runner.AddMigration(2, "Create b", "CREATE TABLE b (id INTEGER)")
+	require.NoError(t, runner.Run())
+
+	statuses, err := runner.Status()
+	require.NoError(t, err)
+	for _, s := range statuses {
+		assert.True(t, s.Applied)
+		assert.Equal(t, s.Checksum, s.StoredChecksum, "Checksum of migration %d should be backfilled", s.Version)
+	}
+}
+
+func TestUnknownAppliedMigration(t *testing.T) {
+	db := openTestDB(t)
+	newer := NewRunner(db)
+	newer.AddReversibleMigration(1, "Create a", "CREATE TABLE a (id INTEGER)", "DROP TABLE a")
+	newer.AddReversibleMigration(2, "Create b", "CREATE TABLE b (id INTEGER)", "DROP TABLE b")
+	require.NoError(t, newer.Run())
+
+	older := NewRunner(db)
+	older.AddReversibleMigration(1, "Create a", "CREATE TABLE a (id INTEGER)", "DROP TABLE a")
+	statuses, err := older.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.True(t, statuses[1].Unknown)
+	assert.Equal(t, "Create b", statuses[1].Description)
+
+	// The older runner does not know how to roll back migration 2
+	err = older.MigrateTo(0)
+	assert.ErrorIs(t, err, ErrIrreversible)
+}
+
+func TestVaultMigrationsReversible(t *testing.T) {
+	db := openTestDB(t)
+	runner := NewVaultRunner(db)
+	require.NoError(t, runner.Run())
+
+	require.NoError(t, runner.MigrateTo(0), "Rolling back every vault migration failed")
+	for _, table := range []string{"vault", "events", "vault_meta", "labels", "search_postings", "search_docs", "vectors", "links"} {
+		assert.False(t, tableExists(t, db, table), "Table %s should be dropped", table)
+	}
+
+	require.NoError(t, runner.Run(), "Re-applying vault migrations failed")
+	version, err := runner.Version()
+	require.NoError(t, err)
+	assert.Equal(t, runner.Latest(), version)
+	assert.True(t, tableExists(t, db, "links"))
+}
+
+func TestDataMigration(t *testing.T) {
+	db := openTestDB(t)
+	runner := NewRunner(db)
+	runner.AddMigration(1, "Create secrets", "CREATE TABLE secrets (id INTEGER PRIMARY KEY, value BLOB NOT NULL)")
+	require.NoError(t, runner.Run())
+	for i := 1; i <= 5; i++ {
+		_, err := db.Exec("INSERT INTO secrets (id, value) VALUES (?, ?)", i, []byte("plain"))
+		require.NoError(t, err)
+	}
+
+	// Encrypt the values two rows at a time, resuming from the last id
+	batches := 0
+	encrypt := func(ctx *Context, cursor string) (string, bool, error) {
+		batches++
+		after := 0
+		if cursor != "" {
+			after = int(cursor[0] - '0')
+		}
+		rows, err := ctx.Tx.Query("SELECT id, value FROM secrets WHERE id > ? ORDER BY id LIMIT 2", after)
+		if err != nil {
+			return "", false, err
+		}
+		values := map[int][]byte{}
+		for rows.Next() {
+			var id int
+			var value []byte
+			require.NoError(t, rows.Scan(&id, &value))
+			values[id] = value
+			after = id
+		}
+		rows.Close()
+		if len(values) == 0 {
+			return "", true, nil
+		}
+		for id, value := range values {
+			ciphertext, err := ctx.Encrypt(value)
+			if err != nil {
+				return "", false, err
+			}
+			if _, err := ctx.Tx.Exec("UPDATE secrets SET value = ? WHERE id = ?", ciphertext, id); err != nil {
+				return "", false, err
+			}
+		}
+		ctx.Progress(int64(after), 5)
+		return string(rune('0' + after)), false, nil
+	}
+	runner.AddDataMigration(2, "Encrypt secrets", encrypt, nil)
+
+	err := runner.Run()
+	assert.ErrorIs(t, err, ErrKeyRequired, "Data migrations needing the key should fail without it")
+
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	var progress []int64
+	runner.WithKey(key).OnProgress(func(m Migration, done, total int64) {
+		assert.Equal(t, 2, m.Version)
+		progress = append(progress, done)
+	})
+	require.NoError(t, runner.Run())
+	assert.Equal(t, []int64{2, 4, 5}, progress)
+	assert.Equal(t, 5, batches, "Expected the failed attempt, three batches and a final empty one")
+
+	var value []byte
+	require.NoError(t, db.QueryRow("SELECT value FROM secrets WHERE id = 5").Scan(&value))
+	plaintext, err := crypto.DecryptBlob(key, value)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("plain"), plaintext)
+
+	statuses, err := runner.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[1].Applied)
+	assert.False(t, statuses[1].Reversible)
+	assert.Empty(t, statuses[1].Cursor)
+	assert.ErrorIs(t, runner.MigrateTo(1), ErrIrreversible)
+}
+
+func TestDataMigrationResumes(t *testing.T) {
+	db := openTestDB(t)
+	runner := NewRunner(db)
+	runner.AddMigration(1, "Create counter", "CREATE TABLE counter (n INTEGER NOT NULL); INSERT INTO counter VALUES (0)")
+
+	// Count to three, one batch at a time, failing once in the second batch
+	failed := false
+	runner.AddDataMigration(2, "Count to three", func(ctx *Context, cursor string) (string, bool, error) {
+		if cursor == "xx" && !failed {
+			failed = true
+			return "", false, assert.AnError
+		}
+		if cursor == "xxx" {
+			return "", true, nil
+		}
+		if _, err := ctx.Tx.Exec("UPDATE counter SET n = n + 1"); err != nil {
+			return "", false, err
+		}
+		return cursor + "x", false, nil
+	}, func(ctx *Context, cursor string) (string, bool, error) {
+		_, err := ctx.Tx.Exec("UPDATE counter SET n = 0")
+		return "", true, err
+	})
+
+	require.ErrorIs(t, runner.Run(), assert.AnError)
+	statuses, err := runner.Status()
+	require.NoError(t, err)
+	assert.Equal(t, "xx", statuses[1].Cursor, "Committed batches should be remembered")
+
+	require.NoError(t, runner.Run())
+	var n int
+	require.NoError(t, db.QueryRow("SELECT n FROM counter").Scan(&n))
+	assert.Equal(t, 3, n, "Resuming should not repeat committed batches")
+
+	require.NoError(t, runner.MigrateTo(1))
+	require.NoError(t, db.QueryRow("SELECT n FROM counter").Scan(&n))
+	assert.Equal(t, 0, n)
+}
+
+func TestCompatibility(t *testing.T) {
+	db := openTestDB(t)
+	require.NoError(t, NewVaultRunner(db).MigrateTo(11))
+
+	compat, err := NewVaultRunner(db).Compatibility()
+	require.NoError(t, err)
+	assert.Equal(t, 11, compat.Version)
+	assert.Positive(t, compat.Pending)
+	assert.Zero(t, compat.Format, "Format version should not be recorded yet")
+	assert.NoError(t, compat.Err(), "Older vaults should be migrated, not refused")
+
+	require.NoError(t, NewVaultRunner(db).Run())
+	compat, err = NewVaultRunner(db).Compatibility()
+	require.NoError(t, err)
+	assert.Zero(t, compat.Pending)
+	assert.Equal(t, FormatVersion, compat.Format)
+	assert.NoError(t, compat.Err())
+
+	// A newer binary added a migration this one does not know
+	newer := NewVaultRunner(db)
+	newer.AddReversibleMigration(compat.Latest+1, "Create future", "CREATE TABLE future (id INTEGER)", "DROP TABLE future")
+	require.NoError(t, newer.Run())
+
+	compat, err = NewVaultRunner(db).Compatibility()
+	require.NoError(t, err)
+	assert.True(t, compat.Newer())
+	assert.True(t, compat.Readable(), "Same format should remain readable")
+	assert.ErrorIs(t, compat.Err(), ErrNewerSchema)
+
+	// ... and then changed the data format
+	_, err = db.Exec("UPDATE vault_meta SET value = ? WHERE name = ?", fmt.Sprint(FormatVersion+1), FormatMetaKey)
+	require.NoError(t, err)
+	compat, err = NewVaultRunner(db).Compatibility()
+	require.NoError(t, err)
+	assert.False(t, compat.Readable())
+	assert.ErrorIs(t, compat.Err(), ErrNewerSchema)
+}
+
+// TestCompatibilityIsReadOnly inspects a vault from before migrations were
+// checksummed through a read-only handle, without changing its schema
+func TestCompatibilityIsReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE _migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);
+	CREATE TABLE vault (id INTEGER PRIMARY KEY AUTOINCREMENT, key TEXT NOT NULL, value BLOB NOT NULL);
+	INSERT INTO _migrations (version, description, applied_at) VALUES (1, 'Create vault table', CURRENT_TIMESTAMP)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	readOnly, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	require.NoError(t, err)
+	defer readOnly.Close()
+	compat, err := NewVaultRunner(readOnly).Compatibility()
+	require.NoError(t, err)
+	assert.Equal(t, 1, compat.Version)
+	assert.Positive(t, compat.Pending)
+	assert.NoError(t, compat.Err())
+
+	assert.False(t, tableExists(t, readOnly, "_migration_progress"))
+	var checksum bool
+	require.NoError(t, readOnly.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('_migrations') WHERE name = 'checksum'").Scan(&checksum))
+	assert.False(t, checksum)
+
+	// A database without any migrations has nothing applied
+	empty, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "empty.db")+"?mode=rwc")
+	require.NoError(t, err)
+	defer empty.Close()
+	compat, err = NewVaultRunner(empty).Compatibility()
+	require.NoError(t, err)
+	assert.Zero(t, compat.Version)
+	assert.False(t, tableExists(t, empty, "_migrations"))
+}
@@ -1,44 +1,93 @@
 package migrations
 
-import "database/sql"
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"sync"
+)
 
-// InitVaultMigrations adds the initial migrations for the vault table
+// vaultFS holds the SQL migrations of the vault schema, one numbered file per
+// migration and direction
+//
+//go:embed vault/*.sql
+var vaultFS embed.FS
+
+// reservedVaultMigrations lists the vault data migrations implemented by
+// other packages, so that vault versions are allocated in one place and the
+// runner can gap-check them even in binaries that do not link those packages
+var reservedVaultMigrations = map[int]string{
+	12: "Seed event log from vault rows", // dao
+}
+
+var (
+	registryMu          sync.Mutex
+	vaultDataMigrations []Migration
+)
+
+// RegisterVaultMigration adds the implementation of a reserved data migration
+// to the vault schema. Packages that own the data being migrated (such as
+// dao, which owns the event log) register their migrations from init, so
+// every vault runner in a binary knows the same set. It panics if the version
+// is not reserved under the same description or is registered twice.
+func RegisterVaultMigration(m Migration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if reservedVaultMigrations[m.Version] != m.Description {
+		panic(fmt.Sprintf("migrations: vault migration %d (%s) is not reserved", m.Version, m.Description))
+	}
+	for _, existing := range vaultDataMigrations {
+		if existing.Version == m.Version {
+			panic(fmt.Sprintf("migrations: vault migration %d registered twice", m.Version))
+		}
+	}
+	vaultDataMigrations = append(vaultDataMigrations, m)
+}
+
+// InitVaultMigrations adds the migrations for the vault schema: the SQL
+// migrations embedded from vault/ and the registered data migrations.
+// Reserved data migrations that are not registered are reserved on the
+// runner, which then refuses to migrate a vault past them.
 func InitVaultMigrations(runner *Runner) {
-	// Migration 1: Create the vault table
-	runner.AddMigration(
-		1,
-		"Create vault table",
-		`CREATE TABLE vault (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			key TEXT NOT NULL,
-			value BLOB NOT NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-		)`,
-	)
-
-	// Migration 2: Create index on vault key
-	runner.AddMigration(
-		2,
-		"Create index on vault key",
-		`CREATE UNIQUE INDEX idx_vault_key ON vault(key)`,
-	)
-
-	// Migration 3: Create trigger to update the updated_at timestamp
-	runner.AddMigration(
-		3,
-		"Create trigger for updated_at",
-		`CREATE TRIGGER trig_vault_updated_at 
-		AFTER UPDATE ON vault
-		BEGIN
-			UPDATE vault SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
-		END`,
-	)
+	runner.AddMigrations(sqlVaultMigrations()...)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	runner.AddMigrations(vaultDataMigrations...)
+	for version, description := range reservedVaultMigrations {
+		if !runner.known(version) {
+			runner.Reserve(version, description)
+		}
+	}
 }
 
-// BootstrapVault initializes the vault table in the database
-func BootstrapVault(db *sql.DB) error {
+// sqlVaultMigrations loads the embedded SQL migrations of the vault schema.
+// Every one is reversible; rolling back a migration that created a table
+// drops the table along with its rows.
+func sqlVaultMigrations() []Migration {
+	migrations, err := LoadFS(vaultFS, "vault")
+	if err != nil {
+		// The files are compiled in, so this is caught by the package tests
+		panic(fmt.Sprintf("migrations: invalid embedded vault migrations: %v", err))
+	}
+	return migrations
+}
+
+// NewVaultRunner returns a runner with the vault migrations added
+func NewVaultRunner(db *sql.DB) *Runner {
 	runner := NewRunner(db)
 	InitVaultMigrations(runner)
-	return runner.Run()
+	return runner
+}
+
+// BootstrapVault initializes the vault table in the database. Data migrations
+// that need the master key fail with ErrKeyRequired; use MigrateVault for
+// vaults that may hold data.
+func BootstrapVault(db *sql.DB) error {
+	return NewVaultRunner(db).Run()
+}
+
+// MigrateVault brings a vault up to the current schema, giving data
+// migrations the master key
+func MigrateVault(db *sql.DB, masterKey []byte) error {
+	return NewVaultRunner(db).WithKey(masterKey).Run()
 }
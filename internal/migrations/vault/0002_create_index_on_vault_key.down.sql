@@ -0,0 +1 @@
+DROP INDEX idx_vault_key;
@@ -0,0 +1,2 @@
+-- Create index on vault key
+CREATE UNIQUE INDEX idx_vault_key ON vault(key);
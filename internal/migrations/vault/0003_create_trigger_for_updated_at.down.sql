@@ -0,0 +1 @@
+DROP TRIGGER trig_vault_updated_at;
@@ -0,0 +1,7 @@
+-- Create trigger for updated_at
+-- Keep updated_at current on every update
+CREATE TRIGGER trig_vault_updated_at
+AFTER UPDATE ON vault
+BEGIN
+    UPDATE vault SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
@@ -0,0 +1,3 @@
+DROP TRIGGER trig_events_no_delete;
+DROP TRIGGER trig_events_no_update;
+DROP TABLE events;
@@ -0,0 +1,6 @@
+DROP TRIGGER trig_vault_updated_at;
+CREATE TRIGGER trig_vault_updated_at
+AFTER UPDATE ON vault
+BEGIN
+    UPDATE vault SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
@@ -0,0 +1,9 @@
+-- Only default updated_at when not set explicitly
+-- Let explicitly written updated_at values (event replay) win over the trigger
+DROP TRIGGER trig_vault_updated_at;
+CREATE TRIGGER trig_vault_updated_at
+AFTER UPDATE ON vault
+WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE vault SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
@@ -0,0 +1,6 @@
+-- Create vault_meta table
+-- Vault metadata: integrity seal, format version
+CREATE TABLE vault_meta (
+    name TEXT PRIMARY KEY,
+    value BLOB NOT NULL
+);
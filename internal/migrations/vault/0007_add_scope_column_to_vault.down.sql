@@ -0,0 +1,2 @@
+DROP INDEX idx_vault_scope;
+ALTER TABLE vault DROP COLUMN scope;
@@ -0,0 +1,4 @@
+-- Add scope column to vault
+-- File records under scopes (inbox, sandbox, safebox, trashbox, ...)
+ALTER TABLE vault ADD COLUMN scope TEXT NOT NULL DEFAULT 'inbox';
+CREATE INDEX idx_vault_scope ON vault(scope);
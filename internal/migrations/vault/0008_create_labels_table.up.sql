@@ -0,0 +1,9 @@
+-- Create labels table
+-- Encrypted tags and labels with a blind index for lookups
+CREATE TABLE labels (
+    record_key TEXT NOT NULL,
+    blind BLOB NOT NULL,
+    label BLOB NOT NULL,
+    PRIMARY KEY (record_key, blind)
+);
+CREATE INDEX idx_labels_blind ON labels(blind);
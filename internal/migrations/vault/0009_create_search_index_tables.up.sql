@@ -0,0 +1,13 @@
+-- Create search index tables
+-- Blind-indexed full-text search with encrypted positions
+CREATE TABLE search_postings (
+    blind BLOB NOT NULL,
+    record_key TEXT NOT NULL,
+    positions BLOB NOT NULL,
+    PRIMARY KEY (blind, record_key)
+);
+CREATE INDEX idx_search_postings_key ON search_postings(record_key);
+CREATE TABLE search_docs (
+    record_key TEXT PRIMARY KEY,
+    length INTEGER NOT NULL
+);
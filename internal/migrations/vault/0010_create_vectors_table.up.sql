@@ -0,0 +1,7 @@
+-- Create vectors table
+-- Encrypted embedding vectors for semantic search
+CREATE TABLE vectors (
+    record_key TEXT PRIMARY KEY,
+    model TEXT NOT NULL,
+    vector BLOB NOT NULL
+);
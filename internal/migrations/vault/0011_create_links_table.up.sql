@@ -0,0 +1,10 @@
+-- Create links table
+-- Typed, weighted links between records
+CREATE TABLE links (
+    from_key TEXT NOT NULL,
+    to_key TEXT NOT NULL,
+    type_blind BLOB NOT NULL,
+    data BLOB NOT NULL,
+    PRIMARY KEY (from_key, to_key, type_blind)
+);
+CREATE INDEX idx_links_to ON links(to_key);
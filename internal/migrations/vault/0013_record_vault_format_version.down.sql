@@ -0,0 +1 @@
+DELETE FROM vault_meta WHERE name = 'format.version';
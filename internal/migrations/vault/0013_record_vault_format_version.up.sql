@@ -0,0 +1,4 @@
+-- Record vault format version
+-- Lets older binaries tell whether they can still read the vault.
+-- Migration 12 is a data migration registered by the dao package.
+INSERT INTO vault_meta (name, value) VALUES ('format.version', '1');
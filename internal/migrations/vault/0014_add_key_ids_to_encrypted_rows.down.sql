@@ -0,0 +1,20 @@
+UPDATE vault_meta SET value = '1' WHERE name = 'format.version';
+DROP TRIGGER trig_events_no_update;
+CREATE TRIGGER trig_events_no_update
+BEFORE UPDATE ON events
+BEGIN
+    SELECT RAISE(ABORT, 'events are append-only');
+END;
+DROP TRIGGER trig_vault_updated_at;
+CREATE TRIGGER trig_vault_updated_at
+AFTER UPDATE ON vault
+WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE vault SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+ALTER TABLE links DROP COLUMN key_id;
+ALTER TABLE vectors DROP COLUMN key_id;
+ALTER TABLE search_postings DROP COLUMN key_id;
+ALTER TABLE labels DROP COLUMN key_id;
+ALTER TABLE events DROP COLUMN key_id;
+ALTER TABLE vault DROP COLUMN key_id;
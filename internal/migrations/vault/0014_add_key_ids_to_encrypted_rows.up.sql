@@ -0,0 +1,31 @@
+-- Add key IDs to encrypted rows
+-- Every encrypted row records the fingerprint of the master key it was
+-- written with, so an online key rotation can re-encrypt rows in small
+-- batches while readers pick the right key for each row. Rows written
+-- before key IDs existed have an empty key ID. Vaults may now hold rows
+-- under more than one key, which older binaries cannot read: format 2.
+ALTER TABLE vault ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE labels ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE search_postings ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE vectors ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE links ADD COLUMN key_id TEXT NOT NULL DEFAULT '';
+-- Re-encrypting a record is not an update of it
+DROP TRIGGER trig_vault_updated_at;
+CREATE TRIGGER trig_vault_updated_at
+AFTER UPDATE ON vault
+WHEN NEW.updated_at = OLD.updated_at AND NEW.key_id = OLD.key_id
+BEGIN
+    UPDATE vault SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+-- While an online rotation is in progress it may re-encrypt event payloads
+-- and relink the hash chain; nothing may ever change what an event records
+DROP TRIGGER trig_events_no_update;
+CREATE TRIGGER trig_events_no_update
+BEFORE UPDATE ON events
+WHEN NEW.seq IS NOT OLD.seq OR NEW.type IS NOT OLD.type OR NEW.created_at IS NOT OLD.created_at
+    OR NOT EXISTS (SELECT 1 FROM vault_meta WHERE name = 'rotation.online')
+BEGIN
+    SELECT RAISE(ABORT, 'events are append-only');
+END;
+UPDATE vault_meta SET value = '2' WHERE name = 'format.version';
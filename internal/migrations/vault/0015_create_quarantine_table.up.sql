@@ -0,0 +1,13 @@
+-- Create quarantine table
+-- Rows that no longer decrypt, moved aside by 'bosr verify --quarantine'.
+-- row holds the original columns as JSON; the table has no key_id, as
+-- nothing in it is readable with any key the vault knows.
+CREATE TABLE quarantine (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_table TEXT NOT NULL,
+    source_rowid INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    row BLOB NOT NULL,
+    reason TEXT NOT NULL,
+    quarantined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+DROP TABLE vector_buckets;
@@ -0,0 +1,11 @@
+-- Create vector_buckets table
+-- Persistent LSH index over the vectors table: the encrypted bucket of each
+-- record in every hash table, with a blind index of it for lookups
+CREATE TABLE vector_buckets (
+    blind BLOB NOT NULL,
+    record_key TEXT NOT NULL,
+    bucket BLOB NOT NULL,
+    key_id TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (blind, record_key)
+);
+CREATE INDEX idx_vector_buckets_key ON vector_buckets(record_key);
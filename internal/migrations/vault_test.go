@@ -0,0 +1,169 @@
+package migrations
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "Rewrite golden files")
+
+// TestVaultSchemaGolden applies every vault migration to a scratch database
+// and compares the migrations and resulting schema with testdata. Released
+// migrations must never change: run with -update only for new migrations.
+func TestVaultSchemaGolden(t *testing.T) {
+	db := openTestDB(t)
+	runner := NewVaultRunner(db)
+	require.NoError(t, runner.Run())
+
+	var b strings.Builder
+	b.WriteString("# migrations\n")
+	for _, m := range runner.sorted() {
+		fmt.Fprintf(&b, "%d\t%s\t%s\n", m.Version, m.Checksum(), m.Description)
+	}
+	b.WriteString("\n# schema\n")
+	b.WriteString(dumpSchema(t, db))
+
+	golden := filepath.Join("testdata", "vault_schema.golden")
+	if *update {
+		require.NoError(t, os.WriteFile(golden, []byte(b.String()), 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err, "Missing golden file; run go test ./internal/migrations -run TestVaultSchemaGolden -update")
+	assert.Equal(t, string(want), b.String(), "Vault schema differs from %s", golden)
+}
+
+// dumpSchema lists every table, index and trigger with whitespace-normalised SQL
+func dumpSchema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	rows, err := db.Query(`SELECT type, name, COALESCE(sql, '') FROM sqlite_master
+		WHERE name NOT LIKE 'sqlite_%' ORDER BY type, name`)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var b strings.Builder
+	for rows.Next() {
+		var typ, name, ddl string
+		require.NoError(t, rows.Scan(&typ, &name, &ddl))
+		fmt.Fprintf(&b, "%s %s: %s\n", typ, name, strings.Join(strings.Fields(ddl), " "))
+	}
+	require.NoError(t, rows.Err())
+	return b.String()
+}
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_add_name.up.sql":   {Data: []byte("-- Add name\nALTER TABLE a ADD COLUMN name TEXT;\n")},
+		"m/0001_create_a.up.sql":   {Data: []byte("-- Create a\n-- A note\nCREATE TABLE a (id INTEGER);\n")},
+		"m/0001_create_a.down.sql": {Data: []byte("DROP TABLE a;\n")},
+		"m/README.md":              {Data: []byte("ignored")},
+	}
+	migrations, err := LoadFS(fsys, "m")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, 1, migrations[0].Version, "Migrations should be sorted by version")
+	assert.Equal(t, "Create a", migrations[0].Description)
+	assert.True(t, migrations[0].Reversible())
+	assert.False(t, migrations[1].Reversible())
+
+	// Comments, whitespace and the trailing semicolon do not affect checksums
+	literal := Migration{SQL: "CREATE TABLE a (id INTEGER)"}
+	assert.Equal(t, literal.Checksum(), migrations[0].Checksum())
+
+	db := openTestDB(t)
+	runner := NewRunner(db)
+	runner.AddMigrations(migrations...)
+	require.NoError(t, runner.Run())
+	_, err = db.Exec("INSERT INTO a (id, name) VALUES (1, 'x')")
+	require.NoError(t, err)
+}
+
+func TestLoadFSRejectsMalformedFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{
+			name:  "missing description",
+			files: fstest.MapFS{"m/0001_create_a.up.sql": {Data: []byte("CREATE TABLE a (id INTEGER);")}},
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"m/0001_create_a.up.sql": {Data: []byte("-- Create a\nCREATE TABLE a (id INTEGER);")},
+				"m/0001_create_b.up.sql": {Data: []byte("-- Create b\nCREATE TABLE b (id INTEGER);")},
+			},
+		},
+		{
+			name:  "down without up",
+			files: fstest.MapFS{"m/0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFS(tt.files, "m")
+			assert.ErrorIs(t, err, ErrInvalidMigrations)
+		})
+	}
+}
+
+func TestUnregisteredReservedMigrationBlocks(t *testing.T) {
+	db := openTestDB(t)
+
+	// A binary without the package implementing version 12 knows 13 and 14
+	runner := NewRunner(db)
+	for _, m := range sqlVaultMigrations() {
+		if m.Version <= 14 {
+			runner.AddMigrations(m)
+		}
+	}
+	runner.Reserve(12, reservedVaultMigrations[12])
+
+	require.NoError(t, runner.MigrateTo(11), "Migrations before the reserved one may run")
+	err := runner.MigrateTo(13)
+	assert.ErrorIs(t, err, ErrUnregistered)
+	assert.ErrorIs(t, runner.Run(), ErrUnregistered)
+	version, err := runner.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 11, version, "Nothing past the reserved migration should be applied")
+	assert.False(t, tableExists(t, db, "quarantine"))
+
+	statuses, err := runner.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 14)
+	assert.Equal(t, 12, statuses[11].Version)
+	assert.True(t, statuses[11].Reserved)
+	assert.False(t, statuses[11].Applied)
+	compat, err := runner.Compatibility()
+	require.NoError(t, err)
+	assert.Equal(t, 3, compat.Pending)
+
+	// Once a binary with version 12 has run it, the reserved one is applied
+	_, err = db.Exec("INSERT INTO _migrations (version, description, applied_at) VALUES (12, ?, CURRENT_TIMESTAMP)", reservedVaultMigrations[12])
+	require.NoError(t, err)
+	require.NoError(t, runner.Run())
+	assert.ErrorIs(t, runner.MigrateTo(11), ErrIrreversible, "A reserved migration cannot be rolled back")
+}
+
+func TestRunnerRejectsGaps(t *testing.T) {
+	db := openTestDB(t)
+
+	gap := NewRunner(db)
+	gap.AddMigration(1, "Create a", "CREATE TABLE a (id INTEGER)")
+	gap.AddMigration(3, "Create c", "CREATE TABLE c (id INTEGER)")
+	assert.ErrorIs(t, gap.Run(), ErrInvalidMigrations)
+	assert.False(t, tableExists(t, db, "a"), "Nothing should run when migrations have gaps")
+
+	duplicate := NewRunner(db)
+	duplicate.AddMigration(1, "Create a", "CREATE TABLE a (id INTEGER)")
+	duplicate.AddMigration(1, "Create b", "CREATE TABLE b (id INTEGER)")
+	assert.ErrorIs(t, duplicate.Run(), ErrInvalidMigrations)
+}
@@ -0,0 +1,307 @@
+package plaintext
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/n1/n1/internal/record"
+	"gopkg.in/yaml.v3"
+)
+
+func writeJSON(w io.Writer, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func readJSON(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func writeYAML(w io.Writer, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(entries); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func readYAML(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	if err := yaml.NewDecoder(r).Decode(&entries); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// csvHeader are the CSV columns. Structured records hold their fields as a
+// JSON object in the value column; labels are separated by spaces, which
+// labels cannot contain.
+var csvHeader = []string{"key", "type", "value", "encoding", "scope", "labels"}
+
+func writeCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		value := e.Value
+		if e.Type != "" {
+			fields, err := json.Marshal(e.Fields)
+			if err != nil {
+				return err
+			}
+			value = string(fields)
+		}
+		row := []string{e.Key, string(e.Type), value, e.Encoding, e.Scope, strings.Join(e.Labels, " ")}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readCSV(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["key"]; !ok {
+		return nil, errors.New(`missing "key" column`)
+	}
+	column := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var entries []Entry
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := Entry{
+			Key:      column(row, "key"),
+			Type:     record.Type(column(row, "type")),
+			Value:    column(row, "value"),
+			Encoding: column(row, "encoding"),
+			Scope:    column(row, "scope"),
+		}
+		if labels := strings.Fields(column(row, "labels")); len(labels) > 0 {
+			e.Labels = labels
+		}
+		if e.Type != "" {
+			if err := json.Unmarshal([]byte(e.Value), &e.Fields); err != nil {
+				return nil, fmt.Errorf("fields of %s: %w", e.Key, err)
+			}
+			e.Value = ""
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+var (
+	// envNamePattern matches the variable names readDotenv accepts
+	envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	envUnsafe      = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+)
+
+// EnvName turns a key into an environment variable name: "db/url" becomes
+// "DB_URL"
+func EnvName(key string) string {
+	name := strings.Trim(envUnsafe.ReplaceAllString(strings.ToUpper(key), "_"), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// envOrigin records the key, and for structured records the type and field,
+// that a dotenv variable was written from. writeDotenv puts it in a comment
+// line before the variable so that readDotenv can rebuild the record; other
+// tools ignore it.
+type envOrigin struct {
+	Key   string      `json:"key"`
+	Type  record.Type `json:"type,omitempty"`
+	Field string      `json:"field,omitempty"`
+}
+
+// envOriginPrefix starts the comment lines holding an envOrigin
+const envOriginPrefix = "# n1 "
+
+func writeDotenv(w io.Writer, entries []Entry) error {
+	seen := make(map[string]string)
+	bw := bufio.NewWriter(w)
+	put := func(origin envOrigin, name, value string) error {
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("%s and %s both map to %s", other, origin.Key, name)
+		}
+		seen[name] = origin.Key
+		data, err := json.Marshal(origin)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(bw, "%s%s\n%s=%s\n", envOriginPrefix, data, name, quoteEnv(value))
+		return err
+	}
+	for _, e := range entries {
+		if e.Encoding != "" {
+			return fmt.Errorf("%s is binary and cannot be written to dotenv", e.Key)
+		}
+		if e.Type == "" {
+			if err := put(envOrigin{Key: e.Key}, EnvName(e.Key), e.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		// One variable per field, in schema order
+		fields, err := record.Schema(e.Type)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if value, ok := e.Fields[f.Name]; ok {
+				origin := envOrigin{Key: e.Key, Type: e.Type, Field: f.Name}
+				if err := put(origin, EnvName(e.Key+"_"+f.Name), value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// quoteEnv quotes a dotenv value when it holds anything but plain characters
+func quoteEnv(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\r\n\"'\\#$`=") {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", `\$`, "`", "\\`")
+	return `"` + r.Replace(value) + `"`
+}
+
+// readDotenv reads variables as entries named after them, unless an origin
+// comment written by writeDotenv precedes them: then they get their original
+// key back, and the fields of a structured record are joined into one entry.
+func readDotenv(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	records := make(map[string]int)
+	var origin *envOrigin
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if data, ok := strings.CutPrefix(line, envOriginPrefix); ok {
+			origin = new(envOrigin)
+			if err := json.Unmarshal([]byte(data), origin); err != nil || origin.Key == "" || (origin.Type == "") != (origin.Field == "") {
+				return nil, fmt.Errorf("line %d: invalid n1 comment", n)
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("line %d: expected NAME=value", n)
+		}
+		value, err := unquoteEnv(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		switch {
+		case origin == nil:
+			entries = append(entries, Entry{Key: name, Value: value})
+		case origin.Type == "":
+			entries = append(entries, Entry{Key: origin.Key, Value: value})
+		default:
+			i, ok := records[origin.Key]
+			if !ok {
+				i = len(entries)
+				records[origin.Key] = i
+				entries = append(entries, Entry{Key: origin.Key, Type: origin.Type, Fields: make(map[string]string)})
+			}
+			if entries[i].Type != origin.Type {
+				return nil, fmt.Errorf("line %d: %s has fields of types %s and %s", n, origin.Key, entries[i].Type, origin.Type)
+			}
+			entries[i].Fields[origin.Field] = value
+		}
+		origin = nil
+	}
+	return entries, scanner.Err()
+}
+
+// unquoteEnv reads a dotenv value: double-quoted with escapes, single-quoted
+// verbatim, or bare up to a comment
+func unquoteEnv(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			switch {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(value[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double quote")
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated single quote")
+		}
+		return value[1 : end+1], nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
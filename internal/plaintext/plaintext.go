@@ -0,0 +1,166 @@
+// Package plaintext exports vault records as decrypted JSON, YAML, CSV or
+// dotenv files for other tools, and reads such files back.
+//
+// Structured records are written as their type and fields, other values as
+// text, or base64 when they are not valid UTF-8. Keys are written relative
+// to the prefix being exported, so exporting and importing with the same
+// prefix round-trips. JSON, YAML and CSV keep scopes and labels; dotenv only
+// holds names and values, so it maps keys to environment variable names and
+// writes one variable per field of a structured record, each preceded by a
+// comment with its key, type and field that reading the file back restores.
+// Scopes and labels are lost in dotenv.
+package plaintext
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/record"
+)
+
+// Format identifies a plaintext file format
+type Format string
+
+// Supported formats
+const (
+	FormatJSON   Format = "json"
+	FormatYAML   Format = "yaml"
+	FormatCSV    Format = "csv"
+	FormatDotenv Format = "dotenv"
+)
+
+// Formats lists the supported formats
+var Formats = []Format{FormatJSON, FormatYAML, FormatCSV, FormatDotenv}
+
+// ParseFormat parses a format name
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown plaintext format %q", s)
+}
+
+// EncodingBase64 marks values that are not valid UTF-8
+const EncodingBase64 = "base64"
+
+// Entry is a decrypted vault record
+type Entry struct {
+	// Key is relative to the exported prefix
+	Key string `json:"key" yaml:"key"`
+	// Type is the record type of structured records, which hold Fields
+	// instead of a Value
+	Type     record.Type       `json:"type,omitempty" yaml:"type,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty" yaml:"fields,omitempty"`
+	Value    string            `json:"value,omitempty" yaml:"value,omitempty"`
+	Encoding string            `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+	Scope    string            `json:"scope,omitempty" yaml:"scope,omitempty"`
+	Labels   []string          `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// NewEntry describes a vault value
+func NewEntry(key string, value []byte) Entry {
+	if r, err := record.Decode(value); err == nil {
+		return Entry{Key: key, Type: r.Type, Fields: r.Fields}
+	}
+	if utf8.Valid(value) {
+		return Entry{Key: key, Value: string(value)}
+	}
+	return Entry{Key: key, Value: base64.StdEncoding.EncodeToString(value), Encoding: EncodingBase64}
+}
+
+// Bytes rebuilds the vault value of the entry
+func (e Entry) Bytes() ([]byte, error) {
+	if e.Type != "" {
+		return record.Encode(&record.Record{Type: e.Type, Fields: e.Fields})
+	}
+	switch e.Encoding {
+	case "":
+		return []byte(e.Value), nil
+	case EncodingBase64:
+		value, err := base64.StdEncoding.DecodeString(e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 value for %s: %w", e.Key, err)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q for %s", e.Encoding, e.Key)
+}
+
+// Collect decrypts the records whose key starts with prefix, sorted by key.
+// n1 bookkeeping records are left out.
+func Collect(vault *dao.SecureVaultDAO, prefix string) ([]Entry, error) {
+	keys, err := vault.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	var entries []Entry
+	for _, key := range keys {
+		rel, ok := strings.CutPrefix(key, prefix)
+		if !ok || rel == "" || dao.IsInternalKey(key) {
+			continue
+		}
+		value, err := vault.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		e := NewEntry(rel, value)
+		if e.Scope, err = vault.ScopeOf(key); err != nil {
+			return nil, err
+		}
+		if e.Labels, err = vault.Labels(key); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Write writes the entries in the given format
+func Write(w io.Writer, format Format, entries []Entry) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, entries)
+	case FormatYAML:
+		return writeYAML(w, entries)
+	case FormatCSV:
+		return writeCSV(w, entries)
+	case FormatDotenv:
+		return writeDotenv(w, entries)
+	}
+	return fmt.Errorf("unknown plaintext format %q", format)
+}
+
+// Read reads entries in the given format
+func Read(r io.Reader, format Format) ([]Entry, error) {
+	var entries []Entry
+	var err error
+	switch format {
+	case FormatJSON:
+		entries, err = readJSON(r)
+	case FormatYAML:
+		entries, err = readYAML(r)
+	case FormatCSV:
+		entries, err = readCSV(r)
+	case FormatDotenv:
+		entries, err = readDotenv(r)
+	default:
+		return nil, fmt.Errorf("unknown plaintext format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", format, err)
+	}
+	for _, e := range entries {
+		if e.Key == "" {
+			return nil, fmt.Errorf("failed to read %s: entry without a key", format)
+		}
+	}
+	return entries, nil
+}
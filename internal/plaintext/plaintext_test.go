@@ -0,0 +1,123 @@
+package plaintext
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/n1/n1/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupVault(t *testing.T) *dao.SecureVaultDAO {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "vault.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	require.NoError(t, migrations.MigrateVault(db, key))
+	return dao.NewSecureVaultDAO(db, key)
+}
+
+func TestCollect(t *testing.T) {
+	vault := setupVault(t)
+	login, err := record.Encode(&record.Record{Type: record.TypeLogin, Fields: map[string]string{"user": "app", "password": "pw"}})
+	require.NoError(t, err)
+	require.NoError(t, vault.WithInternalKeys().Put("__n1_canary__", []byte("ok")))
+	require.NoError(t, vault.Put("app/prod/db", login))
+	require.NoError(t, vault.Put("app/prod/blob", []byte{0xff, 0x00}))
+	require.NoError(t, vault.Put("app/prod/api_key", []byte("k3y")))
+	require.NoError(t, vault.Tag("app/prod/api_key", "env=prod"))
+	require.NoError(t, vault.Move("app/prod/api_key", "safebox"))
+	require.NoError(t, vault.Put("app/dev/api_key", []byte("dev")))
+
+	entries, err := Collect(vault, "app/prod/")
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Key: "api_key", Value: "k3y", Scope: "safebox", Labels: []string{"env=prod"}},
+		{Key: "blob", Value: "/wA=", Encoding: EncodingBase64, Scope: "inbox"},
+		{Key: "db", Type: record.TypeLogin, Fields: map[string]string{"user": "app", "password": "pw"}, Scope: "inbox"},
+	}, entries)
+
+	all, err := Collect(vault, "")
+	require.NoError(t, err)
+	assert.Len(t, all, 4, "bookkeeping records are left out")
+}
+
+func TestRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Key: "api_key", Value: "k3y", Scope: "safebox", Labels: []string{"env=prod", "team"}},
+		{Key: "blob", Value: "/wA=", Encoding: EncodingBase64},
+		{Key: "cert", Value: "line one\nline \"two\", with $HOME"},
+		{Key: "db", Type: record.TypeLogin, Fields: map[string]string{"user": "app", "password": "p,w\n"}},
+	}
+	for _, format := range []Format{FormatJSON, FormatYAML, FormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, format, entries))
+			read, err := Read(&buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, entries, read)
+			for _, e := range read {
+				_, err := e.Bytes()
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		for _, format := range Formats {
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, format, nil))
+			read, err := Read(&buf, format)
+			require.NoError(t, err)
+			assert.Empty(t, read)
+		}
+	})
+}
+
+func TestDotenv(t *testing.T) {
+	entries := []Entry{
+		{Key: "API_KEY", Value: "k3y"},
+		{Key: "cert", Value: "line one\nline \"two\" costs $5 # not a comment"},
+		{Key: "db", Type: record.TypeLogin, Fields: map[string]string{"user": "app", "password": "pw"}},
+		{Key: "empty", Value: ""},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatDotenv, entries))
+	assert.Equal(t, `# n1 {"key":"API_KEY"}`+"\nAPI_KEY=k3y\n"+
+		`# n1 {"key":"cert"}`+"\n"+
+		`CERT="line one\nline \"two\" costs \$5 # not a comment"`+"\n"+
+		`# n1 {"key":"db","type":"login","field":"user"}`+"\nDB_USER=app\n"+
+		`# n1 {"key":"db","type":"login","field":"password"}`+"\nDB_PASSWORD=pw\n"+
+		`# n1 {"key":"empty"}`+"\nEMPTY=\"\"\n", buf.String())
+
+	// The origin comments restore keys and structured records
+	read, err := Read(&buf, FormatDotenv)
+	require.NoError(t, err)
+	assert.Equal(t, entries, read)
+
+	read, err = Read(strings.NewReader("# n1 {\"key\":\"db/url\"}\nDB_URL=x\nPLAIN=y\n"), FormatDotenv)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Key: "db/url", Value: "x"}, {Key: "PLAIN", Value: "y"}}, read, "the comment applies to the next variable only")
+	_, err = Read(strings.NewReader("# n1 {\"key\":\"db\",\"type\":\"login\"}\nDB=x\n"), FormatDotenv)
+	assert.Error(t, err, "a typed origin needs a field")
+
+	read, err = Read(strings.NewReader("# comment\nexport A='x y'\nB=plain # trailing\n"), FormatDotenv)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Key: "A", Value: "x y"}, {Key: "B", Value: "plain"}}, read)
+
+	_, err = Read(strings.NewReader("not a variable\n"), FormatDotenv)
+	assert.Error(t, err)
+	assert.Error(t, Write(&buf, FormatDotenv, []Entry{{Key: "a/b", Value: "1"}, {Key: "a-b", Value: "2"}}), "colliding names are refused")
+	assert.Error(t, Write(&buf, FormatDotenv, []Entry{{Key: "blob", Value: "/wA=", Encoding: EncodingBase64}}))
+	assert.Equal(t, "_1PASSWORD_TOKEN", EnvName("1password/token"))
+}
@@ -0,0 +1,244 @@
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// envelopeTag marks a vault value as a structured record. Values without it
+// are treated as opaque bytes, which keeps pre-existing vault entries valid.
+const envelopeTag = "n1/record/v1"
+
+var (
+	// ErrNotRecord is returned when a value is not a structured record
+	ErrNotRecord = errors.New("value is not a structured record")
+	// ErrUnknownType is returned for record types without a schema
+	ErrUnknownType = errors.New("unknown record type")
+	// ErrUnknownField is returned when a field is not part of the type's schema
+	ErrUnknownField = errors.New("unknown field")
+	// ErrMissingField is returned when a required field is empty or absent
+	ErrMissingField = errors.New("missing required field")
+)
+
+// Type identifies the schema of a structured record
+type Type string
+
+// Supported record types
+const (
+	TypeLogin   Type = "login"
+	TypeNote    Type = "note"
+	TypeToken   Type = "token"
+	TypeSSHKey  Type = "ssh-key"
+	TypeTLSCert Type = "tls-cert"
+	TypeCard    Type = "card"
+)
+
+// Field describes a single field of a record schema
+type Field struct {
+	Name     string
+	Required bool
+	// Secret marks fields that should not be echoed unless explicitly requested
+	Secret bool
+}
+
+// schemas lists the fields of every known type, in display order
+var schemas = map[Type][]Field{
+	TypeLogin: {
+		{Name: "user", Required: true},
+		{Name: "password", Required: true, Secret: true},
+		{Name: "url"},
+		{Name: "totp", Secret: true},
+		{Name: "notes"},
+	},
+	TypeNote: {
+		{Name: "title"},
+		{Name: "text", Required: true},
+	},
+	TypeToken: {
+		{Name: "token", Required: true, Secret: true},
+		{Name: "service"},
+		{Name: "expires"},
+		{Name: "notes"},
+	},
+	TypeSSHKey: {
+		{Name: "private_key", Required: true, Secret: true},
+		{Name: "public_key"},
+		{Name: "passphrase", Secret: true},
+		{Name: "comment"},
+	},
+	TypeTLSCert: {
+		{Name: "certificate", Required: true},
+		{Name: "private_key", Secret: true},
+		{Name: "chain"},
+		{Name: "notes"},
+	},
+	TypeCard: {
+		{Name: "holder", Required: true},
+		{Name: "number", Required: true, Secret: true},
+		{Name: "expiry", Required: true},
+		{Name: "cvv", Secret: true},
+		{Name: "pin", Secret: true},
+		{Name: "notes"},
+	},
+}
+
+// Types returns all supported record types in sorted order
+func Types() []Type {
+	types := make([]Type, 0, len(schemas))
+	for t := range schemas {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Schema returns the fields of a record type in display order
+func Schema(t Type) ([]Field, error) {
+	fields, ok := schemas[t]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, t)
+	}
+	return fields, nil
+}
+
+// Record is a typed set of named fields stored inside an encrypted vault value
+type Record struct {
+	Type   Type
+	Fields map[string]string
+}
+
+// New creates an empty record of the given type
+func New(t Type) (*Record, error) {
+	if _, err := Schema(t); err != nil {
+		return nil, err
+	}
+	return &Record{Type: t, Fields: make(map[string]string)}, nil
+}
+
+// Set assigns a field value, rejecting fields outside the schema
+func (r *Record) Set(name, value string) error {
+	fields, err := Schema(r.Type)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f.Name == name {
+			r.Fields[name] = value
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q for type %s", ErrUnknownField, name, r.Type)
+}
+
+// Get returns the value of a field and whether it is set
+func (r *Record) Get(name string) (string, bool) {
+	v, ok := r.Fields[name]
+	return v, ok
+}
+
+// Validate checks the record against its type's schema
+func (r *Record) Validate() error {
+	fields, err := Schema(r.Type)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		known[f.Name] = true
+		if f.Required && r.Fields[f.Name] == "" {
+			return fmt.Errorf("%w %q for type %s", ErrMissingField, f.Name, r.Type)
+		}
+	}
+	for name := range r.Fields {
+		if !known[name] {
+			return fmt.Errorf("%w %q for type %s", ErrUnknownField, name, r.Type)
+		}
+	}
+	return nil
+}
+
+// envelope is the JSON shape of an encoded record
+type envelope struct {
+	Format string            `json:"n1"`
+	Type   Type              `json:"type"`
+	Fields map[string]string `json:"fields"`
+}
+
+// Encode validates the record and serialises it for storage in a vault value
+func Encode(r *Record) ([]byte, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(envelope{Format: envelopeTag, Type: r.Type, Fields: r.Fields})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record: %w", err)
+	}
+	return data, nil
+}
+
+// Decode parses a vault value previously produced by Encode.
+// It returns ErrNotRecord for plain values so callers can fall back to raw bytes.
+func Decode(data []byte) (*Record, error) {
+	if !IsRecord(data) {
+		return nil, ErrNotRecord
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode record: %w", err)
+	}
+	r := &Record{Type: env.Type, Fields: env.Fields}
+	if r.Fields == nil {
+		r.Fields = make(map[string]string)
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// IsRecord reports whether data carries the structured record envelope
+func IsRecord(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return false
+	}
+	var probe struct {
+		Format string `json:"n1"`
+	}
+	if err := json.Unmarshal(trimmed, &probe); err != nil {
+		return false
+	}
+	return probe.Format == envelopeTag
+}
+
+// ParseAssignment splits a "name=value" command-line argument
+func ParseAssignment(s string) (name, value string, err error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid field %q, expected name=value", s)
+	}
+	return name, value, nil
+}
+
+// Format renders the record as "name: value" lines in schema order.
+// Secret fields are masked unless showSecrets is set.
+func Format(r *Record, showSecrets bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "type: %s\n", r.Type)
+	fields, _ := Schema(r.Type)
+	for _, f := range fields {
+		v, ok := r.Fields[f.Name]
+		if !ok {
+			continue
+		}
+		if f.Secret && !showSecrets {
+			v = "********"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", f.Name, v)
+	}
+	return b.String()
+}
@@ -0,0 +1,117 @@
+package record
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	r, err := New(TypeLogin)
+	require.NoError(t, err, "Creating record failed")
+	require.NoError(t, r.Set("user", "alice"))
+	require.NoError(t, r.Set("password", "hunter2"))
+	require.NoError(t, r.Set("url", "https://example.com/a,b"))
+
+	data, err := Encode(r)
+	require.NoError(t, err, "Encode failed")
+	assert.True(t, IsRecord(data), "Encoded value should be recognised as a record")
+
+	decoded, err := Decode(data)
+	require.NoError(t, err, "Decode failed")
+	assert.Equal(t, TypeLogin, decoded.Type)
+	assert.Equal(t, r.Fields, decoded.Fields)
+
+	password, ok := decoded.Get("password")
+	assert.True(t, ok, "password should be set")
+	assert.Equal(t, "hunter2", password)
+}
+
+func TestDecodePlainValue(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value []byte
+	}{
+		{name: "Empty", value: []byte{}},
+		{name: "Plain text", value: []byte("test_value")},
+		{name: "Foreign JSON", value: []byte(`{"user":"alice","password":"x"}`)},
+		{name: "Broken JSON", value: []byte(`{"n1":`)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.False(t, IsRecord(tc.value))
+			_, err := Decode(tc.value)
+			assert.ErrorIs(t, err, ErrNotRecord)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		record  Record
+		wantErr error
+	}{
+		{
+			name:   "Valid note",
+			record: Record{Type: TypeNote, Fields: map[string]string{"text": "hello"}},
+		},
+		{
+			name:    "Unknown type",
+			record:  Record{Type: "diary", Fields: map[string]string{}},
+			wantErr: ErrUnknownType,
+		},
+		{
+			name:    "Missing required field",
+			record:  Record{Type: TypeToken, Fields: map[string]string{"service": "github"}},
+			wantErr: ErrMissingField,
+		},
+		{
+			name:    "Unknown field",
+			record:  Record{Type: TypeNote, Fields: map[string]string{"text": "hi", "colour": "red"}},
+			wantErr: ErrUnknownField,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.record.Validate()
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetRejectsUnknownField(t *testing.T) {
+	r, err := New(TypeCard)
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.Set("username", "bob"), ErrUnknownField)
+}
+
+func TestFormatMasksSecrets(t *testing.T) {
+	r := &Record{Type: TypeLogin, Fields: map[string]string{"user": "alice", "password": "hunter2"}}
+
+	masked := Format(r, false)
+	assert.Contains(t, masked, "user: alice")
+	assert.NotContains(t, masked, "hunter2")
+
+	shown := Format(r, true)
+	assert.Contains(t, shown, "password: hunter2")
+}
+
+func TestParseAssignment(t *testing.T) {
+	name, value, err := ParseAssignment("url=https://example.com/?a=b")
+	require.NoError(t, err)
+	assert.Equal(t, "url", name)
+	assert.Equal(t, "https://example.com/?a=b", value)
+
+	_, _, err = ParseAssignment("novalue")
+	assert.Error(t, err)
+	_, _, err = ParseAssignment("=value")
+	assert.Error(t, err)
+}
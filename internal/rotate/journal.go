@@ -0,0 +1,132 @@
+package rotate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNoJournal is returned by LoadJournal when no rotation is in progress
+var ErrNoJournal = errors.New("no key rotation in progress")
+
+// Phase is a step of a key rotation, recorded in the journal once complete
+type Phase string
+
+// Rotation phases, in order
+const (
+	// PhaseStarted: the journal exists; nothing else has changed
+	PhaseStarted Phase = "started"
+	// PhaseBackedUp: the vault has been copied to its .bak file
+	PhaseBackedUp Phase = "backed-up"
+	// PhasePopulated: the .tmp file holds the vault re-encrypted with the new key
+	PhasePopulated Phase = "populated"
+	// PhaseKeyStaged: the new key is in the secret store under StagedKeyName
+	PhaseKeyStaged Phase = "key-staged"
+	// PhaseSwapped: the .tmp file has replaced the vault
+	PhaseSwapped Phase = "swapped"
+)
+
+var phases = []Phase{PhaseStarted, PhaseBackedUp, PhasePopulated, PhaseKeyStaged, PhaseSwapped}
+
+// Before reports whether phase p comes before q
+func (p Phase) Before(q Phase) bool {
+	return p.index() < q.index()
+}
+
+func (p Phase) index() int {
+	for i, phase := range phases {
+		if phase == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// Journal records the progress of a key rotation next to the vault. It
+// holds key fingerprints, never keys.
+type Journal struct {
+	Phase Phase `json:"phase"`
+	// OldKey is the fingerprint of the key the vault is rotated away from
+	OldKey string `json:"old_key"`
+	// NewKey is the fingerprint of the key the vault is rotated to
+	NewKey    string    `json:"new_key"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// JournalPath returns the path of the rotation journal of a vault
+func JournalPath(vaultPath string) string {
+	return vaultPath + ".rotation"
+}
+
+// LoadJournal reads the journal of an interrupted rotation of the vault, or
+// returns ErrNoJournal
+func LoadJournal(vaultPath string) (*Journal, error) {
+	data, err := os.ReadFile(JournalPath(vaultPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoJournal
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rotation journal: %w", err)
+	}
+	var j Journal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("failed to parse rotation journal %s: %w", JournalPath(vaultPath), err)
+	}
+	if j.Phase.index() < 0 {
+		return nil, fmt.Errorf("rotation journal %s has unknown phase %q", JournalPath(vaultPath), j.Phase)
+	}
+	return &j, nil
+}
+
+// save atomically replaces the journal of the vault
+func (j *Journal) save(vaultPath string) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := JournalPath(vaultPath)
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write rotation journal: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write rotation journal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync rotation journal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write rotation journal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write rotation journal: %w", err)
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// removeJournal deletes the journal once a rotation is resolved
+func removeJournal(vaultPath string) error {
+	if err := os.Remove(JournalPath(vaultPath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove rotation journal: %w", err)
+	}
+	syncDir(filepath.Dir(vaultPath))
+	return nil
+}
+
+// syncDir makes renames and removals in dir durable where the platform can
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,155 @@
+package rotate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/secretstore"
+	"github.com/n1/n1/internal/sqlite"
+)
+
+// An online rotation keeps the vault in use: it switches the secret store to
+// the new key at once, keeping the old one under RetiredKeyName, and then
+// re-encrypts the vault in place in small transactions while other handles
+// keep reading and writing. The vault records how far the re-encryption got,
+// so an interrupted rotation resumes where it stopped. Once every row is
+// re-encrypted the retired key is deleted.
+
+// DefaultBatchSize is the number of rows an online rotation re-encrypts per
+// transaction
+const DefaultBatchSize = 100
+
+// ErrOnlineRotation is returned when a vault has an unfinished online rotation
+var ErrOnlineRotation = errors.New("an online key rotation is still in progress")
+
+// RetiredKeyName names the secret store entry holding the key an online
+// rotation retires, until every row has been re-encrypted
+func RetiredKeyName(vaultPath string) string {
+	return vaultPath + "#retired-key"
+}
+
+// RetiredKey returns the key of the vault's unfinished online rotation, or
+// nil if there is none
+func RetiredKey(vaultPath string, store secretstore.Store) []byte {
+	key, err := store.Get(RetiredKeyName(vaultPath))
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// Online rotates the master key of one vault in place
+type Online struct {
+	path     string
+	store    secretstore.Store
+	batch    int
+	progress func(done, total int64)
+}
+
+// NewOnline returns an Online rotation of the vault at path whose key is
+// kept in store
+func NewOnline(path string, store secretstore.Store) *Online {
+	return &Online{path: path, store: store, batch: DefaultBatchSize}
+}
+
+// BatchSize sets the number of rows re-encrypted per transaction
+func (o *Online) BatchSize(n int) *Online {
+	o.batch = n
+	return o
+}
+
+// OnProgress registers a function receiving the number of rows re-encrypted
+// so far by Run
+func (o *Online) OnProgress(fn func(done, total int64)) *Online {
+	o.progress = fn
+	return o
+}
+
+// Start switches the vault to a freshly generated key, or picks up the
+// rotation already in progress, and returns a DAO that writes with the new
+// key and reads rows under either. db must be a plaintext vault at the
+// current schema version; fully encrypted vault files are rotated offline.
+func (o *Online) Start(db *sql.DB) (*dao.SecureVaultDAO, error) {
+	if j, err := LoadJournal(o.path); err == nil {
+		return nil, fmt.Errorf("%w (stopped after phase %q)", ErrInterrupted, j.Phase)
+	} else if !errors.Is(err, ErrNoJournal) {
+		return nil, err
+	}
+	encrypted, err := sqlite.IsEncrypted(o.path)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		return nil, errors.New("fully encrypted vault files are encrypted as a whole and cannot be rotated online")
+	}
+
+	current, err := o.store.Get(o.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current key from secret store: %w", err)
+	}
+	// The retired key is stored before the new key replaces it, so a crash
+	// in between never loses the key the vault is encrypted with
+	retired := RetiredKey(o.path, o.store)
+	if retired == nil {
+		if err := o.store.Put(RetiredKeyName(o.path), current); err != nil {
+			return nil, fmt.Errorf("failed to keep current key in secret store: %w", err)
+		}
+		retired = current
+	}
+	if crypto.Fingerprint(current) == crypto.Fingerprint(retired) {
+		if current, err = crypto.Generate(32); err != nil {
+			return nil, fmt.Errorf("failed to generate new master key: %w", err)
+		}
+		if err := o.store.Put(o.path, current); err != nil {
+			return nil, fmt.Errorf("failed to store new master key in secret store: %w", err)
+		}
+	}
+
+	vault := dao.NewSecureVaultDAO(db, current).WithRetiredKey(retired)
+	if err := vault.BeginRotation(); err != nil {
+		return nil, err
+	}
+	return vault, nil
+}
+
+// Run re-encrypts the rest of the vault batch by batch until it is done or
+// ctx is cancelled, then deletes the retired key. Each batch commits on its
+// own, so a cancelled or failed run loses at most one batch of work and a
+// later Start and Run carry on from there.
+func (o *Online) Run(ctx context.Context, vault *dao.SecureVaultDAO) error {
+	status, err := vault.Rotation()
+	if err != nil {
+		return err
+	}
+	var total int64
+	if status != nil {
+		total = status.Remaining
+	}
+
+	var migrated int64
+	for done := status == nil; !done; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var n int
+		if n, done, err = vault.Reencrypt(o.batch); err != nil {
+			return fmt.Errorf("failed to re-encrypt vault: %w", err)
+		}
+		// Writes during the rotation can add rows to relink
+		if migrated += int64(n); migrated > total {
+			total = migrated
+		}
+		if o.progress != nil {
+			o.progress(migrated, total)
+		}
+	}
+
+	if err := o.store.Delete(RetiredKeyName(o.path)); err != nil {
+		return fmt.Errorf("failed to remove retired key from secret store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package rotate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnlineRotation(t *testing.T) {
+	path, store := setupVault(t, false)
+	oldKey, _ := store.Get(path)
+	db, err := sqlite.Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var progress []int64
+	o := NewOnline(path, store).BatchSize(2).OnProgress(func(done, total int64) {
+		progress = append(progress, done)
+		assert.LessOrEqual(t, done, total)
+	})
+	vault, err := o.Start(db)
+	require.NoError(t, err)
+	newKey, _ := store.Get(path)
+	assert.NotEqual(t, oldKey, newKey)
+	assert.Equal(t, oldKey, RetiredKey(path, store))
+
+	// The vault stays writable while the rotation runs in the background
+	errs := make(chan error, 1)
+	go func() { errs <- o.Run(context.Background(), vault) }()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, vault.Put(fmt.Sprintf("during-%d", i), []byte("value")))
+	}
+	require.NoError(t, <-errs)
+
+	assert.Nil(t, RetiredKey(path, store), "the retired key is deleted once the rotation ends")
+	assert.NotEmpty(t, progress)
+	requireIntact(t, path, store)
+	assert.Error(t, VerifyKey(path, oldKey))
+	rotated := dao.NewSecureVaultDAO(db, newKey)
+	for i := 0; i < 10; i++ {
+		_, err := rotated.Get(fmt.Sprintf("during-%d", i))
+		require.NoError(t, err)
+	}
+}
+
+func TestOnlineRotationResumes(t *testing.T) {
+	path, store := setupVault(t, false)
+	db, err := sqlite.Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o := NewOnline(path, store).BatchSize(1).OnProgress(func(int64, int64) { cancel() })
+	vault, err := o.Start(db)
+	require.NoError(t, err)
+	require.ErrorIs(t, o.Run(ctx, vault), context.Canceled)
+
+	newKey, _ := store.Get(path)
+	require.NotNil(t, RetiredKey(path, store))
+	assert.ErrorIs(t, New(path, store).Run(), ErrOnlineRotation, "an offline rotation must not start over an online one")
+
+	// Starting again resumes with the same keys
+	vault, err = NewOnline(path, store).Start(db)
+	require.NoError(t, err)
+	assert.Equal(t, newKey, vault.Key())
+	require.NoError(t, NewOnline(path, store).Run(context.Background(), vault))
+	requireIntact(t, path, store)
+}
+
+// TestOnlineRotationAfterCrashBeforeNewKey covers a crash after the current
+// key was kept as the retired key but before the new key was stored
+func TestOnlineRotationAfterCrashBeforeNewKey(t *testing.T) {
+	path, store := setupVault(t, false)
+	oldKey, _ := store.Get(path)
+	require.NoError(t, store.Put(RetiredKeyName(path), oldKey))
+	db, err := sqlite.Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	o := NewOnline(path, store)
+	vault, err := o.Start(db)
+	require.NoError(t, err)
+	assert.NotEqual(t, crypto.Fingerprint(oldKey), crypto.Fingerprint(vault.Key()))
+	require.NoError(t, o.Run(context.Background(), vault))
+	requireIntact(t, path, store)
+}
+
+func TestOnlineRotationRefusesEncryptedVault(t *testing.T) {
+	path, store := setupVault(t, true)
+	key, _ := store.Get(path)
+	db, err := sqlite.OpenEncrypted(path, key, sqlite.DefaultOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = NewOnline(path, store).Start(db)
+	assert.Error(t, err)
+	stored, _ := store.Get(path)
+	assert.Equal(t, key, stored, "a refused rotation leaves the key alone")
+	assert.Nil(t, RetiredKey(path, store))
+}
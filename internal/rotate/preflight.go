@@ -0,0 +1,95 @@
+package rotate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// SpaceFactor is how many times the vault's size a rotation needs on disk:
+// the vault, its backup and the re-encrypted copy
+const SpaceFactor = 3
+
+// LargeVaultSize is the size above which rotating a vault takes long enough
+// to ask before starting
+const LargeVaultSize = 1 << 30
+
+// ErrInsufficientSpace is returned by Preflight when the vault's file system
+// cannot hold the backup and the re-encrypted copy
+var ErrInsufficientSpace = errors.New("insufficient disk space for key rotation")
+
+// errNoDiskSpace is returned by freeSpace where it is not implemented
+var errNoDiskSpace = errors.New("not supported on " + runtime.GOOS)
+
+// errUnmeasured wraps the reason free space could not be measured
+var errUnmeasured = errors.New("cannot determine free disk space")
+
+// Space is the disk space a rotation of a vault needs and has
+type Space struct {
+	// Size is the size of the vault file
+	Size int64
+	// Need is the space a rotation needs: SpaceFactor times Size
+	Need uint64
+	// Free is the space available to the vault's directory, if Measured
+	Free     uint64
+	Measured bool
+}
+
+// Enough reports whether the rotation fits, or the free space is unknown
+func (s Space) Enough() bool {
+	return !s.Measured || s.Free >= s.Need
+}
+
+// Large reports whether the vault is larger than LargeVaultSize
+func (s Space) Large() bool {
+	return s.Size > LargeVaultSize
+}
+
+// MeasureSpace measures the vault and the space free next to it. When free
+// space cannot be determined, the error says why and the returned Space is
+// not Measured but still holds the vault's size.
+func MeasureSpace(path string) (Space, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Space{}, fmt.Errorf("cannot access vault at %s: %w", path, err)
+	}
+	s := Space{Size: info.Size(), Need: uint64(info.Size()) * SpaceFactor}
+	free, err := freeSpace(filepath.Dir(path))
+	if err != nil {
+		return s, fmt.Errorf("%w: %v", errUnmeasured, err)
+	}
+	s.Free, s.Measured = free, true
+	return s, nil
+}
+
+// Leftovers returns the backup and temporary files of an earlier rotation of
+// the vault that are still on disk
+func Leftovers(path string) []string {
+	var found []string
+	for _, leftover := range []string{BackupPath(path), TempPath(path)} {
+		if _, err := os.Stat(leftover); err == nil {
+			found = append(found, leftover)
+		}
+	}
+	return found
+}
+
+// Preflight checks that a rotation of the vault can start: no files of an
+// earlier rotation are in the way and the vault's file system can hold the
+// backup and the re-encrypted copy. A free space that cannot be measured is
+// not an error; the returned Space then is not Measured.
+func Preflight(path string) (Space, error) {
+	if leftovers := Leftovers(path); len(leftovers) > 0 {
+		return Space{}, fmt.Errorf("file %s already exists; run 'bosr recover %s' to repair the vault from it", leftovers[0], path)
+	}
+	s, err := MeasureSpace(path)
+	if err != nil && !errors.Is(err, errUnmeasured) {
+		return s, err
+	}
+	if !s.Enough() {
+		return s, fmt.Errorf("%w: need approximately %d bytes, have %d bytes available", ErrInsufficientSpace, s.Need, s.Free)
+	}
+	return s, nil
+}
@@ -0,0 +1,37 @@
+package rotate
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflight(t *testing.T) {
+	path, _ := setupVault(t, false)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	space, err := Preflight(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), space.Size)
+	assert.Equal(t, uint64(info.Size())*SpaceFactor, space.Need)
+	assert.True(t, space.Enough())
+	assert.False(t, space.Large())
+
+	require.NoError(t, os.WriteFile(TempPath(path), []byte("half written"), 0600))
+	assert.Equal(t, []string{TempPath(path)}, Leftovers(path))
+	_, err = Preflight(path)
+	assert.ErrorContains(t, err, "bosr recover "+path)
+
+	_, err = Preflight(path + ".missing")
+	assert.Error(t, err)
+}
+
+func TestSpace(t *testing.T) {
+	assert.True(t, Space{Need: 300}.Enough(), "Unmeasured space does not block a rotation")
+	assert.False(t, Space{Need: 300, Free: 299, Measured: true}.Enough())
+	assert.True(t, Space{Need: 300, Free: 300, Measured: true}.Enough())
+	assert.True(t, Space{Size: LargeVaultSize + 1}.Large())
+}
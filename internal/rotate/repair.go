@@ -0,0 +1,245 @@
+package rotate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/secretstore"
+)
+
+// A rotation that failed before journaling existed, or whose journal no
+// longer resolves, can leave the vault, its .bak and its .tmp file opening
+// with different keys. Inspect works out which known key opens which file
+// and plans how to get back to one vault with its key in the secret store;
+// Repair carries the plan out.
+
+// ErrNoKey is returned by Inspect when no known key opens the vault or any
+// file a rotation left behind
+var ErrNoKey = errors.New("no known key opens the vault, its backup or its temporary file")
+
+// Key sources
+const (
+	SourceStore    = "secret store"
+	SourceStaged   = "staged key"
+	SourceProvided = "provided key"
+)
+
+// Candidate is a key that may open one of the vault's files
+type Candidate struct {
+	Source string
+	Key    []byte
+}
+
+// File is what Inspect found out about one of the vault's files
+type File struct {
+	// Role is "vault", "backup" or "temp"
+	Role   string
+	Path   string
+	Exists bool
+	Size   int64
+	// Source names the candidate that opens the file, if any
+	Source      string
+	Fingerprint string
+	// Err says why no candidate opens an existing file
+	Err error
+
+	key []byte
+}
+
+// Opens reports whether a known key opens the file
+func (f *File) Opens() bool {
+	return f.Source != ""
+}
+
+// Plan is how Repair restores a consistent vault and key
+type Plan struct {
+	Path  string
+	Files []*File
+	// Keep is the file that becomes the vault
+	Keep *File
+	// Aside is set when the vault itself is moved out of the way
+	Aside string
+	// StoreKey is set when the secret store must be switched to Keep's key
+	StoreKey bool
+	// Remove lists the leftover files to delete
+	Remove []string
+	// RemoveStaged is set when the staged key entry must be deleted
+	RemoveStaged bool
+}
+
+// Empty reports whether the vault needs no repair
+func (p *Plan) Empty() bool {
+	return p.Aside == "" && p.Keep.Role == "vault" && !p.StoreKey && len(p.Remove) == 0 && !p.RemoveStaged
+}
+
+// Steps describes the changes Repair makes, in order
+func (p *Plan) Steps() []string {
+	var steps []string
+	if p.Aside != "" {
+		steps = append(steps, fmt.Sprintf("move the unreadable vault aside to %s", p.Aside))
+	}
+	if p.Keep.Role != "vault" {
+		steps = append(steps, fmt.Sprintf("restore the vault from %s", p.Keep.Path))
+	}
+	if p.StoreKey {
+		steps = append(steps, fmt.Sprintf("store key %s (%s) in the secret store", p.Keep.Fingerprint, p.Keep.Source))
+	}
+	for _, path := range p.Remove {
+		steps = append(steps, fmt.Sprintf("remove %s", path))
+	}
+	if p.RemoveStaged {
+		steps = append(steps, "remove the staged key from the secret store")
+	}
+	return steps
+}
+
+// UnreadablePath returns the path an unreadable vault is moved aside to
+func UnreadablePath(vaultPath string) string {
+	return vaultPath + ".unreadable"
+}
+
+// Inspect tries the key in the secret store, a staged rotation key and the
+// provided keys on the vault, its backup and its temporary file, and plans a
+// repair. The vault is kept whenever a known key opens it: before the swap it
+// is the original, after it the rotated copy. Otherwise the backup, then the
+// temporary file, takes its place.
+func Inspect(path string, store secretstore.Store, provided ...[]byte) (*Plan, error) {
+	var candidates []Candidate
+	if key, err := store.Get(path); err == nil {
+		candidates = append(candidates, Candidate{Source: SourceStore, Key: key})
+	}
+	staged, stagedErr := store.Get(StagedKeyName(path))
+	if stagedErr == nil {
+		candidates = append(candidates, Candidate{Source: SourceStaged, Key: staged})
+	}
+	for _, key := range provided {
+		candidates = append(candidates, Candidate{Source: SourceProvided, Key: key})
+	}
+
+	p := &Plan{Path: path, RemoveStaged: stagedErr == nil}
+	for _, f := range []*File{
+		{Role: "vault", Path: path},
+		{Role: "backup", Path: BackupPath(path)},
+		{Role: "temp", Path: TempPath(path)},
+	} {
+		if err := inspectFile(f, candidates); err != nil {
+			return nil, err
+		}
+		p.Files = append(p.Files, f)
+		if p.Keep == nil && f.Opens() {
+			p.Keep = f
+		}
+	}
+	if p.Keep == nil {
+		return p, ErrNoKey
+	}
+
+	vault := p.Files[0]
+	if vault.Exists && !vault.Opens() {
+		p.Aside = UnreadablePath(path)
+		if _, err := os.Stat(p.Aside); err == nil {
+			return nil, fmt.Errorf("file %s already exists; please remove it before proceeding", p.Aside)
+		}
+	}
+	p.StoreKey = p.Keep.Source != SourceStore
+	for _, f := range p.Files[1:] {
+		if f.Exists && f != p.Keep {
+			p.Remove = append(p.Remove, f.Path)
+		}
+	}
+	if _, err := os.Stat(JournalPath(path)); err == nil {
+		p.Remove = append(p.Remove, JournalPath(path))
+	}
+	return p, nil
+}
+
+// inspectFile finds the first candidate that opens f
+func inspectFile(f *File, candidates []Candidate) error {
+	info, err := os.Stat(f.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f.Exists, f.Size = true, info.Size()
+	if len(candidates) == 0 {
+		f.Err = errors.New("no key to try")
+		return nil
+	}
+	for _, c := range candidates {
+		if err := VerifyKey(f.Path, c.Key); err != nil {
+			if f.Err == nil {
+				f.Err = err
+			}
+			continue
+		}
+		f.Source, f.Fingerprint, f.key, f.Err = c.Source, crypto.Fingerprint(c.Key), c.Key, nil
+		return nil
+	}
+	return nil
+}
+
+// Repair carries out a plan made by Inspect. Files are moved before the
+// secret store changes and leftovers are removed last, so an interrupted
+// repair can be inspected and repaired again.
+func Repair(p *Plan, store secretstore.Store) error {
+	if p.Aside != "" {
+		for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+			if err := renameFile(p.Path+suffix, p.Aside+suffix); err != nil {
+				return err
+			}
+		}
+	}
+	if p.Keep.Role != "vault" {
+		// A stale journal or WAL must not be applied to the restored file
+		for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+			if err := removeFile(p.Path + suffix); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(p.Keep.Path, p.Path); err != nil {
+			return fmt.Errorf("failed to restore vault from %s: %w", p.Keep.Path, err)
+		}
+		syncDir(filepath.Dir(p.Path))
+	}
+	if err := VerifyKey(p.Path, p.Keep.key); err != nil {
+		return fmt.Errorf("restored vault does not open with key %s: %w", p.Keep.Fingerprint, err)
+	}
+
+	if p.StoreKey {
+		if err := store.Put(p.Path, p.Keep.key); err != nil {
+			return fmt.Errorf("failed to update master key in secret store: %w", err)
+		}
+	}
+	if p.RemoveStaged {
+		if err := store.Delete(StagedKeyName(p.Path)); err != nil {
+			return fmt.Errorf("failed to remove staged key from secret store: %w", err)
+		}
+	}
+	for _, path := range p.Remove {
+		if path == JournalPath(p.Path) {
+			if err := removeJournal(p.Path); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+			if err := removeFile(path + suffix); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func renameFile(from, to string) error {
+	if err := os.Rename(from, to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to move %s to %s: %w", from, to, err)
+	}
+	return nil
+}
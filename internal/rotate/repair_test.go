@@ -0,0 +1,142 @@
+package rotate
+
+import (
+	"os"
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// crashWithoutJournal stops a rotation after phase and throws its journal
+// away, leaving files behind the way rotations did before journaling
+func crashWithoutJournal(t *testing.T, path string, store *memStore, phase Phase) {
+	t.Helper()
+	r := New(path, store)
+	r.crashAfter = phase
+	require.ErrorIs(t, r.Run(), errCrash)
+	require.NoError(t, os.Remove(JournalPath(path)))
+}
+
+func TestInspectHealthyVault(t *testing.T) {
+	path, store := setupVault(t, false)
+	p, err := Inspect(path, store)
+	require.NoError(t, err)
+	assert.True(t, p.Empty())
+	assert.Empty(t, p.Steps())
+	assert.Equal(t, SourceStore, p.Keep.Source)
+	assert.False(t, p.Files[1].Exists)
+	assert.False(t, p.Files[2].Exists)
+}
+
+func TestRepairLeftoverFiles(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plaintext", true: "encrypted"}[encrypted], func(t *testing.T) {
+			path, store := setupVault(t, encrypted)
+			crashWithoutJournal(t, path, store, PhaseKeyStaged)
+			require.FileExists(t, BackupPath(path))
+			require.FileExists(t, TempPath(path))
+
+			p, err := Inspect(path, store)
+			require.NoError(t, err)
+			assert.Equal(t, "vault", p.Keep.Role)
+			assert.Equal(t, SourceStore, p.Files[1].Source, "the backup opens with the old key")
+			assert.Equal(t, SourceStaged, p.Files[2].Source, "the temporary file opens with the new key")
+			assert.False(t, p.StoreKey)
+			assert.True(t, p.RemoveStaged)
+			assert.Equal(t, []string{BackupPath(path), TempPath(path)}, p.Remove)
+
+			require.NoError(t, Repair(p, store))
+			requireIntact(t, path, store)
+		})
+	}
+}
+
+// TestRepairAfterLostKey covers a swapped vault whose new key never reached
+// the secret store
+func TestRepairAfterLostKey(t *testing.T) {
+	setup := func(t *testing.T) (string, *memStore, []byte) {
+		path, store := setupVault(t, false)
+		crashWithoutJournal(t, path, store, PhaseSwapped)
+		newKey, err := store.Get(StagedKeyName(path))
+		require.NoError(t, err)
+		require.NoError(t, store.Delete(StagedKeyName(path)))
+		return path, store, newKey
+	}
+
+	t.Run("restore backup", func(t *testing.T) {
+		path, store, _ := setup(t)
+		oldKey, _ := store.Get(path)
+
+		p, err := Inspect(path, store)
+		require.NoError(t, err)
+		assert.False(t, p.Files[0].Opens())
+		assert.Error(t, p.Files[0].Err)
+		assert.Equal(t, "backup", p.Keep.Role)
+		assert.Equal(t, UnreadablePath(path), p.Aside)
+		assert.Equal(t, []string{
+			"move the unreadable vault aside to " + UnreadablePath(path),
+			"restore the vault from " + BackupPath(path),
+		}, p.Steps())
+
+		require.NoError(t, Repair(p, store))
+		requireIntact(t, path, store)
+		key, _ := store.Get(path)
+		assert.Equal(t, oldKey, key)
+		assert.FileExists(t, UnreadablePath(path))
+
+		_, err = Inspect(path, store)
+		require.NoError(t, err)
+	})
+
+	t.Run("provided key", func(t *testing.T) {
+		path, store, newKey := setup(t)
+		p, err := Inspect(path, store, newKey)
+		require.NoError(t, err)
+		assert.Equal(t, "vault", p.Keep.Role)
+		assert.Equal(t, SourceProvided, p.Keep.Source)
+		assert.Equal(t, crypto.Fingerprint(newKey), p.Keep.Fingerprint)
+		assert.True(t, p.StoreKey)
+		assert.Empty(t, p.Aside)
+
+		require.NoError(t, Repair(p, store))
+		requireIntact(t, path, store)
+		key, _ := store.Get(path)
+		assert.Equal(t, newKey, key)
+	})
+}
+
+func TestInspectNoKey(t *testing.T) {
+	path, store := setupVault(t, false)
+	other, err := crypto.Generate(32)
+	require.NoError(t, err)
+	store.secrets[path] = other
+
+	p, err := Inspect(path, store)
+	require.ErrorIs(t, err, ErrNoKey)
+	assert.True(t, p.Files[0].Exists)
+	assert.Error(t, p.Files[0].Err)
+}
+
+// TestInspectLegacyVault covers a vault from before seals, which only its
+// records tie to a key
+func TestInspectLegacyVault(t *testing.T) {
+	path, store := setupLegacyVault(t)
+	p, err := Inspect(path, store)
+	require.NoError(t, err)
+	assert.True(t, p.Empty())
+	assert.Equal(t, SourceStore, p.Keep.Source)
+
+	// A leftover backup of the legacy vault is cleaned up like any other
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(BackupPath(path), data, 0600))
+	p, err = Inspect(path, store)
+	require.NoError(t, err)
+	assert.Equal(t, "vault", p.Keep.Role)
+	assert.Equal(t, []string{BackupPath(path)}, p.Remove)
+	require.NoError(t, Repair(p, store))
+	assert.NoFileExists(t, BackupPath(path))
+	require.NoError(t, VerifyKey(path, store.secrets[path]))
+}
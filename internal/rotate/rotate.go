@@ -0,0 +1,372 @@
+// Package rotate replaces the master key of a vault.
+//
+// A rotation backs the vault up to <vault>.bak, re-encrypts it into
+// <vault>.tmp under a new key, stages that key in the secret store, and then
+// swaps the files and keys. Each completed phase is recorded in a journal
+// next to the vault, so a rotation interrupted at any point — a crash, a
+// power loss, a failing disk — is completed or rolled back by Recover instead
+// of leaving the secret store and the vault file out of step.
+//
+// An Online rotation instead re-encrypts the vault in place while it stays
+// in use.
+package rotate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/n1/n1/internal/backup"
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/secretstore"
+	"github.com/n1/n1/internal/sqlite"
+)
+
+// ErrInterrupted is returned by Run while an earlier rotation of the same
+// vault is unresolved
+var ErrInterrupted = errors.New("an interrupted key rotation must be recovered first")
+
+// Outcome says how Recover resolved an interrupted rotation
+type Outcome string
+
+const (
+	// OutcomeNone: no rotation was in progress
+	OutcomeNone Outcome = "none"
+	// OutcomeRolledBack: the vault and its old key were kept
+	OutcomeRolledBack Outcome = "rolled back"
+	// OutcomeCompleted: the vault was switched to the new key
+	OutcomeCompleted Outcome = "completed"
+)
+
+// BackupPath returns the path of the copy taken before rotating
+func BackupPath(vaultPath string) string {
+	return vaultPath + ".bak"
+}
+
+// TempPath returns the path the re-encrypted vault is built at
+func TempPath(vaultPath string) string {
+	return vaultPath + ".tmp"
+}
+
+// StagedKeyName names the secret store entry holding the new key until the
+// vault has been swapped
+func StagedKeyName(vaultPath string) string {
+	return vaultPath + "#rotation-key"
+}
+
+// Rotator rotates the master key of one vault
+type Rotator struct {
+	path     string
+	store    secretstore.Store
+	progress func(done, total int64)
+	phase    func(Phase)
+
+	// crashAfter makes the rotation stop dead once this phase is journaled,
+	// as if the process had died; set by tests only
+	crashAfter Phase
+}
+
+// errCrash is returned when a rotation stops at crashAfter
+var errCrash = errors.New("simulated crash")
+
+// New returns a Rotator for the vault at path whose key is kept in store
+func New(path string, store secretstore.Store) *Rotator {
+	return &Rotator{path: path, store: store}
+}
+
+// OnProgress registers a function receiving the number of rows
+// re-encrypted so far
+func (r *Rotator) OnProgress(fn func(done, total int64)) *Rotator {
+	r.progress = fn
+	return r
+}
+
+// OnPhase registers a function called as each phase is journaled
+func (r *Rotator) OnPhase(fn func(Phase)) *Rotator {
+	r.phase = fn
+	return r
+}
+
+// Run rotates the vault to a freshly generated key. The vault must be at
+// the current schema version, and its integrity should have been verified.
+//
+// A failing rotation is rolled back, or completed if the failure came after
+// the new key was staged. If even that fails, the journal is left behind
+// for Recover.
+func (r *Rotator) Run() error {
+	if j, err := LoadJournal(r.path); err == nil {
+		return fmt.Errorf("%w (stopped after phase %q)", ErrInterrupted, j.Phase)
+	} else if !errors.Is(err, ErrNoJournal) {
+		return err
+	}
+	if RetiredKey(r.path, r.store) != nil {
+		return ErrOnlineRotation
+	}
+	if _, err := Preflight(r.path); err != nil {
+		return err
+	}
+
+	oldKey, err := r.store.Get(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to get current key from secret store: %w", err)
+	}
+	newKey, err := crypto.Generate(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate new master key: %w", err)
+	}
+
+	now := time.Now().UTC()
+	j := &Journal{OldKey: crypto.Fingerprint(oldKey), NewKey: crypto.Fingerprint(newKey), StartedAt: now}
+	err = r.rotate(j, oldKey, newKey)
+	if err == nil || errors.Is(err, errCrash) {
+		return err
+	}
+	if j.Phase == "" {
+		// The journal was never written, so nothing has changed
+		return err
+	}
+	if _, resolveErr := r.resolve(j); resolveErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to resolve the interrupted rotation: %w", resolveErr))
+	}
+	return err
+}
+
+func (r *Rotator) rotate(j *Journal, oldKey, newKey []byte) error {
+	if err := r.checkpoint(j, PhaseStarted); err != nil {
+		return err
+	}
+
+	if err := backup.Create(r.path, BackupPath(r.path), oldKey); err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	if err := r.checkpoint(j, PhaseBackedUp); err != nil {
+		return err
+	}
+
+	if err := r.populate(oldKey, newKey); err != nil {
+		return err
+	}
+	if err := r.checkpoint(j, PhasePopulated); err != nil {
+		return err
+	}
+
+	if err := r.store.Put(StagedKeyName(r.path), newKey); err != nil {
+		return fmt.Errorf("failed to stage new master key in secret store: %w", err)
+	}
+	if err := r.checkpoint(j, PhaseKeyStaged); err != nil {
+		return err
+	}
+
+	return r.finish(j, newKey)
+}
+
+// populate builds the temporary vault: a copy of the vault, page for page,
+// re-encrypted in place column by column of the schema's encrypted columns
+// (see dao.EncryptedColumns). Row IDs, timestamps and every table the
+// rotation knows nothing about are kept as they are. Fully encrypted vaults
+// stay fully encrypted.
+func (r *Rotator) populate(oldKey, newKey []byte) (err error) {
+	encrypted, err := sqlite.IsEncrypted(r.path)
+	if err != nil {
+		return err
+	}
+	original, err := sqlite.OpenFile(r.path, oldKey, sqlite.DefaultOptions())
+	if err != nil {
+		return fmt.Errorf("failed to open vault: %w", err)
+	}
+	defer original.Close()
+
+	var temp *sql.DB
+	if encrypted {
+		temp, err = sqlite.OpenEncrypted(TempPath(r.path), newKey, sqlite.DefaultOptions())
+	} else {
+		temp, err = sqlite.Open(TempPath(r.path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create temporary database: %w", err)
+	}
+	defer func() {
+		if closeErr := temp.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close temporary database: %w", closeErr)
+		}
+	}()
+	if err := sqlite.Copy(temp, original); err != nil {
+		return fmt.Errorf("failed to copy vault into temporary database: %w", err)
+	}
+
+	vault := dao.NewSecureVaultDAO(temp, newKey).WithRetiredKey(oldKey)
+	if err := vault.BeginRotation(); err != nil {
+		return fmt.Errorf("failed to prepare temporary database: %w", err)
+	}
+	status, err := vault.Rotation()
+	if err != nil {
+		return err
+	}
+	var migrated int64
+	for done := false; !done; {
+		var n int
+		if n, done, err = vault.Reencrypt(DefaultBatchSize); err != nil {
+			return fmt.Errorf("failed to re-encrypt temporary database: %w", err)
+		}
+		migrated += int64(n)
+		if r.progress != nil {
+			r.progress(migrated, max(migrated, status.Remaining))
+		}
+	}
+	return nil
+}
+
+// finish swaps the rotated vault in and switches the secret store to the
+// new key. Every step is idempotent, so finish can resume from any crash.
+func (r *Rotator) finish(j *Journal, newKey []byte) error {
+	if j.Phase.Before(PhaseSwapped) {
+		if _, err := os.Stat(TempPath(r.path)); err == nil {
+			if err := os.Rename(TempPath(r.path), r.path); err != nil {
+				return fmt.Errorf("failed to replace original vault with new vault: %w", err)
+			}
+			syncDir(filepath.Dir(r.path))
+		}
+		if err := r.checkpoint(j, PhaseSwapped); err != nil {
+			return err
+		}
+	}
+
+	if err := r.store.Put(r.path, newKey); err != nil {
+		return fmt.Errorf("failed to update master key in secret store: %w", err)
+	}
+	if _, err := r.store.Get(StagedKeyName(r.path)); err == nil {
+		if err := r.store.Delete(StagedKeyName(r.path)); err != nil {
+			return fmt.Errorf("failed to remove staged key from secret store: %w", err)
+		}
+	}
+	if err := removeFile(BackupPath(r.path)); err != nil {
+		return err
+	}
+	return removeJournal(r.path)
+}
+
+// rollBack discards a rotation that has not touched the vault file yet
+func (r *Rotator) rollBack(j *Journal) error {
+	// Before the swap the vault is untouched; make sure of that before
+	// throwing the backup away
+	oldKey, err := r.store.Get(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to get current key from secret store: %w", err)
+	}
+	if crypto.Fingerprint(oldKey) != j.OldKey {
+		return fmt.Errorf("secret store key %s is not the key the rotation started from (%s)", crypto.Fingerprint(oldKey), j.OldKey)
+	}
+	if err := VerifyKey(r.path, oldKey); err != nil {
+		return fmt.Errorf("vault does not open with its key, keeping %s: %w", BackupPath(r.path), err)
+	}
+
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := removeFile(TempPath(r.path) + suffix); err != nil {
+			return err
+		}
+	}
+	if _, err := r.store.Get(StagedKeyName(r.path)); err == nil {
+		if err := r.store.Delete(StagedKeyName(r.path)); err != nil {
+			return fmt.Errorf("failed to remove staged key from secret store: %w", err)
+		}
+	}
+	if err := removeFile(BackupPath(r.path)); err != nil {
+		return err
+	}
+	return removeJournal(r.path)
+}
+
+// Recover resolves an interrupted rotation of the vault, if there is one.
+// Rotations interrupted before the new key was staged are rolled back;
+// later ones are completed, provided the rotated vault opens with the staged
+// key.
+func (r *Rotator) Recover() (Outcome, error) {
+	j, err := LoadJournal(r.path)
+	if errors.Is(err, ErrNoJournal) {
+		return OutcomeNone, nil
+	}
+	if err != nil {
+		return OutcomeNone, err
+	}
+	return r.resolve(j)
+}
+
+func (r *Rotator) resolve(j *Journal) (Outcome, error) {
+	if j.Phase.Before(PhaseKeyStaged) {
+		return OutcomeRolledBack, r.rollBack(j)
+	}
+
+	newKey, err := r.store.Get(StagedKeyName(r.path))
+	if err != nil {
+		// The staged key is only deleted once the new key is stored
+		newKey, err = r.store.Get(r.path)
+		if err != nil {
+			return OutcomeNone, fmt.Errorf("failed to get key from secret store: %w", err)
+		}
+	}
+	if crypto.Fingerprint(newKey) != j.NewKey {
+		return OutcomeNone, fmt.Errorf("staged key of the interrupted rotation is missing from the secret store")
+	}
+
+	rotated := r.path
+	if _, err := os.Stat(TempPath(r.path)); err == nil && j.Phase.Before(PhaseSwapped) {
+		rotated = TempPath(r.path)
+	}
+	if err := VerifyKey(rotated, newKey); err != nil {
+		if rotated == TempPath(r.path) {
+			// The vault itself is still the original
+			return OutcomeRolledBack, r.rollBack(j)
+		}
+		return OutcomeNone, fmt.Errorf("rotated vault does not open with the new key: %w", err)
+	}
+	return OutcomeCompleted, r.finish(j, newKey)
+}
+
+// checkpoint journals that phase is complete
+func (r *Rotator) checkpoint(j *Journal, phase Phase) error {
+	next := *j
+	next.Phase = phase
+	next.UpdatedAt = time.Now().UTC()
+	if err := next.save(r.path); err != nil {
+		return err
+	}
+	*j = next
+	if r.phase != nil {
+		r.phase(phase)
+	}
+	if r.crashAfter == phase {
+		return errCrash
+	}
+	return nil
+}
+
+// VerifyKey checks that key is the master key of the vault file at path,
+// which may be plaintext or fully encrypted, as integrity.Authenticate does
+func VerifyKey(path string, key []byte) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	opts := sqlite.DefaultOptions()
+	opts.ReadOnly = true
+	db, err := sqlite.OpenFile(path, key, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = integrity.Authenticate(db, key)
+	return err
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package rotate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/integrity"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/n1/n1/internal/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errStoreFailure = errors.New("secret store unavailable")
+
+// memStore is an in-memory secret store that can be told to fail writes
+type memStore struct {
+	secrets map[string][]byte
+	failPut string
+}
+
+func (m *memStore) Put(name string, data []byte) error {
+	if name == m.failPut {
+		return errStoreFailure
+	}
+	m.secrets[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memStore) Get(name string) ([]byte, error) {
+	data, ok := m.secrets[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+func (m *memStore) Delete(name string) error {
+	delete(m.secrets, name)
+	return nil
+}
+
+var testRecords = map[string]string{"alpha": "one", "beta": "two", "gamma": "three"}
+
+// setupVault creates a vault holding testRecords and returns its path
+func setupVault(t *testing.T, encrypted bool) (string, *memStore) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vault.db")
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	store := &memStore{secrets: map[string][]byte{path: key}}
+
+	open := sqlite.Open
+	if encrypted {
+		open = func(path string) (*sql.DB, error) { return sqlite.OpenEncrypted(path, key, sqlite.DefaultOptions()) }
+	}
+	db, err := open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, migrations.MigrateVault(db, key))
+	vault := dao.NewSecureVaultDAO(db, key)
+	for k, v := range testRecords {
+		require.NoError(t, vault.Put(k, []byte(v)))
+	}
+	return path, store
+}
+
+// legacySchema is the vault schema written before this release: the vault
+// table and a migrations table without checksums, and no event log or seal
+const legacySchema = `
+	CREATE TABLE _migrations (version INTEGER PRIMARY KEY, description TEXT NOT NULL, applied_at TIMESTAMP NOT NULL);
+	CREATE TABLE vault (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		key TEXT NOT NULL,
+		value BLOB NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE UNIQUE INDEX idx_vault_key ON vault(key);
+	INSERT INTO _migrations (version, description, applied_at) VALUES
+		(1, 'Create vault table', CURRENT_TIMESTAMP),
+		(2, 'Create index on vault key', CURRENT_TIMESTAMP);`
+
+// setupLegacyVault creates a vault holding testRecords in the schema of the
+// previous release and returns its path
+func setupLegacyVault(t *testing.T) (string, *memStore) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vault.db")
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(legacySchema)
+	require.NoError(t, err)
+	for k, v := range testRecords {
+		ciphertext, err := crypto.EncryptBlob(key, []byte(v))
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO vault (key, value) VALUES (?, ?)", k, ciphertext)
+		require.NoError(t, err)
+	}
+	return path, &memStore{secrets: map[string][]byte{path: key}}
+}
+
+// requireIntact checks that the vault opens with the key in the store, holds
+// every record, and that the rotation left nothing behind
+func requireIntact(t *testing.T, path string, store *memStore) {
+	t.Helper()
+	key, err := store.Get(path)
+	require.NoError(t, err)
+	require.NoError(t, VerifyKey(path, key))
+
+	db, err := sqlite.OpenFile(path, key, sqlite.DefaultOptions())
+	require.NoError(t, err)
+	defer db.Close()
+	vault := dao.NewSecureVaultDAO(db, key)
+	for k, v := range testRecords {
+		value, err := vault.Get(k)
+		require.NoError(t, err)
+		assert.Equal(t, v, string(value))
+	}
+
+	for _, leftover := range []string{BackupPath(path), TempPath(path), JournalPath(path)} {
+		assert.NoFileExists(t, leftover)
+	}
+	_, err = store.Get(StagedKeyName(path))
+	assert.Error(t, err, "staged key should be removed")
+}
+
+func TestRotate(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plaintext", true: "encrypted"}[encrypted], func(t *testing.T) {
+			path, store := setupVault(t, encrypted)
+			oldKey, _ := store.Get(path)
+
+			var phases []Phase
+			var progress, total int64
+			r := New(path, store).
+				OnPhase(func(p Phase) { phases = append(phases, p) }).
+				OnProgress(func(done, all int64) { progress, total = done, all })
+			require.NoError(t, r.Run())
+
+			newKey, _ := store.Get(path)
+			assert.NotEqual(t, oldKey, newKey)
+			assert.Error(t, VerifyKey(path, oldKey))
+			assert.Equal(t, []Phase{PhaseStarted, PhaseBackedUp, PhasePopulated, PhaseKeyStaged, PhaseSwapped}, phases)
+			assert.Positive(t, progress)
+			assert.Equal(t, total, progress)
+			requireIntact(t, path, store)
+
+			stillEncrypted, err := sqlite.IsEncrypted(path)
+			require.NoError(t, err)
+			assert.Equal(t, encrypted, stillEncrypted)
+		})
+	}
+}
+
+// TestRecoverAfterCrash kills a rotation after each phase and checks that
+// recovery rolls back or completes it
+func TestRecoverAfterCrash(t *testing.T) {
+	tests := []struct {
+		crashAfter Phase
+		want       Outcome
+	}{
+		{PhaseStarted, OutcomeRolledBack},
+		{PhaseBackedUp, OutcomeRolledBack},
+		{PhasePopulated, OutcomeRolledBack},
+		{PhaseKeyStaged, OutcomeCompleted},
+		{PhaseSwapped, OutcomeCompleted},
+	}
+	for _, encrypted := range []bool{false, true} {
+		for _, tt := range tests {
+			name := string(tt.crashAfter)
+			if encrypted {
+				name += "/encrypted"
+			}
+			t.Run(name, func(t *testing.T) {
+				path, store := setupVault(t, encrypted)
+				oldKey, _ := store.Get(path)
+
+				r := New(path, store)
+				r.crashAfter = tt.crashAfter
+				require.ErrorIs(t, r.Run(), errCrash)
+
+				j, err := LoadJournal(path)
+				require.NoError(t, err)
+				assert.Equal(t, tt.crashAfter, j.Phase)
+				assert.ErrorIs(t, New(path, store).Run(), ErrInterrupted, "a new rotation must not start over an interrupted one")
+
+				outcome, err := New(path, store).Recover()
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, outcome)
+				requireIntact(t, path, store)
+
+				key, _ := store.Get(path)
+				if tt.want == OutcomeRolledBack {
+					assert.Equal(t, oldKey, key)
+				} else {
+					assert.Equal(t, j.NewKey, crypto.Fingerprint(key))
+				}
+
+				outcome, err = New(path, store).Recover()
+				require.NoError(t, err)
+				assert.Equal(t, OutcomeNone, outcome)
+			})
+		}
+	}
+}
+
+// TestRecoverAfterCrashDuringSwap covers a crash between renaming the
+// temporary vault into place and journaling it
+func TestRecoverAfterCrashDuringSwap(t *testing.T) {
+	path, store := setupVault(t, false)
+	r := New(path, store)
+	r.crashAfter = PhaseSwapped
+	require.ErrorIs(t, r.Run(), errCrash)
+
+	j, err := LoadJournal(path)
+	require.NoError(t, err)
+	j.Phase = PhaseKeyStaged
+	require.NoError(t, j.save(path))
+	require.NoFileExists(t, TempPath(path))
+
+	outcome, err := New(path, store).Recover()
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeCompleted, outcome)
+	requireIntact(t, path, store)
+}
+
+// TestRecoverAfterCrashBeforeStagedKeyRemoved covers a crash after the new
+// key was stored but before the staged copy was removed
+func TestRecoverAfterCrashBeforeStagedKeyRemoved(t *testing.T) {
+	path, store := setupVault(t, false)
+	r := New(path, store)
+	r.crashAfter = PhaseSwapped
+	require.ErrorIs(t, r.Run(), errCrash)
+
+	staged, err := store.Get(StagedKeyName(path))
+	require.NoError(t, err)
+	require.NoError(t, store.Put(path, staged))
+	require.NoError(t, store.Delete(StagedKeyName(path)))
+
+	outcome, err := New(path, store).Recover()
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeCompleted, outcome)
+	requireIntact(t, path, store)
+}
+
+func TestRotateFailureRollsBack(t *testing.T) {
+	path, store := setupVault(t, false)
+	oldKey, _ := store.Get(path)
+	store.failPut = StagedKeyName(path)
+
+	err := New(path, store).Run()
+	require.ErrorIs(t, err, errStoreFailure)
+
+	key, _ := store.Get(path)
+	assert.Equal(t, oldKey, key)
+	requireIntact(t, path, store)
+}
+
+func TestRecoverCorruptedTempRollsBack(t *testing.T) {
+	path, store := setupVault(t, false)
+	oldKey, _ := store.Get(path)
+	r := New(path, store)
+	r.crashAfter = PhaseKeyStaged
+	require.ErrorIs(t, r.Run(), errCrash)
+	require.NoError(t, os.WriteFile(TempPath(path), []byte("torn write"), 0600))
+
+	outcome, err := New(path, store).Recover()
+	require.NoError(t, err)
+	assert.Equal(t, OutcomeRolledBack, outcome)
+	key, _ := store.Get(path)
+	assert.Equal(t, oldKey, key)
+	requireIntact(t, path, store)
+}
+
+// TestRotatePreservesVault checks that a rotated vault is identical to the
+// original except for its ciphertexts and what is derived from them
+func TestRotatePreservesVault(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plaintext", true: "encrypted"}[encrypted], func(t *testing.T) {
+			path, store := setupVault(t, encrypted)
+			oldKey, _ := store.Get(path)
+
+			db, err := sqlite.OpenFile(path, oldKey, sqlite.DefaultOptions())
+			require.NoError(t, err)
+			vault := dao.NewSecureVaultDAO(db, oldKey)
+			require.NoError(t, vault.Tag("alpha", "prod", "env=prod"))
+			require.NoError(t, vault.Link(dao.Link{From: "alpha", To: "beta", Type: dao.LinkCredentialFor, Weight: 1}))
+			require.NoError(t, vault.DefineScope(dao.Scope{Name: "work", Policy: dao.PolicyOpen}))
+			require.NoError(t, vault.Move("gamma", "work"))
+			require.NoError(t, vault.Delete("beta"))
+			// Tables the rotation knows nothing about are kept as well
+			_, err = db.Exec(`CREATE TABLE history (id INTEGER PRIMARY KEY, note TEXT, at TIMESTAMP);
+				INSERT INTO history (id, note, at) VALUES (5, 'imported', '2021-01-02 03:04:05')`)
+			require.NoError(t, err)
+			require.NoError(t, db.Close())
+			before := dumpVault(t, path, oldKey)
+
+			require.NoError(t, New(path, store).Run())
+			newKey, _ := store.Get(path)
+			assert.Equal(t, before, dumpVault(t, path, newKey))
+		})
+	}
+}
+
+func TestVerifyKey(t *testing.T) {
+	other, err := crypto.Generate(32)
+	require.NoError(t, err)
+
+	t.Run("legacy vault", func(t *testing.T) {
+		path, store := setupLegacyVault(t)
+		key, _ := store.Get(path)
+		assert.NoError(t, VerifyKey(path, key), "A vault from before seals opens with its key")
+		assert.Error(t, VerifyKey(path, other))
+	})
+
+	t.Run("stripped seal", func(t *testing.T) {
+		path, store := setupVault(t, false)
+		key, _ := store.Get(path)
+		db, err := sqlite.Open(path)
+		require.NoError(t, err)
+		_, err = db.Exec("DELETE FROM vault_meta WHERE name = 'integrity.seal'; DELETE FROM vault WHERE key = 'beta'")
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+		assert.ErrorIs(t, VerifyKey(path, key), integrity.ErrTampered)
+	})
+}
+
+// dumpVault returns the schema and every row of the vault, with encrypted
+// columns decrypted and columns derived under the key left out
+func dumpVault(t *testing.T, path string, key []byte) map[string][]map[string]interface{} {
+	t.Helper()
+	db, err := sqlite.OpenFile(path, key, sqlite.DefaultOptions())
+	require.NoError(t, err)
+	defer db.Close()
+
+	columns := make(map[string]dao.EncryptedColumn)
+	for _, c := range dao.EncryptedColumns() {
+		columns[c.Table] = c
+	}
+	dump := make(map[string][]map[string]interface{})
+
+	var tables []string
+	rows, err := db.Query("SELECT type, name, tbl_name, COALESCE(sql, '') FROM sqlite_master ORDER BY name")
+	require.NoError(t, err)
+	for rows.Next() {
+		var kind, name, table, schema string
+		require.NoError(t, rows.Scan(&kind, &name, &table, &schema))
+		dump["sqlite_master"] = append(dump["sqlite_master"], map[string]interface{}{"name": name, "sql": schema})
+		if kind == "table" {
+			tables = append(tables, name)
+		}
+	}
+	require.NoError(t, rows.Err())
+	rows.Close()
+
+	for _, table := range tables {
+		rows, err := db.Query("SELECT rowid AS row_id, * FROM " + table + " ORDER BY rowid")
+		require.NoError(t, err)
+		names, err := rows.Columns()
+		require.NoError(t, err)
+		encrypted, isEncrypted := columns[table]
+		for rows.Next() {
+			values := make([]interface{}, len(names))
+			ptrs := make([]interface{}, len(names))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			require.NoError(t, rows.Scan(ptrs...))
+
+			row := make(map[string]interface{})
+			for i, name := range names {
+				row[name] = values[i]
+			}
+			if isEncrypted {
+				assert.Equal(t, crypto.Fingerprint(key), row["key_id"], "%s row %v is not encrypted with the vault key", table, row["row_id"])
+				plaintext, err := crypto.DecryptBlob(key, row[encrypted.Column].([]byte))
+				require.NoError(t, err)
+				row[encrypted.Column] = string(plaintext)
+				delete(row, "key_id")
+				for _, derived := range encrypted.Derived {
+					delete(row, derived)
+				}
+				if encrypted.Rebuilt {
+					delete(row, "row_id")
+				}
+			}
+			if table == "vault_meta" && row["name"] == "integrity.seal" {
+				delete(row, "value")
+			}
+			dump[table] = append(dump[table], row)
+		}
+		require.NoError(t, rows.Err())
+		rows.Close()
+		if encrypted.Rebuilt {
+			sort.Slice(dump[table], func(i, j int) bool {
+				return fmt.Sprint(dump[table][i]) < fmt.Sprint(dump[table][j])
+			})
+		}
+	}
+	return dump
+}
@@ -0,0 +1,108 @@
+package search
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// Embedder turns text into a fixed-size vector such that related texts have a
+// high cosine similarity. Implementations must be deterministic: the same
+// text always yields the same vector.
+type Embedder interface {
+	// Name identifies the model and its parameters. Vectors produced under
+	// different names are not comparable and get recomputed.
+	Name() string
+	// Dimensions is the length of every vector returned by Embed
+	Dimensions() int
+	// Embed returns the L2-normalised vector for text
+	Embed(text string) ([]float32, error)
+}
+
+// DefaultEmbedder is the offline embedder used unless another is configured
+var DefaultEmbedder Embedder = NewHashEmbedder(256)
+
+// HashEmbedder is a pure-Go embedder that needs no model files, network or
+// GPU. It hashes word unigrams and character trigrams into a fixed number of
+// signed buckets (the "hashing trick"), weights them by log term frequency and
+// normalises the result. Trigrams make it tolerant to inflections and typos
+// ("documents" is close to "document"); it captures no deeper meaning.
+type HashEmbedder struct {
+	dims int
+}
+
+// trigramWeight scales character trigram features relative to whole words
+const trigramWeight = 0.5
+
+// NewHashEmbedder creates a HashEmbedder producing vectors of dims dimensions
+func NewHashEmbedder(dims int) *HashEmbedder {
+	return &HashEmbedder{dims: dims}
+}
+
+// Name implements Embedder
+func (h *HashEmbedder) Name() string {
+	return fmt.Sprintf("hash-ngram-v1/%d", h.dims)
+}
+
+// Dimensions implements Embedder
+func (h *HashEmbedder) Dimensions() int {
+	return h.dims
+}
+
+// Embed implements Embedder
+func (h *HashEmbedder) Embed(text string) ([]float32, error) {
+	if h.dims <= 0 {
+		return nil, fmt.Errorf("invalid embedding dimensions %d", h.dims)
+	}
+	features := make(map[string]float64)
+	for _, t := range Tokenize(text) {
+		features["w:"+t.Term]++
+		padded := []rune(" " + t.Term + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			features["c:"+string(padded[i:i+3])] += trigramWeight
+		}
+	}
+
+	vec := make([]float64, h.dims)
+	for feature, count := range features {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(feature))
+		sum := hasher.Sum64()
+		weight := 1 + math.Log(1+count)
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vec[sum%uint64(h.dims)] += weight
+	}
+	return normalise(vec), nil
+}
+
+// Cosine returns the cosine similarity of two vectors of equal length
+func Cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+func normalise(vec []float64) []float32 {
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	out := make([]float32, len(vec))
+	if norm == 0 {
+		return out
+	}
+	for i, v := range vec {
+		out[i] = float32(v / norm)
+	}
+	return out
+}
@@ -0,0 +1,35 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashEmbedder(t *testing.T) {
+	e := NewHashEmbedder(128)
+	assert.Equal(t, "hash-ngram-v1/128", e.Name())
+
+	tax, err := e.Embed("Tax return documents for 2024")
+	require.NoError(t, err)
+	require.Len(t, tax, 128)
+	assert.InDelta(t, 1.0, Cosine(tax, tax), 1e-6, "Vectors should be normalised")
+
+	again, err := e.Embed("Tax return documents for 2024")
+	require.NoError(t, err)
+	assert.Equal(t, tax, again, "Embedding should be deterministic")
+
+	query, err := e.Embed("tax document")
+	require.NoError(t, err)
+	recipe, err := e.Embed("Pasta recipe with basil and garlic")
+	require.NoError(t, err)
+	assert.Greater(t, Cosine(query, tax), Cosine(query, recipe), "Related text should be more similar")
+
+	empty, err := e.Embed("")
+	require.NoError(t, err)
+	assert.Zero(t, Cosine(empty, tax))
+
+	_, err = NewHashEmbedder(0).Embed("x")
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package search
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Random-hyperplane LSH parameters: lshTables independent hash tables, each
+// keyed by an lshBits-bit signature of which side of lshBits random
+// hyperplanes a vector lies on. Two vectors at angle θ get the same bit from
+// a hyperplane with probability 1-θ/π, so similar vectors tend to share
+// buckets in at least one table.
+const (
+	lshTables = 16
+	lshBits   = 8
+	// lshSeed makes the hyperplanes reproducible, so that buckets stored
+	// with a record stay valid across runs
+	lshSeed = 1
+)
+
+// Bucket identifies an LSH bucket: a table and a signature within it
+type Bucket struct {
+	Table     int
+	Signature uint32
+}
+
+// String encodes the bucket for storage
+func (b Bucket) String() string {
+	return fmt.Sprintf("%d/%02x", b.Table, b.Signature)
+}
+
+// LSH hashes vectors of a fixed dimension into buckets for approximate
+// nearest-neighbour search. Indexing a vector stores it under one bucket per
+// table; a query looks up its own buckets and those one bit away (multi-probe)
+// and only scores the vectors found there. Being approximate, it can miss
+// vectors that are similar to the query but share none of the probed buckets.
+type LSH struct {
+	dims   int
+	planes [][][]float32
+}
+
+// NewLSH creates the hyperplanes for vectors of dims dimensions. They depend
+// only on dims, so the buckets of a vector never change.
+func NewLSH(dims int) *LSH {
+	rng := rand.New(rand.NewSource(lshSeed))
+	l := &LSH{dims: dims, planes: make([][][]float32, lshTables)}
+	for t := range l.planes {
+		l.planes[t] = make([][]float32, lshBits)
+		for b := range l.planes[t] {
+			plane := make([]float32, dims)
+			for i := range plane {
+				plane[i] = float32(rng.NormFloat64())
+			}
+			l.planes[t][b] = plane
+		}
+	}
+	return l
+}
+
+// Buckets returns the bucket of vec in every table, or nothing for a vector
+// of the wrong dimension
+func (l *LSH) Buckets(vec []float32) []Bucket {
+	if len(vec) != l.dims {
+		return nil
+	}
+	buckets := make([]Bucket, lshTables)
+	for t := range l.planes {
+		buckets[t] = Bucket{Table: t, Signature: l.signature(t, vec)}
+	}
+	return buckets
+}
+
+// Probes returns the buckets a query for vec looks in: its own bucket in
+// every table and the buckets whose signature differs from it in one bit
+func (l *LSH) Probes(vec []float32) []Bucket {
+	var probes []Bucket
+	for _, b := range l.Buckets(vec) {
+		probes = append(probes, b)
+		for bit := 0; bit < lshBits; bit++ {
+			probes = append(probes, Bucket{Table: b.Table, Signature: b.Signature ^ 1<<bit})
+		}
+	}
+	return probes
+}
+
+func (l *LSH) signature(table int, vec []float32) uint32 {
+	var sig uint32
+	for b, plane := range l.planes[table] {
+		var dot float32
+		for i, v := range vec {
+			dot += plane[i] * v
+		}
+		if dot >= 0 {
+			sig |= 1 << b
+		}
+	}
+	return sig
+}
@@ -0,0 +1,62 @@
+package search
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLSHBuckets(t *testing.T) {
+	const dims = 64
+	rng := rand.New(rand.NewSource(42))
+	random := func() []float64 {
+		v := make([]float64, dims)
+		for i := range v {
+			v[i] = rng.NormFloat64()
+		}
+		return v
+	}
+
+	l := NewLSH(dims)
+	vec := normalise(random())
+	buckets := l.Buckets(vec)
+	require.Len(t, buckets, lshTables)
+	assert.Equal(t, buckets, NewLSH(dims).Buckets(vec), "Buckets should not change between runs")
+	assert.Nil(t, l.Buckets(vec[:dims-1]), "Vectors of the wrong dimension have no buckets")
+
+	probes := make(map[Bucket]bool)
+	for _, b := range l.Probes(vec) {
+		probes[b] = true
+	}
+	for _, b := range buckets {
+		assert.True(t, probes[b], "A query probes its own buckets")
+	}
+
+	// Near vectors share a probed bucket far more often than unrelated ones
+	shares := func(other []float32) bool {
+		for _, b := range l.Buckets(other) {
+			if probes[b] {
+				return true
+			}
+		}
+		return false
+	}
+	near, far := 0, 0
+	for i := 0; i < 200; i++ {
+		noise := random()
+		v := make([]float64, dims)
+		for j := range v {
+			v[j] = float64(vec[j]) + 0.05*noise[j]
+		}
+		if shares(normalise(v)) {
+			near++
+		}
+		if shares(normalise(random())) {
+			far++
+		}
+	}
+	assert.Greater(t, near, 190, "Near vectors should almost always be found")
+	assert.Less(t, far, 120, "Unrelated vectors should mostly be pruned")
+}
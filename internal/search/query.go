@@ -0,0 +1,120 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrEmptyQuery is returned when a query has nothing to match
+	ErrEmptyQuery = errors.New("empty search query")
+)
+
+// Clause is one part of a query. A clause with several terms is a phrase whose
+// terms must appear consecutively; a Prefix clause matches any term starting
+// with its single term; an Exclude clause removes matching records.
+type Clause struct {
+	Terms   []string
+	Prefix  bool
+	Exclude bool
+}
+
+// Phrase reports whether the clause matches a sequence of terms
+func (c Clause) Phrase() bool {
+	return len(c.Terms) > 1
+}
+
+// Query is a parsed search query. Records must match every clause that is not
+// excluded and none that are.
+type Query struct {
+	Clauses []Clause
+}
+
+// Parse reads a query of the form
+//
+//	word "exact phrase" pref* -unwanted -"unwanted phrase"
+//
+// Words are normalised the same way as indexed content.
+func Parse(q string) (*Query, error) {
+	query := &Query{}
+	rest := strings.TrimSpace(q)
+	for rest != "" {
+		var c Clause
+		if strings.HasPrefix(rest, "-") {
+			c.Exclude = true
+			rest = rest[1:]
+		}
+
+		var text string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated phrase in query %q", q)
+			}
+			text, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, " \t\r\n")
+			if end < 0 {
+				end = len(rest)
+			}
+			text, rest = rest[:end], rest[end:]
+			if strings.HasSuffix(text, "*") {
+				c.Prefix = true
+				text = strings.TrimRight(text, "*")
+			}
+		}
+		rest = strings.TrimSpace(rest)
+
+		for _, t := range Tokenize(text) {
+			c.Terms = append(c.Terms, t.Term)
+		}
+		if len(c.Terms) == 0 {
+			continue
+		}
+		if c.Prefix {
+			if len(c.Terms) > 1 {
+				return nil, fmt.Errorf("prefix %q must be a single word", text)
+			}
+			if utf8.RuneCountInString(c.Terms[0]) < MinPrefix {
+				return nil, fmt.Errorf("prefix %q is shorter than %d characters", text, MinPrefix)
+			}
+		}
+		query.Clauses = append(query.Clauses, c)
+	}
+
+	for _, c := range query.Clauses {
+		if !c.Exclude {
+			return query, nil
+		}
+	}
+	return nil, ErrEmptyQuery
+}
+
+// BM25 ranking parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Stats describes the indexed collection for ranking
+type Stats struct {
+	Docs      int
+	AvgLength float64
+}
+
+// BM25 scores a clause matching tf times in a document of docLength terms,
+// where docFreq documents match the clause overall
+func BM25(tf, docFreq, docLength int, s Stats) float64 {
+	if tf == 0 || s.Docs == 0 {
+		return 0
+	}
+	idf := math.Log(1 + (float64(s.Docs)-float64(docFreq)+0.5)/(float64(docFreq)+0.5))
+	norm := 1.0
+	if s.AvgLength > 0 {
+		norm = 1 - bm25B + bm25B*float64(docLength)/s.AvgLength
+	}
+	return idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*norm)
+}
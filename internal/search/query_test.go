@@ -0,0 +1,49 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []Clause
+	}{
+		{"tax", []Clause{{Terms: []string{"tax"}}}},
+		{"Tax  Return", []Clause{{Terms: []string{"tax"}}, {Terms: []string{"return"}}}},
+		{`"tax return" 2024`, []Clause{{Terms: []string{"tax", "return"}}, {Terms: []string{"2024"}}}},
+		{"pass*", []Clause{{Terms: []string{"pass"}, Prefix: true}}},
+		{`db -stage -"old copy"`, []Clause{
+			{Terms: []string{"db"}},
+			{Terms: []string{"stage"}, Exclude: true},
+			{Terms: []string{"old", "copy"}, Exclude: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := Parse(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, q.Clauses)
+		})
+	}
+}
+
+func TestParseRejectsInvalidQueries(t *testing.T) {
+	for _, q := range []string{"", "  ", "-only", `"unterminated`, "a*", "foo-ba*", "!!!"} {
+		_, err := Parse(q)
+		assert.Error(t, err, "Query %q should be rejected", q)
+	}
+	_, err := Parse("-only")
+	assert.ErrorIs(t, err, ErrEmptyQuery)
+}
+
+func TestBM25(t *testing.T) {
+	stats := Stats{Docs: 10, AvgLength: 20}
+	assert.Zero(t, BM25(0, 1, 20, stats))
+	assert.Greater(t, BM25(3, 1, 20, stats), BM25(1, 1, 20, stats), "More occurrences should rank higher")
+	assert.Greater(t, BM25(1, 1, 20, stats), BM25(1, 8, 20, stats), "Rarer terms should rank higher")
+	assert.Greater(t, BM25(1, 1, 10, stats), BM25(1, 1, 40, stats), "Shorter documents should rank higher")
+}
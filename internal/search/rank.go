@@ -0,0 +1,48 @@
+package search
+
+import "sort"
+
+// Neighbour is a vector found by a similarity query
+type Neighbour struct {
+	Key   string
+	Score float64
+}
+
+// Ranking finds the vectors most similar to a query by scoring every vector
+// it is given exactly, such as the candidates an LSH query found
+type Ranking struct {
+	query []float32
+	limit int
+	found []Neighbour
+}
+
+// NewRanking ranks vectors by cosine similarity to query, keeping up to
+// limit results (all of them if limit is not positive)
+func NewRanking(query []float32, limit int) *Ranking {
+	return &Ranking{query: query, limit: limit}
+}
+
+// Add scores vec under key. Vectors of the wrong dimension and vectors that
+// are not positively similar to the query are ignored.
+func (r *Ranking) Add(key string, vec []float32) {
+	if len(vec) != len(r.query) {
+		return
+	}
+	if score := Cosine(r.query, vec); score > 0 {
+		r.found = append(r.found, Neighbour{Key: key, Score: score})
+	}
+}
+
+// Results returns the vectors most similar to the query, best first
+func (r *Ranking) Results() []Neighbour {
+	sort.Slice(r.found, func(i, j int) bool {
+		if r.found[i].Score != r.found[j].Score {
+			return r.found[i].Score > r.found[j].Score
+		}
+		return r.found[i].Key < r.found[j].Key
+	})
+	if r.limit > 0 && len(r.found) > r.limit {
+		return r.found[:r.limit]
+	}
+	return r.found
+}
@@ -0,0 +1,73 @@
+package search
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRankingFindsNearestNeighbours(t *testing.T) {
+	const dims = 64
+	rng := rand.New(rand.NewSource(42))
+	random := func() []float64 {
+		v := make([]float64, dims)
+		for i := range v {
+			v[i] = rng.NormFloat64()
+		}
+		return v
+	}
+
+	// Clusters of vectors scattered around random centres
+	centres := make([][]float64, 10)
+	var keys []string
+	var vectors [][]float32
+	for c := range centres {
+		centres[c] = random()
+		for m := 0; m < 20; m++ {
+			noise := random()
+			v := make([]float64, dims)
+			for i := range v {
+				v[i] = centres[c][i] + 0.2*noise[i]
+			}
+			keys = append(keys, fmt.Sprintf("c%d/m%d", c, m))
+			vectors = append(vectors, normalise(v))
+		}
+	}
+
+	for c, centre := range centres {
+		r := NewRanking(normalise(centre), 5)
+		for i, key := range keys {
+			r.Add(key, vectors[i])
+		}
+		found := r.Results()
+		require.Len(t, found, 5)
+		for _, n := range found {
+			assert.Regexp(t, fmt.Sprintf("^c%d/", c), n.Key, "Neighbours of a centre should come from its cluster")
+		}
+		for i := 1; i < len(found); i++ {
+			assert.GreaterOrEqual(t, found[i-1].Score, found[i].Score, "Results should be sorted by score")
+		}
+	}
+}
+
+func TestRankingIsExact(t *testing.T) {
+	r := NewRanking([]float32{1, 0, 0}, 0)
+	r.Add("xy", normalise([]float64{1, 1, 0}))
+	r.Add("x", []float32{1, 0, 0})
+	r.Add("neg", []float32{-1, 0, 0})
+	r.Add("wrong-dims", []float32{1, 0})
+
+	found := r.Results()
+	require.Len(t, found, 2, "Only positively similar vectors should be returned")
+	assert.Equal(t, "x", found[0].Key)
+	assert.InDelta(t, 1, found[0].Score, 1e-6)
+	assert.Equal(t, "xy", found[1].Key)
+	assert.InDelta(t, 0.7071, found[1].Score, 1e-4)
+
+	r = NewRanking([]float32{1, 0}, 10)
+	r.Add("x", []float32{1, 0, 0})
+	assert.Empty(t, r.Results(), "Queries of the wrong dimension match nothing")
+}
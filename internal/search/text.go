@@ -0,0 +1,123 @@
+// Package search turns vault contents into searchable terms and parses and
+// ranks full-text queries. It holds no state: the index itself lives in the
+// vault database, blinded and encrypted by the dao package.
+package search
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/n1/n1/internal/record"
+)
+
+const (
+	// MinPrefix is the shortest prefix that can be searched for
+	MinPrefix = 2
+	// MaxPrefix is the longest prefix stored in the index. Longer prefix
+	// queries are answered from the stored prefix and checked against the content.
+	MaxPrefix = 12
+	// maxTermLength caps single terms so pathological input cannot bloat the index
+	maxTermLength = 64
+)
+
+// Token is a normalised term and its position within a document
+type Token struct {
+	Term string
+	Pos  int
+}
+
+// Tokenize splits text into lowercase terms made of letters and digits
+func Tokenize(text string) []Token {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]Token, 0, len(fields))
+	for _, f := range fields {
+		term := strings.ToLower(f)
+		if runes := []rune(term); len(runes) > maxTermLength {
+			term = string(runes[:maxTermLength])
+		}
+		tokens = append(tokens, Token{Term: term, Pos: len(tokens)})
+	}
+	return tokens
+}
+
+// Positions groups the positions of each term in tokens
+func Positions(tokens []Token) map[string][]int {
+	positions := make(map[string][]int)
+	for _, t := range tokens {
+		positions[t.Term] = append(positions[t.Term], t.Pos)
+	}
+	return positions
+}
+
+// Prefixes returns the indexable prefixes of a term, from MinPrefix up to
+// MaxPrefix runes, including the term itself when it is short enough
+func Prefixes(term string) []string {
+	runes := []rune(term)
+	var prefixes []string
+	for n := MinPrefix; n <= len(runes) && n <= MaxPrefix; n++ {
+		prefixes = append(prefixes, string(runes[:n]))
+	}
+	return prefixes
+}
+
+// Document returns the text to index for a vault record: the key followed by
+// the value. Structured records contribute their type and non-secret fields,
+// JSON values (such as Holds) their string contents, and anything else is
+// indexed as plain text.
+func Document(key string, value []byte) string {
+	parts := []string{key}
+	switch {
+	case record.IsRecord(value):
+		r, err := record.Decode(value)
+		if err != nil {
+			break
+		}
+		parts = append(parts, string(r.Type))
+		schema, _ := record.Schema(r.Type)
+		for _, f := range schema {
+			if v, ok := r.Get(f.Name); ok && !f.Secret {
+				parts = append(parts, v)
+			}
+		}
+	case json.Valid(value):
+		var v interface{}
+		if err := json.Unmarshal(value, &v); err == nil {
+			parts = append(parts, jsonStrings(v)...)
+		}
+	default:
+		parts = append(parts, string(value))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// jsonStrings collects the string values of a decoded JSON document in a
+// deterministic order
+func jsonStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			out = append(out, jsonStrings(item)...)
+		}
+		return out
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var out []string
+		for _, k := range keys {
+			out = append(out, jsonStrings(v[k])...)
+		}
+		return out
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/n1/n1/internal/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize("Tax return 2024: Müller-Lüdenscheidt, tax!")
+	var terms []string
+	for i, tok := range tokens {
+		assert.Equal(t, i, tok.Pos, "Positions should be consecutive")
+		terms = append(terms, tok.Term)
+	}
+	assert.Equal(t, []string{"tax", "return", "2024", "müller", "lüdenscheidt", "tax"}, terms)
+
+	assert.Equal(t, map[string][]int{"tax": {0, 5}, "return": {1}, "2024": {2}, "müller": {3}, "lüdenscheidt": {4}}, Positions(tokens))
+}
+
+func TestPrefixes(t *testing.T) {
+	assert.Equal(t, []string{"pa", "pas", "pass"}, Prefixes("pass"))
+	assert.Empty(t, Prefixes("a"))
+	assert.Len(t, Prefixes("internationalisation"), MaxPrefix-MinPrefix+1)
+	assert.Equal(t, []string{"mü", "mül"}, Prefixes("mül"), "Prefixes should not split runes")
+}
+
+func TestDocument(t *testing.T) {
+	login, err := record.New(record.TypeLogin)
+	require.NoError(t, err)
+	require.NoError(t, login.Set("user", "alice"))
+	require.NoError(t, login.Set("password", "hunter2"))
+	data, err := record.Encode(login)
+	require.NoError(t, err)
+
+	doc := Document("github", data)
+	assert.Contains(t, doc, "github")
+	assert.Contains(t, doc, "login")
+	assert.Contains(t, doc, "alice")
+	assert.NotContains(t, doc, "hunter2", "Secret fields must not be indexed")
+
+	assert.Equal(t, "hold/1\nremember the milk\nnote", Document("hold/1", []byte(`{"kind":"note","body":{"text":"remember the milk"},"n":1}`)))
+	assert.Equal(t, "plain\njust text", Document("plain", []byte("just text")))
+}
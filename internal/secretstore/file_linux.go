@@ -5,6 +5,9 @@
 package secretstore
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -27,6 +30,12 @@ func (f fileStore) Put(n string, d []byte) error {
 	return os.WriteFile(path, d, 0600)
 }
 
-func (f fileStore) Get(n string) ([]byte, error) { return os.ReadFile(f.path(n)) }
+func (f fileStore) Get(n string) ([]byte, error) {
+	d, err := os.ReadFile(f.path(n))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return d, err
+}
 
 func (f fileStore) Delete(n string) error { return os.Remove(f.path(n)) }
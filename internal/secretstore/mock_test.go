@@ -1,9 +1,5 @@
 package secretstore
 
-import "errors"
-
-var errNotFound = errors.New("secret not found")
-
 type testStore map[string][]byte
 
 func (m testStore) Put(n string, d []byte) error { m[n] = d; return nil }
@@ -11,7 +7,7 @@ func (m testStore) Put(n string, d []byte) error { m[n] = d; return nil }
 func (m testStore) Get(n string) ([]byte, error) {
 	d, ok := m[n]
 	if !ok {
-		return nil, errNotFound
+		return nil, ErrNotFound
 	}
 	return d, nil
 }
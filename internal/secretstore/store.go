@@ -1,5 +1,10 @@
 package secretstore
 
+import "errors"
+
+// ErrNotFound is returned by Get when no secret is stored under the name
+var ErrNotFound = errors.New("secret not found")
+
 type Store interface {
 	Put(name string, data []byte) error
 	Get(name string) ([]byte, error)
@@ -1,6 +1,9 @@
 package secretstore
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestRoundTrip(t *testing.T) {
 	s := testStore{}
@@ -17,7 +20,7 @@ func TestRoundTrip(t *testing.T) {
 	if err := s.Delete(name); err != nil {
 		t.Fatalf("delete: %v", err)
 	}
-	if _, err := s.Get(name); err == nil {
-		t.Fatalf("expected miss after delete")
+	if _, err := s.Get(name); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
 	}
 }
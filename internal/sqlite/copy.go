@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Copy replaces the database of dst with a copy of the database of src,
+// using SQLite's online backup API. The copy is page for page: the schema,
+// every row with its row ID, and header fields such as user_version are
+// kept. Either handle may be a fully encrypted database; an encrypted dst is
+// saved under its own key.
+func Copy(dst, src *sql.DB) error {
+	ctx := context.Background()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to source database: %w", err)
+	}
+	defer srcConn.Close()
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to destination database: %w", err)
+	}
+	defer dstConn.Close()
+
+	return dstConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			to, encrypted := rawConn(d)
+			from, _ := rawConn(s)
+			if to == nil || from == nil {
+				return fmt.Errorf("copying needs SQLite connections, got %T and %T", d, s)
+			}
+			if encrypted != nil {
+				inMemory, err := withoutWAL(from)
+				if err != nil {
+					return err
+				}
+				defer inMemory.Close()
+				from = inMemory
+			}
+
+			backup, err := to.Backup("main", from, "main")
+			if err != nil {
+				return fmt.Errorf("failed to start copying database: %w", err)
+			}
+			if _, err := backup.Step(-1); err != nil {
+				_ = backup.Finish()
+				return fmt.Errorf("failed to copy database: %w", err)
+			}
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("failed to copy database: %w", err)
+			}
+			// The backup API bypasses transactions, so nothing else saves
+			// the copy to the encrypted file
+			if encrypted != nil {
+				return encrypted.persist()
+			}
+			return nil
+		})
+	})
+}
+
+// withoutWAL returns a private in-memory copy of the database of conn that
+// uses a rollback journal. Copying pages from a WAL database would carry
+// over its header's WAL flag, which an in-memory database cannot honour.
+func withoutWAL(conn *sqlite3.SQLiteConn) (*sqlite3.SQLiteConn, error) {
+	image, err := conn.Serialize("main")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize database: %w", err)
+	}
+	if len(image) > 19 {
+		// File format write and read versions: 1 is rollback, 2 is WAL
+		image[18], image[19] = 1, 1
+	}
+	var driver sqlite3.SQLiteDriver
+	c, err := driver.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	inMemory := c.(*sqlite3.SQLiteConn)
+	if err := inMemory.Deserialize(image, "main"); err != nil {
+		inMemory.Close()
+		return nil, fmt.Errorf("failed to load database copy: %w", err)
+	}
+	return inMemory, nil
+}
+
+// rawConn unwraps the SQLite connection of a driver connection
+func rawConn(conn interface{}) (*sqlite3.SQLiteConn, *encryptedConn) {
+	switch c := conn.(type) {
+	case *sqlite3.SQLiteConn:
+		return c, nil
+	case *encryptedConn:
+		return c.SQLiteConn, c
+	}
+	return nil, nil
+}
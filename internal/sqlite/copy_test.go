@@ -0,0 +1,49 @@
+package sqlite
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCopy copies a plaintext database into an encrypted one and back,
+// keeping row IDs the rows do not declare themselves
+func TestCopy(t *testing.T) {
+	dir := t.TempDir()
+	plain, err := Open(filepath.Join(dir, "plain.db"))
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = plain.Exec(`CREATE TABLE secrets (name TEXT);
+		INSERT INTO secrets (rowid, name) VALUES (7, 'bank'), (42, 'mail');
+		PRAGMA user_version = 3`)
+	require.NoError(t, err)
+
+	encryptedPath := filepath.Join(dir, "encrypted.db")
+	encrypted, err := OpenEncrypted(encryptedPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	require.NoError(t, Copy(encrypted, plain))
+	require.NoError(t, encrypted.Close())
+
+	// The copy was saved to the encrypted file
+	otherKey := bytes.Repeat([]byte{0x43}, 32)
+	_, err = OpenEncrypted(encryptedPath, otherKey, DefaultOptions())
+	assert.ErrorIs(t, err, ErrDecrypt)
+	encrypted, err = OpenEncrypted(encryptedPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	defer encrypted.Close()
+
+	copied, err := Open(filepath.Join(dir, "copy.db"))
+	require.NoError(t, err)
+	defer copied.Close()
+	require.NoError(t, Copy(copied, encrypted))
+
+	var version int
+	require.NoError(t, copied.QueryRow("PRAGMA user_version").Scan(&version))
+	assert.Equal(t, 3, version)
+	var name string
+	require.NoError(t, copied.QueryRow("SELECT name FROM secrets WHERE rowid = 42").Scan(&name))
+	assert.Equal(t, "mail", name)
+}
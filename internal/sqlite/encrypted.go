@@ -0,0 +1,430 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/n1/n1/internal/crypto"
+)
+
+// Fully encrypted vaults keep the whole database file encrypted at rest, so
+// that record names, timestamps, row counts and the schema do not leak the
+// way they do from a plaintext file with encrypted values.
+//
+// go-sqlite3 offers no way to plug in a page-encrypting VFS written in Go, so
+// the file holds an AES-GCM encrypted image of the database instead. Opening
+// it decrypts the image into a shared in-memory database (SQLite's memdb VFS);
+// after every committed write the image is encrypted again and atomically
+// replaces the file. Plaintext pages never touch the disk, at the cost of
+// holding the database in memory and rewriting the whole file on each
+// commit: a commit costs time and I/O proportional to the vault's size, not
+// to the change. Saves from different handles are serialized by an exclusive
+// lock on a <path>.lock file beside the database.
+
+// encryptedMagic starts every fully encrypted database file
+var encryptedMagic = []byte("N1ENCDB\x01")
+
+// fileKeyContext derives the file encryption key from the master key
+const fileKeyContext = "n1/sqlite/file/v1"
+
+var (
+	// ErrNotEncrypted is returned by OpenEncrypted for plaintext database files
+	ErrNotEncrypted = errors.New("database file is not fully encrypted")
+	// ErrDecrypt is returned when the file cannot be decrypted with the key
+	ErrDecrypt = errors.New("failed to decrypt database file: wrong key or corrupted file")
+	// ErrConcurrentWrite is returned instead of overwriting an encrypted
+	// database file that another handle changed since it was read
+	ErrConcurrentWrite = errors.New("encrypted database file was changed by another writer")
+)
+
+// IsEncrypted reports whether the file at path is a fully encrypted database.
+// A missing or empty file is not.
+func IsEncrypted(path string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open database file: %w", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, len(encryptedMagic))
+	if _, err := io.ReadFull(f, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read database file header: %w", err)
+	}
+	return bytes.Equal(header, encryptedMagic), nil
+}
+
+// OpenFile opens the database file at path, whether plaintext or fully
+// encrypted; masterKey is only used for the latter. Missing files are created
+// as plaintext databases.
+func OpenFile(path string, masterKey []byte, opts Options) (*sql.DB, error) {
+	encrypted, err := IsEncrypted(path)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		return OpenEncrypted(path, masterKey, opts)
+	}
+	return OpenWith(path, opts)
+}
+
+// OpenEncrypted opens the fully encrypted database file at path, creating it
+// on the first write if it does not exist. The file key is derived from
+// masterKey. JournalMode does not apply: the database lives in memory while
+// open. With opts.ReadOnly the file is never written.
+//
+// Every committed write re-encrypts and rewrites the whole file, so opts
+// that tune page I/O (Synchronous, CacheSizeKiB) do not make commits any
+// cheaper. Only one handle may write to the file at a time; a handle whose
+// file was replaced by another writer fails with ErrConcurrentWrite instead
+// of overwriting it.
+func OpenEncrypted(path string, masterKey []byte, opts Options) (*sql.DB, error) {
+	key, err := crypto.DeriveHKDF(masterKey, fileKeyContext, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive file key: %w", err)
+	}
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return nil, fmt.Errorf("failed to name in-memory database: %w", err)
+	}
+
+	memOpts := opts
+	memOpts.JournalMode = JournalMemory
+	memOpts.ReadOnly = false
+	params := memOpts.params()
+	params.Set("vfs", "memdb")
+
+	db := sql.OpenDB(&encryptedConnector{
+		path:     path,
+		key:      key,
+		readOnly: opts.ReadOnly,
+		// A name starting with "/" shares the memdb between connections
+		dsn: "file:/n1-" + hex.EncodeToString(name) + "?" + params.Encode(),
+	})
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+
+	// The first connection decrypts the file, so a wrong key fails here
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to open encrypted database '%s': %w", path, err)
+	}
+	return db, nil
+}
+
+// encryptedConnector opens connections to the in-memory copy of an encrypted
+// database file. The copy lives as long as at least one connection is open:
+// the first connection loads it from the file, the last one to close saves it.
+type encryptedConnector struct {
+	path     string
+	key      []byte
+	dsn      string
+	readOnly bool
+	driver   sqlite3.SQLiteDriver
+
+	mu    sync.Mutex
+	conns int
+	// image is the digest of the database image last loaded or saved
+	image [sha256.Size]byte
+	// file is the digest of the file contents last read or written, or zero
+	// if the file did not exist
+	file [sha256.Size]byte
+}
+
+func (c *encryptedConnector) Driver() driver.Driver {
+	return &c.driver
+}
+
+func (c *encryptedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	sc := conn.(*sqlite3.SQLiteConn)
+	if c.conns == 0 {
+		if err := c.load(sc); err != nil {
+			_ = sc.Close()
+			return nil, err
+		}
+	}
+	if c.readOnly {
+		if _, err := sc.ExecContext(ctx, "PRAGMA query_only = ON", nil); err != nil {
+			_ = sc.Close()
+			return nil, err
+		}
+	}
+	c.conns++
+	return &encryptedConn{SQLiteConn: sc, connector: c}, nil
+}
+
+// load decrypts the file into the in-memory database of conn
+func (c *encryptedConnector) load(conn *sqlite3.SQLiteConn) error {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) && !c.readOnly {
+		c.image, c.file = [sha256.Size]byte{}, [sha256.Size]byte{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read database file: %w", err)
+	}
+	if len(data) == 0 && !c.readOnly {
+		c.image, c.file = [sha256.Size]byte{}, sha256.Sum256(data)
+		return nil
+	}
+	ciphertext, ok := bytes.CutPrefix(data, encryptedMagic)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotEncrypted, c.path)
+	}
+	image, err := crypto.DecryptBlob(c.key, ciphertext)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+
+	// Deserializing replaces a connection's database with a private one, so
+	// the image is deserialized elsewhere and copied into the shared database
+	src, err := c.driver.Open(":memory:")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := src.(*sqlite3.SQLiteConn).Deserialize(image, "main"); err != nil {
+		return fmt.Errorf("failed to load decrypted database: %w", err)
+	}
+	backup, err := conn.Backup("main", src.(*sqlite3.SQLiteConn), "main")
+	if err != nil {
+		return fmt.Errorf("failed to load decrypted database: %w", err)
+	}
+	if _, err := backup.Step(-1); err != nil {
+		_ = backup.Finish()
+		return fmt.Errorf("failed to load decrypted database: %w", err)
+	}
+	if err := backup.Finish(); err != nil {
+		return fmt.Errorf("failed to load decrypted database: %w", err)
+	}
+
+	// Copying rewrites header fields such as the change counter, so compare
+	// later snapshots with the copy rather than with the file's image
+	loaded, err := snapshot(conn)
+	if err != nil {
+		return err
+	}
+	c.image, c.file = sha256.Sum256(loaded), sha256.Sum256(data)
+	return nil
+}
+
+// save encrypts the in-memory database of conn and replaces the file with
+// it, unless nothing changed. The caller must hold c.mu.
+func (c *encryptedConnector) save(conn *sqlite3.SQLiteConn) error {
+	if c.readOnly {
+		return nil
+	}
+	image, err := snapshot(conn)
+	if err != nil || image == nil {
+		return err
+	}
+	sum := sha256.Sum256(image)
+	if sum == c.image {
+		return nil
+	}
+
+	// Hold the lock from the check until the file is replaced, so another
+	// writer cannot save in between
+	unlock, err := lockDatabase(c.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := os.ReadFile(c.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if c.file != ([sha256.Size]byte{}) {
+			return fmt.Errorf("%w: %s was removed", ErrConcurrentWrite, c.path)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to read database file: %w", err)
+	case sha256.Sum256(current) != c.file:
+		return fmt.Errorf("%w: %s", ErrConcurrentWrite, c.path)
+	}
+
+	ciphertext, err := crypto.EncryptBlob(c.key, image)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt database: %w", err)
+	}
+	data := append(bytes.Clone(encryptedMagic), ciphertext...)
+	if err := replaceFile(c.path, data); err != nil {
+		return err
+	}
+	c.image, c.file = sum, sha256.Sum256(data)
+	return nil
+}
+
+// snapshot serializes the database of conn, or returns nil if it is empty.
+// It holds a read transaction meanwhile, so that no other connection is
+// midway through writing its pages.
+func snapshot(conn *sqlite3.SQLiteConn) (image []byte, err error) {
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, "BEGIN", nil); err != nil {
+		return nil, fmt.Errorf("failed to begin snapshot: %w", err)
+	}
+	defer func() {
+		if _, commitErr := conn.ExecContext(ctx, "COMMIT", nil); commitErr != nil && err == nil {
+			err = fmt.Errorf("failed to end snapshot: %w", commitErr)
+		}
+	}()
+
+	rows, err := conn.QueryContext(ctx, "PRAGMA page_count", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pages: %w", err)
+	}
+	dest := make([]driver.Value, 1)
+	err = rows.Next(dest)
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pages: %w", err)
+	}
+	if pages, _ := dest[0].(int64); pages == 0 {
+		return nil, nil
+	}
+
+	image, err = conn.Serialize("main")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize database: %w", err)
+	}
+	return image, nil
+}
+
+// lockPath returns the path of the file locked while an encrypted database
+// file is saved. The database file itself is replaced on every save, so it
+// cannot carry the lock.
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// lockDatabase takes the exclusive lock serializing saves to the encrypted
+// database file at path and returns the function releasing it
+func lockDatabase(path string) (func(), error) {
+	f, err := os.OpenFile(lockPath(path), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database lock file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock database file: %w", err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// replaceFile atomically replaces path with a new file holding data
+func replaceFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".save-*")
+	if err != nil {
+		return fmt.Errorf("failed to create database file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write database file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync database file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write database file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace database file: %w", err)
+	}
+	// Make the rename itself durable; not every platform can sync directories
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// encryptedConn saves the database after every write it commits
+type encryptedConn struct {
+	*sqlite3.SQLiteConn
+	connector *encryptedConnector
+}
+
+func (c *encryptedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *encryptedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.SQLiteConn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedTx{Tx: tx, conn: c}, nil
+}
+
+func (c *encryptedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	// Statements outside a transaction commit on their own
+	if c.AutoCommit() {
+		if err := c.persist(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (c *encryptedConn) persist() error {
+	c.connector.mu.Lock()
+	defer c.connector.mu.Unlock()
+	return c.connector.save(c.SQLiteConn)
+}
+
+func (c *encryptedConn) Close() error {
+	c.connector.mu.Lock()
+	defer c.connector.mu.Unlock()
+
+	// The in-memory database goes away with its last connection
+	var err error
+	c.connector.conns--
+	if c.connector.conns == 0 {
+		err = c.connector.save(c.SQLiteConn)
+	}
+	return errors.Join(err, c.SQLiteConn.Close())
+}
+
+type encryptedTx struct {
+	driver.Tx
+	conn *encryptedConn
+}
+
+func (t *encryptedTx) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	return t.conn.persist()
+}
@@ -0,0 +1,179 @@
+package sqlite
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMasterKey = bytes.Repeat([]byte{0x42}, 32)
+
+// TestEncryptedRoundTrip verifies that an encrypted database persists across
+// handles while its file reveals neither the schema nor the data.
+func TestEncryptedRoundTrip(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "encrypted.db")
+
+	db, err := OpenEncrypted(dbPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE secrets (name TEXT)")
+	require.NoError(t, err)
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("INSERT INTO secrets (name) VALUES ('bank-account')")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	// Committed writes are on disk before the handle is closed
+	data, err := os.ReadFile(dbPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, encryptedMagic))
+	assert.NotContains(t, string(data), "SQLite format 3")
+	assert.NotContains(t, string(data), "secrets")
+	assert.NotContains(t, string(data), "bank-account")
+	require.NoError(t, db.Close())
+
+	encrypted, err := IsEncrypted(dbPath)
+	require.NoError(t, err)
+	assert.True(t, encrypted)
+
+	db, err = OpenEncrypted(dbPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	var name string
+	require.NoError(t, db.QueryRow("SELECT name FROM secrets").Scan(&name))
+	assert.Equal(t, "bank-account", name)
+	require.NoError(t, db.Close())
+
+	// Reading does not rewrite the file
+	after, err := os.ReadFile(dbPath)
+	require.NoError(t, err)
+	assert.Equal(t, data, after)
+}
+
+func TestEncryptedOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	encryptedPath := filepath.Join(dir, "encrypted.db")
+	db, err := OpenEncrypted(encryptedPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (x)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = OpenEncrypted(encryptedPath, bytes.Repeat([]byte{0x07}, 32), DefaultOptions())
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	plainPath := filepath.Join(dir, "plain.db")
+	plain, err := Open(plainPath)
+	require.NoError(t, err)
+	_, err = plain.Exec("CREATE TABLE t (x)")
+	require.NoError(t, err)
+	require.NoError(t, plain.Close())
+
+	encrypted, err := IsEncrypted(plainPath)
+	require.NoError(t, err)
+	assert.False(t, encrypted)
+	_, err = OpenEncrypted(plainPath, testMasterKey, DefaultOptions())
+	assert.ErrorIs(t, err, ErrNotEncrypted)
+
+	encrypted, err = IsEncrypted(filepath.Join(dir, "missing.db"))
+	require.NoError(t, err)
+	assert.False(t, encrypted)
+}
+
+func TestEncryptedReadOnly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "encrypted.db")
+	opts := DefaultOptions()
+	opts.ReadOnly = true
+	_, err := OpenEncrypted(dbPath, testMasterKey, opts)
+	require.Error(t, err, "read-only handles must not create files")
+
+	db, err := OpenEncrypted(dbPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (x)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	data, err := os.ReadFile(dbPath)
+	require.NoError(t, err)
+
+	ro, err := OpenEncrypted(dbPath, testMasterKey, opts)
+	require.NoError(t, err)
+	var count int
+	require.NoError(t, ro.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	_, err = ro.Exec("INSERT INTO t (x) VALUES (1)")
+	assert.Error(t, err)
+	require.NoError(t, ro.Close())
+
+	after, err := os.ReadFile(dbPath)
+	require.NoError(t, err)
+	assert.Equal(t, data, after)
+}
+
+// TestEncryptedConcurrentWriters verifies that a handle never overwrites
+// changes another handle saved after it was opened.
+func TestEncryptedConcurrentWriters(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "encrypted.db")
+	db, err := OpenEncrypted(dbPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (x)")
+	require.NoError(t, err)
+
+	other, err := OpenEncrypted(dbPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	_, err = other.Exec("INSERT INTO t (x) VALUES ('other')")
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+
+	_, err = db.Exec("INSERT INTO t (x) VALUES ('stale')")
+	assert.ErrorIs(t, err, ErrConcurrentWrite)
+	_ = db.Close()
+
+	db, err = OpenEncrypted(dbPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	defer db.Close()
+	var x string
+	require.NoError(t, db.QueryRow("SELECT x FROM t").Scan(&x))
+	assert.Equal(t, "other", x)
+}
+
+// TestEncryptedConnectionsShareDatabase verifies that every connection in the
+// pool sees the same in-memory database.
+func TestEncryptedConnectionsShareDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "encrypted.db")
+	db, err := OpenEncrypted(dbPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE t (x INTEGER)")
+	require.NoError(t, err)
+
+	const writers, rows = 4, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rows; i++ {
+				if _, err := db.Exec("INSERT INTO t (x) VALUES (?)", i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	db, err = OpenEncrypted(dbPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	defer db.Close()
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count))
+	assert.Equal(t, writers*rows, count)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package sqlite
+
+import "os"
+
+// lockFile is a no-op where flock is unavailable; the digest check in save
+// remains the only guard against concurrent writers there
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package sqlite
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEncryptedSaveHoldsLock verifies that a save checks the file only once
+// it holds the lock, so a writer that replaced the file while holding the
+// lock is detected instead of overwritten.
+func TestEncryptedSaveHoldsLock(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "encrypted.db")
+	db, err := OpenEncrypted(dbPath, testMasterKey, DefaultOptions())
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE t (x)")
+	require.NoError(t, err)
+
+	unlock, err := lockDatabase(dbPath)
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.Exec("INSERT INTO t (x) VALUES ('stale')")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		unlock()
+		t.Fatalf("save did not wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Another writer saves while holding the lock
+	data, err := os.ReadFile(dbPath)
+	require.NoError(t, err)
+	require.NoError(t, replaceFile(dbPath, append(data, 0)))
+	unlock()
+
+	assert.ErrorIs(t, <-done, ErrConcurrentWrite)
+	assert.FileExists(t, lockPath(dbPath))
+}
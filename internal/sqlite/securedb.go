@@ -3,26 +3,117 @@ package sqlite
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	// Ensure the driver is imported. The name "_" means we only want its side effects (registering the driver).
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// Open returns a standard handle to a potentially non-existent SQLite database file.
-// Creates the file if it does not exist. This version does NOT handle encryption.
+// Journal modes. WAL lets readers proceed while a writer commits.
+const (
+	JournalWAL      = "WAL"
+	JournalDelete   = "DELETE"
+	JournalTruncate = "TRUNCATE"
+	// JournalMemory keeps the rollback journal in memory; used for vaults
+	// that live in memory while open (see OpenEncrypted)
+	JournalMemory = "MEMORY"
+)
+
+// Synchronous levels. NORMAL is durable in WAL mode except for the last
+// transactions before a power loss; FULL syncs on every commit.
+const (
+	SyncOff    = "OFF"
+	SyncNormal = "NORMAL"
+	SyncFull   = "FULL"
+)
+
+// Options tunes how a database file is opened. The zero value is not
+// useful; start from DefaultOptions.
+type Options struct {
+	// JournalMode is one of the Journal* constants. It is persistent: once a
+	// file is in WAL mode, every connection uses WAL.
+	JournalMode string
+	// Synchronous is one of the Sync* constants
+	Synchronous string
+	// CacheSizeKiB is the page cache size per connection
+	CacheSizeKiB int
+	// ReadOnly opens an existing file for reading only; writes fail
+	ReadOnly bool
+	// MaxOpenConns caps the connection pool; 0 means unlimited
+	MaxOpenConns int
+	// SecureDelete overwrites deleted content with zeros, so that removed
+	// ciphertexts do not linger in free pages
+	SecureDelete bool
+	// BusyTimeout is how long to wait for another connection's lock
+	BusyTimeout time.Duration
+}
+
+// DefaultOptions returns the options every vault is opened with: WAL with
+// NORMAL sync so readers never block the writer, an 8 MiB page cache, a small
+// connection pool, and secure delete.
+func DefaultOptions() Options {
+	return Options{
+		JournalMode:  JournalWAL,
+		Synchronous:  SyncNormal,
+		CacheSizeKiB: 8 * 1024,
+		MaxOpenConns: 8,
+		SecureDelete: true,
+		BusyTimeout:  5 * time.Second,
+	}
+}
+
+// DSN builds the go-sqlite3 data source name for path. Every connection in
+// the pool applies the same pragmas. Write transactions take the write lock
+// when they begin (BEGIN IMMEDIATE), so two transactions that read before
+// writing wait for each other instead of failing with SQLITE_BUSY.
+func (o Options) DSN(path string) string {
+	return "file:" + path + "?" + o.params().Encode()
+}
+
+// params returns the DSN query parameters for the options
+func (o Options) params() url.Values {
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.FormatInt(o.BusyTimeout.Milliseconds(), 10))
+	params.Set("_foreign_keys", "on")
+	params.Set("_synchronous", strings.ToUpper(o.Synchronous))
+	params.Set("_cache_size", strconv.Itoa(-o.CacheSizeKiB))
+	params.Set("_secure_delete", strconv.FormatBool(o.SecureDelete))
+	if o.ReadOnly {
+		// The journal mode cannot be changed without writing to the file
+		params.Set("mode", "ro")
+		params.Set("_query_only", "true")
+	} else {
+		params.Set("_journal_mode", strings.ToUpper(o.JournalMode))
+		params.Set("_txlock", "immediate")
+	}
+	return params
+}
+
+// Open returns a standard handle to a potentially non-existent SQLite database file,
+// opened with DefaultOptions. Creates the file if it does not exist.
+// This version does NOT handle encryption.
 func Open(path string) (*sql.DB, error) {
-	// Basic DSN for a file-based SQLite database.
-	// Busy timeout is generally a good idea.
-	// Foreign keys are often enabled by default or good practice.
-	dsn := fmt.Sprintf(
-		"file:%s?_busy_timeout=5000&_foreign_keys=on", // Use standard DSN, no encryption params
-		path,
-	)
-
-	db, err := sql.Open("sqlite3", dsn)
+	return OpenWith(path, DefaultOptions())
+}
+
+// OpenReadOnly opens an existing SQLite database file for reading only.
+// Any write through the returned handle fails.
+func OpenReadOnly(path string) (*sql.DB, error) {
+	opts := DefaultOptions()
+	opts.ReadOnly = true
+	return OpenWith(path, opts)
+}
+
+// OpenWith opens a SQLite database file with the given options
+func OpenWith(path string, opts Options) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", opts.DSN(path))
 	if err != nil {
 		return nil, fmt.Errorf("sql open failed: %w", err)
 	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
 
 	// Ping to verify the connection is alive immediately after opening.
 	if err := db.Ping(); err != nil {
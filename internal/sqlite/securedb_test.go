@@ -2,8 +2,13 @@ package sqlite
 
 import (
 	// Import errors potentially if needed for specific error checks later
+	"context"
+	"database/sql"
+	"fmt"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require" // Using testify/require
 )
@@ -46,3 +51,200 @@ func TestPlainOpen(t *testing.T) {
 
 	t.Logf("PlainOpen test completed successfully.")
 }
+
+// TestOpenReadOnly verifies that read-only handles can read but not write,
+// and do not create missing files.
+func TestOpenReadOnly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "readonly_test.db")
+
+	_, err := OpenReadOnly(dbPath)
+	require.Error(t, err, "OpenReadOnly: Opening a missing file should fail")
+
+	db, err := Open(dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE test_table (id INTEGER PRIMARY KEY)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	ro, err := OpenReadOnly(dbPath)
+	require.NoError(t, err, "OpenReadOnly: Opening existing file failed")
+	defer ro.Close()
+
+	var count int
+	require.NoError(t, ro.QueryRow(`SELECT count(*) FROM test_table`).Scan(&count))
+	_, err = ro.Exec(`INSERT INTO test_table (id) VALUES (1)`)
+	require.Error(t, err, "OpenReadOnly: Writing should fail")
+}
+
+// TestDefaultOptionsApplied verifies that every pooled connection carries the
+// configured pragmas.
+func TestDefaultOptionsApplied(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "options_test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	tests := []struct {
+		pragma string
+		want   string
+	}{
+		{"journal_mode", "wal"},
+		{"synchronous", "1"}, // NORMAL
+		{"secure_delete", "1"},
+		{"cache_size", "-8192"},
+		{"foreign_keys", "1"},
+	}
+	// Hold several connections at once so that more than one is checked
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := db.Conn(context.Background())
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		for _, tt := range tests {
+			var got string
+			require.NoError(t, conn.QueryRowContext(context.Background(), "PRAGMA "+tt.pragma).Scan(&got))
+			require.Equal(t, tt.want, got, "PRAGMA %s", tt.pragma)
+		}
+		require.NoError(t, conn.Close())
+	}
+}
+
+// TestConcurrentReadersAndWriter verifies that in WAL mode readers see the
+// last committed state while a writer holds an open transaction, and that
+// concurrent readers and a single writer make progress without errors.
+func TestConcurrentReadersAndWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "concurrency_test.db")
+	writer, err := Open(path)
+	require.NoError(t, err)
+	defer writer.Close()
+	_, err = writer.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, value TEXT NOT NULL)`)
+	require.NoError(t, err)
+
+	// A reader that would block on the writer's lock gives up quickly
+	opts := DefaultOptions()
+	opts.BusyTimeout = 100 * time.Millisecond
+	opts.ReadOnly = true
+	reader, err := OpenWith(path, opts)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	tx, err := writer.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec(`INSERT INTO items (value) VALUES ('uncommitted')`)
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, reader.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&count), "Readers should not block on an open write transaction")
+	require.Equal(t, 0, count, "Readers should not see uncommitted writes")
+	require.NoError(t, tx.Commit())
+
+	const writes = 200
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writes; i++ {
+			tx, err := writer.Begin()
+			if err != nil {
+				errs <- err
+				return
+			}
+			// Read before writing, as the DAO does
+			var n int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&n); err != nil {
+				_ = tx.Rollback()
+				errs <- err
+				return
+			}
+			if _, err := tx.Exec(`INSERT INTO items (value) VALUES (?)`, n); err != nil {
+				_ = tx.Rollback()
+				errs <- err
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := 0
+			for i := 0; i < writes; i++ {
+				var n int
+				if err := reader.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&n); err != nil {
+					errs <- err
+					return
+				}
+				if n < last {
+					errs <- fmt.Errorf("count went backwards from %d to %d", last, n)
+					return
+				}
+				last = n
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&count))
+	require.Equal(t, writes+1, count)
+}
+
+// TestConcurrentWriters verifies that transactions which read before writing
+// are serialised instead of failing with SQLITE_BUSY.
+func TestConcurrentWriters(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "writers_test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE counter (n INTEGER NOT NULL); INSERT INTO counter VALUES (0)`)
+	require.NoError(t, err)
+
+	const workers, increments = 4, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				tx, err := db.Begin()
+				if err != nil {
+					errs <- err
+					return
+				}
+				var n int
+				if err := tx.QueryRow(`SELECT n FROM counter`).Scan(&n); err != nil {
+					_ = tx.Rollback()
+					errs <- err
+					return
+				}
+				if _, err := tx.Exec(`UPDATE counter SET n = ?`, n+1); err != nil {
+					_ = tx.Rollback()
+					errs <- err
+					return
+				}
+				if err := tx.Commit(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	var n int
+	require.NoError(t, db.QueryRow(`SELECT n FROM counter`).Scan(&n))
+	require.Equal(t, workers*increments, n, "No increment should be lost")
+}
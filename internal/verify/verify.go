@@ -0,0 +1,198 @@
+// Package verify checks a whole vault: the SQLite file, the schema and its
+// migrations, the vault metadata, the event log and every encrypted row.
+//
+// Unlike opening a vault, which only decrypts the canary, verifying decrypts
+// every row, so corruption is found before someone needs the secret it hit.
+// The result is a Report that can be written as JSON.
+package verify
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/migrations"
+)
+
+// Checks named in problems
+const (
+	CheckSQLite   = "sqlite"
+	CheckSchema   = "schema"
+	CheckMetadata = "metadata"
+	CheckEvents   = "events"
+	CheckRows     = "rows"
+	CheckSeal     = "seal"
+)
+
+// canaryKey and canaryValue are written by 'bosr init' to verify the key
+const (
+	canaryKey   = "__n1_canary__"
+	canaryValue = "ok"
+)
+
+// Problem is a failed check, or something worth knowing for a warning
+type Problem struct {
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// Schema describes the vault's schema and migration state
+type Schema struct {
+	Version int `json:"version"`
+	Latest  int `json:"latest"`
+	Pending int `json:"pending"`
+	Format  int `json:"format"`
+}
+
+// Report is the result of verifying a vault
+type Report struct {
+	OK bool `json:"ok"`
+	// SQLite holds what PRAGMA integrity_check reported: "ok", or the problems
+	SQLite []string      `json:"sqlite"`
+	Schema Schema        `json:"schema"`
+	Events int64         `json:"events"`
+	Rows   *dao.RowCheck `json:"rows,omitempty"`
+	// Quarantined counts the rows moved into the quarantine table so far
+	Quarantined int64     `json:"quarantined"`
+	Problems    []Problem `json:"problems,omitempty"`
+	Warnings    []Problem `json:"warnings,omitempty"`
+}
+
+// Fail records a failed check
+func (r *Report) Fail(check string, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{Check: check, Message: fmt.Sprintf(format, args...)})
+	r.OK = false
+}
+
+// Warn records something worth knowing that does not fail verification
+func (r *Report) Warn(check string, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, Problem{Check: check, Message: fmt.Sprintf(format, args...)})
+}
+
+// Run verifies the vault, decrypting its rows with workers goroutines (one
+// per CPU if workers is not positive). Failed checks are recorded in the
+// report; an error means the vault could not be verified at all.
+func Run(db *sql.DB, vault *dao.SecureVaultDAO, workers int) (*Report, error) {
+	r := &Report{OK: true}
+	if err := checkSQLite(db, r); err != nil {
+		return nil, err
+	}
+	if err := checkSchema(db, r); err != nil {
+		return nil, err
+	}
+	if err := checkMetadata(vault, r); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if r.Events, err = vault.Events().Count(); err != nil {
+		return nil, err
+	}
+	// Payloads that do not decrypt are reported row by row below
+	if err := vault.Events().Verify(); errors.Is(err, dao.ErrChainBroken) {
+		r.Fail(CheckEvents, "%v", err)
+	}
+
+	if r.Rows, err = vault.CheckRows(workers); err != nil {
+		return nil, err
+	}
+	for _, row := range r.Rows.Unreadable {
+		r.Fail(CheckRows, "%s row %d (%s) is unreadable: %s", row.Table, row.RowID, row.Name, row.Error)
+	}
+	if r.Quarantined, err = vault.Quarantined(); err != nil {
+		return nil, err
+	}
+	if r.Quarantined > 0 {
+		r.Warn(CheckRows, "%d rows are kept in the quarantine table", r.Quarantined)
+	}
+	return r, nil
+}
+
+// checkSQLite runs SQLite's own consistency check of the database file
+func checkSQLite(db *sql.DB, r *Report) error {
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			return fmt.Errorf("failed to read integrity check: %w", err)
+		}
+		r.SQLite = append(r.SQLite, message)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read integrity check: %w", err)
+	}
+	if len(r.SQLite) != 1 || r.SQLite[0] != "ok" {
+		for _, message := range r.SQLite {
+			r.Fail(CheckSQLite, "%s", message)
+		}
+	}
+	return nil
+}
+
+// checkSchema compares the applied migrations with the known ones and the
+// encrypted tables with the ones key rotation knows about
+func checkSchema(db *sql.DB, r *Report) error {
+	runner := migrations.NewVaultRunner(db)
+	compat, err := runner.Compatibility()
+	if err != nil {
+		return fmt.Errorf("failed to inspect migrations: %w", err)
+	}
+	r.Schema = Schema{Version: compat.Version, Latest: compat.Latest, Pending: compat.Pending, Format: compat.Format}
+	if err := compat.Err(); err != nil {
+		r.Warn(CheckSchema, "%v", err)
+	}
+	if compat.Pending > 0 {
+		r.Fail(CheckSchema, "%d migrations are pending", compat.Pending)
+	}
+
+	statuses, err := runner.Status()
+	if err != nil {
+		return fmt.Errorf("failed to inspect migrations: %w", err)
+	}
+	for _, s := range statuses {
+		switch {
+		case s.Applied && s.Drift:
+			r.Fail(CheckSchema, "migration %d (%s) has changed since it was applied", s.Version, s.Description)
+		case s.Cursor != "":
+			r.Fail(CheckSchema, "data migration %d (%s) was interrupted", s.Version, s.Description)
+		}
+	}
+
+	if err := dao.CheckEncryptedColumns(db); err != nil {
+		r.Fail(CheckSchema, "%v", err)
+	}
+	return nil
+}
+
+// checkMetadata checks the format version, canary and rotation state
+func checkMetadata(vault *dao.SecureVaultDAO, r *Report) error {
+	if r.Schema.Format == 0 && r.Schema.Version >= 13 {
+		r.Fail(CheckMetadata, "vault format version is not recorded")
+	} else if r.Schema.Pending == 0 && r.Schema.Format < migrations.FormatVersion {
+		r.Fail(CheckMetadata, "vault format version %d is older than its schema (format %d)", r.Schema.Format, migrations.FormatVersion)
+	}
+
+	canary, err := vault.Get(canaryKey)
+	switch {
+	case errors.Is(err, dao.ErrNotFound):
+		r.Fail(CheckMetadata, "key canary is missing")
+	case err == nil && string(canary) != canaryValue:
+		r.Fail(CheckMetadata, "key canary holds an unexpected value")
+	}
+	// A canary that does not decrypt is reported with the other rows
+
+	rotation, err := vault.Rotation()
+	if err != nil {
+		return err
+	}
+	if rotation != nil {
+		r.Warn(CheckMetadata, "online key rotation started at %s has %d rows left to re-encrypt",
+			rotation.StartedAt.Format("2006-01-02 15:04:05"), rotation.Remaining)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package verify
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/dao"
+	"github.com/n1/n1/internal/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupVault(t *testing.T) (*sql.DB, *dao.SecureVaultDAO) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "vault.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	key, err := crypto.Generate(32)
+	require.NoError(t, err)
+	require.NoError(t, migrations.MigrateVault(db, key))
+	vault := dao.NewSecureVaultDAO(db, key)
+	require.NoError(t, vault.WithInternalKeys().Put("__n1_canary__", []byte("ok")))
+	require.NoError(t, vault.Put("db", []byte("hunter2")))
+	require.NoError(t, vault.Tag("db", "prod"))
+	return db, vault
+}
+
+func TestRunHealthyVault(t *testing.T) {
+	db, vault := setupVault(t)
+	report, err := Run(db, vault, 2)
+	require.NoError(t, err)
+	assert.True(t, report.OK, "problems: %v", report.Problems)
+	assert.Equal(t, []string{"ok"}, report.SQLite)
+	assert.Equal(t, report.Schema.Latest, report.Schema.Version)
+	assert.Equal(t, migrations.FormatVersion, report.Schema.Format)
+	assert.Equal(t, int64(3), report.Events)
+	assert.Equal(t, int64(2), report.Rows.Checked["vault"])
+	assert.Empty(t, report.Warnings)
+}
+
+func TestRunReportsProblems(t *testing.T) {
+	db, vault := setupVault(t)
+	_, err := db.Exec("UPDATE vault SET value = x'00' WHERE key = 'db'")
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM vault WHERE key = '__n1_canary__'")
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE _migrations SET checksum = 'changed' WHERE version = 1")
+	require.NoError(t, err)
+
+	report, err := Run(db, vault, 0)
+	require.NoError(t, err)
+	assert.False(t, report.OK)
+	checks := make(map[string]int)
+	for _, p := range report.Problems {
+		checks[p.Check]++
+	}
+	assert.Equal(t, map[string]int{CheckSchema: 1, CheckMetadata: 1, CheckRows: 1}, checks)
+	require.Len(t, report.Rows.Unreadable, 1)
+	assert.Equal(t, "db", report.Rows.Unreadable[0].Name)
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, false, decoded["ok"])
+	assert.Contains(t, decoded, "problems")
+
+	// Quarantining rebuilds the record from the event log
+	_, err = vault.Quarantine(report.Rows.Unreadable)
+	require.NoError(t, err)
+	report, err = Run(db, vault, 0)
+	require.NoError(t, err)
+	assert.Empty(t, report.Rows.Unreadable)
+	assert.Equal(t, int64(1), report.Quarantined)
+	assert.Len(t, report.Warnings, 1)
+}
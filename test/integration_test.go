@@ -1,12 +1,19 @@
 package test
 
 import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/n1/n1/internal/crypto"
+	"github.com/n1/n1/internal/secretstore"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,14 +25,7 @@ func TestBosrCLI(t *testing.T) {
 		t.Skip("Skipping integration test outside of CI environment")
 	}
 
-	// Find the bosr binary
-	bosrPath := filepath.Join("..", "bin", "bosr")
-	if _, err := os.Stat(bosrPath); os.IsNotExist(err) {
-		// Try to build it
-		buildCmd := exec.Command("go", "build", "-o", bosrPath, "../cmd/bosr")
-		output, err := buildCmd.CombinedOutput()
-		require.NoError(t, err, "Failed to build bosr binary: %s", output)
-	}
+	bosrPath := bosrBinary(t)
 
 	// Create a temporary directory for the test vault
 	tmpDir := t.TempDir()
@@ -79,6 +79,78 @@ func TestBosrCLI(t *testing.T) {
 				assert.Equal(t, "test_value\n", string(output), "Get output should be the stored value")
 			},
 		},
+		{
+			name:    "Put typed record",
+			args:    []string{"put", "--type", "login", "--field", "user=alice", "--field", "password=s3cret", vaultPath, "login_key"},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "stored", "Put output should indicate success")
+			},
+		},
+		{
+			name:    "Get record field",
+			args:    []string{"get", "--field", "password", vaultPath, "login_key"},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Equal(t, "s3cret\n", string(output), "Get --field should print only the field value")
+			},
+		},
+		{
+			name:    "Put typed record with missing field",
+			args:    []string{"put", "--type", "login", "--field", "user=alice", vaultPath, "bad_login"},
+			wantErr: true,
+		},
+		{
+			name:    "Move record to safebox",
+			args:    []string{"mv", "--scope", "safebox", vaultPath, "test_key"},
+			wantErr: false,
+		},
+		{
+			name:    "Put into safebox refused",
+			args:    []string{"put", vaultPath, "test_key", "overwritten"},
+			wantErr: true,
+		},
+		{
+			name:    "Put reserved key refused",
+			args:    []string{"put", vaultPath, "__n1_scope__/evil", "garbage"},
+			wantErr: true,
+		},
+		{
+			name:    "List safebox",
+			args:    []string{"ls", "--scope", "safebox", vaultPath},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "test_key\tsafebox", "Listing should show the moved record")
+				assert.NotContains(t, string(output), "login_key", "Listing should be filtered by scope")
+			},
+		},
+		{
+			name:    "Tag record",
+			args:    []string{"tag", "add", vaultPath, "login_key", "prod", "db", "env=prod"},
+			wantErr: false,
+		},
+		{
+			name:    "List by tags",
+			args:    []string{"ls", "--tag", "prod", "--tag", "db", vaultPath},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "login_key\tinbox\tdb,env=prod,prod", "Listing should show the tagged record with its tags")
+				assert.NotContains(t, string(output), "test_key", "Untagged records should be filtered out")
+			},
+		},
+		{
+			name:    "Link records",
+			args:    []string{"link", "add", "--type", "credential-for", vaultPath, "login_key", "test_key"},
+			wantErr: false,
+		},
+		{
+			name:    "Render graph",
+			args:    []string{"graph", "--dot", vaultPath},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), `"login_key" -> "test_key" [label="credential-for"];`, "DOT output should contain the link")
+			},
+		},
 		{
 			name:    "Key rotate dry-run",
 			args:    []string{"key", "rotate", "--dry-run", vaultPath},
@@ -107,6 +179,178 @@ func TestBosrCLI(t *testing.T) {
 				assert.Equal(t, "test_value\n", string(output), "Get output after rotation should still be the stored value")
 			},
 		},
+		{
+			name:    "Key rotate online",
+			args:    []string{"key", "rotate", "--online", vaultPath},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				outputStr := string(output)
+				assert.Contains(t, outputStr, "Online key rotation started", "Online rotation should switch keys at once")
+				assert.Contains(t, outputStr, "Online key rotation completed successfully", "Online rotation should run to completion")
+			},
+		},
+		{
+			name:    "Get value after online rotation",
+			args:    []string{"get", vaultPath, "test_key"},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Equal(t, "test_value\n", string(output), "Get output after online rotation should still be the stored value")
+			},
+		},
+		{
+			name:    "Open after online rotation",
+			args:    []string{"open", vaultPath},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.NotContains(t, string(output), "online key rotation is unfinished", "The retired key should be gone")
+				assert.Contains(t, string(output), "Key verified", "The vault should open with the new key")
+			},
+		},
+		{
+			name:    "Recover interrupted rotation",
+			args:    []string{"recover", vaultPath},
+			wantErr: false,
+			setup: func(t *testing.T) {
+				// Leave the files of a rotation that died after backing up
+				key, err := secretstore.Default.Get(vaultPath)
+				require.NoError(t, err)
+				journal := fmt.Sprintf(`{"phase":"backed-up","old_key":%q,"new_key":"0000000000000000"}`, crypto.Fingerprint(key))
+				require.NoError(t, os.WriteFile(vaultPath+".rotation", []byte(journal), 0600))
+				data, err := os.ReadFile(vaultPath)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(vaultPath+".bak", data, 0600))
+			},
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "Key rotation rolled back")
+				assert.NoFileExists(t, vaultPath+".rotation")
+				assert.NoFileExists(t, vaultPath+".bak")
+			},
+		},
+		{
+			name:    "Recover without interrupted rotation",
+			args:    []string{"recover", vaultPath},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "nothing to recover")
+			},
+		},
+		{
+			name:    "Search after rotation",
+			args:    []string{"search", vaultPath, "ali*"},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "login_key\t", "Search should find the record by a field prefix")
+				assert.NotContains(t, string(output), "test_key", "Non-matching records should not be returned")
+			},
+		},
+		{
+			name:    "Semantic search",
+			args:    []string{"search", "--semantic", "--limit", "1", vaultPath, "alice login"},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "login_key\t", "Semantic search should rank the login record first")
+			},
+		},
+		{
+			name:    "Tags survive rotation",
+			args:    []string{"ls", "--tag", "env=prod", vaultPath},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "login_key", "Blind-indexed tags should be rebuilt under the new key")
+			},
+		},
+		{
+			name:    "Backlinks survive rotation",
+			args:    []string{"link", "ls", "--backlinks", vaultPath, "test_key"},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "login_key\tcredential-for\t1\ttest_key")
+			},
+		},
+		{
+			name:    "Migration status",
+			args:    []string{"db", "migrate", "status", vaultPath},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "11\tapplied\t", "Every vault migration should be applied")
+				assert.Contains(t, string(output), "12\tapplied\t", "Data migrations should be applied too")
+				assert.NotContains(t, string(output), "pending")
+			},
+		},
+		{
+			name:    "Migrate down",
+			args:    []string{"db", "migrate", "down", "--to", "10", vaultPath},
+			wantErr: false,
+		},
+		{
+			name:    "Migration status after down",
+			args:    []string{"db", "migrate", "status", vaultPath},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "11\tpending\t-\tCreate links table")
+				assert.Contains(t, string(output), "12\tpending\t-\tSeed event log from vault rows")
+			},
+		},
+		{
+			name:    "Migrate down past the event log refused",
+			args:    []string{"db", "migrate", "down", "--to", "2", vaultPath},
+			wantErr: true,
+		},
+		{
+			name:    "Older schema is migrated on open",
+			args:    []string{"get", vaultPath, "test_key"},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.FileExists(t, vaultPath+".schema-v10.bak", "Vault should be backed up before migrating")
+			},
+		},
+		{
+			name:    "Replay after migrating",
+			args:    []string{"events", "replay", vaultPath},
+			wantErr: false,
+		},
+		{
+			name:    "Links rebuilt after migrating",
+			args:    []string{"link", "ls", "--backlinks", vaultPath, "test_key"},
+			wantErr: false,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "login_key\tcredential-for\t1\ttest_key")
+			},
+		},
+		{
+			name:    "Newer schema opens read-only",
+			args:    []string{"get", vaultPath, "test_key"},
+			wantErr: false,
+			setup: func(t *testing.T) {
+				execSQL(t, vaultPath, "INSERT INTO _migrations (version, description, applied_at) VALUES (99, 'From the future', CURRENT_TIMESTAMP)")
+			},
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "test_value")
+			},
+		},
+		{
+			name:    "Newer schema refuses writes",
+			args:    []string{"put", vaultPath, "test_key", "overwritten"},
+			wantErr: true,
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "upgrade n1 to write to it")
+			},
+		},
+		{
+			name:    "Newer format refuses to open",
+			args:    []string{"get", vaultPath, "test_key"},
+			wantErr: true,
+			setup: func(t *testing.T) {
+				execSQL(t, vaultPath, "UPDATE vault_meta SET value = '99' WHERE name = 'format.version'")
+			},
+			check: func(t *testing.T, output []byte) {
+				assert.Contains(t, string(output), "upgrade n1 to open it")
+			},
+			cleanup: func(t *testing.T) {
+				execSQL(t, vaultPath, "UPDATE vault_meta SET value = '1' WHERE name = 'format.version'")
+				execSQL(t, vaultPath, "DELETE FROM _migrations WHERE version = 99")
+			},
+		},
 		{
 			name:    "Open vault after rotation",
 			args:    []string{"open", vaultPath},
@@ -157,3 +401,481 @@ func TestBosrCLI(t *testing.T) {
 		})
 	}
 }
+
+// TestBosrFullEncryption runs a fully encrypted vault through the commands
+// that open, migrate and rewrite the database file
+func TestBosrFullEncryption(t *testing.T) {
+	if os.Getenv("CI") != "true" {
+		t.Skip("Skipping integration test outside of CI environment")
+	}
+	bosrPath := bosrBinary(t)
+	vaultPath := filepath.Join(t.TempDir(), "encrypted_vault.db")
+
+	bosr := func(t *testing.T, args ...string) string {
+		t.Helper()
+		output, err := exec.Command(bosrPath, args...).CombinedOutput()
+		require.NoError(t, err, "bosr %v failed: %s", args, output)
+		return string(output)
+	}
+	assertEncrypted := func(t *testing.T) {
+		t.Helper()
+		data, err := os.ReadFile(vaultPath)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "SQLite format 3")
+		assert.NotContains(t, string(data), "bank_login")
+		assert.NotContains(t, string(data), "CREATE TABLE")
+	}
+
+	assert.Contains(t, bosr(t, "init", "--full-encryption", vaultPath), "Fully encrypted vault file created")
+	bosr(t, "put", vaultPath, "bank_login", "hunter2")
+	assertEncrypted(t)
+	assert.Contains(t, bosr(t, "get", vaultPath, "bank_login"), "hunter2")
+	assert.Contains(t, bosr(t, "open", vaultPath), "Key verified and database accessible")
+	assert.Contains(t, bosr(t, "db", "migrate", "status", vaultPath), "applied")
+
+	bosr(t, "key", "rotate", vaultPath)
+	assertEncrypted(t)
+	assert.Contains(t, bosr(t, "get", vaultPath, "bank_login"), "hunter2")
+	assert.Contains(t, bosr(t, "ls", vaultPath), "bank_login")
+}
+
+// TestBosrBackupRestore backs a vault up, changes it and restores the backup
+func TestBosrBackupRestore(t *testing.T) {
+	if os.Getenv("CI") != "true" {
+		t.Skip("Skipping integration test outside of CI environment")
+	}
+	bosrPath := bosrBinary(t)
+	vaultPath := filepath.Join(t.TempDir(), "backup_vault.db")
+	backupDir := t.TempDir()
+
+	bosr := func(t *testing.T, args ...string) (string, error) {
+		t.Helper()
+		output, err := exec.Command(bosrPath, args...).CombinedOutput()
+		return string(output), err
+	}
+	mustBosr := func(t *testing.T, args ...string) string {
+		t.Helper()
+		output, err := bosr(t, args...)
+		require.NoError(t, err, "bosr %v failed: %s", args, output)
+		return output
+	}
+
+	mustBosr(t, "init", vaultPath)
+	mustBosr(t, "put", vaultPath, "bank_login", "hunter2")
+	assert.Contains(t, mustBosr(t, "backup", vaultPath, backupDir), "Backup written and verified")
+	assert.Contains(t, mustBosr(t, "backup", "--keep-daily", "1", vaultPath, backupDir), "Removed expired backup")
+	backups, err := filepath.Glob(filepath.Join(backupDir, "backup_vault.db.*.backup"))
+	require.NoError(t, err)
+	require.Len(t, backups, 1, "only the newest backup of the day is kept")
+
+	mustBosr(t, "put", vaultPath, "bank_login", "changed")
+	output := mustBosr(t, "restore", backups[0], vaultPath)
+	assert.Contains(t, output, "Backed up the current vault")
+	assert.Contains(t, output, "Vault restored")
+	assert.Contains(t, mustBosr(t, "get", vaultPath, "bank_login"), "hunter2")
+	assert.Contains(t, mustBosr(t, "open", vaultPath), "Key verified")
+	sidecars, err := filepath.Glob(filepath.Join(backupDir, "*-[sw][ha][ml]"))
+	require.NoError(t, err)
+	assert.Empty(t, sidecars, "backing up and restoring leave no journal files behind")
+
+	// Stripping the seal does not get a tampered backup restored
+	stripped := filepath.Join(t.TempDir(), "stripped.backup")
+	data, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(stripped, data, 0600))
+	execSQL(t, stripped, "DELETE FROM vault_meta WHERE name = 'integrity.seal'; DELETE FROM vault WHERE key = 'bank_login'")
+	output, err = bosr(t, "restore", "--no-backup", stripped, vaultPath)
+	assert.Error(t, err)
+	assert.NotContains(t, output, "Vault restored")
+	assert.Contains(t, mustBosr(t, "get", vaultPath, "bank_login"), "hunter2")
+
+	// A backup taken under the old key no longer matches the vault
+	mustBosr(t, "key", "rotate", vaultPath)
+	output, err = bosr(t, "restore", backups[0], vaultPath)
+	assert.Error(t, err)
+	assert.Contains(t, output, "cannot be restored with the vault's key")
+	assert.Contains(t, mustBosr(t, "get", vaultPath, "bank_login"), "hunter2")
+}
+
+func TestBosrExportImport(t *testing.T) {
+	if os.Getenv("CI") != "true" {
+		t.Skip("Skipping integration test outside of CI environment")
+	}
+	bosrPath := bosrBinary(t)
+	dir := t.TempDir()
+	vaultPath := filepath.Join(dir, "export_vault.db")
+	bundlePath := filepath.Join(dir, "vault.age")
+
+	bosr := func(t *testing.T, stdin string, args ...string) (string, error) {
+		t.Helper()
+		cmd := exec.Command(bosrPath, args...)
+		cmd.Stdin = strings.NewReader(stdin)
+		output, err := cmd.CombinedOutput()
+		return string(output), err
+	}
+	mustBosr := func(t *testing.T, stdin string, args ...string) string {
+		t.Helper()
+		output, err := bosr(t, stdin, args...)
+		require.NoError(t, err, "bosr %v failed: %s", args, output)
+		return output
+	}
+
+	mustBosr(t, "", "init", vaultPath)
+	mustBosr(t, "", "put", vaultPath, "bank_login", "hunter2")
+	mustBosr(t, "", "put", vaultPath, "mail", "secret")
+	mustBosr(t, "", "tag", "add", vaultPath, "bank_login", "finance")
+
+	assert.Contains(t, mustBosr(t, "correct horse\n", "export", "--to", bundlePath, "--passphrase", vaultPath), "Vault exported")
+	info, err := os.Stat(bundlePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := os.ReadFile(bundlePath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+
+	// Into a new vault: the whole history is replayed
+	newPath := filepath.Join(dir, "imported.db")
+	_, err = bosr(t, "wrong\n", "import", "--passphrase", bundlePath, newPath)
+	assert.Error(t, err)
+	assert.NoFileExists(t, newPath)
+	assert.Contains(t, mustBosr(t, "correct horse\n", "import", "--passphrase", bundlePath, newPath), "Replayed the bundle's history")
+	assert.Contains(t, mustBosr(t, "", "get", newPath, "bank_login"), "hunter2")
+	assert.Contains(t, mustBosr(t, "", "open", newPath), "Key verified")
+	assert.Contains(t, mustBosr(t, "", "tag", "ls", newPath, "bank_login"), "finance")
+
+	// Into an existing vault, with a conflict
+	mustBosr(t, "", "put", newPath, "bank_login", "changed")
+	mustBosr(t, "correct horse\n", "import", "--passphrase", bundlePath, newPath)
+	assert.Contains(t, mustBosr(t, "", "get", newPath, "bank_login"), "changed", "conflicts are skipped by default")
+	output := mustBosr(t, "correct horse\n", "import", "--passphrase", "--on-conflict", "rename", bundlePath, newPath)
+	assert.Contains(t, output, "bank_login.imported")
+	assert.Contains(t, mustBosr(t, "", "get", newPath, "bank_login.imported"), "hunter2")
+	mustBosr(t, "correct horse\n", "import", "--passphrase", "--on-conflict", "overwrite", bundlePath, newPath)
+	assert.Contains(t, mustBosr(t, "", "get", newPath, "bank_login"), "hunter2")
+}
+
+func TestBosrImportFormats(t *testing.T) {
+	if os.Getenv("CI") != "true" {
+		t.Skip("Skipping integration test outside of CI environment")
+	}
+	bosrPath := bosrBinary(t)
+	dir := t.TempDir()
+	vaultPath := filepath.Join(dir, "imported_vault.db")
+
+	mustBosr := func(t *testing.T, args ...string) string {
+		t.Helper()
+		output, err := exec.Command(bosrPath, args...).CombinedOutput()
+		require.NoError(t, err, "bosr %v failed: %s", args, output)
+		return string(output)
+	}
+
+	export := filepath.Join(dir, "bitwarden.json")
+	require.NoError(t, os.WriteFile(export, []byte(`{"encrypted": false,
+		"folders": [{"id": "f1", "name": "Work"}],
+		"items": [{"type": 1, "name": "GitHub", "folderId": "f1", "login": {"username": "octocat", "password": "hunter2"}}]}`), 0600))
+	assert.Contains(t, mustBosr(t, "import", "--format", "bitwarden", export, vaultPath), "Import complete")
+	output := mustBosr(t, "get", "--show-secrets", vaultPath, "Work/GitHub")
+	assert.Contains(t, output, "type: login")
+	assert.Contains(t, output, "password: hunter2")
+	assert.Contains(t, mustBosr(t, "open", vaultPath), "Key verified")
+
+	// pass entries are decrypted with gpg; the stand-in prints the plaintext file
+	gpg := filepath.Join(dir, "gpg")
+	require.NoError(t, os.WriteFile(gpg, []byte("#!/bin/sh\nfor last; do :; done\ncat \"$last\"\n"), 0700))
+	store := filepath.Join(dir, "password-store")
+	require.NoError(t, os.MkdirAll(filepath.Join(store, "servers"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(store, "servers", "db.gpg"), []byte("s3cret\nuser: postgres\n"), 0600))
+	mustBosr(t, "import", "--format", "pass", "--gpg", gpg, store, vaultPath)
+	assert.Contains(t, mustBosr(t, "get", "--field", "user", vaultPath, "servers/db"), "postgres")
+}
+
+func TestBosrPlaintextExport(t *testing.T) {
+	if os.Getenv("CI") != "true" {
+		t.Skip("Skipping integration test outside of CI environment")
+	}
+	bosrPath := bosrBinary(t)
+	dir := t.TempDir()
+	vaultPath := filepath.Join(dir, "plaintext_vault.db")
+
+	bosr := func(t *testing.T, args ...string) (string, error) {
+		t.Helper()
+		output, err := exec.Command(bosrPath, args...).CombinedOutput()
+		return string(output), err
+	}
+	mustBosr := func(t *testing.T, args ...string) string {
+		t.Helper()
+		output, err := bosr(t, args...)
+		require.NoError(t, err, "bosr %v failed: %s", args, output)
+		return output
+	}
+
+	mustBosr(t, "init", vaultPath)
+	mustBosr(t, "put", vaultPath, "app/prod/db_password", "hunter2")
+	mustBosr(t, "put", vaultPath, "app/prod/api_key", "k3y")
+	mustBosr(t, "put", vaultPath, "app/dev/api_key", "dev")
+	mustBosr(t, "tag", "add", vaultPath, "app/prod/api_key", "billing")
+
+	output, err := bosr(t, "export", "--format", "json", vaultPath)
+	assert.Error(t, err, "plaintext exports need --plaintext")
+	assert.NotContains(t, output, "hunter2")
+
+	jsonPath := filepath.Join(dir, "prod.json")
+	mustBosr(t, "export", "--format", "json", "--plaintext", "--prefix", "app/prod/", "--to", jsonPath, vaultPath)
+	info, err := os.Stat(jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"key": "db_password"`)
+	assert.NotContains(t, string(data), "dev")
+
+	// Existing files, including a leftover partial file, are never overwritten
+	_, err = bosr(t, "export", "--format", "json", "--plaintext", "--to", jsonPath, vaultPath)
+	assert.Error(t, err, "export refuses an existing destination")
+	again, err := os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+	otherPath := filepath.Join(dir, "other.json")
+	require.NoError(t, os.WriteFile(otherPath+".partial", []byte("mine"), 0600))
+	_, err = bosr(t, "export", "--format", "json", "--plaintext", "--to", otherPath, vaultPath)
+	assert.Error(t, err, "export refuses to reuse a partial file it did not create")
+	assert.NoFileExists(t, otherPath)
+	partial, err := os.ReadFile(otherPath + ".partial")
+	require.NoError(t, err)
+	assert.Equal(t, "mine", string(partial))
+
+	// dotenv goes to stdout
+	cmd := exec.Command(bosrPath, "export", "--format", "dotenv", "--plaintext", "--prefix", "app/prod/", vaultPath)
+	stdout, err := cmd.Output()
+	require.NoError(t, err)
+	assert.Equal(t, `# n1 {"key":"api_key"}`+"\nAPI_KEY=k3y\n"+`# n1 {"key":"db_password"}`+"\nDB_PASSWORD=hunter2\n", string(stdout))
+
+	// Importing with the same prefix round-trips
+	newPath := filepath.Join(dir, "roundtrip.db")
+	assert.Contains(t, mustBosr(t, "import", "--format", "json", "--prefix", "app/prod/", jsonPath, newPath), "Import complete")
+	assert.Contains(t, mustBosr(t, "get", newPath, "app/prod/db_password"), "hunter2")
+	assert.Contains(t, mustBosr(t, "tag", "ls", newPath, "app/prod/api_key"), "billing")
+	assert.Contains(t, mustBosr(t, "open", newPath), "Key verified")
+
+	// So does dotenv, keeping the original keys
+	envPath := filepath.Join(dir, "prod.env")
+	require.NoError(t, os.WriteFile(envPath, stdout, 0600))
+	envVault := filepath.Join(dir, "roundtrip_env.db")
+	assert.Contains(t, mustBosr(t, "import", "--format", "dotenv", "--prefix", "app/prod/", envPath, envVault), "Import complete")
+	assert.Contains(t, mustBosr(t, "get", envVault, "app/prod/db_password"), "hunter2")
+}
+
+func TestBosrVerifyQuarantine(t *testing.T) {
+	if os.Getenv("CI") != "true" {
+		t.Skip("Skipping integration test outside of CI environment")
+	}
+	bosrPath := bosrBinary(t)
+	vaultPath := filepath.Join(t.TempDir(), "verify_vault.db")
+
+	mustBosr := func(t *testing.T, args ...string) string {
+		t.Helper()
+		output, err := exec.Command(bosrPath, args...).CombinedOutput()
+		require.NoError(t, err, "bosr %v failed: %s", args, output)
+		return string(output)
+	}
+	verifyJSON := func(t *testing.T, args ...string) (map[string]interface{}, error) {
+		t.Helper()
+		stdout, err := exec.Command(bosrPath, append([]string{"verify", "--json"}, args...)...).Output()
+		var report map[string]interface{}
+		require.NoError(t, json.Unmarshal(stdout, &report), "report: %s", stdout)
+		return report, err
+	}
+
+	mustBosr(t, "init", vaultPath)
+	mustBosr(t, "put", vaultPath, "db_password", "hunter2")
+	mustBosr(t, "put", vaultPath, "api_key", "k3y")
+
+	report, err := verifyJSON(t, vaultPath)
+	require.NoError(t, err)
+	assert.Equal(t, true, report["ok"])
+	assert.Equal(t, []interface{}{"ok"}, report["sqlite"])
+
+	// Corrupt one record behind bosr's back
+	execSQL(t, vaultPath, "UPDATE vault SET value = x'00' WHERE key = 'db_password'")
+	report, err = verifyJSON(t, vaultPath)
+	assert.Error(t, err, "verify fails on unreadable rows")
+	assert.Equal(t, false, report["ok"])
+	rows := report["rows"].(map[string]interface{})
+	unreadable := rows["unreadable"].([]interface{})
+	require.Len(t, unreadable, 1)
+	assert.Equal(t, "db_password", unreadable[0].(map[string]interface{})["name"])
+
+	// Quarantine moves the row aside and rebuilds it from the event log
+	report, err = verifyJSON(t, "--quarantine", vaultPath)
+	require.NoError(t, err, "report: %v", report)
+	assert.Equal(t, true, report["ok"])
+	assert.Equal(t, float64(1), report["quarantined"])
+	assert.Contains(t, mustBosr(t, "get", vaultPath, "db_password"), "hunter2")
+	assert.Contains(t, mustBosr(t, "verify", vaultPath), "Every encrypted row decrypted")
+}
+
+func TestBosrDoctor(t *testing.T) {
+	if os.Getenv("CI") != "true" {
+		t.Skip("Skipping integration test outside of CI environment")
+	}
+	bosrPath := bosrBinary(t)
+	dir := t.TempDir()
+	vaultPath := filepath.Join(dir, "doctor_vault.db")
+
+	bosr := func(t *testing.T, args ...string) (string, error) {
+		t.Helper()
+		output, err := exec.Command(bosrPath, args...).CombinedOutput()
+		return string(output), err
+	}
+
+	_, err := bosr(t, "init", vaultPath)
+	require.NoError(t, err)
+	output, err := bosr(t, "doctor", vaultPath)
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "opens the vault")
+
+	require.NoError(t, os.Chmod(vaultPath, 0644))
+	require.NoError(t, os.WriteFile(vaultPath+".bak", []byte("left over"), 0600))
+	output, err = bosr(t, "doctor", vaultPath)
+	assert.Error(t, err)
+	assert.Contains(t, output, "chmod 600 "+vaultPath)
+	assert.Contains(t, output, "bosr recover "+vaultPath)
+
+	// A copied vault has no key under its new path
+	copyPath := filepath.Join(dir, "copy.db")
+	data, err := os.ReadFile(vaultPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(copyPath, data, 0600))
+	output, err = bosr(t, "open", copyPath)
+	assert.Error(t, err)
+	assert.Contains(t, output, "bosr doctor")
+	stdout, err := exec.Command(bosrPath, "doctor", "--json", copyPath).Output()
+	assert.Error(t, err)
+	var report struct {
+		Findings []struct {
+			Check  string `json:"check"`
+			Status string `json:"status"`
+		} `json:"findings"`
+	}
+	require.NoError(t, json.Unmarshal(stdout, &report))
+	statuses := make(map[string]string)
+	for _, f := range report.Findings {
+		statuses[f.Check] = f.Status
+	}
+	assert.Equal(t, "ok", statuses["secret store"])
+	assert.Equal(t, "fail", statuses["key"])
+}
+
+func TestBosrRecoverLeftovers(t *testing.T) {
+	if os.Getenv("CI") != "true" {
+		t.Skip("Skipping integration test outside of CI environment")
+	}
+	bosrPath := bosrBinary(t)
+	dir := t.TempDir()
+	vaultPath := filepath.Join(dir, "recover_vault.db")
+
+	bosr := func(t *testing.T, stdin string, args ...string) (string, error) {
+		t.Helper()
+		cmd := exec.Command(bosrPath, args...)
+		cmd.Stdin = strings.NewReader(stdin)
+		output, err := cmd.CombinedOutput()
+		return string(output), err
+	}
+
+	_, err := bosr(t, "", "init", vaultPath)
+	require.NoError(t, err)
+	_, err = bosr(t, "", "put", vaultPath, "db", "hunter2")
+	require.NoError(t, err)
+
+	// A failed rotation without a journal leaves its files behind
+	data, err := os.ReadFile(vaultPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(vaultPath+".bak", data, 0600))
+	require.NoError(t, os.WriteFile(vaultPath+".tmp", []byte("half written"), 0600))
+	output, err := bosr(t, "", "key", "rotate", vaultPath)
+	assert.Error(t, err)
+	assert.Contains(t, output, "bosr recover "+vaultPath)
+
+	output, err = bosr(t, "n\n", "recover", vaultPath)
+	assert.Error(t, err)
+	assert.Contains(t, output, "opens with key")
+	assert.Contains(t, output, "remove "+vaultPath+".bak")
+	assert.FileExists(t, vaultPath+".bak")
+
+	output, err = bosr(t, "y\n", "recover", vaultPath)
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "Vault repaired")
+	assert.NoFileExists(t, vaultPath+".bak")
+	assert.NoFileExists(t, vaultPath+".tmp")
+
+	// The vault file belongs to another key, which is provided
+	otherPath := filepath.Join(dir, "other_vault.db")
+	_, err = bosr(t, "", "init", otherPath)
+	require.NoError(t, err)
+	_, err = bosr(t, "", "put", otherPath, "api", "sk_live")
+	require.NoError(t, err)
+	otherKey, err := secretstore.Default.Get(otherPath)
+	require.NoError(t, err)
+	data, err = os.ReadFile(otherPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(vaultPath, data, 0600))
+
+	output, err = bosr(t, "", "recover", "--yes", vaultPath)
+	assert.Error(t, err)
+	assert.Contains(t, output, "--old-key")
+
+	keyFile := filepath.Join(dir, "old.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(hex.EncodeToString(otherKey)+"\n"), 0600))
+	output, err = bosr(t, "", "recover", "--old-key", keyFile, "--yes", vaultPath)
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "provided key")
+	output, err = bosr(t, "", "get", vaultPath, "api")
+	require.NoError(t, err, output)
+	assert.Contains(t, output, "sk_live")
+}
+
+func TestBosrStrippedSeal(t *testing.T) {
+	if os.Getenv("CI") != "true" {
+		t.Skip("Skipping integration test outside of CI environment")
+	}
+	bosrPath := bosrBinary(t)
+	vaultPath := filepath.Join(t.TempDir(), "sealed_vault.db")
+
+	for _, args := range [][]string{{"init", vaultPath}, {"put", vaultPath, "a", "1"}, {"put", vaultPath, "b", "2"}} {
+		output, err := exec.Command(bosrPath, args...).CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+
+	// Removing the seal does not turn a tampered vault into a legacy one
+	execSQL(t, vaultPath, "DELETE FROM vault_meta WHERE name = 'integrity.seal'")
+	execSQL(t, vaultPath, "DELETE FROM vault WHERE key = 'b'")
+	for _, args := range [][]string{{"open", vaultPath}, {"verify", vaultPath}, {"put", vaultPath, "c", "3"}} {
+		output, err := exec.Command(bosrPath, args...).CombinedOutput()
+		assert.Error(t, err, "%s should fail", args[0])
+		assert.Contains(t, string(output), "seal is missing", args[0])
+	}
+}
+
+// bosrBinary returns the path of the bosr binary, building it if needed
+func bosrBinary(t *testing.T) string {
+	t.Helper()
+	bosrPath := filepath.Join("..", "bin", "bosr")
+	if _, err := os.Stat(bosrPath); os.IsNotExist(err) {
+		// Try to build it
+		buildCmd := exec.Command("go", "build", "-o", bosrPath, "../cmd/bosr")
+		output, err := buildCmd.CombinedOutput()
+		require.NoError(t, err, "Failed to build bosr binary: %s", output)
+	}
+	return bosrPath
+}
+
+// execSQL modifies a vault file directly, e.g. to simulate one written by
+// another version of bosr
+func execSQL(t *testing.T, path, query string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(query)
+	require.NoError(t, err)
+}